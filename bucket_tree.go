@@ -0,0 +1,375 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// 桶的层级：parent_id 为0的是顶层桶。子桶的目标占比是其在父桶中的占比，
+// 同一父桶下(包括顶层)各桶的目标占比合计为100%；有子桶的桶只用于汇总，基金放在最底层的桶中
+
+// 按层级排列的顺序：父桶在前，子桶紧随其后，同级保持原顺序；
+// 父桶不存在或形成循环的桶排在最后，由校验报告错误
+func bucketTreeOrder(ids, parents []int) []int {
+	index := make(map[int]int, len(ids))
+	for i, id := range ids {
+		index[id] = i
+	}
+	children := make(map[int][]int)
+	var roots []int
+	for i, parent := range parents {
+		if _, ok := index[parent]; parent != 0 && ok {
+			children[parent] = append(children[parent], i)
+		} else if parent == 0 {
+			roots = append(roots, i)
+		}
+	}
+
+	order := make([]int, 0, len(ids))
+	visited := make([]bool, len(ids))
+	var walk func(i int)
+	walk = func(i int) {
+		if visited[i] {
+			return
+		}
+		visited[i] = true
+		order = append(order, i)
+		for _, child := range children[ids[i]] {
+			walk(child)
+		}
+	}
+	for _, i := range roots {
+		walk(i)
+	}
+	for i := range ids {
+		walk(i)
+	}
+	return order
+}
+
+// 按层级顺序排列数据库中的桶
+func orderDBBuckets(buckets []DBBucket) []DBBucket {
+	ids := make([]int, len(buckets))
+	parents := make([]int, len(buckets))
+	for i, b := range buckets {
+		ids[i], parents[i] = b.ID, b.ParentID
+	}
+	ordered := make([]DBBucket, 0, len(buckets))
+	for _, i := range bucketTreeOrder(ids, parents) {
+		ordered = append(ordered, buckets[i])
+	}
+	return ordered
+}
+
+// 桶列表上的层级索引，buckets 按层级顺序排列
+type bucketTree struct {
+	buckets  []Bucket
+	index    map[int]int   // 桶ID -> 下标
+	children map[int][]int // 父桶ID -> 子桶下标，顶层桶的父桶ID为0
+}
+
+func newBucketTree(buckets []Bucket) bucketTree {
+	t := bucketTree{buckets: buckets, index: make(map[int]int, len(buckets)), children: make(map[int][]int)}
+	for i, b := range buckets {
+		t.index[b.ID] = i
+	}
+	for i, b := range buckets {
+		t.children[b.ParentID] = append(t.children[b.ParentID], i)
+	}
+	return t
+}
+
+// 是否有子桶
+func (t bucketTree) nested() bool {
+	return len(t.children[0]) < len(t.buckets)
+}
+
+func (t bucketTree) isLeaf(i int) bool {
+	return len(t.children[t.buckets[i].ID]) == 0
+}
+
+// 父桶下标，顶层桶返回-1
+func (t bucketTree) parent(i int) int {
+	if p, ok := t.index[t.buckets[i].ParentID]; ok && t.buckets[i].ParentID != 0 {
+		return p
+	}
+	return -1
+}
+
+// 从父桶到顶层桶的下标；父桶形成循环时在遍历完所有桶后停止
+func (t bucketTree) ancestors(i int) []int {
+	var result []int
+	for p := t.parent(i); p >= 0 && len(result) <= len(t.buckets); p = t.parent(p) {
+		result = append(result, p)
+	}
+	return result
+}
+
+// 桶及其所有子桶中基金的市值合计
+func (t bucketTree) subtreeCurrent(i int) float64 {
+	var total float64
+	for _, f := range t.buckets[i].Funds {
+		total += f.Current
+	}
+	for _, child := range t.children[t.buckets[i].ID] {
+		total += t.subtreeCurrent(child)
+	}
+	return total
+}
+
+// 桶占总市值的目标占比，即从顶层到该桶各级目标占比的乘积
+func (t bucketTree) effectiveRate(i int) float64 {
+	rate := t.buckets[i].TargetRate
+	for _, p := range t.ancestors(i) {
+		rate *= t.buckets[p].TargetRate
+	}
+	return rate
+}
+
+// 层级深度，顶层为0
+func (t bucketTree) level(i int) int {
+	return len(t.ancestors(i))
+}
+
+// 从顶层到该桶的名称，如 "长期桶 / 海外"
+func (t bucketTree) path(i int) string {
+	names := []string{t.buckets[i].Name}
+	for _, p := range t.ancestors(i) {
+		names = append([]string{t.buckets[p].Name}, names...)
+	}
+	return strings.Join(names, " / ")
+}
+
+// 填写每个桶的层级深度和占总市值的目标占比
+func annotateBucketTree(buckets []Bucket) {
+	t := newBucketTree(buckets)
+	for i := range buckets {
+		buckets[i].Level = t.level(i)
+		buckets[i].EffectiveRate = t.effectiveRate(i)
+	}
+}
+
+// 校验桶的层级：父桶存在、没有循环，有子桶的桶不直接持有基金
+func validateBucketTree(buckets []Bucket) error {
+	t := newBucketTree(buckets)
+	for i, b := range buckets {
+		if b.ParentID == 0 {
+			continue
+		}
+		if _, ok := t.index[b.ParentID]; !ok {
+			return fmt.Errorf("%s 的父桶不存在: %d", b.Name, b.ParentID)
+		}
+		for _, p := range t.ancestors(i) {
+			if p == i {
+				return fmt.Errorf("%s 的父桶形成循环", b.Name)
+			}
+		}
+		parent := buckets[t.index[b.ParentID]]
+		if len(parent.Funds) > 0 {
+			return fmt.Errorf("%s 下有子桶，不能直接持有基金，请把其中%d只基金移到子桶(删除后恢复到子桶)", parent.Name, len(parent.Funds))
+		}
+	}
+	return nil
+}
+
+// 父桶 parentID 下各子桶目标占比之和，excludeBucketID 为不计入的桶
+func siblingRateSum(dbBuckets []DBBucket, parentID, excludeBucketID int) float64 {
+	var total float64
+	for _, b := range dbBuckets {
+		if b.ParentID == parentID && b.ID != excludeBucketID {
+			total += b.TargetRate
+		}
+	}
+	return total
+}
+
+// 桶是否有子桶
+func hasChildBuckets(dbBuckets []DBBucket, bucketID int) bool {
+	for _, b := range dbBuckets {
+		if b.ParentID == bucketID {
+			return true
+		}
+	}
+	return false
+}
+
+// 校验桶可以直接持有基金，即没有子桶
+func checkLeafBucket(dbBuckets []DBBucket, bucket DBBucket) error {
+	if hasChildBuckets(dbBuckets, bucket.ID) {
+		return fmt.Errorf("%s 下有子桶，基金需放在子桶中", bucket.Name)
+	}
+	return nil
+}
+
+// 校验桶的父桶，bucketID 为正在修改的桶（新增时为0）；父桶不能是自身或自身的子桶，
+// 也不能直接持有基金，否则添加子桶后该桶既有基金又有子桶，无法再平衡
+func checkBucketParent(dbBuckets []DBBucket, bucketID, parentID int) error {
+	if parentID == 0 {
+		return nil
+	}
+	if parentID < 0 {
+		return fmt.Errorf("无效的父桶ID: %d", parentID)
+	}
+	for id := parentID; id != 0; {
+		if id == bucketID {
+			return fmt.Errorf("父桶不能是该桶自身或其子桶")
+		}
+		bi, found := findDBBucketByID(dbBuckets, id)
+		if !found {
+			return fmt.Errorf("父桶不存在: %d", id)
+		}
+		id = dbBuckets[bi].ParentID
+	}
+	if pi, _ := findDBBucketByID(dbBuckets, parentID); len(dbBuckets[pi].Funds) > 0 {
+		return fmt.Errorf("%s 内有%d只基金，不能作为父桶，请先把基金移到其他桶(删除后恢复到其他桶)",
+			dbBuckets[pi].Name, len(dbBuckets[pi].Funds))
+	}
+	return nil
+}
+
+// 桶列表中出现的父桶ID(包括顶层的0)，按首次出现的顺序
+func bucketParentIDs(buckets []Bucket) []int {
+	seen := make(map[int]bool)
+	var ids []int
+	for _, b := range buckets {
+		if !seen[b.ParentID] {
+			seen[b.ParentID] = true
+			ids = append(ids, b.ParentID)
+		}
+	}
+	return ids
+}
+
+// 把 rates 写入各桶的目标占比：同一父桶下(包括顶层) fixed 的桶使用 rates 中的占比，其余桶按原占比的比例
+// 分配剩余占比，使合计仍为100%。reason 为调整的原因(如"按估值调整")，返回按比例补足的桶的调整说明
+func fillSiblingTargets(buckets []Bucket, rates []float64, fixed []bool, reason string) ([]TargetAdjustment, error) {
+	t := newBucketTree(buckets)
+	var adjustments []TargetAdjustment
+	for _, parentID := range bucketParentIDs(buckets) {
+		members := t.children[parentID]
+		var fixedSum, freeSum float64
+		var free []int
+		changed := false
+		for _, i := range members {
+			if fixed[i] {
+				fixedSum += rates[i]
+				changed = changed || rates[i] != buckets[i].TargetRate
+			} else {
+				freeSum += rates[i]
+				free = append(free, i)
+			}
+		}
+		if !changed {
+			continue
+		}
+
+		group := "顶层桶"
+		if p, ok := t.index[parentID]; ok && parentID != 0 {
+			group = t.path(p) + " 的子桶"
+		}
+		remaining := 1 - fixedSum
+		if remaining < -targetRateTolerance {
+			return nil, fmt.Errorf("%s中%s的目标占比合计为%.1f%%，超过100%%", group, reason, fixedSum*100)
+		}
+		if freeSum <= targetRateTolerance {
+			if remaining > targetRateTolerance {
+				return nil, fmt.Errorf("%s中%s后目标占比合计为%.1f%%，没有其他桶可以补足到100%%", group, reason, fixedSum*100)
+			}
+			continue
+		}
+		for _, i := range free {
+			rates[i] = buckets[i].TargetRate * remaining / freeSum
+			adjustments = append(adjustments, TargetAdjustment{
+				BucketID: buckets[i].ID, BucketName: t.path(i), BaseRate: buckets[i].TargetRate, TargetRate: rates[i],
+				Note: fmt.Sprintf("%s中其他桶%s，按原占比的比例补足", group, reason),
+			})
+		}
+	}
+
+	for i := range buckets {
+		buckets[i].TargetRate = rates[i]
+	}
+	annotateBucketTree(buckets)
+	return adjustments, nil
+}
+
+// 逐层执行阈值再平衡：先比较顶层桶(含子桶)占总市值的比例，超出阈值的桶连同子桶整体调回目标；
+// 未超出的桶再比较各子桶在该桶内的占比，逐层向下，最底层的桶检查基金偏离带。
+// 没有子桶时等同于 rebalanceByThreshold
+func rebalanceTree(buckets []Bucket, opts RebalanceOptions) []Bucket {
+	t := newBucketTree(buckets)
+	if !t.nested() {
+		return rebalanceByThreshold(buckets, opts)
+	}
+	t.rebalanceLevel(0, opts)
+	return buckets
+}
+
+// 同一层的子桶视作一组桶执行阈值再平衡：每个子桶包含其下所有基金，
+// 基金的权重换算为在该子桶中的占比，总市值为父桶的市值
+func (t bucketTree) rebalanceLevel(parentID int, opts RebalanceOptions) {
+	type fundRef struct{ bucket, fund int }
+	nodes := t.children[parentID]
+	level := make([]Bucket, len(nodes))
+	refs := make([][]fundRef, len(nodes))
+
+	var collect func(n, i int, share float64)
+	collect = func(n, i int, share float64) {
+		for fi, f := range t.buckets[i].Funds {
+			f.Weight *= share
+			level[n].Funds = append(level[n].Funds, f)
+			refs[n] = append(refs[n], fundRef{i, fi})
+		}
+		for _, child := range t.children[t.buckets[i].ID] {
+			collect(n, child, share*t.buckets[child].TargetRate)
+		}
+	}
+	var total float64
+	for n, i := range nodes {
+		b := t.buckets[i]
+		level[n] = Bucket{ID: b.ID, Name: b.Name, TargetRate: b.TargetRate}
+		collect(n, i, 1)
+		for _, f := range level[n].Funds {
+			total += f.Current
+		}
+	}
+
+	rebalanceByThreshold(level, opts)
+
+	prefix := ""
+	if p, ok := t.index[parentID]; ok && parentID != 0 {
+		prefix = t.path(p) + "内："
+	}
+	for n, i := range nodes {
+		var current float64
+		for _, f := range level[n].Funds {
+			current += f.Current
+		}
+		// 未触发的父桶不整体调整，继续检查下一层
+		triggered := total > 0 && math.Abs(current/total-t.buckets[i].TargetRate) > opts.Threshold
+		if !triggered && !t.isLeaf(i) {
+			t.rebalanceLevel(t.buckets[i].ID, opts)
+			continue
+		}
+		for k, ref := range refs[n] {
+			result := level[n].Funds[k]
+			fund := &t.buckets[ref.bucket].Funds[ref.fund]
+			fund.Target, fund.Diff, fund.Advice = result.Target, result.Diff, result.Advice
+			fund.Reason = prefix + result.Reason
+		}
+	}
+}
+
+// 按层级缩进的桶名称，用于文本输出
+func indentedBucketName(b Bucket) string {
+	return strings.Repeat("  ", b.Level) + b.Name
+}
+
+// 桶的目标占比说明，子桶附带占总市值的目标占比
+func bucketRateLabel(b Bucket) string {
+	if b.ParentID == 0 {
+		return fmt.Sprintf("目标占比 %.1f%%", b.TargetRate*100)
+	}
+	return fmt.Sprintf("目标占比 %.1f%%(占总市值 %.1f%%)", b.TargetRate*100, b.EffectiveRate*100)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// 两层的桶：权益(60%)下分A股、美股各50%，债券40%
+func newTwoLevelBuckets(bond, ashare, us float64) []Bucket {
+	return []Bucket{
+		{ID: 1, Name: "权益", TargetRate: 0.6},
+		{ID: 2, Name: "A股", ParentID: 1, TargetRate: 0.5, Funds: []Fund{{ID: 1, Name: "沪深300", Current: ashare, Weight: 1}}},
+		{ID: 3, Name: "美股", ParentID: 1, TargetRate: 0.5, Funds: []Fund{{ID: 2, Name: "标普500", Current: us, Weight: 1}}},
+		{ID: 4, Name: "债券", TargetRate: 0.4, Funds: []Fund{{ID: 3, Name: "债券基金", Current: bond, Weight: 1}}},
+	}
+}
+
+func TestRebalanceTree(t *testing.T) {
+	tests := []struct {
+		name       string
+		buckets    []Bucket
+		wantDiff   map[string]float64
+		wantReason map[string]string // 调整原因应包含的内容
+	}{
+		{
+			// 权益60/100未超出阈值；权益内A股40/60=66.7%超出50%±5%，只在权益内部调整
+			name:       "只有子桶一层超出阈值",
+			buckets:    newTwoLevelBuckets(40, 40, 20),
+			wantDiff:   map[string]float64{"沪深300": -10, "标普500": 10, "债券基金": 0},
+			wantReason: map[string]string{"沪深300": "权益内：", "标普500": "权益内："},
+		},
+		{
+			// 权益80/100超出60%±5%，连同子桶整体调回：A股、美股各30，债券40
+			name:     "顶层超出阈值时子桶整体调回目标",
+			buckets:  newTwoLevelBuckets(20, 60, 20),
+			wantDiff: map[string]float64{"沪深300": -30, "标普500": 10, "债券基金": 20},
+		},
+		{
+			name:     "各层都未超出阈值",
+			buckets:  newTwoLevelBuckets(40, 31, 29),
+			wantDiff: map[string]float64{"沪深300": 0, "标普500": 0, "债券基金": 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buckets := rebalanceTree(tt.buckets, RebalanceOptions{Threshold: 0.05, Mode: RebalanceModeTarget})
+			for _, b := range buckets {
+				for _, f := range b.Funds {
+					if !approxEqual(f.Diff, tt.wantDiff[f.Name]) {
+						t.Errorf("%s 调整%.4f万，应为%.4f万(%s)", f.Name, f.Diff, tt.wantDiff[f.Name], f.Reason)
+					}
+					if want, ok := tt.wantReason[f.Name]; ok && !strings.HasPrefix(f.Reason, want) {
+						t.Errorf("%s 调整原因 %q 应以 %q 开头", f.Name, f.Reason, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+// 总目标占比为从顶层到该桶各级目标占比的乘积
+func TestAnnotateBucketTree(t *testing.T) {
+	buckets := []Bucket{
+		{ID: 1, Name: "权益", TargetRate: 0.6},
+		{ID: 2, Name: "海外", ParentID: 1, TargetRate: 0.5},
+		{ID: 3, Name: "美股", ParentID: 2, TargetRate: 0.4},
+		{ID: 4, Name: "欧股", ParentID: 2, TargetRate: 0.6},
+		{ID: 5, Name: "A股", ParentID: 1, TargetRate: 0.5},
+		{ID: 6, Name: "债券", TargetRate: 0.4},
+	}
+	want := []struct {
+		level int
+		rate  float64
+	}{{0, 0.6}, {1, 0.3}, {2, 0.12}, {2, 0.18}, {1, 0.3}, {0, 0.4}}
+
+	annotateBucketTree(buckets)
+	for i, b := range buckets {
+		if b.Level != want[i].level || !approxEqual(b.EffectiveRate, want[i].rate) {
+			t.Errorf("%s 层级%d、总目标占比%.4f，应为层级%d、占比%.4f", b.Name, b.Level, b.EffectiveRate, want[i].level, want[i].rate)
+		}
+	}
+}
+
+func TestCheckBucketParent(t *testing.T) {
+	dbBuckets := []DBBucket{
+		{ID: 1, Name: "权益"},
+		{ID: 2, Name: "A股", ParentID: 1, Funds: []DBFund{{ID: 1, Name: "沪深300"}}},
+		{ID: 3, Name: "海外", ParentID: 1},
+		{ID: 4, Name: "美股", ParentID: 3},
+		{ID: 5, Name: "债券", Funds: []DBFund{{ID: 2, Name: "债券基金"}}},
+	}
+
+	tests := []struct {
+		name      string
+		bucketID  int // 0 表示新增的桶
+		parentID  int
+		wantError string // 为空表示不应报错
+	}{
+		{"顶层桶", 5, 0, ""},
+		{"新增到没有基金的桶下", 0, 3, ""},
+		{"移到其他父桶下", 3, 1, ""},
+		{"父桶ID无效", 0, -1, "无效的父桶ID"},
+		{"父桶不存在", 0, 9, "父桶不存在"},
+		{"父桶为自身", 3, 3, "不能是该桶自身或其子桶"},
+		{"父桶为自己的子桶", 1, 4, "不能是该桶自身或其子桶"},
+		{"父桶直接持有基金", 0, 5, "债券 内有1只基金，不能作为父桶"},
+		{"子桶直接持有基金", 4, 2, "A股 内有1只基金，不能作为父桶"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkBucketParent(dbBuckets, tt.bucketID, tt.parentID)
+			if tt.wantError == "" {
+				if err != nil {
+					t.Errorf("不应报错，实际: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+				t.Errorf("错误 %v 应包含 %q", err, tt.wantError)
+			}
+		})
+	}
+}
@@ -0,0 +1,2068 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// 子命令退出码
+const (
+	exitOK       = 0 // 成功
+	exitError    = 1 // 运行错误（数据库等）
+	exitUsage    = 2 // 参数错误或校验失败
+	exitNotFound = 3 // 指定的桶、基金或记录不存在
+)
+
+// 带退出码的错误
+type commandError struct {
+	code int
+	err  error
+}
+
+func (e *commandError) Error() string { return e.err.Error() }
+
+func usageErrorf(format string, args ...interface{}) error {
+	return &commandError{code: exitUsage, err: fmt.Errorf(format, args...)}
+}
+
+func notFoundErrorf(format string, args ...interface{}) error {
+	return &commandError{code: exitNotFound, err: fmt.Errorf(format, args...)}
+}
+
+// 子命令定义
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(args []string, out io.Writer) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"list", "list [--archived] [--format table|json|csv]", "查看当前基金配置（--archived 查看已归档的基金）", runListCommand},
+		{"add-fund", "add-fund --bucket-id N --name 名称 --code 代码 --current 市值 --weight 权重", "添加基金", runAddFundCommand},
+		{"update-fund", "update-fund --id N [--name 名称] [--code 代码] [--current 市值] [--weight 权重] [--purchase-rate 0.015] [--purchase-discount 0.1] [--redemption-tiers 7:0.015,0:0] [--held-since 2024-01-02] [--min-trade 0.001] [--current-override 0]", "修改基金信息", runUpdateFundCommand},
+		{"delete-fund", "delete-fund --id N", "删除基金(归档，交易流水和历史记录保留)", runDeleteFundCommand},
+		{"restore-fund", "restore-fund --id N [--bucket-id 桶ID]", "恢复已归档的基金，默认恢复到原所在桶", runRestoreFundCommand},
+		{"add-bucket", "add-bucket --name 名称 [--target-rate 占比] [--parent-id 父桶ID]", "添加桶，指定父桶时为子桶", runAddBucketCommand},
+		{"update-bucket", "update-bucket --id N [--name 名称] [--target-rate 占比] [--parent-id 父桶ID]", "修改桶信息", runUpdateBucketCommand},
+		{"set-targets", "set-targets --targets 1=0.1,2=0.3,3=0.6", "一次性调整所有桶的目标占比", runSetTargetsCommand},
+		{"delete-bucket", "delete-bucket --id N [--move-to 桶ID]", "删除桶", runDeleteBucketCommand},
+		{"transactions", "transactions --fund-id N [--format table|json|csv]", "查看基金的交易流水及持仓", runTransactionsCommand},
+		{"add-transaction", "add-transaction --fund-id N --type buy|sell|dividend|conversion|fee --date 2024-01-02 [--shares 份额] [--nav 净值] [--amount 金额] [--note 备注]", "添加交易流水", runAddTransactionCommand},
+		{"delete-transaction", "delete-transaction --id N", "删除交易流水", runDeleteTransactionCommand},
+		{"import-navs", "import-navs --file 净值文件 [--code 基金代码] [--input-format csv|json] [--gap-days 1] [--format table|json]", "导入CSV或JSON格式的历史净值", runImportNAVsCommand},
+		{"fetch-navs", "fetch-navs [--provider http|stub] [--url http://host/nav/{code}] [--mapping list=Data.LSJZList,date=FSRQ,unit_nav=DWJZ] [--file 净值文件] [--format table|json|csv]", "从净值数据源获取所有基金的最新净值（默认读取 NAV_PROVIDER 等环境变量）", runFetchNAVsCommand},
+		{"nav-stub-server", "nav-stub-server --file 净值文件 [--addr :9090]", "启动本地替身净值服务，GET /nav/{code} 返回文件中的最新净值", runNAVStubServerCommand},
+		{"navs", "navs --code 基金代码 [--from 2024-01-01] [--to 2024-12-31] [--format table|json|csv]", "查看基金的历史净值", runNAVsCommand},
+		{"import-valuations", "import-valuations --file 估值文件 [--code 指数代码] [--metric pe|pb] [--input-format csv|json] [--format table|json]", "导入CSV或JSON格式的指数估值及历史分位", runImportValuationsCommand},
+		{"valuations", "valuations --code 指数代码 [--metric pe|pb] [--from 2024-01-01] [--to 2024-12-31] [--format table|json|csv]", "查看指数的历史估值", runValuationsCommand},
+		{"valuation-rules", "valuation-rules [--date 2024-06-30] [--format table|json|csv]", "查看估值规则及其对目标占比的调整", runValuationRulesCommand},
+		{"add-valuation-rule", "add-valuation-rule --bucket-id N --code 指数代码 [--metric pe|pb] [--min-percentile 0] [--max-percentile 0.2] --target-rate 占比", "添加估值规则：估值分位在区间内时使用指定的目标占比", runAddValuationRuleCommand},
+		{"delete-valuation-rule", "delete-valuation-rule --id N", "删除估值规则", runDeleteValuationRuleCommand},
+		{"glide-paths", "glide-paths [--from 2024-01-01] [--to 2050-01-01] [--step year|quarter|month] [--format table|json|csv]", "查看下滑路径及各时间点的目标占比投影", runGlidePathsCommand},
+		{"set-glide-path", "set-glide-path --bucket-id N [--basis date|age] --points 2025-01-01=0.8,2035-01-01=0.5", "设置桶的下滑路径：目标占比按日期或年龄线性变化", runSetGlidePathCommand},
+		{"delete-glide-path", "delete-glide-path --bucket-id N", "删除桶的下滑路径，恢复使用配置的目标占比", runDeleteGlidePathCommand},
+		{"execute-suggestion", "execute-suggestion --id N --status executed|partial|skipped [--amount 金额] [--nav 净值] [--date 2024-01-02] [--note 备注]", "标记再平衡建议的执行情况，生成交易流水并更新市值", runExecuteSuggestionCommand},
+		{"rebalance", "rebalance [--strategy bucket_threshold] [--threshold 0.05] [--fund-abs-band 0.05] [--fund-rel-band 0.25] [--mode target|band_edge|cash_flow [--inner-band 0.5] [--amount 10]] [--max-fee-rate 0.01] [--min-trade 0.01] [--round-step 0.01] [--static-targets] [--dry-run [--set-target 1=0.2] [--set-current 4=90] [--set-weight 4=0.5]] [--format table|json|csv]", "执行再平衡分析并保存记录（--dry-run 仅预览）", runRebalanceCommand},
+		{"schedule", "schedule [--count 5] [--format table|json|csv]", "查看定时再平衡配置及之后的执行时间（调度器在Web服务器中运行）", runScheduleCommand},
+		{"migrate", "migrate status | migrate up [--no-backup] [--format table|json|csv]", "查看数据库结构版本或执行迁移（其他命令启动时会自动迁移）", runMigrateCommand},
+		{"history", "history [--limit 10] | history show <id> | history replay <id> | history diff <a> <b> [--format table|json|csv]", "查看再平衡历史记录", runHistoryCommand},
+	}
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "用法:")
+	fmt.Fprintln(w, "  dynamic-rebalance-fund [全局参数]              启动Web服务器")
+	fmt.Fprintln(w, "  dynamic-rebalance-fund [全局参数] cli          交互式命令行模式")
+	fmt.Fprintln(w, "  dynamic-rebalance-fund [全局参数] <命令> [参数]")
+	fmt.Fprintln(w, "\n全局参数:")
+	fmt.Fprintln(w, "  --config 文件    配置文件(YAML)，默认读取 FUND_CONFIG 环境变量或 ./config.yaml")
+	fmt.Fprintln(w, "  --driver 后端    存储后端 sqlite|postgres，默认 sqlite(FUND_DB_DRIVER)")
+	fmt.Fprintln(w, "  --db 文件|连接串 SQLite数据库文件或 PostgreSQL 连接串(FUND_DB)")
+	fmt.Fprintln(w, "  --listen 地址    Web服务器监听地址(FUND_LISTEN)")
+	fmt.Fprintln(w, "  --seed 文件|none 新数据库的初始组合，none 表示空组合(FUND_SEED)")
+	fmt.Fprintln(w, "\n命令:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "  %s\t%s\n", cmd.name, cmd.summary)
+	}
+	tw.Flush()
+	fmt.Fprintln(w, "\n所有命令均支持 --format table|json|csv，json 输出与API的 data 字段结构一致。")
+	fmt.Fprintln(w, "退出码: 0 成功，1 运行错误，2 参数错误或校验失败，3 对象不存在")
+}
+
+// 运行子命令并返回退出码
+func runCommand(cmd command, args []string) int {
+	// 子命令供脚本调用，标准输出只保留结果，错误通过返回值输出到 stderr
+	log.SetOutput(io.Discard)
+
+	// 数据库在参数解析成功后才打开(见 parseFlags)，参数有误时不执行迁移和写入种子组合；
+	// migrate 命令自行执行迁移，便于先查看状态
+	openCommandDatabase = initDatabase
+	if cmd.name == "migrate" {
+		openCommandDatabase = openDatabase
+	}
+	defer closeDatabase()
+
+	if err := cmd.run(args, os.Stdout); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+
+		var cmdErr *commandError
+		if errors.As(err, &cmdErr) {
+			if cmdErr.code == exitUsage {
+				fmt.Fprintf(os.Stderr, "用法: %s\n", cmd.usage)
+			}
+			return cmdErr.code
+		}
+		return exitError
+	}
+	return exitOK
+}
+
+// 创建子命令参数集，错误由 runCommand 统一输出
+func newFlagSet(name string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	format := fs.String("format", "table", "输出格式: table|json|csv")
+	return fs, format
+}
+
+// 子命令使用的数据库，由 runCommand 设置，在 parseFlags 解析成功后打开
+var openCommandDatabase func() error
+
+// 解析参数，允许参数与位置参数交错出现；-h 时输出命令的用法和参数说明。
+// 解析成功后打开子命令使用的数据库
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				printCommandHelp(os.Stdout, fs)
+				return nil, err
+			}
+			return nil, usageErrorf("%v", err)
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+
+	if open := openCommandDatabase; open != nil {
+		openCommandDatabase = nil
+		if err := open(); err != nil {
+			return nil, fmt.Errorf("数据库初始化失败: %v", err)
+		}
+	}
+	return positional, nil
+}
+
+// 输出子命令的用法、说明和参数
+func printCommandHelp(w io.Writer, fs *flag.FlagSet) {
+	if cmd, ok := findCommand(fs.Name()); ok {
+		fmt.Fprintf(w, "用法: %s\n%s\n", cmd.usage, cmd.summary)
+	}
+	fmt.Fprintln(w, "\n参数:")
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+	fs.SetOutput(io.Discard)
+}
+
+func checkFormat(format string) error {
+	switch format {
+	case "table", "json", "csv":
+		return nil
+	default:
+		return usageErrorf("无效的输出格式: %s", format)
+	}
+}
+
+// 解析 "ID=数值,ID=数值" 形式的参数
+func parseIDValues(s string) (map[int]float64, error) {
+	values := make(map[int]float64)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		idStr, valueStr, ok := strings.Cut(pair, "=")
+		id, err1 := strconv.Atoi(strings.TrimSpace(idStr))
+		value, err2 := strconv.ParseFloat(strings.TrimSpace(valueStr), 64)
+		if !ok || err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("%s", pair)
+		}
+		values[id] = value
+	}
+	return values, nil
+}
+
+// 记录哪些参数被显式设置
+func setFlags(fs *flag.FlagSet) map[string]bool {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	return set
+}
+
+func writeJSON(out io.Writer, v interface{}) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func writeCSV(out io.Writer, header []string, rows [][]string) error {
+	w := csv.NewWriter(out)
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	if err := w.WriteAll(rows); err != nil {
+		return err
+	}
+	return w.Error()
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// 输出基金配置
+func writeBuckets(out io.Writer, format string, buckets []Bucket) error {
+	switch format {
+	case "json":
+		if buckets == nil {
+			buckets = []Bucket{}
+		}
+		return writeJSON(out, buckets)
+	case "csv":
+		var rows [][]string
+		for _, b := range buckets {
+			for _, f := range b.Funds {
+				rows = append(rows, []string{
+					strconv.Itoa(b.ID), b.Name, formatFloat(b.TargetRate),
+					strconv.Itoa(f.ID), f.Name, f.Code, formatFloat(f.Current), formatFloat(f.Weight), f.CurrentSource,
+					strconv.Itoa(b.ParentID),
+				})
+			}
+		}
+		return writeCSV(out, []string{"bucket_id", "bucket_name", "target_rate", "fund_id", "fund_name", "fund_code", "current", "weight", "current_source", "parent_id"}, rows)
+	default:
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		for _, b := range buckets {
+			fmt.Fprintf(tw, "%s[%d] %s\t%s\n", strings.Repeat("  ", b.Level), b.ID, b.Name, bucketRateLabel(b))
+			for _, f := range b.Funds {
+				source := ""
+				switch f.CurrentSource {
+				case "ledger":
+					source = "(流水)"
+				case "override":
+					source = "(手动覆盖)"
+				}
+				fmt.Fprintf(tw, "%s%d\t%s\t%s\t%.2f万%s\t权重 %.1f%%\n", strings.Repeat("  ", b.Level+1), f.ID, f.Name, f.Code, f.Current, source, f.Weight*100)
+			}
+		}
+		return tw.Flush()
+	}
+}
+
+// 输出已归档的基金
+func writeArchivedFunds(out io.Writer, format string, funds []DBFund) error {
+	switch format {
+	case "json":
+		if funds == nil {
+			funds = []DBFund{}
+		}
+		return writeJSON(out, funds)
+	case "csv":
+		var rows [][]string
+		for _, f := range funds {
+			rows = append(rows, []string{
+				strconv.Itoa(f.ID), f.Name, f.Code, strconv.Itoa(f.BucketID), formatFloat(f.Current), formatFloat(f.Weight), f.ArchivedAt,
+			})
+		}
+		return writeCSV(out, []string{"fund_id", "fund_name", "fund_code", "bucket_id", "current", "weight", "archived_at"}, rows)
+	default:
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "基金ID\t基金名称\t代码\t原桶ID\t市值(万)\t权重\t归档时间")
+		for _, f := range funds {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%.2f\t%.1f%%\t%s\n", f.ID, f.Name, f.Code, f.BucketID, f.Current, f.Weight*100, f.ArchivedAt)
+		}
+		return tw.Flush()
+	}
+}
+
+// 输出再平衡结果
+func writeRebalanceResults(out io.Writer, format string, results []Bucket) error {
+	switch format {
+	case "json":
+		return writeBuckets(out, format, results)
+	case "csv":
+		var rows [][]string
+		for _, b := range results {
+			for _, f := range b.Funds {
+				rows = append(rows, []string{
+					strconv.Itoa(b.ID), b.Name, strconv.Itoa(f.ID), f.Name, f.Code,
+					formatFloat(f.Current), formatFloat(f.Target), formatFloat(f.Diff), f.Advice, f.Reason, formatFloat(f.Fee), formatFloat(f.Dropped),
+					formatFloat(f.PostTrade),
+				})
+			}
+		}
+		return writeCSV(out, []string{"bucket_id", "bucket_name", "fund_id", "fund_name", "fund_code", "current", "target", "diff", "advice", "reason", "fee", "dropped", "post_trade"}, rows)
+	default:
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "基金ID\t基金名称\t代码\t当前(万)\t目标(万)\t调整(万)\t调整后(万)\t建议\t费用(元)")
+		for _, b := range results {
+			for _, f := range b.Funds {
+				fmt.Fprintf(tw, "%d\t%s\t%s\t%.2f\t%.2f\t%+.2f\t%.2f\t%s\t%.2f\n",
+					f.ID, f.Name, f.Code, f.Current, f.Target, f.Diff, f.PostTrade, f.Advice, f.Fee*10000)
+			}
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		return writeProjectedAllocation(out, results)
+	}
+}
+
+// 输出按建议调整后各桶的预计占比，父桶包含其下所有子桶，占比均为占总市值的比例
+func writeProjectedAllocation(out io.Writer, results []Bucket) error {
+	t := newBucketTree(results)
+	currents := make([]float64, len(results))
+	projecteds := make([]float64, len(results))
+	var total, projectedTotal float64
+	for i, b := range results {
+		for _, f := range b.Funds {
+			total += f.Current
+			projectedTotal += f.Current + f.Diff
+			for _, j := range append([]int{i}, t.ancestors(i)...) {
+				currents[j] += f.Current
+				projecteds[j] += f.Current + f.Diff
+			}
+		}
+	}
+	if total <= 0 || projectedTotal <= 0 {
+		return nil
+	}
+
+	fmt.Fprintf(out, "\n调整后配置(总市值 %.2f万 → %.2f万):\n", total, projectedTotal)
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "桶ID\t桶名称\t当前占比\t调整后占比\t目标占比")
+	for i, b := range results {
+		fmt.Fprintf(tw, "%d\t%s\t%.1f%%\t%.1f%%\t%.1f%%\n",
+			b.ID, strings.Repeat("  ", t.level(i))+b.Name, currents[i]/total*100, projecteds[i]/projectedTotal*100, t.effectiveRate(i)*100)
+	}
+	return tw.Flush()
+}
+
+// 读取并输出基金的交易流水及持仓
+func writeFundLedger(out io.Writer, format string, fundID int) error {
+	ledger, err := getFundLedger(fundID)
+	if err != nil {
+		return err
+	}
+	return writeLedger(out, format, *ledger)
+}
+
+// 输出交易流水及持仓
+func writeLedger(out io.Writer, format string, ledger FundLedger) error {
+	switch format {
+	case "json":
+		return writeJSON(out, ledger)
+	case "csv":
+		var rows [][]string
+		for _, t := range ledger.Transactions {
+			rows = append(rows, []string{
+				strconv.Itoa(t.ID), strconv.Itoa(t.FundID), t.Type, t.TradeDate,
+				formatFloat(t.Shares), formatFloat(t.NAV), formatFloat(t.Amount), t.Note,
+			})
+		}
+		return writeCSV(out, []string{"id", "fund_id", "type", "trade_date", "shares", "nav", "amount", "note"}, rows)
+	default:
+		h := ledger.Holding
+		fmt.Fprintf(out, "基金 #%d | 持有份额 %.2f | 最新净值 %.4f(%s) | 持仓市值 %.2f万\n\n",
+			ledger.FundID, h.Shares, h.NAV, h.NAVDate, h.Value())
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\t日期\t类型\t份额\t净值\t金额(万)\t备注")
+		for _, t := range ledger.Transactions {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%.2f\t%.4f\t%.4f\t%s\n",
+				t.ID, t.TradeDate, transactionTypeLabel(t.Type), t.Shares, t.NAV, t.Amount, t.Note)
+		}
+		return tw.Flush()
+	}
+}
+
+// 输出再平衡记录的配置快照
+func writeSnapshot(out io.Writer, snapshot *RebalanceSnapshot) error {
+	if snapshot == nil {
+		fmt.Fprintln(out, "\n(该记录没有配置快照)")
+		return nil
+	}
+
+	opts := snapshot.Options
+	fmt.Fprintf(out, "\n配置快照: 阈值 %.1f%% | 基金偏离带 绝对%.1f%% 相对%.1f%% | 模式 %s | 费用上限 %.2f%% | 最低交易 %.4f万 | 取整步长 %.4f万\n",
+		opts.Threshold*100, opts.FundBand.Absolute*100, opts.FundBand.Relative*100, opts.Mode, opts.MaxFeeRate*100, opts.MinTrade, opts.RoundStep)
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, b := range snapshot.restoreBuckets() {
+		fmt.Fprintf(tw, "%s[%d] %s\t%s\n", strings.Repeat("  ", b.Level), b.ID, b.Name, bucketRateLabel(b))
+		for _, f := range b.Funds {
+			fmt.Fprintf(tw, "%s%d\t%s\t%s\t%.2f万\t权重 %.1f%%\n", strings.Repeat("  ", b.Level+1), f.ID, f.Name, f.Code, f.Current, f.Weight*100)
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(snapshot.TargetAdjustments) > 0 {
+		fmt.Fprintln(out, "目标占比的动态调整:")
+		for _, d := range targetAdjustmentDiagnostics(snapshot.TargetAdjustments) {
+			fmt.Fprintf(out, "  %s\n", d)
+		}
+	}
+	return nil
+}
+
+// 输出历史记录列表
+func writeRecords(out io.Writer, format string, records []RebalanceRecord) error {
+	switch format {
+	case "json":
+		if records == nil {
+			records = []RebalanceRecord{}
+		}
+		return writeJSON(out, records)
+	case "csv":
+		var rows [][]string
+		for _, r := range records {
+			rows = append(rows, []string{
+				strconv.Itoa(r.ID), r.CreatedAt.Format("2006-01-02 15:04:05"),
+				formatFloat(r.Threshold), formatFloat(r.TotalValue), r.Mode, formatFloat(r.InnerBand), formatFloat(r.CashFlow), formatFloat(r.TotalFee),
+				r.Strategy, r.Trigger,
+			})
+		}
+		return writeCSV(out, []string{"id", "created_at", "threshold", "total_value", "mode", "inner_band", "cash_flow", "total_fee", "strategy", "trigger"}, rows)
+	default:
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\t执行时间\t触发\t策略\t阈值\t总市值(万)\t模式\t费用(元)")
+		for _, r := range records {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%.1f%%\t%.2f\t%s\t%.2f\n",
+				r.ID, r.CreatedAt.Format("2006-01-02 15:04:05"), triggerLabel(r.Trigger), r.Strategy, r.Threshold*100, r.TotalValue, rebalanceModeLabel(r), r.TotalFee*10000)
+		}
+		return tw.Flush()
+	}
+}
+
+// 输出历史记录详情
+func writeRecordDetail(out io.Writer, format string, detail RebalanceDetail) error {
+	switch format {
+	case "json":
+		if detail.Suggestions == nil {
+			detail.Suggestions = []RebalanceSuggestion{}
+		}
+		return writeJSON(out, detail)
+	case "csv":
+		var rows [][]string
+		for _, s := range detail.Suggestions {
+			rows = append(rows, []string{
+				strconv.Itoa(s.RecordID), strconv.Itoa(s.FundID), s.FundName, s.FundCode,
+				formatFloat(s.CurrentValue), formatFloat(s.TargetValue), formatFloat(s.DiffValue), s.Advice, s.Reason, formatFloat(s.Fee),
+				strconv.Itoa(s.ID), s.Status, formatFloat(s.ExecutedAmount), formatFloat(s.ExecutedNAV), formatFloat(s.ExecutedShares), s.ExecutedAt, formatFloat(s.Gap),
+				s.FundStatus,
+			})
+		}
+		return writeCSV(out, []string{"record_id", "fund_id", "fund_name", "fund_code", "current_value", "target_value", "diff_value", "advice", "reason", "fee",
+			"suggestion_id", "status", "executed_amount", "executed_nav", "executed_shares", "executed_at", "gap", "fund_status"}, rows)
+	default:
+		r := detail.Record
+		fmt.Fprintf(out, "记录 #%d | %s | %s执行 | 策略 %s | 阈值 %.1f%% | 总市值 %.2f万 | %s | 预计费用 %.2f元\n\n",
+			r.ID, r.CreatedAt.Format("2006-01-02 15:04:05"), triggerLabel(r.Trigger), r.Strategy, r.Threshold*100, r.TotalValue, rebalanceModeLabel(r), r.TotalFee*10000)
+		e := detail.Execution
+		fmt.Fprintf(out, "执行情况: 未处理 %d | 已执行 %d | 部分执行 %d | 已跳过 %d | 建议买入 %.2f万、卖出 %.2f万 | 实际买入 %.2f万、卖出 %.2f万\n\n",
+			e.Pending, e.Executed, e.Partial, e.Skipped, e.SuggestedBuy, e.SuggestedSell, e.ExecutedBuy, e.ExecutedSell)
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "建议ID\t基金ID\t基金名称\t代码\t当前(万)\t目标(万)\t调整(万)\t建议\t费用(元)\t执行状态\t实际(万)\t差额(万)")
+		for _, s := range detail.Suggestions {
+			name := s.FundName
+			switch s.FundStatus {
+			case FundStatusArchived:
+				name += "(已归档)"
+			case FundStatusDeleted:
+				name += "(已删除)"
+			}
+			fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%.2f\t%.2f\t%+.2f\t%s\t%.2f\t%s\t%+.2f\t%+.2f\n",
+				s.ID, s.FundID, name, s.FundCode, s.CurrentValue, s.TargetValue, s.DiffValue, s.Advice, s.Fee*10000,
+				suggestionStatusLabel(s.Status), s.ExecutedAmount, s.Gap)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		return writeSnapshot(out, detail.Snapshot)
+	}
+}
+
+// 修改类命令完成后输出提示和最新配置
+func writeMutationResult(out io.Writer, format, message string) error {
+	dbBuckets, err := getAllBucketsFromDB()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "✅ %s\n", message)
+	return writeBuckets(out, format, convertDBBucketsToAPIBuckets(dbBuckets))
+}
+
+func runListCommand(args []string, out io.Writer) error {
+	fs, format := newFlagSet("list")
+	archived := fs.Bool("archived", false, "只列出已归档的基金")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+
+	if *archived {
+		funds, err := getArchivedFunds()
+		if err != nil {
+			return fmt.Errorf("获取归档基金失败: %v", err)
+		}
+		return writeArchivedFunds(out, *format, funds)
+	}
+
+	dbBuckets, err := getAllBucketsFromDB()
+	if err != nil {
+		return fmt.Errorf("获取基金配置失败: %v", err)
+	}
+	return writeBuckets(out, *format, convertDBBucketsToAPIBuckets(dbBuckets))
+}
+
+func runAddFundCommand(args []string, out io.Writer) error {
+	fs, format := newFlagSet("add-fund")
+	bucketID := fs.Int("bucket-id", 0, "所属桶ID")
+	name := fs.String("name", "", "基金名称")
+	code := fs.String("code", "", "基金代码")
+	current := fs.Float64("current", 0, "当前市值(万元)")
+	weight := fs.Float64("weight", 0, "在桶内的权重(0-1)")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	if *bucketID <= 0 || *name == "" || *code == "" {
+		return usageErrorf("--bucket-id、--name、--code 为必填参数")
+	}
+	if *weight < 0 || *weight > 1 {
+		return usageErrorf("权重必须在0-1之间")
+	}
+
+	dbBuckets, err := getAllBucketsFromDB()
+	if err != nil {
+		return fmt.Errorf("获取桶信息失败: %v", err)
+	}
+	bi, found := findDBBucketByID(dbBuckets, *bucketID)
+	if !found {
+		return notFoundErrorf("桶不存在: %d", *bucketID)
+	}
+	if err := checkLeafBucket(dbBuckets, dbBuckets[bi]); err != nil {
+		return usageErrorf("%v", err)
+	}
+	if err := checkFundWeight(dbBuckets[bi], 0, *weight); err != nil {
+		return usageErrorf("%v", err)
+	}
+
+	if err := store.AddFund(*bucketID, *name, *code, *current, *weight); err != nil {
+		return fmt.Errorf("添加基金失败: %v", err)
+	}
+	return writeMutationResult(out, *format, "已添加基金: "+*name)
+}
+
+func runUpdateFundCommand(args []string, out io.Writer) error {
+	fs, format := newFlagSet("update-fund")
+	fundID := fs.Int("id", 0, "基金ID")
+	fs.String("name", "", "新的基金名称")
+	fs.String("code", "", "新的基金代码")
+	fs.String("current", "", "新的当前市值(万元)")
+	fs.String("weight", "", "新的权重(0-1)")
+	fs.String("purchase-rate", "", "申购费率，如0.015表示1.5%")
+	fs.String("purchase-discount", "", "申购费折扣，如0.1表示1折，0表示不打折")
+	fs.String("redemption-tiers", "", "赎回费档位，格式: 持有天数:费率,...，如 7:0.015,30:0.0075,0:0")
+	fs.String("held-since", "", "持有起始日期(YYYY-MM-DD)，用于确定赎回费档位")
+	fs.String("min-trade", "", "最低交易金额(万元)，如最低申购额")
+	fs.String("current-override", "", "设为0取消手动覆盖，恢复按交易流水计算市值")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	if *fundID <= 0 {
+		return usageErrorf("--id 为必填参数")
+	}
+
+	// 参数名中的 - 对应字段名中的 _
+	set := setFlags(fs)
+	values := make(map[string]string)
+	var fields []string
+	for _, field := range []string{"name", "code", "current", "weight", "purchase_rate", "purchase_discount", "redemption_tiers", "held_since", "min_trade", "current_override"} {
+		name := strings.ReplaceAll(field, "_", "-")
+		if set[name] {
+			fields = append(fields, field)
+			values[field] = fs.Lookup(name).Value.String()
+		}
+	}
+	if len(fields) == 0 {
+		return usageErrorf("至少需要指定一个要修改的字段")
+	}
+
+	dbBuckets, err := getAllBucketsFromDB()
+	if err != nil {
+		return fmt.Errorf("获取桶信息失败: %v", err)
+	}
+	bi, fi, found := findDBFundByID(dbBuckets, *fundID)
+	if !found {
+		return notFoundErrorf("基金不存在: %d", *fundID)
+	}
+
+	// 先全部校验再写入，避免部分字段更新
+	bucket, fund := dbBuckets[bi], dbBuckets[bi].Funds[fi]
+	for _, field := range fields {
+		if err := checkFundField(bucket, fund, field, values[field]); err != nil {
+			return usageErrorf("%s: %v", field, err)
+		}
+	}
+	for _, field := range fields {
+		if err := store.UpdateFund(fund.ID, field, values[field]); err != nil {
+			return fmt.Errorf("更新基金失败: %v", err)
+		}
+	}
+	return writeMutationResult(out, *format, "基金信息更新成功")
+}
+
+func runDeleteFundCommand(args []string, out io.Writer) error {
+	fs, format := newFlagSet("delete-fund")
+	fundID := fs.Int("id", 0, "基金ID")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	if *fundID <= 0 {
+		return usageErrorf("--id 为必填参数")
+	}
+
+	dbBuckets, err := getAllBucketsFromDB()
+	if err != nil {
+		return fmt.Errorf("获取桶信息失败: %v", err)
+	}
+	bi, fi, found := findDBFundByID(dbBuckets, *fundID)
+	if !found {
+		return notFoundErrorf("基金不存在: %d", *fundID)
+	}
+
+	fund := dbBuckets[bi].Funds[fi]
+	if err := store.ArchiveFund(fund.ID); err != nil {
+		return fmt.Errorf("删除基金失败: %v", err)
+	}
+	return writeMutationResult(out, *format, "已归档基金: "+fund.Name+"，历史记录保留，可通过 restore-fund 恢复")
+}
+
+func runRestoreFundCommand(args []string, out io.Writer) error {
+	fs, format := newFlagSet("restore-fund")
+	fundID := fs.Int("id", 0, "基金ID")
+	bucketID := fs.Int("bucket-id", 0, "恢复到的桶ID，默认为原所在桶")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	if *fundID <= 0 {
+		return usageErrorf("--id 为必填参数")
+	}
+
+	fund, err := store.GetFundByID(*fundID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return notFoundErrorf("基金不存在: %d", *fundID)
+	}
+	if err != nil {
+		return fmt.Errorf("获取基金失败: %v", err)
+	}
+	dbBuckets, err := getAllBucketsFromDB()
+	if err != nil {
+		return fmt.Errorf("获取桶信息失败: %v", err)
+	}
+	bucket, err := checkFundRestore(dbBuckets, *fund, *bucketID)
+	if err != nil {
+		return usageErrorf("%v", err)
+	}
+
+	if err := store.RestoreFund(fund.ID, bucket.ID); err != nil {
+		return fmt.Errorf("恢复基金失败: %v", err)
+	}
+	return writeMutationResult(out, *format, "已恢复基金: "+fund.Name+" 到 "+bucket.Name)
+}
+
+func runAddBucketCommand(args []string, out io.Writer) error {
+	fs, format := newFlagSet("add-bucket")
+	name := fs.String("name", "", "桶名称")
+	targetRate := fs.Float64("target-rate", 0, "目标占比(0-1)，子桶为在父桶中的占比")
+	parentID := fs.Int("parent-id", 0, "父桶ID，不指定时为顶层桶")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+
+	dbBuckets, err := getAllBucketsFromDB()
+	if err != nil {
+		return fmt.Errorf("获取桶信息失败: %v", err)
+	}
+
+	*name = strings.TrimSpace(*name)
+	if err := checkBucketName(dbBuckets, 0, *name); err != nil {
+		return usageErrorf("%v", err)
+	}
+	if err := checkBucketParent(dbBuckets, 0, *parentID); err != nil {
+		return usageErrorf("%v", err)
+	}
+	if err := checkBucketRate(dbBuckets, 0, *parentID, *targetRate); err != nil {
+		return usageErrorf("%v", err)
+	}
+
+	if _, err := store.AddBucket(*name, *targetRate, *parentID); err != nil {
+		return fmt.Errorf("添加桶失败: %v", err)
+	}
+	return writeMutationResult(out, *format, "已添加桶: "+*name)
+}
+
+func runUpdateBucketCommand(args []string, out io.Writer) error {
+	fs, format := newFlagSet("update-bucket")
+	bucketID := fs.Int("id", 0, "桶ID")
+	fs.String("name", "", "新的桶名称")
+	fs.String("target-rate", "", "新的目标占比(0-1)，子桶为在父桶中的占比")
+	fs.String("parent-id", "", "新的父桶ID，0表示移为顶层桶")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	if *bucketID <= 0 {
+		return usageErrorf("--id 为必填参数")
+	}
+
+	set := setFlags(fs)
+	values := make(map[string]string)
+	if set["name"] {
+		values["name"] = fs.Lookup("name").Value.String()
+	}
+	if set["target-rate"] {
+		values["target_rate"] = fs.Lookup("target-rate").Value.String()
+	}
+	if set["parent-id"] {
+		values["parent_id"] = fs.Lookup("parent-id").Value.String()
+	}
+	if len(values) == 0 {
+		return usageErrorf("至少需要指定一个要修改的字段")
+	}
+
+	dbBuckets, err := getAllBucketsFromDB()
+	if err != nil {
+		return fmt.Errorf("获取桶信息失败: %v", err)
+	}
+	bi, found := findDBBucketByID(dbBuckets, *bucketID)
+	if !found {
+		return notFoundErrorf("桶不存在: %d", *bucketID)
+	}
+
+	// 同时修改父桶和目标占比时，按新的父桶和新的目标占比校验
+	bucket := dbBuckets[bi]
+	if rate, err := strconv.ParseFloat(values["target_rate"], 64); err == nil {
+		bucket.TargetRate = rate
+	}
+	for _, field := range []string{"parent_id", "name", "target_rate"} {
+		value, ok := values[field]
+		if !ok {
+			continue
+		}
+		value, err := checkBucketField(dbBuckets, bucket, field, value)
+		if err != nil {
+			return usageErrorf("%v", err)
+		}
+		values[field] = value
+		if field == "parent_id" {
+			bucket.ParentID, _ = strconv.Atoi(value)
+		}
+	}
+	for field, value := range values {
+		if err := store.UpdateBucket(*bucketID, field, value); err != nil {
+			return fmt.Errorf("更新桶失败: %v", err)
+		}
+	}
+	return writeMutationResult(out, *format, "桶信息更新成功")
+}
+
+func runSetTargetsCommand(args []string, out io.Writer) error {
+	fs, format := newFlagSet("set-targets")
+	targets := fs.String("targets", "", "所有桶的目标占比，格式: 桶ID=占比,桶ID=占比")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+
+	rates, err := parseIDValues(*targets)
+	if err != nil {
+		return usageErrorf("无效的目标占比: %v", err)
+	}
+
+	dbBuckets, err := getAllBucketsFromDB()
+	if err != nil {
+		return fmt.Errorf("获取桶信息失败: %v", err)
+	}
+
+	for _, b := range dbBuckets {
+		if _, exists := rates[b.ID]; !exists {
+			return usageErrorf("缺少桶的目标占比: [%d] %s", b.ID, b.Name)
+		}
+	}
+	if len(rates) != len(dbBuckets) {
+		return usageErrorf("包含不存在的桶ID")
+	}
+	if err := validateNewTargetRates(dbBuckets, rates); err != nil {
+		return usageErrorf("%v", err)
+	}
+
+	if err := store.UpdateBucketTargetRates(rates); err != nil {
+		return fmt.Errorf("更新目标占比失败: %v", err)
+	}
+	return writeMutationResult(out, *format, "目标占比更新成功")
+}
+
+func runDeleteBucketCommand(args []string, out io.Writer) error {
+	fs, format := newFlagSet("delete-bucket")
+	bucketID := fs.Int("id", 0, "桶ID")
+	moveToID := fs.Int("move-to", 0, "桶内基金及目标占比并入的桶ID")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	if *bucketID <= 0 {
+		return usageErrorf("--id 为必填参数")
+	}
+
+	dbBuckets, err := getAllBucketsFromDB()
+	if err != nil {
+		return fmt.Errorf("获取桶信息失败: %v", err)
+	}
+	bi, found := findDBBucketByID(dbBuckets, *bucketID)
+	if !found {
+		return notFoundErrorf("桶不存在: %d", *bucketID)
+	}
+
+	moveToIndex := -1
+	if *moveToID > 0 {
+		mi, found := findDBBucketByID(dbBuckets, *moveToID)
+		if !found {
+			return notFoundErrorf("迁移目标桶不存在: %d", *moveToID)
+		}
+		moveToIndex = mi
+	}
+
+	moveTo, err := checkBucketDelete(dbBuckets, bi, moveToIndex)
+	if err != nil {
+		return usageErrorf("%v", err)
+	}
+
+	bucket := dbBuckets[bi]
+	if err := store.DeleteBucket(bucket, moveTo); err != nil {
+		return fmt.Errorf("删除桶失败: %v", err)
+	}
+
+	message := "已删除桶: " + bucket.Name
+	if moveTo != nil {
+		message += "，基金及目标占比已并入: " + moveTo.Name
+	}
+	return writeMutationResult(out, *format, message)
+}
+
+func runTransactionsCommand(args []string, out io.Writer) error {
+	fs, format := newFlagSet("transactions")
+	fundID := fs.Int("fund-id", 0, "基金ID")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	if *fundID <= 0 {
+		return usageErrorf("--fund-id 为必填参数")
+	}
+	if err := checkFundExistsCommand(*fundID); err != nil {
+		return err
+	}
+
+	return writeFundLedger(out, *format, *fundID)
+}
+
+func runAddTransactionCommand(args []string, out io.Writer) error {
+	fs, format := newFlagSet("add-transaction")
+	fundID := fs.Int("fund-id", 0, "基金ID")
+	txType := fs.String("type", "", "交易类型: buy|sell|dividend|conversion|fee")
+	date := fs.String("date", time.Now().Format(dateLayout), "交易日期(YYYY-MM-DD)，默认今天")
+	shares := fs.Float64("shares", 0, "份额，基金转换时转出为负数")
+	nav := fs.Float64("nav", 0, "成交净值(元/份)")
+	amount := fs.Float64("amount", 0, "金额(万元)，买入卖出时默认为 份额×净值")
+	note := fs.String("note", "", "备注")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	if *fundID <= 0 || *txType == "" {
+		return usageErrorf("--fund-id、--type 为必填参数")
+	}
+	if err := checkFundExistsCommand(*fundID); err != nil {
+		return err
+	}
+
+	t := Transaction{FundID: *fundID, Type: *txType, TradeDate: *date, Shares: *shares, NAV: *nav, Amount: *amount, Note: *note}
+	if err := checkTransaction(&t); err != nil {
+		return usageErrorf("%v", err)
+	}
+	txs, err := store.GetTransactionsByFundID(*fundID)
+	if err != nil {
+		return fmt.Errorf("获取交易流水失败: %v", err)
+	}
+	if err := checkLedger(append(txs, t)); err != nil {
+		return usageErrorf("%v", err)
+	}
+
+	id, err := store.AddTransaction(t)
+	if err != nil {
+		return fmt.Errorf("添加交易流水失败: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "✅ 交易流水已添加，ID: %d\n", id)
+	return writeFundLedger(out, *format, *fundID)
+}
+
+func runDeleteTransactionCommand(args []string, out io.Writer) error {
+	fs, format := newFlagSet("delete-transaction")
+	id := fs.Int("id", 0, "交易流水ID")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	if *id <= 0 {
+		return usageErrorf("--id 为必填参数")
+	}
+
+	t, err := store.GetTransactionByID(*id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return notFoundErrorf("交易流水不存在: %d", *id)
+	}
+	if err != nil {
+		return fmt.Errorf("获取交易流水失败: %v", err)
+	}
+
+	txs, err := store.GetTransactionsByFundID(t.FundID)
+	if err != nil {
+		return fmt.Errorf("获取交易流水失败: %v", err)
+	}
+	remaining := make([]Transaction, 0, len(txs))
+	for _, other := range txs {
+		if other.ID != t.ID {
+			remaining = append(remaining, other)
+		}
+	}
+	if err := checkLedger(remaining); err != nil {
+		return usageErrorf("删除后%v", err)
+	}
+
+	if err := store.DeleteTransaction(t.ID); err != nil {
+		return fmt.Errorf("删除交易流水失败: %v", err)
+	}
+	fmt.Fprintln(os.Stderr, "✅ 交易流水已删除")
+	return writeFundLedger(out, *format, t.FundID)
+}
+
+func runImportNAVsCommand(args []string, out io.Writer) error {
+	fs, format := newFlagSet("import-navs")
+	file := fs.String("file", "", "净值文件路径，- 表示从标准输入读取")
+	code := fs.String("code", "", "基金代码，文件中没有基金代码列时必填")
+	inputFormat := fs.String("input-format", "", "文件格式: csv|json，默认自动识别")
+	gapDays := fs.Int("gap-days", 1, "报告缺口的最少缺失工作日数")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	if *file == "" {
+		return usageErrorf("--file 为必填参数")
+	}
+	if *gapDays < 1 {
+		return usageErrorf("--gap-days 必须是正整数")
+	}
+
+	var data []byte
+	var err error
+	if *file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(*file)
+	}
+	if err != nil {
+		return usageErrorf("读取净值文件失败: %v", err)
+	}
+
+	navs, err := parseNAVFile(data, *inputFormat, strings.TrimSpace(*code))
+	if err != nil {
+		return usageErrorf("%v", err)
+	}
+	result, err := importFundNAVs(navs, *gapDays)
+	if err != nil {
+		return fmt.Errorf("导入净值失败: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "✅ %s\n", navImportSummary(result))
+
+	switch *format {
+	case "json":
+		return writeJSON(out, result)
+	case "csv":
+		var rows [][]string
+		for _, gap := range result.Gaps {
+			rows = append(rows, []string{gap.Code, gap.From, gap.To, strconv.Itoa(gap.MissingDays)})
+		}
+		return writeCSV(out, []string{"code", "from", "to", "missing_days"}, rows)
+	default:
+		if len(result.Gaps) == 0 {
+			fmt.Fprintln(out, "未发现净值缺口")
+			return nil
+		}
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "基金代码\t缺口起\t缺口止\t缺失工作日")
+		for _, gap := range result.Gaps {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\n", gap.Code, gap.From, gap.To, gap.MissingDays)
+		}
+		return tw.Flush()
+	}
+}
+
+func runFetchNAVsCommand(args []string, out io.Writer) error {
+	cfg := appConfig.NAV
+
+	fs, format := newFlagSet("fetch-navs")
+	fs.StringVar(&cfg.Type, "provider", cfg.Type, "净值数据源: http|stub，默认取自配置")
+	fs.StringVar(&cfg.URL, "url", cfg.URL, "http 数据源地址，{code} 替换为基金代码，默认取自配置")
+	fs.StringVar(&cfg.Mapping, "mapping", cfg.Mapping, "http 响应字段映射，默认取自配置")
+	fs.StringVar(&cfg.File, "file", cfg.File, "stub 数据源的净值文件，默认取自配置")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	if cfg.Type == "" {
+		return usageErrorf("未配置净值数据源，请指定 --provider，或在配置文件的 nav_provider 中设置(也可使用 NAV_PROVIDER 环境变量)")
+	}
+
+	provider, err := newNAVProvider(cfg)
+	if err != nil {
+		return usageErrorf("%v", err)
+	}
+	result, err := fetchLatestNAVs(context.Background(), provider)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "✅ %s\n", navFetchSummary(result))
+
+	switch *format {
+	case "json":
+		return writeJSON(out, result)
+	case "csv":
+		var rows [][]string
+		for _, nav := range result.NAVs {
+			rows = append(rows, []string{nav.Code, nav.Date, formatFloat(nav.UnitNAV), formatFloat(nav.AccNAV), ""})
+		}
+		for _, failed := range result.Failed {
+			rows = append(rows, []string{failed.Code, "", "", "", failed.Error})
+		}
+		return writeCSV(out, []string{"code", "date", "unit_nav", "acc_nav", "error"}, rows)
+	default:
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "基金代码\t净值日期\t单位净值\t累计净值")
+		for _, nav := range result.NAVs {
+			fmt.Fprintf(tw, "%s\t%s\t%.4f\t%.4f\n", nav.Code, nav.Date, nav.UnitNAV, nav.AccNAV)
+		}
+		for _, failed := range result.Failed {
+			fmt.Fprintf(tw, "%s\t获取失败: %s\t\t\n", failed.Code, failed.Error)
+		}
+		return tw.Flush()
+	}
+}
+
+func runNAVStubServerCommand(args []string, out io.Writer) error {
+	fs, _ := newFlagSet("nav-stub-server")
+	file := fs.String("file", "", "净值文件(CSV或JSON，需包含基金代码列)")
+	addr := fs.String("addr", ":9090", "监听地址")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *file == "" {
+		return usageErrorf("--file 为必填参数")
+	}
+
+	provider, err := newStubNAVProvider(*file)
+	if err != nil {
+		return usageErrorf("%v", err)
+	}
+	fmt.Fprintf(os.Stderr, "📡 本地替身净值服务已启动: http://localhost%s/nav/{code}\n", *addr)
+	return http.ListenAndServe(*addr, newNAVStubHandler(provider))
+}
+
+func runNAVsCommand(args []string, out io.Writer) error {
+	fs, format := newFlagSet("navs")
+	code := fs.String("code", "", "基金代码")
+	from := fs.String("from", "", "开始日期(YYYY-MM-DD)")
+	to := fs.String("to", "", "结束日期(YYYY-MM-DD)")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	if *code == "" {
+		return usageErrorf("--code 为必填参数")
+	}
+	for _, value := range []string{*from, *to} {
+		if value == "" {
+			continue
+		}
+		if _, err := parseDate(value); err != nil {
+			return usageErrorf("%v", err)
+		}
+	}
+
+	navs, err := store.GetFundNAVs(*code, *from, *to)
+	if err != nil {
+		return fmt.Errorf("获取净值失败: %v", err)
+	}
+
+	switch *format {
+	case "json":
+		return writeJSON(out, navs)
+	case "csv":
+		var rows [][]string
+		for _, nav := range navs {
+			rows = append(rows, []string{nav.Code, nav.Date, formatFloat(nav.UnitNAV), formatFloat(nav.AccNAV)})
+		}
+		return writeCSV(out, []string{"code", "date", "unit_nav", "acc_nav"}, rows)
+	default:
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "日期\t单位净值\t累计净值")
+		for _, nav := range navs {
+			fmt.Fprintf(tw, "%s\t%.4f\t%.4f\n", nav.Date, nav.UnitNAV, nav.AccNAV)
+		}
+		return tw.Flush()
+	}
+}
+
+func runImportValuationsCommand(args []string, out io.Writer) error {
+	fs, format := newFlagSet("import-valuations")
+	file := fs.String("file", "", "估值文件路径，- 表示从标准输入读取")
+	code := fs.String("code", "", "指数代码，文件中没有指数代码列时必填")
+	metric := fs.String("metric", ValuationMetricPE, "估值指标 pe|pb，文件中没有指标列时使用")
+	inputFormat := fs.String("input-format", "", "文件格式: csv|json，默认自动识别")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	if *file == "" {
+		return usageErrorf("--file 为必填参数")
+	}
+
+	var data []byte
+	var err error
+	if *file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(*file)
+	}
+	if err != nil {
+		return usageErrorf("读取估值文件失败: %v", err)
+	}
+
+	valuations, err := parseValuationFile(data, *inputFormat, strings.TrimSpace(*code), strings.ToLower(*metric))
+	if err != nil {
+		return usageErrorf("%v", err)
+	}
+	result, err := importIndexValuations(valuations)
+	if err != nil {
+		return fmt.Errorf("导入估值失败: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "✅ %s\n", valuationImportSummary(result))
+
+	if *format == "json" {
+		return writeJSON(out, result)
+	}
+	// 列出每个指数和指标导入的最新估值
+	unique, _ := dedupValuations(valuations)
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "指数代码\t指标\t最新日期\t指标值\t分位")
+	for i, v := range unique {
+		if i+1 < len(unique) && unique[i+1].IndexCode == v.IndexCode && unique[i+1].Metric == v.Metric {
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%.2f\t%.1f%%\n", v.IndexCode, metricLabel(v.Metric), v.Date, v.Value, v.Percentile*100)
+	}
+	return tw.Flush()
+}
+
+func runValuationsCommand(args []string, out io.Writer) error {
+	fs, format := newFlagSet("valuations")
+	code := fs.String("code", "", "指数代码")
+	metric := fs.String("metric", ValuationMetricPE, "估值指标 pe|pb")
+	from := fs.String("from", "", "开始日期(YYYY-MM-DD)")
+	to := fs.String("to", "", "结束日期(YYYY-MM-DD)")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	if *code == "" {
+		return usageErrorf("--code 为必填参数")
+	}
+	if !validValuationMetric(*metric) {
+		return usageErrorf("无效的估值指标: %s（可选 %s、%s）", *metric, ValuationMetricPE, ValuationMetricPB)
+	}
+	for _, value := range []string{*from, *to} {
+		if value == "" {
+			continue
+		}
+		if _, err := parseDate(value); err != nil {
+			return usageErrorf("%v", err)
+		}
+	}
+
+	valuations, err := store.GetIndexValuations(*code, *metric, *from, *to)
+	if err != nil {
+		return fmt.Errorf("获取估值失败: %v", err)
+	}
+
+	switch *format {
+	case "json":
+		return writeJSON(out, valuations)
+	case "csv":
+		var rows [][]string
+		for _, v := range valuations {
+			rows = append(rows, []string{v.IndexCode, v.Metric, v.Date, formatFloat(v.Value), formatFloat(v.Percentile)})
+		}
+		return writeCSV(out, []string{"index_code", "metric", "date", "value", "percentile"}, rows)
+	default:
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "日期\t%s\t分位\n", metricLabel(*metric))
+		for _, v := range valuations {
+			fmt.Fprintf(tw, "%s\t%.2f\t%.1f%%\n", v.Date, v.Value, v.Percentile*100)
+		}
+		return tw.Flush()
+	}
+}
+
+func runValuationRulesCommand(args []string, out io.Writer) error {
+	fs, format := newFlagSet("valuation-rules")
+	date := fs.String("date", "", "按该日期的估值计算目标占比(YYYY-MM-DD)，默认今天")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	if *date == "" {
+		*date = time.Now().Format(dateLayout)
+	} else if _, err := parseDate(*date); err != nil {
+		return usageErrorf("%v", err)
+	}
+
+	view, err := getValuationRulesView(*date)
+	if err != nil {
+		return err
+	}
+	return writeValuationRules(out, *format, view)
+}
+
+// 输出估值规则及其对目标占比的调整
+func writeValuationRules(out io.Writer, format string, view *ValuationRulesView) error {
+	switch format {
+	case "json":
+		return writeJSON(out, view)
+	case "csv":
+		var rows [][]string
+		for _, r := range view.Rules {
+			rows = append(rows, []string{
+				strconv.Itoa(r.ID), strconv.Itoa(r.BucketID), r.IndexCode, r.Metric,
+				formatFloat(r.MinPercentile), formatFloat(r.MaxPercentile), formatFloat(r.TargetRate),
+			})
+		}
+		return writeCSV(out, []string{"id", "bucket_id", "index_code", "metric", "min_percentile", "max_percentile", "target_rate"}, rows)
+	default:
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "规则ID\t桶ID\t规则")
+		for _, r := range view.Rules {
+			fmt.Fprintf(tw, "%d\t%d\t%s\n", r.ID, r.BucketID, r)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+
+		if view.Error != "" {
+			fmt.Fprintf(out, "\n⚠️  按 %s 的估值无法应用规则: %s\n", view.Date, view.Error)
+			return nil
+		}
+		if len(view.Adjustments) > 0 {
+			fmt.Fprintf(out, "\n按 %s 的估值计算的目标占比:\n", view.Date)
+			for _, d := range targetAdjustmentDiagnostics(view.Adjustments) {
+				fmt.Fprintf(out, "  %s\n", d)
+			}
+		}
+		return nil
+	}
+}
+
+func runAddValuationRuleCommand(args []string, out io.Writer) error {
+	fs, format := newFlagSet("add-valuation-rule")
+	bucketID := fs.Int("bucket-id", 0, "桶ID")
+	code := fs.String("code", "", "指数代码，如 000300")
+	metric := fs.String("metric", ValuationMetricPE, "估值指标 pe|pb")
+	minPercentile := fs.Float64("min-percentile", 0, "分位区间下限(含)，0-1")
+	maxPercentile := fs.Float64("max-percentile", 1, "分位区间上限(不含，为1时含)，0-1")
+	targetRate := fs.Float64("target-rate", -1, "估值分位在区间内时桶使用的目标占比，子桶为在父桶中的占比")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	if *bucketID <= 0 || *code == "" || *targetRate < 0 {
+		return usageErrorf("--bucket-id、--code 和 --target-rate 为必填参数")
+	}
+
+	dbBuckets, err := getAllBucketsFromDB()
+	if err != nil {
+		return fmt.Errorf("获取桶信息失败: %v", err)
+	}
+	if _, found := findDBBucketByID(dbBuckets, *bucketID); !found {
+		return notFoundErrorf("桶不存在: %d", *bucketID)
+	}
+
+	rule := ValuationRule{
+		BucketID:      *bucketID,
+		IndexCode:     strings.TrimSpace(*code),
+		Metric:        strings.ToLower(*metric),
+		MinPercentile: *minPercentile,
+		MaxPercentile: *maxPercentile,
+		TargetRate:    *targetRate,
+	}
+	id, err := addValuationRule(dbBuckets, rule)
+	if err != nil {
+		return usageErrorf("%v", err)
+	}
+	fmt.Fprintf(os.Stderr, "✅ 已添加估值规则 #%d: 桶 %d，%s\n", id, rule.BucketID, rule)
+	return writeCurrentValuationRules(out, *format)
+}
+
+func runDeleteValuationRuleCommand(args []string, out io.Writer) error {
+	fs, format := newFlagSet("delete-valuation-rule")
+	id := fs.Int("id", 0, "规则ID")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	if *id <= 0 {
+		return usageErrorf("--id 为必填参数")
+	}
+
+	rule, err := findValuationRule(*id)
+	if err != nil {
+		return fmt.Errorf("获取估值规则失败: %v", err)
+	}
+	if rule == nil {
+		return notFoundErrorf("估值规则不存在: %d", *id)
+	}
+	if err := store.DeleteValuationRule(*id); err != nil {
+		return fmt.Errorf("删除估值规则失败: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "✅ 已删除估值规则 #%d: %s\n", rule.ID, rule)
+	return writeCurrentValuationRules(out, *format)
+}
+
+// 修改估值规则后输出最新的规则及今天的目标占比
+func writeCurrentValuationRules(out io.Writer, format string) error {
+	view, err := getValuationRulesView(time.Now().Format(dateLayout))
+	if err != nil {
+		return err
+	}
+	return writeValuationRules(out, format, view)
+}
+
+func runGlidePathsCommand(args []string, out io.Writer) error {
+	fs, format := newFlagSet("glide-paths")
+	from := fs.String("from", "", "投影的开始日期(YYYY-MM-DD)，默认今天")
+	to := fs.String("to", "", "投影的结束日期(YYYY-MM-DD)，默认为最后一个下滑路径点")
+	step := fs.String("step", "year", "投影的步长 year|quarter|month")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	months, err := glideStepMonths(*step)
+	if err != nil {
+		return usageErrorf("%v", err)
+	}
+	for _, value := range []string{*from, *to} {
+		if value == "" {
+			continue
+		}
+		if _, err := parseDate(value); err != nil {
+			return usageErrorf("%v", err)
+		}
+	}
+
+	projection, err := getGlideProjection(*from, *to, months)
+	if err != nil {
+		return err
+	}
+	return writeGlideProjection(out, *format, projection)
+}
+
+// 输出下滑路径及其投影
+func writeGlideProjection(out io.Writer, format string, projection *GlideProjection) error {
+	switch format {
+	case "json":
+		return writeJSON(out, projection)
+	case "csv":
+		var rows [][]string
+		for _, p := range projection.Points {
+			age := ""
+			if projection.BirthDate != "" {
+				age = formatFloat(p.Age)
+			}
+			for _, t := range p.Targets {
+				rows = append(rows, []string{
+					p.Date, age, strconv.Itoa(t.BucketID), t.Name, formatFloat(t.TargetRate), formatFloat(t.EffectiveRate),
+				})
+			}
+		}
+		return writeCSV(out, []string{"date", "age", "bucket_id", "bucket", "target_rate", "effective_rate"}, rows)
+	default:
+		tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "桶ID\t基准\t下滑路径")
+		for _, p := range projection.Paths {
+			fmt.Fprintf(tw, "%d\t%s\t%s\n", p.BucketID, p.Basis, p)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		if len(projection.Points) == 0 {
+			return nil
+		}
+
+		// 每个时间点一行，列出各桶的目标占比(子桶为在父桶中的占比)
+		fmt.Fprintln(out, "\n目标占比投影:")
+		tw = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		header := "日期"
+		if projection.BirthDate != "" {
+			header += "\t年龄"
+		}
+		for _, t := range projection.Points[0].Targets {
+			header += "\t" + t.Name
+		}
+		fmt.Fprintln(tw, header)
+		for _, p := range projection.Points {
+			line := p.Date
+			if projection.BirthDate != "" {
+				line += fmt.Sprintf("\t%.1f", p.Age)
+			}
+			for _, t := range p.Targets {
+				line += fmt.Sprintf("\t%.1f%%", t.TargetRate*100)
+			}
+			fmt.Fprintln(tw, line)
+		}
+		return tw.Flush()
+	}
+}
+
+func runSetGlidePathCommand(args []string, out io.Writer) error {
+	fs, format := newFlagSet("set-glide-path")
+	bucketID := fs.Int("bucket-id", 0, "桶ID")
+	basis := fs.String("basis", GlideBasisDate, "时间基准 date|age，按年龄时需配置出生日期")
+	points := fs.String("points", "", "下滑路径的点，如 2025-01-01=0.8,2035-01-01=0.5 或 40=0.8,60=0.4")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	if *bucketID <= 0 || *points == "" {
+		return usageErrorf("--bucket-id 和 --points 为必填参数")
+	}
+
+	dbBuckets, err := getAllBucketsFromDB()
+	if err != nil {
+		return fmt.Errorf("获取桶信息失败: %v", err)
+	}
+	bi, found := findDBBucketByID(dbBuckets, *bucketID)
+	if !found {
+		return notFoundErrorf("桶不存在: %d", *bucketID)
+	}
+	path := GlidePath{BucketID: *bucketID, Basis: strings.ToLower(*basis)}
+	if path.Points, err = parseGlidePoints(path.Basis, *points); err != nil {
+		return usageErrorf("%v", err)
+	}
+	paths, err := store.GetGlidePaths()
+	if err != nil {
+		return fmt.Errorf("获取下滑路径失败: %v", err)
+	}
+	if err := validateGlidePath(&path, dbBuckets, paths, appConfig.BirthDate); err != nil {
+		return usageErrorf("%v", err)
+	}
+	if err := store.SetGlidePath(path); err != nil {
+		return fmt.Errorf("保存下滑路径失败: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "✅ 已设置 %s 的下滑路径: %s\n", dbBuckets[bi].Name, path)
+	return writeCurrentGlideProjection(out, *format)
+}
+
+func runDeleteGlidePathCommand(args []string, out io.Writer) error {
+	fs, format := newFlagSet("delete-glide-path")
+	bucketID := fs.Int("bucket-id", 0, "桶ID")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	if *bucketID <= 0 {
+		return usageErrorf("--bucket-id 为必填参数")
+	}
+
+	paths, err := store.GetGlidePaths()
+	if err != nil {
+		return fmt.Errorf("获取下滑路径失败: %v", err)
+	}
+	if len(withoutGlidePath(paths, *bucketID)) == len(paths) {
+		return notFoundErrorf("桶 %d 没有下滑路径", *bucketID)
+	}
+	if err := store.DeleteGlidePath(*bucketID); err != nil {
+		return fmt.Errorf("删除下滑路径失败: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "✅ 已删除桶 %d 的下滑路径，恢复使用配置的目标占比\n", *bucketID)
+	return writeCurrentGlideProjection(out, *format)
+}
+
+// 修改下滑路径后输出最新的下滑路径及从今天开始的投影
+func writeCurrentGlideProjection(out io.Writer, format string) error {
+	projection, err := getGlideProjection("", "", 12)
+	if err != nil {
+		return err
+	}
+	return writeGlideProjection(out, format, projection)
+}
+
+// 检查基金是否存在
+// 检查基金是否存在，包括已归档的基金
+func checkFundExistsCommand(fundID int) error {
+	_, err := store.GetFundByID(fundID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return notFoundErrorf("基金不存在: %d", fundID)
+	}
+	if err != nil {
+		return fmt.Errorf("获取基金失败: %v", err)
+	}
+	return nil
+}
+
+func runRebalanceCommand(args []string, out io.Writer) error {
+	fs, format := newFlagSet("rebalance")
+	strategy := fs.String("strategy", defaultStrategyName, "再平衡策略，可选: "+strings.Join(strategyNames(), ", "))
+	threshold := fs.Float64("threshold", appConfig.DefaultThreshold, "再平衡触发阈值，默认取自配置")
+	fundAbsBand := fs.Float64("fund-abs-band", 0, "基金层面绝对偏离带，如0.05表示±5个百分点，0为不启用")
+	fundRelBand := fs.Float64("fund-rel-band", 0, "基金层面相对偏离带，如0.25表示偏离目标占比±25%，0为不启用")
+	mode := fs.String("mode", "", "再平衡模式: target 完全调回目标，band_edge 只调回偏离带边缘，cash_flow 只用投入/取出的资金调整")
+	amount := fs.Float64("amount", 0, "cash_flow 模式下投入(正数)或取出(负数)的金额(万元)，指定后默认使用 cash_flow 模式")
+	innerBand := fs.Float64("inner-band", 0, "band_edge 模式下调回到偏离带的比例位置，如0.5表示调回一半偏离带处，默认为边缘")
+	maxFeeRate := fs.Float64("max-fee-rate", 0, "费用占交易金额的比例超过该值时跳过该笔交易，如0.01表示1%，0为不跳过")
+	minTrade := fs.Float64("min-trade", 0, "最低交易金额(万元)，低于该金额的交易被取消，如0.01表示100元")
+	roundStep := fs.Float64("round-step", 0, "交易金额取整步长(万元)，如0.01表示取整到百元，0为不取整")
+	dryRun := fs.Bool("dry-run", false, "只预览，不保存结果")
+	staticTargets := fs.Bool("static-targets", false, "不按下滑路径和估值规则调整，直接使用配置的目标占比")
+	setTargets := fs.String("set-target", "", "假设的桶目标占比，格式: 桶ID=占比,...（仅预览）")
+	setCurrents := fs.String("set-current", "", "假设的基金市值，格式: 基金ID=市值,...（仅预览）")
+	setWeights := fs.String("set-weight", "", "假设的基金权重，格式: 基金ID=权重,...（仅预览）")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	var overrides *RebalanceOverrides
+	if *setTargets != "" || *setCurrents != "" || *setWeights != "" {
+		if !*dryRun {
+			return usageErrorf("--set-target/--set-current/--set-weight 只能与 --dry-run 一起使用")
+		}
+		overrides = &RebalanceOverrides{}
+		var err error
+		if overrides.TargetRates, err = parseIDValues(*setTargets); err != nil {
+			return usageErrorf("无效的 --set-target: %v", err)
+		}
+		if overrides.Currents, err = parseIDValues(*setCurrents); err != nil {
+			return usageErrorf("无效的 --set-current: %v", err)
+		}
+		if overrides.Weights, err = parseIDValues(*setWeights); err != nil {
+			return usageErrorf("无效的 --set-weight: %v", err)
+		}
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	if *threshold <= 0 {
+		return usageErrorf("阈值必须大于0")
+	}
+	opts := RebalanceOptions{
+		Strategy:   *strategy,
+		Threshold:  *threshold,
+		FundBand:   DriftBand{Absolute: *fundAbsBand, Relative: *fundRelBand},
+		Mode:       *mode,
+		InnerBand:  *innerBand,
+		CashFlow:   *amount,
+		MaxFeeRate: *maxFeeRate,
+		MinTrade:   *minTrade,
+		RoundStep:  *roundStep,
+	}
+	if err := normalizeRebalanceOptions(&opts); err != nil {
+		return usageErrorf("%v", err)
+	}
+
+	dbBuckets, err := getAllBucketsFromDB()
+	if err != nil {
+		return fmt.Errorf("获取基金配置失败: %v", err)
+	}
+
+	buckets := convertDBBucketsToAPIBuckets(dbBuckets)
+	// 先按下滑路径和估值规则调整目标占比，假设性调整在其后应用
+	var adjustments []TargetAdjustment
+	if !*staticTargets {
+		if adjustments, err = applyDynamicTargets(buckets, time.Now().Format(dateLayout)); err != nil {
+			return usageErrorf("%v", err)
+		}
+	}
+	if err := applyRebalanceOverrides(buckets, overrides); err != nil {
+		return usageErrorf("%v", err)
+	}
+	if err := checkRebalanceInput(buckets, opts); err != nil {
+		return usageErrorf("%v", err)
+	}
+
+	if *dryRun {
+		result, err := rebalance(buckets, opts)
+		if err != nil {
+			return fmt.Errorf("再平衡计算失败: %v", err)
+		}
+		result.Diagnostics = append(targetAdjustmentDiagnostics(adjustments), result.Diagnostics...)
+		fmt.Fprintf(os.Stderr, "ℹ️  预览模式，结果未保存，策略 %s，%s\n", opts.Strategy, rebalanceSummary(result.Buckets))
+		writeDiagnostics(result.Diagnostics)
+		return writeRebalanceResults(out, *format, result.Buckets)
+	}
+
+	result, recordID, err := rebalanceAndRecord(buckets, opts, adjustments, RebalanceTriggerManual)
+	if err != nil {
+		return fmt.Errorf("保存再平衡记录失败: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "✅ 再平衡记录已保存，ID: %d，策略 %s，%s\n", recordID, opts.Strategy, rebalanceSummary(result.Buckets))
+	writeDiagnostics(result.Diagnostics)
+
+	return writeRebalanceResults(out, *format, result.Buckets)
+}
+
+// 把策略的诊断信息输出到标准错误，不影响标准输出中的结果
+func writeDiagnostics(diagnostics []string) {
+	for _, d := range diagnostics {
+		fmt.Fprintf(os.Stderr, "   · %s\n", d)
+	}
+}
+
+func runScheduleCommand(args []string, out io.Writer) error {
+	fs, format := newFlagSet("schedule")
+	count := fs.Int("count", 5, "列出之后的日历执行次数(最多50)")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	if *count <= 0 || *count > maxScheduleCount {
+		return usageErrorf("--count 须为1到%d之间的整数", maxScheduleCount)
+	}
+
+	status, err := getScheduleStatus(time.Now(), *count)
+	if err != nil {
+		return err
+	}
+	return writeScheduleStatus(out, *format, status)
+}
+
+// 输出定时再平衡配置及之后的日历执行时间
+func writeScheduleStatus(out io.Writer, format string, status ScheduleStatus) error {
+	switch format {
+	case "json":
+		return writeJSON(out, status)
+	case "csv":
+		var rows [][]string
+		for _, run := range status.Upcoming {
+			rows = append(rows, []string{run.Format("2006-01-02 15:04"), RebalanceTriggerScheduled})
+		}
+		return writeCSV(out, []string{"run_at", "trigger"}, rows)
+	default:
+		if !status.Enabled {
+			fmt.Fprintln(out, "未配置定时再平衡（配置文件 schedule 或 FUND_SCHEDULE_* 环境变量）")
+		}
+		if status.Calendar != "" {
+			fmt.Fprintf(out, "日历: %s\n", status.Calendar)
+		}
+		if status.Drift {
+			fmt.Fprintf(out, "偏离检查: 每 %s，冷却时间 %s\n", status.CheckInterval, status.Cooldown)
+		}
+		fmt.Fprintf(out, "策略 %s，阈值 %.1f%%\n", status.Strategy, status.Threshold*100)
+		if r := status.LastRecord; r != nil {
+			fmt.Fprintf(out, "最近一次再平衡: #%d %s(%s)\n", r.ID, r.CreatedAt.Format("2006-01-02 15:04:05"), triggerLabel(r.Trigger))
+		}
+		if len(status.Upcoming) > 0 {
+			fmt.Fprintln(out, "之后的执行时间:")
+			for _, run := range status.Upcoming {
+				fmt.Fprintf(out, "  %s\n", run.Format("2006-01-02 15:04 (Mon)"))
+			}
+		}
+		return nil
+	}
+}
+
+func runMigrateCommand(args []string, out io.Writer) error {
+	fs, format := newFlagSet("migrate")
+	noBackup := fs.Bool("no-backup", false, "迁移前不备份数据库")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageErrorf("需要指定 status 或 up")
+	}
+
+	switch positional[0] {
+	case "status":
+		status, err := store.SchemaStatus()
+		if err != nil {
+			return fmt.Errorf("获取数据库结构版本失败: %v", err)
+		}
+		return writeSchemaStatus(out, *format, status)
+	case "up":
+		result, err := store.Migrate(!*noBackup)
+		if err != nil {
+			return err
+		}
+		if len(result.Applied) == 0 {
+			fmt.Fprintf(os.Stderr, "✅ 数据库结构已是最新版本 %d\n", result.ToVersion)
+		} else {
+			fmt.Fprintf(os.Stderr, "✅ 数据库结构已从版本 %d 升级到 %d\n", result.FromVersion, result.ToVersion)
+		}
+		if result.Backup != "" {
+			fmt.Fprintf(os.Stderr, "迁移前的数据已备份到 %s\n", result.Backup)
+		}
+		if *format == "json" {
+			return writeJSON(out, result)
+		}
+		return writeMigrations(out, *format, result.Applied)
+	default:
+		return usageErrorf("未知参数: %s", positional[0])
+	}
+}
+
+// 输出数据库结构版本
+func writeSchemaStatus(out io.Writer, format string, status *SchemaStatus) error {
+	if format == "json" {
+		return writeJSON(out, status)
+	}
+	if format == "table" {
+		fmt.Fprintf(out, "当前版本 %d | 最新版本 %d\n\n", status.CurrentVersion, status.LatestVersion)
+	}
+	return writeMigrations(out, format, status.Migrations)
+}
+
+// 输出迁移列表
+func writeMigrations(out io.Writer, format string, migrations []MigrationStatus) error {
+	if format == "csv" {
+		var rows [][]string
+		for _, m := range migrations {
+			rows = append(rows, []string{strconv.Itoa(m.Version), m.Name, strconv.FormatBool(m.Applied), m.AppliedAt})
+		}
+		return writeCSV(out, []string{"version", "name", "applied", "applied_at"}, rows)
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "版本\t名称\t状态\t执行时间")
+	for _, m := range migrations {
+		state := "待执行"
+		if m.Applied {
+			state = "已执行"
+		}
+		fmt.Fprintf(tw, "%04d\t%s\t%s\t%s\n", m.Version, m.Name, state, m.AppliedAt)
+	}
+	return tw.Flush()
+}
+
+func runHistoryCommand(args []string, out io.Writer) error {
+	fs, format := newFlagSet("history")
+	limit := fs.Int("limit", appConfig.HistoryLimit, "显示的记录数，默认取自配置")
+	positional, err := parseFlags(fs, args)
+	if err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+
+	if len(positional) == 0 {
+		if *limit <= 0 {
+			return usageErrorf("--limit 必须大于0")
+		}
+		records, err := store.GetRebalanceHistory(*limit)
+		if err != nil {
+			return fmt.Errorf("获取历史记录失败: %v", err)
+		}
+		return writeRecords(out, *format, records)
+	}
+
+	switch {
+	case positional[0] == "replay" && len(positional) == 2:
+		return runHistoryReplay(positional[1], *format, out)
+	case positional[0] == "diff" && len(positional) == 3:
+		return runHistoryDiff(positional[1], positional[2], *format, out)
+	case positional[0] != "show" || len(positional) != 2:
+		return usageErrorf("未知参数: %s", strings.Join(positional, " "))
+	}
+
+	recordID, err := strconv.Atoi(positional[1])
+	if err != nil {
+		return usageErrorf("无效的记录ID: %s", positional[1])
+	}
+
+	detail, err := getRebalanceDetail(recordID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return notFoundErrorf("记录不存在: %d", recordID)
+	}
+	if err != nil {
+		return fmt.Errorf("获取记录失败: %v", err)
+	}
+
+	return writeRecordDetail(out, *format, *detail)
+}
+
+// 用当前算法重新计算历史记录
+func runHistoryReplay(arg, format string, out io.Writer) error {
+	recordID, err := strconv.Atoi(arg)
+	if err != nil {
+		return usageErrorf("无效的记录ID: %s", arg)
+	}
+
+	result, err := replayRebalanceRecord(recordID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return notFoundErrorf("记录不存在: %d", recordID)
+	case errors.Is(err, errNoSnapshot):
+		return usageErrorf("%v", err)
+	case err != nil:
+		return fmt.Errorf("重现记录失败: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "已按当前算法重新计算记录 #%d（策略 %s），%d只基金的操作建议发生变化\n", recordID, result.Options.Strategy, result.AdviceChanges)
+	writeDiagnostics(result.Diagnostics)
+
+	if format == "json" {
+		return writeJSON(out, result)
+	}
+	return writeFundComparisons(out, format, result.Funds, "原建议", "重新计算")
+}
+
+// 比较两条历史记录
+func runHistoryDiff(argA, argB, format string, out io.Writer) error {
+	recordA, errA := strconv.Atoi(argA)
+	recordB, errB := strconv.Atoi(argB)
+	if errA != nil || errB != nil {
+		return usageErrorf("无效的记录ID: %s %s", argA, argB)
+	}
+
+	comparison, err := compareRebalanceRecords(recordA, recordB)
+	if errors.Is(err, sql.ErrNoRows) {
+		return notFoundErrorf("记录不存在: %d 或 %d", recordA, recordB)
+	}
+	if err != nil {
+		return fmt.Errorf("比较记录失败: %v", err)
+	}
+
+	switch format {
+	case "json":
+		return writeJSON(out, comparison)
+	case "csv":
+		return writeFundComparisons(out, format, comparison.Funds, "", "")
+	default:
+		fmt.Fprintf(out, "记录 #%d(%s) → #%d(%s) | 总市值 %.2f万 → %.2f万(%+.2f万) | %d只基金的操作建议发生变化\n",
+			comparison.A.ID, comparison.A.CreatedAt.Format("2006-01-02"), comparison.B.ID, comparison.B.CreatedAt.Format("2006-01-02"),
+			comparison.A.TotalValue, comparison.B.TotalValue, comparison.TotalValueChange, comparison.AdviceChanges)
+		for _, b := range comparison.Buckets {
+			if b.TargetRateA != nil && b.TargetRateB != nil && *b.TargetRateA == *b.TargetRateB {
+				continue
+			}
+			fmt.Fprintf(out, "  %s 目标占比: %s → %s\n", b.Name, formatOptionalRate(b.TargetRateA), formatOptionalRate(b.TargetRateB))
+		}
+		fmt.Fprintln(out)
+		return writeFundComparisons(out, format, comparison.Funds, "#"+argA, "#"+argB)
+	}
+}
+
+// 输出逐只基金的对比
+func writeFundComparisons(out io.Writer, format string, funds []FundComparison, labelA, labelB string) error {
+	outcome := func(o *FundOutcome) (current, target, diff, advice string) {
+		if o == nil {
+			return "", "", "", "-"
+		}
+		return formatFloat(o.Current), formatFloat(o.Target), formatFloat(o.Diff), o.Advice
+	}
+
+	if format == "csv" {
+		var rows [][]string
+		for _, f := range funds {
+			currentA, targetA, diffA, adviceA := outcome(f.A)
+			currentB, targetB, diffB, adviceB := outcome(f.B)
+			rows = append(rows, []string{
+				strconv.Itoa(f.FundID), f.FundName, f.FundCode,
+				currentA, targetA, diffA, adviceA, currentB, targetB, diffB, adviceB,
+				formatFloat(f.ValueChange), formatFloat(f.TargetChange), formatFloat(f.DiffChange), strconv.FormatBool(f.AdviceChanged),
+			})
+		}
+		return writeCSV(out, []string{"fund_id", "fund_name", "fund_code", "current_a", "target_a", "diff_a", "advice_a",
+			"current_b", "target_b", "diff_b", "advice_b", "value_change", "target_change", "diff_change", "advice_changed"}, rows)
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "基金ID\t基金名称\t市值变化(万)\t目标变化(万)\t%s调整(万)\t%s调整(万)\t建议\n", labelA, labelB)
+	for _, f := range funds {
+		_, _, _, adviceA := outcome(f.A)
+		_, _, _, adviceB := outcome(f.B)
+		var diffA, diffB float64
+		if f.A != nil {
+			diffA = f.A.Diff
+		}
+		if f.B != nil {
+			diffB = f.B.Diff
+		}
+		advice := adviceA
+		if f.AdviceChanged {
+			advice = adviceA + " → " + adviceB + " *"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%+.2f\t%+.2f\t%+.2f\t%+.2f\t%s\n", f.FundID, f.FundName, f.ValueChange, f.TargetChange, diffA, diffB, advice)
+	}
+	return tw.Flush()
+}
+
+// 格式化可能不存在的占比
+func formatOptionalRate(rate *float64) string {
+	if rate == nil {
+		return "无"
+	}
+	return fmt.Sprintf("%.1f%%", *rate*100)
+}
+
+func runExecuteSuggestionCommand(args []string, out io.Writer) error {
+	fs, format := newFlagSet("execute-suggestion")
+	id := fs.Int("id", 0, "建议ID(见 history show 的输出)")
+	var req ExecutionRequest
+	fs.StringVar(&req.Status, "status", "", "执行状态: executed|partial|skipped")
+	fs.Float64Var(&req.Amount, "amount", 0, "实际成交金额(万元)，买入为正、卖出为负；executed 时默认为建议金额")
+	fs.Float64Var(&req.NAV, "nav", 0, "成交净值，默认取净值库中的最新净值")
+	fs.StringVar(&req.Date, "date", "", "成交日期(YYYY-MM-DD)，默认今天")
+	fs.StringVar(&req.Note, "note", "", "备注")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := checkFormat(*format); err != nil {
+		return err
+	}
+	if *id <= 0 || req.Status == "" {
+		return usageErrorf("--id、--status 为必填参数")
+	}
+
+	suggestion, err := store.GetSuggestionByID(*id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return notFoundErrorf("建议不存在: %d", *id)
+	}
+	if err != nil {
+		return fmt.Errorf("获取建议失败: %v", err)
+	}
+
+	updated, err := executeSuggestion(*suggestion, req)
+	var execErr executionError
+	if errors.As(err, &execErr) {
+		return usageErrorf("%v", execErr)
+	}
+	if errors.Is(err, errSuggestionNotPending) {
+		return usageErrorf("%v", err)
+	}
+	if err != nil {
+		return fmt.Errorf("更新执行情况失败: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "✅ %s已标记为%s\n", updated.FundName, suggestionStatusLabel(updated.Status))
+
+	detail, err := getRebalanceDetail(updated.RecordID)
+	if err != nil {
+		return fmt.Errorf("获取记录失败: %v", err)
+	}
+	return writeRecordDetail(out, *format, *detail)
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 默认配置文件，存在时自动读取
+const defaultConfigFile = "./config.yaml"
+
+// 种子组合取值：为空使用内置示例组合，none 表示新数据库不写入任何桶和基金
+const seedNone = "none"
+
+// 程序配置，优先级：命令行参数 > 环境变量 > 配置文件 > 默认值
+type Config struct {
+	Driver           string            `yaml:"driver"`            // 存储后端：sqlite 或 postgres
+	Database         string            `yaml:"database"`          // SQLite数据库文件，或 PostgreSQL 连接串
+	Listen           string            `yaml:"listen"`            // Web服务器监听地址
+	DefaultThreshold float64           `yaml:"default_threshold"` // 未指定阈值时的再平衡触发阈值
+	HistoryLimit     int               `yaml:"history_limit"`     // 历史记录默认显示条数
+	Seed             string            `yaml:"seed"`              // 新数据库的初始组合：为空使用内置示例，none 为空组合，其余为种子文件路径
+	BirthDate        string            `yaml:"birth_date"`        // 投资者出生日期(YYYY-MM-DD)，按年龄的下滑路径需要
+	NAV              NAVProviderConfig `yaml:"nav_provider"`      // 净值数据源
+	Schedule         ScheduleConfig    `yaml:"schedule"`          // Web服务器中的定时与偏离触发再平衡
+}
+
+// 当前生效的配置，main 启动时加载
+var appConfig = defaultConfig()
+
+func defaultConfig() Config {
+	return Config{
+		Driver:           DriverSQLite,
+		Database:         "./fund_data.db",
+		Listen:           ":8080",
+		DefaultThreshold: 0.05,
+		HistoryLimit:     10,
+		NAV:              NAVProviderConfig{Timeout: 10 * time.Second},
+		Schedule:         defaultScheduleConfig(),
+	}
+}
+
+// 解析命令行开头的全局参数并加载配置，返回剩余的参数(命令及其参数)
+func loadConfig(args []string) (Config, []string, error) {
+	fs := flag.NewFlagSet("dynamic-rebalance-fund", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	configFile := fs.String("config", "", "配置文件(YAML)，默认读取 FUND_CONFIG 或 ./config.yaml")
+	driver := fs.String("driver", "", "存储后端：sqlite 或 postgres")
+	database := fs.String("db", "", "SQLite数据库文件或 PostgreSQL 连接串")
+	listen := fs.String("listen", "", "Web服务器监听地址，如 :8080")
+	seed := fs.String("seed", "", "新数据库的初始组合：种子文件路径，或 none 表示空组合")
+	if err := fs.Parse(args); err != nil {
+		return Config{}, nil, err
+	}
+
+	cfg := defaultConfig()
+	path := *configFile
+	if path == "" {
+		path = os.Getenv("FUND_CONFIG")
+	}
+	if path == "" {
+		if _, err := os.Stat(defaultConfigFile); err == nil {
+			path = defaultConfigFile
+		}
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return Config{}, nil, err
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return Config{}, nil, err
+	}
+
+	if *driver != "" {
+		cfg.Driver = *driver
+	}
+	if *database != "" {
+		cfg.Database = *database
+	}
+	if *listen != "" {
+		cfg.Listen = *listen
+	}
+	if *seed != "" {
+		cfg.Seed = *seed
+	}
+
+	if err := cfg.validate(); err != nil {
+		return Config{}, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+// 读取配置文件，文件中未出现的项保持原值
+func (cfg *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("读取配置文件失败: %v", err)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("解析配置文件 %s 失败: %v", path, err)
+	}
+	return nil
+}
+
+// 用环境变量覆盖配置
+func (cfg *Config) applyEnv() error {
+	values := []struct {
+		env    string
+		target *string
+	}{
+		{"FUND_DB_DRIVER", &cfg.Driver},
+		{"FUND_DB", &cfg.Database},
+		{"FUND_LISTEN", &cfg.Listen},
+		{"FUND_SEED", &cfg.Seed},
+		{"FUND_BIRTH_DATE", &cfg.BirthDate},
+		{"NAV_PROVIDER", &cfg.NAV.Type},
+		{"NAV_PROVIDER_URL", &cfg.NAV.URL},
+		{"NAV_PROVIDER_MAPPING", &cfg.NAV.Mapping},
+		{"NAV_PROVIDER_FILE", &cfg.NAV.File},
+		{"FUND_SCHEDULE_CALENDAR", &cfg.Schedule.Calendar},
+		{"FUND_SCHEDULE_TIME", &cfg.Schedule.Time},
+	}
+	for _, v := range values {
+		if value := os.Getenv(v.env); value != "" {
+			*v.target = value
+		}
+	}
+
+	if value := os.Getenv("FUND_DEFAULT_THRESHOLD"); value != "" {
+		threshold, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("无效的 FUND_DEFAULT_THRESHOLD: %s", value)
+		}
+		cfg.DefaultThreshold = threshold
+	}
+	if value := os.Getenv("FUND_HISTORY_LIMIT"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("无效的 FUND_HISTORY_LIMIT: %s", value)
+		}
+		cfg.HistoryLimit = limit
+	}
+	if value := os.Getenv("NAV_FETCH_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("无效的 NAV_FETCH_INTERVAL: %s（如 6h、30m）", value)
+		}
+		cfg.NAV.Interval = interval
+	}
+	if value := os.Getenv("NAV_PROVIDER_TIMEOUT"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("无效的 NAV_PROVIDER_TIMEOUT: %s", value)
+		}
+		cfg.NAV.Timeout = timeout
+	}
+	if value := os.Getenv("FUND_SCHEDULE_DAY"); value != "" {
+		day, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("无效的 FUND_SCHEDULE_DAY: %s", value)
+		}
+		cfg.Schedule.Day = day
+	}
+	if value := os.Getenv("FUND_SCHEDULE_DATES"); value != "" {
+		cfg.Schedule.Dates = parseScheduleDates(value)
+	}
+	if value := os.Getenv("FUND_SCHEDULE_DRIFT"); value != "" {
+		drift, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("无效的 FUND_SCHEDULE_DRIFT: %s（true 或 false）", value)
+		}
+		cfg.Schedule.Drift = drift
+	}
+	if value := os.Getenv("FUND_SCHEDULE_CHECK_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("无效的 FUND_SCHEDULE_CHECK_INTERVAL: %s（如 1h、30m）", value)
+		}
+		cfg.Schedule.CheckInterval = interval
+	}
+	if value := os.Getenv("FUND_SCHEDULE_COOLDOWN"); value != "" {
+		cooldown, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("无效的 FUND_SCHEDULE_COOLDOWN: %s（如 24h）", value)
+		}
+		cfg.Schedule.Cooldown = cooldown
+	}
+	return nil
+}
+
+func (cfg Config) validate() error {
+	if cfg.Driver != DriverSQLite && cfg.Driver != DriverPostgres {
+		return fmt.Errorf("不支持的存储后端: %s（可选 %s、%s）", cfg.Driver, DriverSQLite, DriverPostgres)
+	}
+	if cfg.Database == "" {
+		return fmt.Errorf("数据库文件或连接串不能为空")
+	}
+	if cfg.Listen == "" {
+		return fmt.Errorf("监听地址不能为空")
+	}
+	if cfg.DefaultThreshold <= 0 || cfg.DefaultThreshold >= 1 {
+		return fmt.Errorf("默认阈值必须在0-1之间: %v", cfg.DefaultThreshold)
+	}
+	if cfg.HistoryLimit <= 0 {
+		return fmt.Errorf("历史记录默认条数必须大于0: %d", cfg.HistoryLimit)
+	}
+	if cfg.BirthDate != "" {
+		if _, err := parseDate(cfg.BirthDate); err != nil {
+			return fmt.Errorf("无效的出生日期: %s（格式: YYYY-MM-DD）", cfg.BirthDate)
+		}
+	}
+	if cfg.NAV.Interval < 0 {
+		return fmt.Errorf("净值抓取间隔不能为负数: %s", cfg.NAV.Interval)
+	}
+	if cfg.NAV.Timeout <= 0 {
+		return fmt.Errorf("净值请求超时必须大于0: %s", cfg.NAV.Timeout)
+	}
+	if err := cfg.Schedule.validate(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// 浏览器访问地址，监听地址省略主机时使用 localhost
+func (cfg Config) webURL() string {
+	if strings.HasPrefix(cfg.Listen, ":") {
+		return "http://localhost" + cfg.Listen
+	}
+	return "http://" + cfg.Listen
+}
+
+// 存储后端的说明，用于启动提示；连接串中的密码不显示
+func (cfg Config) databaseLabel() string {
+	if cfg.Driver == DriverPostgres {
+		return "PostgreSQL 数据库 " + redactDSN(cfg.Database)
+	}
+	return "SQLite 数据库 " + cfg.Database
+}
+
+// 连接串中 password=... 形式的密码
+var dsnPasswordPattern = regexp.MustCompile(`(?i)(password\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+
+// 隐藏 PostgreSQL 连接串中的密码，支持 URL 和 key=value 两种格式
+func redactDSN(dsn string) string {
+	if strings.Contains(dsn, "://") {
+		if u, err := url.Parse(dsn); err == nil {
+			if query := u.Query(); query.Has("password") {
+				query.Set("password", "xxxxx")
+				u.RawQuery = query.Encode()
+			}
+			return u.Redacted()
+		}
+	}
+	return dsnPasswordPattern.ReplaceAllString(dsn, "${1}xxxxx")
+}
+
+// 种子组合：新数据库的初始桶和基金
+type SeedPortfolio struct {
+	Buckets []SeedBucket `yaml:"buckets"`
+}
+
+type SeedBucket struct {
+	Name       string       `yaml:"name"`
+	TargetRate float64      `yaml:"target_rate"` // 子桶为在父桶中的占比
+	Funds      []SeedFund   `yaml:"funds"`
+	Children   []SeedBucket `yaml:"children"` // 子桶，有子桶的桶不能直接持有基金
+}
+
+type SeedFund struct {
+	Name    string  `yaml:"name"`
+	Code    string  `yaml:"code"`
+	Current float64 `yaml:"current"` // 当前市值(万元)
+	Weight  float64 `yaml:"weight"`
+}
+
+// 内置示例组合
+func defaultSeedPortfolio() SeedPortfolio {
+	return SeedPortfolio{Buckets: []SeedBucket{
+		{Name: "短期桶（货币基金）", TargetRate: 0.10, Funds: []SeedFund{
+			{Name: "易方达货币A", Code: "000009", Current: 20.0, Weight: 1.0},
+		}},
+		{Name: "中期桶（债券基金）", TargetRate: 0.30, Funds: []SeedFund{
+			{Name: "广发国开债7-10A", Code: "003375", Current: 50.0, Weight: 0.5},
+			{Name: "博时信用债纯债A", Code: "050026", Current: 40.0, Weight: 0.5},
+		}},
+		{Name: "长期桶（股票基金）", TargetRate: 0.60, Funds: []SeedFund{
+			{Name: "易方达沪深300ETF联接A", Code: "110020", Current: 100.0, Weight: 0.4},
+			{Name: "南方中证500ETF联接A", Code: "160119", Current: 80.0, Weight: 0.3},
+			{Name: "汇添富海外互联网50ETF", Code: "006327", Current: 60.0, Weight: 0.3},
+		}},
+	}}
+}
+
+// 按配置得到种子组合：为空使用内置示例，none 为空组合，其余读取YAML或JSON文件
+func loadSeedPortfolio(seed string) (SeedPortfolio, error) {
+	switch seed {
+	case "":
+		return defaultSeedPortfolio(), nil
+	case seedNone:
+		return SeedPortfolio{}, nil
+	}
+
+	data, err := os.ReadFile(seed)
+	if err != nil {
+		return SeedPortfolio{}, fmt.Errorf("读取种子文件失败: %v", err)
+	}
+	var portfolio SeedPortfolio
+	if err := yaml.Unmarshal(data, &portfolio); err != nil {
+		return SeedPortfolio{}, fmt.Errorf("解析种子文件 %s 失败: %v", seed, err)
+	}
+	if err := portfolio.validate(); err != nil {
+		return SeedPortfolio{}, fmt.Errorf("种子文件 %s 无效: %v", seed, err)
+	}
+	return portfolio, nil
+}
+
+// 校验种子组合：桶名称不能重复，同一父桶下(包括顶层)目标占比合计为100%，桶内权重合计不超过1
+func (p SeedPortfolio) validate() error {
+	if len(p.Buckets) == 0 {
+		return nil
+	}
+	return validateSeedBuckets(p.Buckets, make(map[string]bool))
+}
+
+// 校验同一层的桶及其子桶，names 为已出现的桶名称
+func validateSeedBuckets(buckets []SeedBucket, names map[string]bool) error {
+	rates := make([]float64, 0, len(buckets))
+	for _, b := range buckets {
+		if b.Name == "" {
+			return fmt.Errorf("桶名称不能为空")
+		}
+		if names[b.Name] {
+			return fmt.Errorf("桶名称重复: %s", b.Name)
+		}
+		names[b.Name] = true
+		rates = append(rates, b.TargetRate)
+
+		var totalWeight float64
+		for _, f := range b.Funds {
+			if f.Name == "" || f.Code == "" {
+				return fmt.Errorf("%s 中有基金缺少名称或代码", b.Name)
+			}
+			if f.Current < 0 || f.Weight < 0 || f.Weight > 1 {
+				return fmt.Errorf("基金 %s 的市值不能为负数，权重必须在0-1之间", f.Name)
+			}
+			totalWeight += f.Weight
+		}
+		if totalWeight > 1.0+targetRateTolerance {
+			return fmt.Errorf("%s 内基金权重合计为%.2f，超过1", b.Name, totalWeight)
+		}
+
+		if len(b.Children) > 0 {
+			if len(b.Funds) > 0 {
+				return fmt.Errorf("%s 有子桶，基金需放在子桶中", b.Name)
+			}
+			if err := validateSeedBuckets(b.Children, names); err != nil {
+				return fmt.Errorf("%s 的子桶: %v", b.Name, err)
+			}
+		}
+	}
+	return validateTargetRates(rates)
+}
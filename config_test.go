@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestDatabaseLabel(t *testing.T) {
+	tests := []struct {
+		driver   string
+		database string
+		want     string
+	}{
+		{DriverSQLite, "./fund_data.db", "SQLite 数据库 ./fund_data.db"},
+		{DriverPostgres, "postgres://fund:secret@db:5432/fund?sslmode=disable", "PostgreSQL 数据库 postgres://fund:xxxxx@db:5432/fund?sslmode=disable"},
+		{DriverPostgres, "postgres://db/fund?password=secret&sslmode=disable", "PostgreSQL 数据库 postgres://db/fund?password=xxxxx&sslmode=disable"},
+		{DriverPostgres, "postgres://fund@db/fund", "PostgreSQL 数据库 postgres://fund@db/fund"},
+		{DriverPostgres, "host=db user=fund password=secret dbname=fund", "PostgreSQL 数据库 host=db user=fund password=xxxxx dbname=fund"},
+		{DriverPostgres, "host=db password = 'se cr\\'et' dbname=fund", "PostgreSQL 数据库 host=db password = xxxxx dbname=fund"},
+	}
+
+	for _, tt := range tests {
+		cfg := Config{Driver: tt.driver, Database: tt.database}
+		if got := cfg.databaseLabel(); got != tt.want {
+			t.Errorf("%s 显示为 %q，应为 %q", tt.database, got, tt.want)
+		}
+	}
+}
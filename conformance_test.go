@@ -0,0 +1,729 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 存储一致性检查：每种存储后端都应通过同一组检查，保证业务逻辑在不同数据库上行为一致。
+// 检查会写入数据，只能在空数据库上运行；PostgreSQL 的检查需用 FUND_TEST_POSTGRES_DSN 指定一个空数据库
+type conformanceCheck struct {
+	name string
+	run  func(repo Repository) error
+}
+
+var conformanceChecks = []conformanceCheck{
+	{"结构迁移", checkRepositoryMigrations},
+	{"桶的增删改", checkRepositoryBuckets},
+	{"基金的增改、归档与恢复", checkRepositoryFunds},
+	{"合并删除桶", checkRepositoryBucketMerge},
+	{"交易流水", checkRepositoryTransactions},
+	{"净值的新增、覆盖与查询", checkRepositoryNAVs},
+	{"指数估值与估值规则", checkRepositoryValuations},
+	{"下滑路径", checkRepositoryGlidePaths},
+	{"再平衡记录与建议", checkRepositoryRecords},
+	{"建议的执行登记", checkRepositoryExecution},
+}
+
+func TestSQLiteRepositoryConformance(t *testing.T) {
+	runConformanceChecks(t, DriverSQLite, filepath.Join(t.TempDir(), "check.db"))
+}
+
+func TestPostgresRepositoryConformance(t *testing.T) {
+	dsn := os.Getenv("FUND_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("未设置 FUND_TEST_POSTGRES_DSN，跳过 PostgreSQL 存储检查")
+	}
+	runConformanceChecks(t, DriverPostgres, dsn)
+}
+
+// 在空数据库上迁移后依次运行所有检查，检查依次写入数据，某项失败不影响后续检查
+func runConformanceChecks(t *testing.T, driver, dsn string) {
+	repo, err := openRepository(driver, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.Close()
+
+	if _, err := repo.Migrate(true); err != nil {
+		t.Fatalf("数据库迁移失败: %v", err)
+	}
+	if err := checkRepositoryEmpty(repo); err != nil {
+		t.Fatal(err)
+	}
+	for _, check := range conformanceChecks {
+		t.Run(check.name, func(t *testing.T) {
+			if err := check.run(repo); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// 检查前确认数据库为空，避免写入真实数据
+func checkRepositoryEmpty(repo Repository) error {
+	buckets, err := repo.GetBuckets()
+	if err != nil {
+		return err
+	}
+	archived, err := repo.GetArchivedFunds()
+	if err != nil {
+		return err
+	}
+	records, err := repo.GetRebalanceHistory(1)
+	if err != nil {
+		return err
+	}
+	txs, err := repo.GetTransactions()
+	if err != nil {
+		return err
+	}
+	navs, err := repo.GetLatestFundNAVs()
+	if err != nil {
+		return err
+	}
+	if len(buckets)+len(archived)+len(records)+len(txs)+len(navs) > 0 {
+		return fmt.Errorf("数据库中已有数据，一致性检查只能在空数据库上运行")
+	}
+	return nil
+}
+
+func approxEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func expect(ok bool, format string, args ...interface{}) error {
+	if ok {
+		return nil
+	}
+	return fmt.Errorf(format, args...)
+}
+
+// 按名称查找桶
+func findBucketByName(repo Repository, name string) (*DBBucket, error) {
+	buckets, err := repo.GetBuckets()
+	if err != nil {
+		return nil, err
+	}
+	for i := range buckets {
+		if buckets[i].Name == name {
+			return &buckets[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// 新增一个桶和其中的基金，返回桶
+func addConformanceBucket(repo Repository, name string, targetRate float64, funds ...SeedFund) (*DBBucket, error) {
+	bucketID, err := repo.AddBucket(name, targetRate, 0)
+	if err != nil {
+		return nil, fmt.Errorf("添加桶失败: %v", err)
+	}
+	for _, f := range funds {
+		if err := repo.AddFund(bucketID, f.Name, f.Code, f.Current, f.Weight); err != nil {
+			return nil, fmt.Errorf("添加基金失败: %v", err)
+		}
+	}
+	bucket, err := findBucketByName(repo, name)
+	if err != nil {
+		return nil, err
+	}
+	if bucket == nil || bucket.ID != bucketID {
+		return nil, fmt.Errorf("新增的桶 %s 未出现在桶列表中或ID不一致", name)
+	}
+	if len(bucket.Funds) != len(funds) {
+		return nil, fmt.Errorf("桶 %s 中应有 %d 只基金，实际 %d 只", name, len(funds), len(bucket.Funds))
+	}
+	return bucket, nil
+}
+
+func checkRepositoryMigrations(repo Repository) error {
+	status, err := repo.SchemaStatus()
+	if err != nil {
+		return err
+	}
+	if err := expect(status.LatestVersion > 0 && status.CurrentVersion == status.LatestVersion,
+		"迁移后结构版本应为最新版本 %d，实际 %d", status.LatestVersion, status.CurrentVersion); err != nil {
+		return err
+	}
+	result, err := repo.Migrate(false)
+	if err != nil {
+		return err
+	}
+	return expect(len(result.Applied) == 0, "重复执行迁移不应再执行任何迁移，实际执行了 %d 个", len(result.Applied))
+}
+
+func checkRepositoryBuckets(repo Repository) error {
+	bucket, err := addConformanceBucket(repo, "检查桶", 0.125)
+	if err != nil {
+		return err
+	}
+	if err := expect(bucket.TargetRate == 0.125 && !bucket.CreatedAt.IsZero(), "桶的目标占比或创建时间有误: %+v", *bucket); err != nil {
+		return err
+	}
+	if _, err := repo.AddBucket("检查桶", 0, 0); err == nil {
+		return fmt.Errorf("桶名称重复时应报错")
+	}
+
+	childID, err := repo.AddBucket("检查子桶", 1, bucket.ID)
+	if err != nil {
+		return fmt.Errorf("添加子桶失败: %v", err)
+	}
+	child, err := findBucketByName(repo, "检查子桶")
+	if err != nil {
+		return err
+	}
+	if err := expect(child != nil && child.ID == childID && child.ParentID == bucket.ID, "子桶的父桶ID有误: %+v", child); err != nil {
+		return err
+	}
+	if err := repo.UpdateBucket(childID, "parent_id", "0"); err != nil {
+		return err
+	}
+	if child, err = findBucketByName(repo, "检查子桶"); err != nil {
+		return err
+	}
+	if err := expect(child != nil && child.ParentID == 0, "修改父桶后仍为子桶: %+v", child); err != nil {
+		return err
+	}
+	if err := repo.DeleteBucket(*child, nil); err != nil {
+		return err
+	}
+
+	if err := repo.UpdateBucket(bucket.ID, "name", "检查桶(改名)"); err != nil {
+		return err
+	}
+	if err := repo.UpdateBucketTargetRates(map[int]float64{bucket.ID: 0.3}); err != nil {
+		return err
+	}
+	renamed, err := findBucketByName(repo, "检查桶(改名)")
+	if err != nil {
+		return err
+	}
+	if err := expect(renamed != nil && renamed.TargetRate == 0.3, "修改后的桶名称或目标占比有误"); err != nil {
+		return err
+	}
+
+	if err := repo.DeleteBucket(*renamed, nil); err != nil {
+		return err
+	}
+	deleted, err := findBucketByName(repo, "检查桶(改名)")
+	if err != nil {
+		return err
+	}
+	return expect(deleted == nil, "删除的桶仍在桶列表中")
+}
+
+func checkRepositoryFunds(repo Repository) error {
+	bucket, err := addConformanceBucket(repo, "基金检查桶", 0.5,
+		SeedFund{Name: "基金B", Code: "000002", Current: 12.5, Weight: 0.123456789012345},
+		SeedFund{Name: "基金A", Code: "000001", Current: 7, Weight: 0.3},
+	)
+	if err != nil {
+		return err
+	}
+	fund := bucket.Funds[0]
+	if err := expect(fund.Name == "基金B" && fund.Weight == 0.123456789012345 && fund.Current == 12.5 && !fund.CurrentOverride,
+		"桶内基金应按ID排序且数值不失精度: %+v", fund); err != nil {
+		return err
+	}
+
+	for field, value := range map[string]string{"weight": "0.25", "purchase_rate": "0.015", "redemption_tiers": "7:0.015,0:0", "held_since": "2024-01-02", "min_trade": "0.01"} {
+		if err := repo.UpdateFund(fund.ID, field, value); err != nil {
+			return fmt.Errorf("修改基金 %s 失败: %v", field, err)
+		}
+	}
+	if err := repo.UpdateFund(fund.ID, "current", "15"); err != nil {
+		return err
+	}
+	updated, err := repo.GetFundByID(fund.ID)
+	if err != nil {
+		return err
+	}
+	if err := expect(updated.Weight == 0.25 && updated.Current == 15 && updated.Fees.PurchaseRate == 0.015 &&
+		len(updated.Fees.RedemptionTiers) == 2 && updated.Fees.HeldSince == "2024-01-02" && updated.MinTrade == 0.01,
+		"修改后的基金信息有误: %+v", *updated); err != nil {
+		return err
+	}
+	if err := expect(!updated.CurrentOverride, "没有交易流水的基金修改市值不应标记为手动覆盖"); err != nil {
+		return err
+	}
+	if _, err := repo.GetFundByID(-1); !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("基金不存在时应返回 sql.ErrNoRows，实际: %v", err)
+	}
+
+	if err := repo.ArchiveFund(fund.ID); err != nil {
+		return err
+	}
+	bucketAfter, err := findBucketByName(repo, "基金检查桶")
+	if err != nil {
+		return err
+	}
+	if err := expect(len(bucketAfter.Funds) == 1, "归档的基金不应出现在桶内"); err != nil {
+		return err
+	}
+	archived, err := repo.GetArchivedFunds()
+	if err != nil {
+		return err
+	}
+	if err := expect(len(archived) == 1 && archived[0].ID == fund.ID && archived[0].ArchivedAt != "", "已归档基金列表有误: %+v", archived); err != nil {
+		return err
+	}
+	codes, err := repo.GetFundCodes()
+	if err != nil {
+		return err
+	}
+	if err := expect(len(codes) == 1 && codes[0] == "000001", "基金代码应只包含未归档的基金: %v", codes); err != nil {
+		return err
+	}
+
+	if err := repo.RestoreFund(fund.ID, bucket.ID); err != nil {
+		return err
+	}
+	restored, err := repo.GetFundByID(fund.ID)
+	if err != nil {
+		return err
+	}
+	if err := expect(restored.ArchivedAt == "" && restored.BucketID == bucket.ID, "恢复后的基金状态有误: %+v", *restored); err != nil {
+		return err
+	}
+
+	results := []Bucket{{ID: bucket.ID, Funds: []Fund{{ID: fund.ID, Target: 20, Diff: 5, Advice: "买入"}}}}
+	if err := repo.UpdateFundRebalanceResults(results); err != nil {
+		return err
+	}
+	rebalanced, err := repo.GetFundByID(fund.ID)
+	if err != nil {
+		return err
+	}
+	return expect(rebalanced.Target == 20 && rebalanced.Diff == 5 && rebalanced.Advice == "买入", "回写的再平衡结果有误: %+v", *rebalanced)
+}
+
+func checkRepositoryBucketMerge(repo Repository) error {
+	from, err := addConformanceBucket(repo, "合并来源桶", 0.2, SeedFund{Name: "来源基金", Code: "000011", Current: 10, Weight: 1})
+	if err != nil {
+		return err
+	}
+	to, err := addConformanceBucket(repo, "合并目标桶", 0.3, SeedFund{Name: "目标基金", Code: "000012", Current: 10, Weight: 1})
+	if err != nil {
+		return err
+	}
+
+	if err := repo.DeleteBucket(*from, to); err != nil {
+		return err
+	}
+	merged, err := findBucketByName(repo, "合并目标桶")
+	if err != nil {
+		return err
+	}
+	if err := expect(merged != nil && len(merged.Funds) == 2 && approxEqual(merged.TargetRate, 0.5), "合并后目标桶应有2只基金、目标占比50%%"); err != nil {
+		return err
+	}
+	fromScale, toScale := mergedWeightScales(0.2, 0.3, 1)
+	return expect(approxEqual(merged.Funds[0].Weight, fromScale) && approxEqual(merged.Funds[1].Weight, toScale) ||
+		approxEqual(merged.Funds[0].Weight, toScale) && approxEqual(merged.Funds[1].Weight, fromScale),
+		"合并后的权重有误: %v, %v", merged.Funds[0].Weight, merged.Funds[1].Weight)
+}
+
+func checkRepositoryTransactions(repo Repository) error {
+	bucket, err := addConformanceBucket(repo, "流水检查桶", 0, SeedFund{Name: "流水基金", Code: "000021", Current: 1, Weight: 1})
+	if err != nil {
+		return err
+	}
+	fundID := bucket.Funds[0].ID
+
+	second, err := repo.AddTransaction(Transaction{FundID: fundID, Type: TransactionSell, TradeDate: "2024-03-01", Shares: 100, NAV: 1.5, Note: "卖出"})
+	if err != nil {
+		return err
+	}
+	first, err := repo.AddTransaction(Transaction{FundID: fundID, Type: TransactionBuy, TradeDate: "2024-01-02", Shares: 1000, NAV: 1.2345})
+	if err != nil {
+		return err
+	}
+	if err := expect(first > 0 && second > 0 && first != second, "交易流水ID应为不同的正数: %d, %d", first, second); err != nil {
+		return err
+	}
+
+	txs, err := repo.GetTransactionsByFundID(fundID)
+	if err != nil {
+		return err
+	}
+	if err := expect(len(txs) == 2 && txs[0].ID == first && txs[1].ID == second, "交易流水应按交易日期排序"); err != nil {
+		return err
+	}
+	t, err := repo.GetTransactionByID(second)
+	if err != nil {
+		return err
+	}
+	if err := expect(t.Type == TransactionSell && t.NAV == 1.5 && t.Note == "卖出" && !t.CreatedAt.IsZero(), "交易流水内容有误: %+v", *t); err != nil {
+		return err
+	}
+
+	if err := repo.UpdateFund(fundID, "current", "2"); err != nil {
+		return err
+	}
+	fund, err := repo.GetFundByID(fundID)
+	if err != nil {
+		return err
+	}
+	if err := expect(fund.CurrentOverride, "有交易流水的基金修改市值应标记为手动覆盖"); err != nil {
+		return err
+	}
+	if err := repo.UpdateFund(fundID, "current_override", "0"); err != nil {
+		return err
+	}
+	if fund, err = repo.GetFundByID(fundID); err != nil {
+		return err
+	}
+	if err := expect(!fund.CurrentOverride, "取消手动覆盖失败"); err != nil {
+		return err
+	}
+
+	if err := repo.DeleteTransaction(second); err != nil {
+		return err
+	}
+	if _, err := repo.GetTransactionByID(second); !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("删除的交易流水应返回 sql.ErrNoRows，实际: %v", err)
+	}
+	all, err := repo.GetTransactions()
+	if err != nil {
+		return err
+	}
+	return expect(len(all) == 1 && all[0].ID == first, "删除后应只剩1笔交易流水")
+}
+
+func checkRepositoryNAVs(repo Repository) error {
+	navs := []FundNAV{
+		{Code: "000031", Date: "2024-01-03", UnitNAV: 1.0123, AccNAV: 2.1},
+		{Code: "000031", Date: "2024-01-02", UnitNAV: 1.0001},
+		{Code: "000032", Date: "2024-01-02", UnitNAV: 3.3},
+	}
+	saved, err := repo.SaveFundNAVs(navs)
+	if err != nil {
+		return err
+	}
+	if err := expect(saved.Inserted == 3, "应新增3条净值，实际 %d", saved.Inserted); err != nil {
+		return err
+	}
+
+	navs[0].UnitNAV = 1.02
+	navs[2].Source = "stub"
+	navs[2].FetchedAt = "2024-01-02 20:00:00"
+	if saved, err = repo.SaveFundNAVs(navs); err != nil {
+		return err
+	}
+	if err := expect(saved.Inserted == 0 && saved.Updated == 1 && saved.Unchanged == 2, "覆盖结果有误: %+v", *saved); err != nil {
+		return err
+	}
+
+	stored, err := repo.GetFundNAVs("000031", "", "")
+	if err != nil {
+		return err
+	}
+	if err := expect(len(stored) == 2 && stored[0].Date == "2024-01-02" && stored[1].UnitNAV == 1.02 && stored[1].Source == NAVSourceImport,
+		"历史净值应按日期排序并已覆盖: %+v", stored); err != nil {
+		return err
+	}
+	ranged, err := repo.GetFundNAVs("000031", "2024-01-03", "2024-12-31")
+	if err != nil {
+		return err
+	}
+	if err := expect(len(ranged) == 1, "按日期范围查询应返回1条，实际 %d", len(ranged)); err != nil {
+		return err
+	}
+
+	latest, err := repo.GetLatestFundNAVs()
+	if err != nil {
+		return err
+	}
+	return expect(len(latest) == 2 && latest["000031"].Date == "2024-01-03" && latest["000032"].Source == "stub" && latest["000032"].FetchedAt != "",
+		"最新净值有误: %+v", latest)
+}
+
+func checkRepositoryValuations(repo Repository) error {
+	valuations := []IndexValuation{
+		{IndexCode: "000300", Metric: ValuationMetricPE, Date: "2024-01-31", Value: 11.2, Percentile: 0.15},
+		{IndexCode: "000300", Metric: ValuationMetricPE, Date: "2024-02-29", Value: 11.8, Percentile: 0.22},
+		{IndexCode: "000300", Metric: ValuationMetricPB, Date: "2024-02-29", Value: 1.3, Percentile: 0.1},
+	}
+	saved, err := repo.SaveIndexValuations(valuations)
+	if err != nil {
+		return err
+	}
+	if err := expect(saved.Inserted == 3, "应新增3条估值，实际 %d", saved.Inserted); err != nil {
+		return err
+	}
+	valuations[1].Percentile = 0.25
+	if saved, err = repo.SaveIndexValuations(valuations); err != nil {
+		return err
+	}
+	if err := expect(saved.Inserted == 0 && saved.Updated == 1 && saved.Unchanged == 2, "覆盖结果有误: %+v", *saved); err != nil {
+		return err
+	}
+
+	stored, err := repo.GetIndexValuations("000300", ValuationMetricPE, "", "")
+	if err != nil {
+		return err
+	}
+	if err := expect(len(stored) == 2 && stored[0].Date == "2024-01-31" && approxEqual(stored[1].Percentile, 0.25),
+		"历史估值应按日期排序并已覆盖: %+v", stored); err != nil {
+		return err
+	}
+	latest, err := repo.GetLatestIndexValuation("000300", ValuationMetricPE, "2024-02-15")
+	if err != nil {
+		return err
+	}
+	if err := expect(latest != nil && latest.Date == "2024-01-31", "应取指定日期之前最近的估值: %+v", latest); err != nil {
+		return err
+	}
+	if latest, err = repo.GetLatestIndexValuation("000300", ValuationMetricPE, "2023-12-31"); err != nil {
+		return err
+	}
+	if err := expect(latest == nil, "指定日期之前没有估值时应返回空: %+v", latest); err != nil {
+		return err
+	}
+
+	bucket, err := addConformanceBucket(repo, "估值桶", 0)
+	if err != nil {
+		return err
+	}
+	ruleID, err := repo.AddValuationRule(ValuationRule{BucketID: bucket.ID, IndexCode: "000300", Metric: ValuationMetricPE, MinPercentile: 0, MaxPercentile: 0.2, TargetRate: 0.7})
+	if err != nil {
+		return err
+	}
+	if _, err := repo.AddValuationRule(ValuationRule{BucketID: bucket.ID, IndexCode: "000300", Metric: ValuationMetricPE, MinPercentile: 0.8, MaxPercentile: 1, TargetRate: 0.4}); err != nil {
+		return err
+	}
+	rules, err := repo.GetValuationRules()
+	if err != nil {
+		return err
+	}
+	if err := expect(len(rules) == 2 && rules[0].ID == ruleID && approxEqual(rules[0].TargetRate, 0.7) && !rules[0].CreatedAt.IsZero(),
+		"估值规则应按区间排序: %+v", rules); err != nil {
+		return err
+	}
+	if err := repo.DeleteValuationRule(ruleID); err != nil {
+		return err
+	}
+
+	// 删除桶时一并删除其估值规则
+	if err := repo.DeleteBucket(*bucket, nil); err != nil {
+		return err
+	}
+	if rules, err = repo.GetValuationRules(); err != nil {
+		return err
+	}
+	return expect(len(rules) == 0, "删除桶后应没有估值规则，实际 %d 条", len(rules))
+}
+
+func checkRepositoryGlidePaths(repo Repository) error {
+	stock, err := addConformanceBucket(repo, "下滑股票桶", 0)
+	if err != nil {
+		return err
+	}
+	bond, err := addConformanceBucket(repo, "下滑债券桶", 0)
+	if err != nil {
+		return err
+	}
+
+	err = repo.SetGlidePath(GlidePath{BucketID: stock.ID, Basis: GlideBasisDate, Points: []GlidePoint{
+		{Date: "2030-01-01", TargetRate: 0.5}, {Date: "2025-01-01", TargetRate: 0.8},
+	}})
+	if err != nil {
+		return err
+	}
+	err = repo.SetGlidePath(GlidePath{BucketID: bond.ID, Basis: GlideBasisAge, Points: []GlidePoint{
+		{Age: 40, TargetRate: 0.2}, {Age: 65, TargetRate: 0.6},
+	}})
+	if err != nil {
+		return err
+	}
+	paths, err := repo.GetGlidePaths()
+	if err != nil {
+		return err
+	}
+	if err := expect(len(paths) == 2 && paths[0].BucketID == stock.ID && paths[0].Points[0].Date == "2025-01-01" &&
+		paths[1].Basis == GlideBasisAge && len(paths[1].Points) == 2 && approxEqual(paths[1].Points[1].Age, 65),
+		"下滑路径应按桶和时间排序: %+v", paths); err != nil {
+		return err
+	}
+
+	// 重新设置时替换原有的点
+	err = repo.SetGlidePath(GlidePath{BucketID: stock.ID, Basis: GlideBasisDate, Points: []GlidePoint{
+		{Date: "2025-01-01", TargetRate: 0.7}, {Date: "2035-01-01", TargetRate: 0.4}, {Date: "2045-01-01", TargetRate: 0.3},
+	}})
+	if err != nil {
+		return err
+	}
+	if paths, err = repo.GetGlidePaths(); err != nil {
+		return err
+	}
+	if err := expect(len(paths) == 2 && len(paths[0].Points) == 3 && approxEqual(paths[0].Points[0].TargetRate, 0.7),
+		"重新设置后应只有新的点: %+v", paths); err != nil {
+		return err
+	}
+
+	if err := repo.DeleteGlidePath(stock.ID); err != nil {
+		return err
+	}
+	// 删除桶时一并删除其下滑路径
+	if err := repo.DeleteBucket(*bond, nil); err != nil {
+		return err
+	}
+	if err := repo.DeleteBucket(*stock, nil); err != nil {
+		return err
+	}
+	if paths, err = repo.GetGlidePaths(); err != nil {
+		return err
+	}
+	return expect(len(paths) == 0, "删除后应没有下滑路径，实际 %d 条", len(paths))
+}
+
+func checkRepositoryRecords(repo Repository) error {
+	bucket, err := addConformanceBucket(repo, "记录检查桶", 0,
+		SeedFund{Name: "记录基金乙", Code: "000042", Current: 10, Weight: 0.5},
+		SeedFund{Name: "记录基金甲", Code: "000041", Current: 20, Weight: 0.5},
+	)
+	if err != nil {
+		return err
+	}
+	buckets := convertDBBucketsToAPIBuckets([]DBBucket{*bucket})
+	opts := RebalanceOptions{Strategy: defaultStrategyName, Threshold: 0.05, Mode: RebalanceModeTarget}
+	snapshot := newRebalanceSnapshot(buckets, opts)
+	suggestions := []RebalanceSuggestion{
+		{FundID: bucket.Funds[0].ID, FundName: "记录基金乙", FundCode: "000042", CurrentValue: 10, TargetValue: 15, DiffValue: 5, Advice: "买入", Reason: "偏离", Fee: 0.0015},
+		{FundID: bucket.Funds[1].ID, FundName: "记录基金甲", FundCode: "000041", CurrentValue: 20, TargetValue: 15, DiffValue: -5, Advice: "卖出"},
+	}
+
+	first, err := repo.SaveRebalanceRecord(snapshot, RebalanceTriggerScheduled, 30, 0.0015, suggestions)
+	if err != nil {
+		return err
+	}
+	second, err := repo.SaveRebalanceRecord(snapshot, RebalanceTriggerManual, 31, 0, nil)
+	if err != nil {
+		return err
+	}
+
+	history, err := repo.GetRebalanceHistory(10)
+	if err != nil {
+		return err
+	}
+	if err := expect(len(history) == 2 && history[0].ID == second && history[1].ID == first, "历史记录应按时间倒序"); err != nil {
+		return err
+	}
+	record, err := repo.GetRebalanceRecordByID(first)
+	if err != nil {
+		return err
+	}
+	if err := expect(record.TotalValue == 30 && record.TotalFee == 0.0015 && record.Mode == RebalanceModeTarget && record.Threshold == 0.05 &&
+		record.Strategy == defaultStrategyName && record.Trigger == RebalanceTriggerScheduled,
+		"再平衡记录内容有误: %+v", *record); err != nil {
+		return err
+	}
+	// 记录时间须是准确的时刻，定时再平衡的冷却期按它与当前时间的间隔判断，不能因数据库会话时区而偏移
+	if age := time.Since(record.CreatedAt); age < -time.Minute || age > time.Minute {
+		return fmt.Errorf("记录时间 %s 与当前时间相差 %s", record.CreatedAt, age)
+	}
+	if _, err := repo.GetRebalanceRecordByID(-1); !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("记录不存在时应返回 sql.ErrNoRows，实际: %v", err)
+	}
+
+	restored, err := repo.GetRebalanceSnapshot(first)
+	if err != nil {
+		return err
+	}
+	if err := expect(restored != nil && len(restored.Buckets) == 1 && len(restored.Buckets[0].Funds) == 2 && restored.Options.Threshold == 0.05,
+		"配置快照有误"); err != nil {
+		return err
+	}
+
+	saved, err := repo.GetSuggestionsByRecordID(first)
+	if err != nil {
+		return err
+	}
+	if err := expect(len(saved) == 2 && saved[0].FundName == "记录基金乙" && saved[0].Reason == "偏离" && saved[0].Fee == 0.0015 &&
+		saved[0].Status == SuggestionPending && saved[0].FundStatus == FundStatusActive,
+		"建议应按基金名称排序且内容完整: %+v", saved); err != nil {
+		return err
+	}
+	if err := repo.ArchiveFund(bucket.Funds[1].ID); err != nil {
+		return err
+	}
+	s, err := repo.GetSuggestionByID(saved[1].ID)
+	if err != nil {
+		return err
+	}
+	if err := expect(s.FundStatus == FundStatusArchived, "基金归档后建议的 fund_status 应为 archived，实际 %s", s.FundStatus); err != nil {
+		return err
+	}
+	if _, err := repo.GetSuggestionByID(-1); !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("建议不存在时应返回 sql.ErrNoRows，实际: %v", err)
+	}
+	return nil
+}
+
+func checkRepositoryExecution(repo Repository) error {
+	bucket, err := addConformanceBucket(repo, "执行检查桶", 0, SeedFund{Name: "执行基金", Code: "000051", Current: 10, Weight: 1})
+	if err != nil {
+		return err
+	}
+	fund := bucket.Funds[0]
+	snapshot := newRebalanceSnapshot(convertDBBucketsToAPIBuckets([]DBBucket{*bucket}), RebalanceOptions{Strategy: defaultStrategyName, Threshold: 0.05, Mode: RebalanceModeTarget})
+	recordID, err := repo.SaveRebalanceRecord(snapshot, RebalanceTriggerManual, 10, 0, []RebalanceSuggestion{
+		{FundID: fund.ID, FundName: fund.Name, FundCode: fund.Code, CurrentValue: 10, TargetValue: 12, DiffValue: 2, Advice: "买入"},
+	})
+	if err != nil {
+		return err
+	}
+	saved, err := repo.GetSuggestionsByRecordID(recordID)
+	if err != nil {
+		return err
+	}
+	if len(saved) != 1 {
+		return fmt.Errorf("应有1条建议，实际 %d 条", len(saved))
+	}
+
+	req := ExecutionRequest{Status: SuggestionExecuted, Amount: 2, NAV: 2, Date: "2024-05-06", Note: "检查"}
+	planned := []Transaction{
+		{FundID: fund.ID, Type: TransactionBuy, TradeDate: "2024-05-06", Shares: 50000, NAV: 2, Note: "期初持仓"},
+		{FundID: fund.ID, Type: TransactionBuy, TradeDate: "2024-05-06", Shares: 10000, NAV: 2, Note: "执行"},
+	}
+	if _, err := repo.SaveFundNAVs([]FundNAV{{Code: fund.Code, Date: "2024-05-07", UnitNAV: 2.5, Source: NAVSourceImport}}); err != nil {
+		return err
+	}
+	if err := repo.SaveExecution(saved[0], req, planned); err != nil {
+		return err
+	}
+	// 同一条建议再次登记时已不是未处理状态，不应再写入交易流水
+	if err := repo.SaveExecution(saved[0], req, planned); !errors.Is(err, errSuggestionNotPending) {
+		return fmt.Errorf("重复登记应返回 errSuggestionNotPending，实际: %v", err)
+	}
+
+	s, err := repo.GetSuggestionByID(saved[0].ID)
+	if err != nil {
+		return err
+	}
+	txs, err := repo.GetTransactionsByFundID(fund.ID)
+	if err != nil {
+		return err
+	}
+	if err := expect(len(txs) == 2 && s.TransactionID == txs[1].ID && s.ExecutedShares == 10000,
+		"执行登记应关联最后一笔交易流水: %+v", *s); err != nil {
+		return err
+	}
+	if err := expect(s.Status == SuggestionExecuted && s.ExecutedAmount == 2 && s.ExecutedNAV == 2 && s.ExecutedAt == "2024-05-06" && s.ExecutionNote == "检查",
+		"执行情况有误: %+v", *s); err != nil {
+		return err
+	}
+	updated, err := repo.GetFundByID(fund.ID)
+	if err != nil {
+		return err
+	}
+	// 市值按交易流水的60000份和净值库中更新的净值2.5计算
+	return expect(approxEqual(updated.Current, 15), "执行后基金市值应为15，实际 %v", updated.Current)
+}
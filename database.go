@@ -1,20 +1,16 @@
 package main
 
 import (
-	"database/sql"
 	"fmt"
 	"log"
 	"time"
-
-	_ "github.com/mattn/go-sqlite3"
 )
 
-var db *sql.DB
-
 // 数据库模型
 type DBBucket struct {
 	ID         int       `json:"id" db:"id"`
 	Name       string    `json:"name" db:"name"`
+	ParentID   int       `json:"parent_id" db:"parent_id"` // 父桶ID，0为顶层桶
 	TargetRate float64   `json:"target_rate" db:"target_rate"`
 	CreatedAt  time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
@@ -22,23 +18,51 @@ type DBBucket struct {
 }
 
 type DBFund struct {
-	ID        int       `json:"id" db:"id"`
-	BucketID  int       `json:"bucket_id" db:"bucket_id"`
-	Name      string    `json:"name" db:"name"`
-	Code      string    `json:"code" db:"code"`
-	Current   float64   `json:"current" db:"current"`
-	Weight    float64   `json:"weight" db:"weight"`
-	Target    float64   `json:"target" db:"target"`
-	Diff      float64   `json:"diff" db:"diff"`
-	Advice    string    `json:"advice" db:"advice"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	ID       int         `json:"id" db:"id"`
+	BucketID int         `json:"bucket_id" db:"bucket_id"`
+	Name     string      `json:"name" db:"name"`
+	Code     string      `json:"code" db:"code"`
+	Current  float64     `json:"current" db:"current"`
+	Weight   float64     `json:"weight" db:"weight"`
+	Target   float64     `json:"target" db:"target"`
+	Diff     float64     `json:"diff" db:"diff"`
+	Advice   string      `json:"advice" db:"advice"`
+	Fees     FeeSchedule `json:"fees"`
+	MinTrade float64     `json:"min_trade" db:"min_trade"` // 最低交易金额(万元)
+	// 有交易流水时市值由 份额×最新净值 计算，手动修改市值后改用手动值
+	CurrentOverride bool     `json:"current_override" db:"current_override"`
+	CurrentSource   string   `json:"current_source"`    // manual 手动录入，ledger 由流水计算，override 有流水但手动覆盖
+	Holding         *Holding `json:"holding,omitempty"` // 由交易流水得到的持仓
+	// 归档时间，未归档为空；归档的基金不参与再平衡和列表
+	ArchivedAt string    `json:"archived_at,omitempty" db:"archived_at"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
 }
 
+// 历史建议所属基金的当前状态
+const (
+	FundStatusActive   = "active"
+	FundStatusArchived = "archived"
+	FundStatusDeleted  = "deleted" // 归档功能之前被删除的基金
+)
+
+// 再平衡记录的触发方式
+const (
+	RebalanceTriggerManual    = "manual"    // 手动执行(Web、API或命令行)
+	RebalanceTriggerScheduled = "scheduled" // 按日历定时执行
+	RebalanceTriggerDrift     = "drift"     // 偏离超出阈值时自动执行
+)
+
 type RebalanceRecord struct {
 	ID         int       `json:"id" db:"id"`
+	Strategy   string    `json:"strategy" db:"strategy"` // 再平衡策略
 	Threshold  float64   `json:"threshold" db:"threshold"`
 	TotalValue float64   `json:"total_value" db:"total_value"`
+	Mode       string    `json:"mode" db:"mode"`             // 再平衡模式：target 或 band_edge
+	InnerBand  float64   `json:"inner_band" db:"inner_band"` // 偏离带边缘模式下的内层带比例
+	CashFlow   float64   `json:"cash_flow" db:"cash_flow"`   // 现金流模式下投入(正)或取出(负)的金额
+	TotalFee   float64   `json:"total_fee" db:"total_fee"`   // 预计总交易费用(万元)
+	Trigger    string    `json:"trigger" db:"trigger"`       // 触发方式：manual、scheduled 或 drift
 	CreatedAt  time.Time `json:"created_at" db:"created_at"`
 }
 
@@ -53,357 +77,358 @@ type RebalanceSuggestion struct {
 	DiffValue    float64   `json:"diff_value" db:"diff_value"`
 	Advice       string    `json:"advice" db:"advice"`
 	Reason       string    `json:"reason" db:"reason"`
+	Fee          float64   `json:"fee" db:"fee"` // 预计交易费用(万元)
 	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+
+	// 执行情况
+	Status         string  `json:"status" db:"status"`                   // pending、executed、partial 或 skipped
+	ExecutedAmount float64 `json:"executed_amount" db:"executed_amount"` // 实际成交金额(万元)，买入为正、卖出为负
+	ExecutedNAV    float64 `json:"executed_nav" db:"executed_nav"`       // 成交净值
+	ExecutedShares float64 `json:"executed_shares" db:"executed_shares"` // 成交份额
+	ExecutedAt     string  `json:"executed_at" db:"executed_at"`         // 成交日期
+	ExecutionNote  string  `json:"execution_note" db:"execution_note"`
+	TransactionID  int     `json:"transaction_id" db:"transaction_id"` // 对应的交易流水ID，0表示没有
+	Gap            float64 `json:"gap"`                                // 实际成交与建议的差额(万元)
+	FundStatus     string  `json:"fund_status"`                        // 基金当前状态：active、archived 或 deleted
 }
 
-// 初始化数据库
+// 再平衡历史详情
+type RebalanceDetail struct {
+	Record      RebalanceRecord       `json:"record"`
+	Suggestions []RebalanceSuggestion `json:"suggestions"`
+	Execution   ExecutionSummary      `json:"execution"`
+	Snapshot    *RebalanceSnapshot    `json:"snapshot"` // 再平衡时的配置快照，旧记录为 null
+}
+
+// 初始化存储：打开数据库，执行尚未执行的迁移(迁移前自动备份)，并写入默认数据
 func initDatabase() error {
-	var err error
-	db, err = sql.Open("sqlite3", "./fund_data.db")
-	if err != nil {
-		return fmt.Errorf("打开数据库失败: %v", err)
+	if err := openDatabase(); err != nil {
+		return err
 	}
 
-	// 创建表
-	if err := createTables(); err != nil {
-		return fmt.Errorf("创建表失败: %v", err)
+	result, err := store.Migrate(true)
+	if err != nil {
+		return fmt.Errorf("数据库迁移失败: %v", err)
+	}
+	if len(result.Applied) > 0 && result.Backup != "" {
+		log.Printf("✅ 数据库结构已从版本 %d 升级到 %d，迁移前的数据已备份到 %s", result.FromVersion, result.ToVersion, result.Backup)
 	}
 
-	// 初始化默认数据
+	// 初始化默认数据，种子文件有误时不启动，避免在空库上继续运行
 	if err := initDefaultData(); err != nil {
-		log.Printf("初始化默认数据警告: %v", err)
+		return fmt.Errorf("初始化默认数据失败: %v", err)
 	}
 
 	log.Println("✅ 数据库初始化完成")
 	return nil
 }
 
-// 创建数据库表
-func createTables() error {
-	queries := []string{
-		`CREATE TABLE IF NOT EXISTS buckets (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			name TEXT NOT NULL UNIQUE,
-			target_rate REAL NOT NULL,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		)`,
-
-		`CREATE TABLE IF NOT EXISTS funds (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			bucket_id INTEGER NOT NULL,
-			name TEXT NOT NULL,
-			code TEXT NOT NULL,
-			current REAL NOT NULL DEFAULT 0,
-			weight REAL NOT NULL DEFAULT 0,
-			target REAL NOT NULL DEFAULT 0,
-			diff REAL NOT NULL DEFAULT 0,
-			advice TEXT DEFAULT '',
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (bucket_id) REFERENCES buckets(id) ON DELETE CASCADE
-		)`,
-
-		`CREATE TABLE IF NOT EXISTS rebalance_records (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			threshold REAL NOT NULL,
-			total_value REAL NOT NULL,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		)`,
-
-		`CREATE TABLE IF NOT EXISTS rebalance_suggestions (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			record_id INTEGER NOT NULL,
-			fund_id INTEGER NOT NULL,
-			fund_name TEXT NOT NULL,
-			fund_code TEXT NOT NULL,
-			current_value REAL NOT NULL,
-			target_value REAL NOT NULL,
-			diff_value REAL NOT NULL,
-			advice TEXT NOT NULL,
-			reason TEXT DEFAULT '',
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			FOREIGN KEY (record_id) REFERENCES rebalance_records(id) ON DELETE CASCADE,
-			FOREIGN KEY (fund_id) REFERENCES funds(id) ON DELETE CASCADE
-		)`,
-
-		`CREATE INDEX IF NOT EXISTS idx_funds_bucket_id ON funds(bucket_id)`,
-		`CREATE INDEX IF NOT EXISTS idx_suggestions_record_id ON rebalance_suggestions(record_id)`,
-		`CREATE INDEX IF NOT EXISTS idx_suggestions_fund_id ON rebalance_suggestions(fund_id)`,
-	}
-
-	for _, query := range queries {
-		if _, err := db.Exec(query); err != nil {
-			return fmt.Errorf("执行SQL失败 [%s]: %v", query, err)
-		}
-	}
-
-	return nil
+// 按配置的存储后端打开数据库，不执行迁移
+func openDatabase() error {
+	var err error
+	store, err = openRepository(appConfig.Driver, appConfig.Database)
+	return err
 }
 
-// 初始化默认数据
+// 新数据库写入种子组合，默认为内置示例组合
 func initDefaultData() error {
 	// 检查是否已有数据
-	var count int
-	err := db.QueryRow("SELECT COUNT(*) FROM buckets").Scan(&count)
+	buckets, err := store.GetBuckets()
 	if err != nil {
 		return err
 	}
 
-	if count > 0 {
+	if len(buckets) > 0 {
 		log.Println("数据库已有数据，跳过初始化")
 		return nil
 	}
 
-	// 插入默认桶
-	buckets := []struct {
-		name       string
-		targetRate float64
-	}{
-		{"短期桶（货币基金）", 0.10},
-		{"中期桶（债券基金）", 0.30},
-		{"长期桶（股票基金）", 0.60},
+	seed, err := loadSeedPortfolio(appConfig.Seed)
+	if err != nil {
+		return err
+	}
+	if len(seed.Buckets) == 0 {
+		log.Println("种子组合为空，不写入初始数据")
+		return nil
 	}
 
+	if err := addSeedBuckets(seed.Buckets, 0); err != nil {
+		return err
+	}
+
+	log.Println("✅ 默认数据初始化完成")
+	return nil
+}
+
+// 写入种子组合中的桶和基金，子桶写在父桶之后
+func addSeedBuckets(buckets []SeedBucket, parentID int) error {
 	for _, bucket := range buckets {
-		_, err := db.Exec(
-			"INSERT INTO buckets (name, target_rate) VALUES (?, ?)",
-			bucket.name, bucket.targetRate,
-		)
+		bucketID, err := store.AddBucket(bucket.Name, bucket.TargetRate, parentID)
 		if err != nil {
 			return fmt.Errorf("插入桶数据失败: %v", err)
 		}
-	}
 
-	// 插入默认基金
-	funds := []struct {
-		bucketID int
-		name     string
-		code     string
-		current  float64
-		weight   float64
-	}{
-		{1, "易方达货币A", "000009", 20.0, 1.0},
-		{2, "广发国开债7-10A", "003375", 50.0, 0.5},
-		{2, "博时信用债纯债A", "050026", 40.0, 0.5},
-		{3, "易方达沪深300ETF联接A", "110020", 100.0, 0.4},
-		{3, "南方中证500ETF联接A", "160119", 80.0, 0.3},
-		{3, "汇添富海外互联网50ETF", "006327", 60.0, 0.3},
-	}
-
-	for _, fund := range funds {
-		_, err := db.Exec(`
-			INSERT INTO funds (bucket_id, name, code, current, weight) 
-			VALUES (?, ?, ?, ?, ?)`,
-			fund.bucketID, fund.name, fund.code, fund.current, fund.weight,
-		)
-		if err != nil {
-			return fmt.Errorf("插入基金数据失败: %v", err)
+		for _, fund := range bucket.Funds {
+			if err := store.AddFund(bucketID, fund.Name, fund.Code, fund.Current, fund.Weight); err != nil {
+				return fmt.Errorf("插入基金数据失败: %v", err)
+			}
+		}
+		if err := addSeedBuckets(bucket.Children, bucketID); err != nil {
+			return err
 		}
 	}
-
-	log.Println("✅ 默认数据初始化完成")
 	return nil
 }
 
-// 数据库操作函数
+// 获取所有桶及其基金，有交易流水的基金市值按流水计算
 func getAllBucketsFromDB() ([]DBBucket, error) {
-	query := `
-		SELECT id, name, target_rate, created_at, updated_at 
-		FROM buckets 
-		ORDER BY id
-	`
-
-	rows, err := db.Query(query)
+	buckets, err := store.GetBuckets()
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	var buckets []DBBucket
-	for rows.Next() {
-		var bucket DBBucket
-		err := rows.Scan(&bucket.ID, &bucket.Name, &bucket.TargetRate,
-			&bucket.CreatedAt, &bucket.UpdatedAt)
-		if err != nil {
-			return nil, err
-		}
-
-		// 获取桶内基金
-		funds, err := getFundsByBucketID(bucket.ID)
-		if err != nil {
-			return nil, err
-		}
-		bucket.Funds = funds
-
-		buckets = append(buckets, bucket)
+	if err := applyLedgerHoldings(buckets); err != nil {
+		return nil, err
 	}
 
-	return buckets, nil
+	// 按层级排列，父桶在前、子桶紧随其后，按索引操作桶的接口也使用这一顺序
+	return orderDBBuckets(buckets), nil
 }
 
-func getFundsByBucketID(bucketID int) ([]DBFund, error) {
-	query := `
-		SELECT id, bucket_id, name, code, current, weight, target, diff, advice, created_at, updated_at
-		FROM funds 
-		WHERE bucket_id = ?
-		ORDER BY id
-	`
-
-	rows, err := db.Query(query, bucketID)
+// 用交易流水计算的持仓市值替换手动录入的市值；净值库中有更新的净值时按最新净值计算
+func applyLedgerHoldings(buckets []DBBucket) error {
+	txs, err := store.GetTransactions()
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("获取交易流水失败: %v", err)
 	}
-	defer rows.Close()
+	holdings := computeHoldings(txs)
 
-	var funds []DBFund
-	for rows.Next() {
-		var fund DBFund
-		err := rows.Scan(&fund.ID, &fund.BucketID, &fund.Name, &fund.Code,
-			&fund.Current, &fund.Weight, &fund.Target, &fund.Diff, &fund.Advice,
-			&fund.CreatedAt, &fund.UpdatedAt)
-		if err != nil {
-			return nil, err
-		}
-		funds = append(funds, fund)
+	latestNAVs, err := store.GetLatestFundNAVs()
+	if err != nil {
+		return fmt.Errorf("获取最新净值失败: %v", err)
 	}
 
-	return funds, nil
-}
-
-func addFundToDB(bucketID int, name, code string, current, weight float64) error {
-	query := `
-		INSERT INTO funds (bucket_id, name, code, current, weight) 
-		VALUES (?, ?, ?, ?, ?)
-	`
+	for bi := range buckets {
+		for fi := range buckets[bi].Funds {
+			fund := &buckets[bi].Funds[fi]
+			fund.CurrentSource = "manual"
+			holding, ok := holdings[fund.ID]
+			if !ok {
+				continue
+			}
+			holding.useLatestNAV(latestNAVs[fund.Code])
 
-	_, err := db.Exec(query, bucketID, name, code, current, weight)
-	return err
+			fund.Holding = &holding
+			if fund.CurrentOverride {
+				fund.CurrentSource = "override"
+				continue
+			}
+			fund.CurrentSource = "ledger"
+			fund.Current = holding.Value()
+		}
+	}
+	return nil
 }
 
-func updateFundInDB(fundID int, field, value string) error {
-	query := fmt.Sprintf("UPDATE funds SET %s = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", field)
-	_, err := db.Exec(query, value, fundID)
-	return err
-}
+// 获取已归档的基金，最近归档的在前，市值按交易流水计算
+func getArchivedFunds() ([]DBFund, error) {
+	funds, err := store.GetArchivedFunds()
+	if err != nil {
+		return nil, err
+	}
 
-func deleteFundFromDB(fundID int) error {
-	_, err := db.Exec("DELETE FROM funds WHERE id = ?", fundID)
-	return err
+	holder := []DBBucket{{Funds: funds}}
+	if err := applyLedgerHoldings(holder); err != nil {
+		return nil, err
+	}
+	return holder[0].Funds, nil
 }
 
-func saveRebalanceRecord(threshold, totalValue float64, suggestions []RebalanceSuggestion) (int, error) {
-	// 开始事务
-	tx, err := db.Begin()
+// 获取基金的交易流水及持仓，持仓按最新净值计算
+func getFundLedger(fundID int) (*FundLedger, error) {
+	txs, err := store.GetTransactionsByFundID(fundID)
 	if err != nil {
-		return 0, err
+		return nil, fmt.Errorf("获取交易流水失败: %v", err)
+	}
+	ledger := newFundLedger(fundID, txs)
+	if len(txs) == 0 {
+		return &ledger, nil
 	}
-	defer tx.Rollback()
 
-	// 插入再平衡记录
-	result, err := tx.Exec(
-		"INSERT INTO rebalance_records (threshold, total_value) VALUES (?, ?)",
-		threshold, totalValue,
-	)
+	fund, err := store.GetFundByID(fundID)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-
-	recordID, err := result.LastInsertId()
+	latest, err := store.GetLatestFundNAVs()
 	if err != nil {
-		return 0, err
-	}
-
-	// 插入建议
-	for _, suggestion := range suggestions {
-		_, err := tx.Exec(`
-			INSERT INTO rebalance_suggestions 
-			(record_id, fund_id, fund_name, fund_code, current_value, target_value, diff_value, advice, reason)
-			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-			recordID, suggestion.FundID, suggestion.FundName, suggestion.FundCode,
-			suggestion.CurrentValue, suggestion.TargetValue, suggestion.DiffValue, suggestion.Advice, suggestion.Reason,
-		)
-		if err != nil {
-			return 0, err
-		}
+		return nil, fmt.Errorf("获取最新净值失败: %v", err)
 	}
-
-	return int(recordID), tx.Commit()
+	ledger.Holding.useLatestNAV(latest[fund.Code])
+	return &ledger, nil
 }
 
-func getRebalanceHistory(limit int) ([]RebalanceRecord, error) {
-	query := `
-		SELECT id, threshold, total_value, created_at 
-		FROM rebalance_records 
-		ORDER BY created_at DESC 
-		LIMIT ?
-	`
+// 导入净值：文件内去重后逐条新增或覆盖，并报告导入范围内的净值缺口；
+// 净值相同的记录不覆盖，但数据源抓取的记录会刷新来源和抓取时间
+func importFundNAVs(navs []FundNAV, minGap int) (*NAVImportResult, error) {
+	unique, duplicates := dedupNAVs(navs)
+	result := &NAVImportResult{Total: len(navs), Duplicates: duplicates, Codes: []string{}, Gaps: []NAVGap{}}
 
-	rows, err := db.Query(query, limit)
+	saved, err := store.SaveFundNAVs(unique)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
+	result.Inserted, result.Updated, result.Unchanged = saved.Inserted, saved.Updated, saved.Unchanged
 
-	var records []RebalanceRecord
-	for rows.Next() {
-		var record RebalanceRecord
-		err := rows.Scan(&record.ID, &record.Threshold, &record.TotalValue, &record.CreatedAt)
+	// 按基金检查导入日期范围内(含库中原有数据)的缺口
+	for start := 0; start < len(unique); {
+		end := start
+		for end < len(unique) && unique[end].Code == unique[start].Code {
+			end++
+		}
+		code := unique[start].Code
+		stored, err := store.GetFundNAVs(code, unique[start].Date, unique[end-1].Date)
 		if err != nil {
 			return nil, err
 		}
-		records = append(records, record)
+		dates := make([]string, len(stored))
+		for i, nav := range stored {
+			dates[i] = nav.Date
+		}
+		result.Codes = append(result.Codes, code)
+		result.Gaps = append(result.Gaps, findNAVGaps(code, dates, minGap)...)
+		start = end
 	}
 
-	return records, nil
+	return result, nil
 }
 
-func getRebalanceRecordByID(recordID int) (*RebalanceRecord, error) {
-	query := `
-		SELECT id, threshold, total_value, created_at 
-		FROM rebalance_records 
-		WHERE id = ?
-	`
+// 对当前配置执行再平衡，回写基金的再平衡结果并保存历史记录；adjustments 为按下滑路径和估值对目标占比的调整，
+// 记入配置快照，trigger 为触发方式
+func rebalanceAndRecord(buckets []Bucket, opts RebalanceOptions, adjustments []TargetAdjustment, trigger string) (StrategyResult, int, error) {
+	snapshot := newRebalanceSnapshot(buckets, opts)
+	snapshot.TargetAdjustments = adjustments
+	result, err := rebalance(buckets, opts)
+	if err != nil {
+		return result, 0, err
+	}
+	result.Diagnostics = append(targetAdjustmentDiagnostics(adjustments), result.Diagnostics...)
+	results := result.Buckets
+
+	if err := store.UpdateFundRebalanceResults(results); err != nil {
+		log.Printf("更新再平衡结果失败: %v", err)
+	}
+
+	recordID, err := store.SaveRebalanceRecord(snapshot, trigger, totalCurrentValue(results), totalTradeFee(results), buildRebalanceSuggestions(results))
+	return result, recordID, err
+}
 
-	var record RebalanceRecord
-	err := db.QueryRow(query, recordID).Scan(&record.ID, &record.Threshold, &record.TotalValue, &record.CreatedAt)
+// 获取再平衡记录及其建议和执行汇总
+func getRebalanceDetail(recordID int) (*RebalanceDetail, error) {
+	record, err := store.GetRebalanceRecordByID(recordID)
 	if err != nil {
 		return nil, err
 	}
+	suggestions, err := store.GetSuggestionsByRecordID(recordID)
+	if err != nil {
+		return nil, fmt.Errorf("获取建议详情失败: %v", err)
+	}
+	if suggestions == nil {
+		suggestions = []RebalanceSuggestion{}
+	}
+	snapshot, err := store.GetRebalanceSnapshot(recordID)
+	if err != nil {
+		return nil, err
+	}
+	return &RebalanceDetail{Record: *record, Suggestions: suggestions, Execution: summarizeExecution(suggestions), Snapshot: snapshot}, nil
+}
 
-	return &record, nil
+// 用当前的再平衡算法重新计算历史记录，输入取自记录的配置快照，时间取记录时间；不保存结果
+func replayRebalanceRecord(recordID int) (*ReplayResult, error) {
+	detail, err := getRebalanceDetail(recordID)
+	if err != nil {
+		return nil, err
+	}
+	if detail.Snapshot == nil {
+		return nil, errNoSnapshot
+	}
+
+	// 引入策略之前的快照没有策略名称，按默认策略重现
+	opts := detail.Snapshot.Options
+	if opts.Strategy == "" {
+		opts.Strategy = defaultStrategyName
+	}
+	result, err := rebalanceAt(detail.Snapshot.restoreBuckets(), opts, detail.Record.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	funds, changes := compareOutcomes(outcomesFromSuggestions(detail.Suggestions, detail.Snapshot), outcomesFromBuckets(result.Buckets))
+	return &ReplayResult{Record: detail.Record, Options: opts, Results: result.Buckets, Diagnostics: result.Diagnostics, Funds: funds, AdviceChanges: changes}, nil
 }
 
-func getRebalanceSuggestionsByRecordID(recordID int) ([]RebalanceSuggestion, error) {
-	query := `
-		SELECT id, record_id, fund_id, fund_name, fund_code, 
-		       current_value, target_value, diff_value, advice, 
-		       COALESCE(reason, '') as reason, created_at
-		FROM rebalance_suggestions 
-		WHERE record_id = ?
-		ORDER BY fund_name
-	`
-
-	rows, err := db.Query(query, recordID)
+// 逐只基金比较两条再平衡记录
+func compareRebalanceRecords(recordA, recordB int) (*RecordComparison, error) {
+	a, err := getRebalanceDetail(recordA)
+	if err != nil {
+		return nil, err
+	}
+	b, err := getRebalanceDetail(recordB)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
 
-	var suggestions []RebalanceSuggestion
-	for rows.Next() {
-		var suggestion RebalanceSuggestion
-		err := rows.Scan(&suggestion.ID, &suggestion.RecordID, &suggestion.FundID,
-			&suggestion.FundName, &suggestion.FundCode, &suggestion.CurrentValue,
-			&suggestion.TargetValue, &suggestion.DiffValue, &suggestion.Advice,
-			&suggestion.Reason, &suggestion.CreatedAt)
+	funds, changes := compareOutcomes(outcomesFromSuggestions(a.Suggestions, a.Snapshot), outcomesFromSuggestions(b.Suggestions, b.Snapshot))
+	return &RecordComparison{
+		A:                a.Record,
+		B:                b.Record,
+		TotalValueChange: roundAmount(b.Record.TotalValue - a.Record.TotalValue),
+		Buckets:          compareSnapshotBuckets(a.Snapshot, b.Snapshot),
+		Funds:            funds,
+		AdviceChanges:    changes,
+	}, nil
+}
+
+// 标记建议的执行情况；执行或部分执行时生成交易流水并更新基金市值，返回更新后的建议
+func executeSuggestion(s RebalanceSuggestion, req ExecutionRequest) (*RebalanceSuggestion, error) {
+	if err := checkExecutionRequest(s, &req); err != nil {
+		return nil, err
+	}
+
+	var planned []Transaction
+	if req.Status != SuggestionSkipped {
+		dbBuckets, err := getAllBucketsFromDB()
 		if err != nil {
+			return nil, fmt.Errorf("获取桶信息失败: %v", err)
+		}
+		bi, fi, found := findDBFundByID(dbBuckets, s.FundID)
+		if !found {
+			if s.FundStatus == FundStatusArchived {
+				return nil, executionErrorf("基金 %s 已归档，请先恢复或标记为跳过", s.FundName)
+			}
+			return nil, executionErrorf("基金 %s 已删除，只能标记为跳过", s.FundName)
+		}
+		fund := dbBuckets[bi].Funds[fi]
+
+		if req.NAV == 0 {
+			latest, err := store.GetLatestFundNAVs()
+			if err != nil {
+				return nil, fmt.Errorf("获取最新净值失败: %v", err)
+			}
+			req.NAV = latest[fund.Code].UnitNAV
+		}
+		existing, err := store.GetTransactionsByFundID(fund.ID)
+		if err != nil {
+			return nil, fmt.Errorf("获取交易流水失败: %v", err)
+		}
+		if planned, err = planExecutionTransactions(s, req, fund, existing); err != nil {
 			return nil, err
 		}
-		suggestions = append(suggestions, suggestion)
 	}
 
-	return suggestions, nil
+	if err := store.SaveExecution(s, req, planned); err != nil {
+		return nil, err
+	}
+
+	return store.GetSuggestionByID(s.ID)
 }
 
 // 转换函数：DB模型 -> API模型
@@ -411,64 +436,95 @@ func convertDBBucketsToAPIBuckets(dbBuckets []DBBucket) []Bucket {
 	var buckets []Bucket
 	for _, dbBucket := range dbBuckets {
 		bucket := Bucket{
+			ID:         dbBucket.ID,
 			Name:       dbBucket.Name,
+			ParentID:   dbBucket.ParentID,
 			TargetRate: dbBucket.TargetRate,
 			Funds:      make([]Fund, len(dbBucket.Funds)),
 		}
 
 		for i, dbFund := range dbBucket.Funds {
 			bucket.Funds[i] = Fund{
-				Name:    dbFund.Name,
-				Code:    dbFund.Code,
-				Current: dbFund.Current,
-				Weight:  dbFund.Weight,
-				Target:  dbFund.Target,
-				Diff:    dbFund.Diff,
-				Advice:  dbFund.Advice,
+				ID:            dbFund.ID,
+				Name:          dbFund.Name,
+				Code:          dbFund.Code,
+				Current:       dbFund.Current,
+				Weight:        dbFund.Weight,
+				Target:        dbFund.Target,
+				Diff:          dbFund.Diff,
+				Advice:        dbFund.Advice,
+				Fees:          dbFund.Fees,
+				MinTrade:      dbFund.MinTrade,
+				CurrentSource: dbFund.CurrentSource,
 			}
 		}
 
 		buckets = append(buckets, bucket)
 	}
+	annotateBucketTree(buckets)
 	return buckets
 }
 
-// 更新基金的再平衡结果到数据库
-func updateFundRebalanceResults(dbBuckets []DBBucket, rebalancedBuckets []Bucket) error {
-	// 构建基金ID到再平衡结果的映射
-	fundResultMap := make(map[string]Fund) // 使用 "name-code" 作为key
-
-	for _, bucket := range rebalancedBuckets {
+// 计算组合总市值
+func totalCurrentValue(buckets []Bucket) float64 {
+	var total float64
+	for _, bucket := range buckets {
 		for _, fund := range bucket.Funds {
-			key := fmt.Sprintf("%s-%s", fund.Name, fund.Code)
-			fundResultMap[key] = fund
+			total += fund.Current
 		}
 	}
+	return total
+}
 
-	// 更新数据库中的基金信息
-	for _, dbBucket := range dbBuckets {
-		for _, dbFund := range dbBucket.Funds {
-			key := fmt.Sprintf("%s-%s", dbFund.Name, dbFund.Code)
-			if result, exists := fundResultMap[key]; exists {
-				query := `
-					UPDATE funds 
-					SET target = ?, diff = ?, advice = ?, updated_at = CURRENT_TIMESTAMP 
-					WHERE id = ?
-				`
-				_, err := db.Exec(query, result.Target, result.Diff, result.Advice, dbFund.ID)
-				if err != nil {
-					return err
-				}
+// 由再平衡结果构建建议记录，通过基金ID关联回 funds 表
+func buildRebalanceSuggestions(results []Bucket) []RebalanceSuggestion {
+	var suggestions []RebalanceSuggestion
+	for _, bucket := range results {
+		for _, fund := range bucket.Funds {
+			if fund.ID == 0 {
+				continue
 			}
+			suggestions = append(suggestions, RebalanceSuggestion{
+				FundID:       fund.ID,
+				FundName:     fund.Name,
+				FundCode:     fund.Code,
+				CurrentValue: fund.Current,
+				TargetValue:  fund.Target,
+				DiffValue:    fund.Diff,
+				Advice:       fund.Advice,
+				Reason:       fund.Reason,
+				Fee:          fund.Fee,
+			})
 		}
 	}
+	return suggestions
+}
 
-	return nil
+// 按ID在桶列表中查找桶
+func findDBBucketByID(dbBuckets []DBBucket, bucketID int) (int, bool) {
+	for i, b := range dbBuckets {
+		if b.ID == bucketID {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// 按ID在桶列表中查找基金，返回所在桶和基金的位置
+func findDBFundByID(dbBuckets []DBBucket, fundID int) (int, int, bool) {
+	for bi, b := range dbBuckets {
+		for fi, f := range b.Funds {
+			if f.ID == fundID {
+				return bi, fi, true
+			}
+		}
+	}
+	return -1, -1, false
 }
 
 // 关闭数据库连接
 func closeDatabase() {
-	if db != nil {
-		db.Close()
+	if store != nil {
+		store.Close()
 	}
 }
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// 再平衡建议的执行状态
+const (
+	SuggestionPending  = "pending"  // 未处理
+	SuggestionExecuted = "executed" // 已执行
+	SuggestionPartial  = "partial"  // 部分执行
+	SuggestionSkipped  = "skipped"  // 跳过
+)
+
+// 标记建议执行情况的请求
+type ExecutionRequest struct {
+	Status string  `json:"status"` // executed、partial 或 skipped
+	Amount float64 `json:"amount"` // 实际成交金额(万元)，买入为正、卖出为负；executed 时默认为建议金额
+	NAV    float64 `json:"nav"`    // 成交净值(元/份)，默认取净值库中的最新净值
+	Date   string  `json:"date"`   // 成交日期(YYYY-MM-DD)，默认今天
+	Note   string  `json:"note"`
+}
+
+// 执行请求不合法，区别于数据库错误
+type executionError string
+
+func (e executionError) Error() string {
+	return string(e)
+}
+
+func executionErrorf(format string, args ...interface{}) error {
+	return executionError(fmt.Sprintf(format, args...))
+}
+
+// 保存执行情况时建议已不是未处理状态，通常是同一条建议被并发处理
+var errSuggestionNotPending = errors.New("该建议已被其他请求处理，请刷新后查看")
+
+// 校验执行请求并补全默认值
+func checkExecutionRequest(s RebalanceSuggestion, req *ExecutionRequest) error {
+	if s.Status != SuggestionPending {
+		return executionErrorf("该建议已标记为%s，不能重复处理", suggestionStatusLabel(s.Status))
+	}
+	if req.Date == "" {
+		req.Date = time.Now().Format(dateLayout)
+	}
+	if _, err := parseDate(req.Date); err != nil {
+		return executionError(err.Error())
+	}
+	if req.NAV < 0 {
+		return executionErrorf("成交净值不能为负数")
+	}
+
+	switch req.Status {
+	case SuggestionSkipped:
+		req.Amount = 0
+		return nil
+	case SuggestionExecuted:
+		if req.Amount == 0 {
+			req.Amount = s.DiffValue
+		}
+		if req.Amount == 0 {
+			return executionErrorf("该建议无需交易，只能标记为跳过")
+		}
+		if s.DiffValue != 0 && deviationSign(req.Amount) != deviationSign(s.DiffValue) {
+			return executionErrorf("实际成交金额的方向与建议不一致(买入为正、卖出为负)")
+		}
+	case SuggestionPartial:
+		if req.Amount == 0 {
+			return executionErrorf("部分执行需要填写实际成交金额")
+		}
+		if deviationSign(req.Amount) != deviationSign(s.DiffValue) || math.Abs(req.Amount) >= math.Abs(s.DiffValue) {
+			return executionErrorf("部分执行的成交金额须与建议方向一致且小于建议金额%.4f万", s.DiffValue)
+		}
+	default:
+		return executionErrorf("无效的执行状态: %s（可选 executed、partial、skipped）", req.Status)
+	}
+	return nil
+}
+
+// 生成执行建议对应的交易流水；基金还没有交易流水时，先按手动市值折算一笔期初持仓，
+// 使交易后按流水计算的市值与手动市值衔接
+func planExecutionTransactions(s RebalanceSuggestion, req ExecutionRequest, fund DBFund, existing []Transaction) ([]Transaction, error) {
+	if req.NAV <= 0 {
+		return nil, executionErrorf("净值库中没有基金 %s 的净值，请填写成交净值", fund.Code)
+	}
+
+	var planned []Transaction
+	if len(existing) == 0 && fund.Current > 0 {
+		planned = append(planned, Transaction{
+			FundID:    fund.ID,
+			Type:      TransactionBuy,
+			TradeDate: req.Date,
+			Shares:    fund.Current * 10000 / req.NAV,
+			NAV:       req.NAV,
+			Amount:    fund.Current,
+			Note:      "期初持仓(按手动市值折算)",
+		})
+	}
+
+	trade := Transaction{
+		FundID:    fund.ID,
+		Type:      TransactionBuy,
+		TradeDate: req.Date,
+		Shares:    math.Abs(req.Amount) * 10000 / req.NAV,
+		NAV:       req.NAV,
+		Amount:    math.Abs(req.Amount),
+		Note:      fmt.Sprintf("执行再平衡记录#%d的建议", s.RecordID),
+	}
+	if req.Amount < 0 {
+		trade.Type = TransactionSell
+	}
+	if req.Note != "" {
+		trade.Note += "：" + req.Note
+	}
+	planned = append(planned, trade)
+
+	for i := range planned {
+		if err := checkTransaction(&planned[i]); err != nil {
+			return nil, executionError(err.Error())
+		}
+	}
+	if err := checkLedger(append(append([]Transaction{}, existing...), planned...)); err != nil {
+		return nil, executionError(err.Error())
+	}
+	return planned, nil
+}
+
+// 实际成交与建议的差额(万元)，未处理的建议为0
+func (s RebalanceSuggestion) executionGap() float64 {
+	if s.Status == SuggestionPending {
+		return 0
+	}
+	return s.ExecutedAmount - s.DiffValue
+}
+
+// 执行状态的显示名称
+func suggestionStatusLabel(status string) string {
+	switch status {
+	case SuggestionPending:
+		return "未处理"
+	case SuggestionExecuted:
+		return "已执行"
+	case SuggestionPartial:
+		return "部分执行"
+	case SuggestionSkipped:
+		return "已跳过"
+	default:
+		return status
+	}
+}
+
+// 一次再平衡的执行汇总，只统计需要交易或已处理的建议
+type ExecutionSummary struct {
+	Pending       int     `json:"pending"`
+	Executed      int     `json:"executed"`
+	Partial       int     `json:"partial"`
+	Skipped       int     `json:"skipped"`
+	SuggestedBuy  float64 `json:"suggested_buy"`  // 建议买入合计(万元)
+	SuggestedSell float64 `json:"suggested_sell"` // 建议卖出合计(万元)
+	ExecutedBuy   float64 `json:"executed_buy"`   // 实际买入合计(万元)
+	ExecutedSell  float64 `json:"executed_sell"`  // 实际卖出合计(万元)
+}
+
+func summarizeExecution(suggestions []RebalanceSuggestion) ExecutionSummary {
+	var summary ExecutionSummary
+	for _, s := range suggestions {
+		if s.DiffValue == 0 && s.Status == SuggestionPending {
+			continue
+		}
+		switch s.Status {
+		case SuggestionExecuted:
+			summary.Executed++
+		case SuggestionPartial:
+			summary.Partial++
+		case SuggestionSkipped:
+			summary.Skipped++
+		default:
+			summary.Pending++
+		}
+
+		if s.DiffValue > 0 {
+			summary.SuggestedBuy += s.DiffValue
+		} else {
+			summary.SuggestedSell -= s.DiffValue
+		}
+		if s.ExecutedAmount > 0 {
+			summary.ExecutedBuy += s.ExecutedAmount
+		} else {
+			summary.ExecutedSell -= s.ExecutedAmount
+		}
+	}
+	return summary
+}
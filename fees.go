@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 基金费率，默认全部为0，即货币基金等免费基金
+type FeeSchedule struct {
+	PurchaseRate     float64          `json:"purchase_rate"`     // 申购费率，如0.015表示1.5%
+	PurchaseDiscount float64          `json:"purchase_discount"` // 申购费折扣，如0.1表示1折，0表示不打折
+	RedemptionTiers  []RedemptionTier `json:"redemption_tiers"`  // 按持有天数分档的赎回费率
+	HeldSince        string           `json:"held_since"`        // 持有起始日期(YYYY-MM-DD)，用于确定赎回费档位
+}
+
+// 赎回费档位：持有天数小于 MaxDays 时适用 Rate，MaxDays 为0表示不限
+type RedemptionTier struct {
+	MaxDays int     `json:"max_days"`
+	Rate    float64 `json:"rate"`
+}
+
+// 日期格式，用于持有起始日期和交易日期
+const dateLayout = "2006-01-02"
+
+// 解析赎回费档位，格式如 "7:0.015,30:0.0075,0:0"，表示持有不满7天1.5%，不满30天0.75%，此后免费
+func parseRedemptionTiers(value string) ([]RedemptionTier, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+
+	var tiers []RedemptionTier
+	for _, part := range strings.Split(value, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("无效的赎回费档位: %s（格式: 天数:费率,...）", part)
+		}
+		days, err := strconv.Atoi(strings.TrimSpace(kv[0]))
+		if err != nil || days < 0 {
+			return nil, fmt.Errorf("无效的持有天数: %s", kv[0])
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		if err != nil || rate < 0 || rate >= 1 {
+			return nil, fmt.Errorf("赎回费率必须在0-1之间: %s", kv[1])
+		}
+		tiers = append(tiers, RedemptionTier{MaxDays: days, Rate: rate})
+	}
+
+	for i, tier := range tiers {
+		if tier.MaxDays == 0 && i != len(tiers)-1 {
+			return nil, fmt.Errorf("天数为0(不限)的档位只能放在最后")
+		}
+		if i > 0 && tier.MaxDays != 0 && tier.MaxDays <= tiers[i-1].MaxDays {
+			return nil, fmt.Errorf("赎回费档位的持有天数必须递增")
+		}
+	}
+	return tiers, nil
+}
+
+// 解析 YYYY-MM-DD 格式的日期
+func parseDate(value string) (time.Time, error) {
+	t, err := time.ParseInLocation(dateLayout, strings.TrimSpace(value), time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("无效的日期: %s（格式: YYYY-MM-DD）", value)
+	}
+	return t, nil
+}
+
+// 申购费，按外扣法计算：申购费 = 申购金额 / (1 + 费率) × 费率
+func (f FeeSchedule) purchaseFee(amount float64) float64 {
+	rate := f.PurchaseRate
+	if f.PurchaseDiscount > 0 {
+		rate *= f.PurchaseDiscount
+	}
+	return amount / (1 + rate) * rate
+}
+
+// 按持有天数确定赎回费率；持有起始日期未知时视为长期持有，与持有天数超出所有档位时的费率相同
+func (f FeeSchedule) redemptionRate(now time.Time) (rate float64, days int, known bool) {
+	if len(f.RedemptionTiers) == 0 {
+		return 0, 0, false
+	}
+
+	heldSince, err := parseDate(f.HeldSince)
+	if f.HeldSince == "" || err != nil {
+		return f.longHeldRate(), 0, false
+	}
+
+	days = int(now.Sub(heldSince).Hours() / 24)
+	for _, tier := range f.RedemptionTiers {
+		if tier.MaxDays != 0 && days < tier.MaxDays {
+			return tier.Rate, days, true
+		}
+	}
+	return f.longHeldRate(), days, true
+}
+
+// 持有天数超出所有有上限的档位时的赎回费率：有不限天数(0)的档位时取其费率，否则免赎回费
+func (f FeeSchedule) longHeldRate() float64 {
+	if n := len(f.RedemptionTiers); n > 0 && f.RedemptionTiers[n-1].MaxDays == 0 {
+		return f.RedemptionTiers[n-1].Rate
+	}
+	return 0
+}
+
+// 估算单笔调整的交易费用(万元)，并返回费用说明
+func (f FeeSchedule) tradeFee(diff float64, now time.Time) (float64, string) {
+	switch {
+	case diff > 0:
+		fee := f.purchaseFee(diff)
+		if fee <= 0 {
+			return 0, "免申购费"
+		}
+		return fee, fmt.Sprintf("预计申购费%.2f元", fee*10000)
+	case diff < 0:
+		rate, days, known := f.redemptionRate(now)
+		fee := -diff * rate
+		if fee <= 0 {
+			return 0, "免赎回费"
+		}
+		if known {
+			return fee, fmt.Sprintf("已持有%d天，预计赎回费%.2f元(%.2f%%)", days, fee*10000, rate*100)
+		}
+		return fee, fmt.Sprintf("预计赎回费%.2f元(%.2f%%)", fee*10000, rate*100)
+	default:
+		return 0, ""
+	}
+}
+
+// maxFeeRate 大于0时，费用占交易金额比例超过该值的交易改为不动，返回各桶跳过的调整金额合计(万元)，
+// 由 applyTradeRules 与取消、取整的差额一并在桶内重新分配
+func skipCostlyTrades(buckets []Bucket, maxFeeRate float64, now time.Time) []float64 {
+	skipped := make([]float64, len(buckets))
+	if maxFeeRate <= 0 {
+		return skipped
+	}
+	for bi := range buckets {
+		for fi := range buckets[bi].Funds {
+			fund := &buckets[bi].Funds[fi]
+			if fund.Diff == 0 {
+				continue
+			}
+
+			fee, note := fund.Fees.tradeFee(fund.Diff, now)
+			if fee/math.Abs(fund.Diff) > maxFeeRate {
+				fund.Reason = fmt.Sprintf("%s；但%s，占交易金额%.2f%%，超过费用上限%.2f%%，跳过该笔交易",
+					fund.Reason, note, fee/math.Abs(fund.Diff)*100, maxFeeRate*100)
+				fund.Advice = "保持不动"
+				skipped[bi] += fund.Diff
+				fund.Diff = 0
+			}
+		}
+	}
+	return skipped
+}
+
+// 按最终的调整金额估算交易费用
+func applyTradeFees(buckets []Bucket, now time.Time) {
+	for bi := range buckets {
+		for fi := range buckets[bi].Funds {
+			fund := &buckets[bi].Funds[fi]
+			fund.Fee = 0
+			if fund.Diff == 0 {
+				continue
+			}
+
+			fee, note := fund.Fees.tradeFee(fund.Diff, now)
+			fund.Fee = fee
+			fund.Reason = fmt.Sprintf("%s（%s）", fund.Reason, note)
+		}
+	}
+}
+
+// 再平衡的预计总交易费用(万元)
+func totalTradeFee(buckets []Bucket) float64 {
+	var total float64
+	for _, b := range buckets {
+		for _, f := range b.Funds {
+			total += f.Fee
+		}
+	}
+	return total
+}
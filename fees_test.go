@@ -0,0 +1,184 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRedemptionTiers(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []RedemptionTier
+		wantErr bool
+	}{
+		{value: "", want: nil},
+		{value: "7:0.015,30:0.0075,0:0", want: []RedemptionTier{{7, 0.015}, {30, 0.0075}, {0, 0}}},
+		{value: " 7 : 0.015 , 365:0.005 ", want: []RedemptionTier{{7, 0.015}, {365, 0.005}}},
+		{value: "7", wantErr: true},
+		{value: "-1:0.01", wantErr: true},
+		{value: "7:1", wantErr: true},
+		{value: "0:0,7:0.015", wantErr: true},
+		{value: "30:0.0075,7:0.015", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseRedemptionTiers(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%q 应报错，实际解析为 %v", tt.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q 解析失败: %v", tt.value, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%q 解析为 %v，应为 %v", tt.value, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%q 解析为 %v，应为 %v", tt.value, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestRedemptionRate(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local)
+	withCatchAll := []RedemptionTier{{7, 0.015}, {30, 0.0075}, {0, 0.005}}
+	withoutCatchAll := []RedemptionTier{{7, 0.015}, {365, 0.005}}
+
+	tests := []struct {
+		name      string
+		tiers     []RedemptionTier
+		heldSince string
+		wantRate  float64
+		wantDays  int
+		wantKnown bool
+	}{
+		{"没有档位时免赎回费", nil, "2024-05-30", 0, 0, false},
+		{"持有不满7天", withCatchAll, "2024-05-30", 0.015, 2, true},
+		{"持有恰好7天进入下一档", withCatchAll, "2024-05-25", 0.0075, 7, true},
+		{"超出有上限的档位时取不限天数档位", withCatchAll, "2023-01-01", 0.005, 517, true},
+		{"持有起始日期未知时按长期持有", withCatchAll, "", 0.005, 0, false},
+		{"没有不限天数档位时长期持有免赎回费", withoutCatchAll, "2023-01-01", 0, 517, true},
+		{"没有不限天数档位且持有起始日期未知时同样免赎回费", withoutCatchAll, "", 0, 0, false},
+		{"持有起始日期无效时按长期持有", withoutCatchAll, "2024/01/01", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fees := FeeSchedule{RedemptionTiers: tt.tiers, HeldSince: tt.heldSince}
+			rate, days, known := fees.redemptionRate(now)
+			if rate != tt.wantRate || days != tt.wantDays || known != tt.wantKnown {
+				t.Errorf("费率%v、持有%d天、已知%v，应为费率%v、持有%d天、已知%v", rate, days, known, tt.wantRate, tt.wantDays, tt.wantKnown)
+			}
+		})
+	}
+}
+
+func TestTradeFee(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		name string
+		fees FeeSchedule
+		diff float64
+		want float64
+	}{
+		{"申购费按外扣法计算", FeeSchedule{PurchaseRate: 0.015}, 10, 10 / 1.015 * 0.015},
+		{"申购费打1折", FeeSchedule{PurchaseRate: 0.015, PurchaseDiscount: 0.1}, 10, 10 / 1.0015 * 0.0015},
+		{"卖出不收申购费", FeeSchedule{PurchaseRate: 0.015}, -10, 0},
+		{"赎回费按持有天数对应档位", FeeSchedule{RedemptionTiers: []RedemptionTier{{7, 0.015}, {0, 0}}, HeldSince: "2024-05-30"}, -10, 0.15},
+		{"不交易时没有费用", FeeSchedule{PurchaseRate: 0.015}, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, _ := tt.fees.tradeFee(tt.diff, now); !approxEqual(got, tt.want) {
+				t.Errorf("交易费用%.6f万，应为%.6f万", got, tt.want)
+			}
+		})
+	}
+}
+
+// 现金流模式下跳过费用过高的买入：跳过的金额只并入同一桶内的其他买入，桶内没有买入时以现金留存，目标市值不变
+func TestRebalanceCashFlowSkipsCostlyTrades(t *testing.T) {
+	costly := FeeSchedule{PurchaseRate: 0.015} // 申购费占买入金额1.48%，超过1%的上限
+	tests := []struct {
+		name     string
+		stock    []Fund
+		cashFlow float64
+		want     map[int]struct{ target, diff float64 }
+		wantCash float64
+	}{
+		{
+			// 投入后总市值120：债券48(+18)，股票甲36(+6)、乙18(+3)、丙18(+3)
+			name: "跳过的金额并入同一桶内金额最大的买入",
+			stock: []Fund{
+				{ID: 2, Name: "股票基金甲", Current: 30, Weight: 0.5},
+				{ID: 3, Name: "股票基金乙", Current: 15, Weight: 0.25, Fees: costly},
+				{ID: 4, Name: "股票基金丙", Current: 15, Weight: 0.25},
+			},
+			cashFlow: 30,
+			want:     map[int]struct{ target, diff float64 }{1: {48, 18}, 2: {36, 9}, 3: {18, 0}, 4: {18, 3}},
+		},
+		{
+			// 投入后总市值120：债券48(+18)，股票甲36(0)、乙36(+6)
+			name: "桶内没有其他买入时以现金留存，不并入其他桶",
+			stock: []Fund{
+				{ID: 2, Name: "股票基金甲", Current: 36, Weight: 0.5},
+				{ID: 3, Name: "股票基金乙", Current: 30, Weight: 0.5, Fees: costly},
+			},
+			cashFlow: 24,
+			want:     map[int]struct{ target, diff float64 }{1: {48, 18}, 2: {36, 0}, 3: {36, 0}},
+			wantCash: 6,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buckets := []Bucket{
+				{ID: 1, Name: "债券", TargetRate: 0.4, Funds: []Fund{{ID: 1, Name: "债券基金", Current: 30, Weight: 1}}},
+				{ID: 2, Name: "股票", TargetRate: 0.6, Funds: tt.stock},
+			}
+			opts := RebalanceOptions{Threshold: 0.05, Mode: RebalanceModeCashFlow, CashFlow: tt.cashFlow, MaxFeeRate: 0.01}
+			if err := normalizeRebalanceOptions(&opts); err != nil {
+				t.Fatal(err)
+			}
+			result, err := rebalanceAt(buckets, opts, time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var invested float64
+			for _, b := range result.Buckets {
+				for _, f := range b.Funds {
+					w := tt.want[f.ID]
+					if !approxEqual(f.Target, w.target) || !approxEqual(f.Diff, w.diff) {
+						t.Errorf("%s 目标%.4f、调整%.4f，应为目标%.4f、调整%.4f", f.Name, f.Target, f.Diff, w.target, w.diff)
+					}
+					invested += f.Diff
+				}
+			}
+			if !approxEqual(result.Unallocated, tt.wantCash) || !approxEqual(invested+result.Unallocated, tt.cashFlow) {
+				t.Errorf("买入%.4f万、以现金留存%.4f万，应为现金留存%.4f万且合计等于投入的%.4f万",
+					invested, result.Unallocated, tt.wantCash, tt.cashFlow)
+			}
+		})
+	}
+}
+
+// 并入差额后的费用占比超过上限的交易不再并入，差额并入下一笔
+func TestPlaceResidualChecksFees(t *testing.T) {
+	funds := []*Fund{
+		{Name: "高费率基金", Current: 10, Diff: 8, Fees: FeeSchedule{PurchaseRate: 0.015}},
+		{Name: "免费基金", Current: 10, Diff: 2},
+	}
+	placed := placeResidual(funds, 3, 0.01, time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local))
+	if !approxEqual(placed, 3) || funds[0].Diff != 8 || !approxEqual(funds[1].Diff, 5) {
+		t.Errorf("并入%.4f万，调整金额为 %.4f、%.4f，应并入3万且只并入免费基金", placed, funds[0].Diff, funds[1].Diff)
+	}
+}
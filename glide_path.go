@@ -0,0 +1,431 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 下滑路径的时间基准
+const (
+	GlideBasisDate = "date" // 按日期
+	GlideBasisAge  = "age"  // 按投资者年龄，需配置出生日期
+)
+
+// 下滑路径投影的点数上限，避免步长过小时返回过多数据
+const maxGlideProjectionPoints = 1200
+
+// 下滑路径上的一个点
+type GlidePoint struct {
+	Date       string  `json:"date,omitempty"` // 按日期时的日期 YYYY-MM-DD
+	Age        float64 `json:"age,omitempty"`  // 按年龄时的年龄(岁)
+	TargetRate float64 `json:"target_rate"`    // 子桶为在父桶中的占比
+}
+
+// 桶的下滑路径：目标占比按时间点设置，点之间线性插值，早于第一个点或晚于最后一个点时取端点的占比。
+// 有下滑路径的桶不再使用配置的目标占比，同一父桶下其他桶按原占比的比例补足
+type GlidePath struct {
+	BucketID int          `json:"bucket_id"`
+	Basis    string       `json:"basis"`  // date 或 age
+	Points   []GlidePoint `json:"points"` // 按日期或年龄升序
+}
+
+// 点在路径上的位置：按日期时为距1970-01-01的天数，按年龄时为岁数
+func (p GlidePath) position(pt GlidePoint) float64 {
+	if p.Basis == GlideBasisAge {
+		return pt.Age
+	}
+	t, _ := time.Parse(dateLayout, pt.Date)
+	return float64(t.Unix()) / 86400
+}
+
+// 某日在路径上的位置，birth 为投资者出生日期
+func (p GlidePath) positionAt(date, birth time.Time) float64 {
+	if p.Basis == GlideBasisAge {
+		return ageAt(birth, date)
+	}
+	return float64(date.Unix()) / 86400
+}
+
+func (p GlidePath) pointLabel(pt GlidePoint) string {
+	if p.Basis == GlideBasisAge {
+		return strconv.FormatFloat(pt.Age, 'f', -1, 64) + "岁"
+	}
+	return pt.Date
+}
+
+// 点对应的日期，按年龄时由出生日期推算
+func (p GlidePath) pointDate(pt GlidePoint, birth time.Time) time.Time {
+	if p.Basis == GlideBasisAge {
+		return birth.Add(time.Duration(pt.Age * 365.25 * 24 * float64(time.Hour))).Truncate(24 * time.Hour)
+	}
+	t, _ := time.Parse(dateLayout, pt.Date)
+	return t
+}
+
+// 路径的说明，如 "2025-01-01 80.0% → 2035-01-01 50.0%"
+func (p GlidePath) String() string {
+	parts := make([]string, len(p.Points))
+	for i, pt := range p.Points {
+		parts[i] = fmt.Sprintf("%s %.1f%%", p.pointLabel(pt), pt.TargetRate*100)
+	}
+	return strings.Join(parts, " → ")
+}
+
+// 按某日插值得到目标占比及说明
+func (p GlidePath) rateAt(date, birth time.Time) (float64, string) {
+	x := p.positionAt(date, birth)
+	here := date.Format(dateLayout)
+	if p.Basis == GlideBasisAge {
+		here = fmt.Sprintf("%.1f岁", x)
+	}
+
+	first, last := p.Points[0], p.Points[len(p.Points)-1]
+	if x < p.position(first) {
+		return first.TargetRate, fmt.Sprintf("下滑路径：%s 未到起点 %s，使用起点的目标占比", here, p.pointLabel(first))
+	}
+	if x > p.position(last) {
+		return last.TargetRate, fmt.Sprintf("下滑路径：%s 已过终点 %s，使用终点的目标占比", here, p.pointLabel(last))
+	}
+	for i := 1; i < len(p.Points); i++ {
+		a, b := p.Points[i-1], p.Points[i]
+		xa, xb := p.position(a), p.position(b)
+		if x <= xb {
+			rate := a.TargetRate + (b.TargetRate-a.TargetRate)*(x-xa)/(xb-xa)
+			return rate, fmt.Sprintf("下滑路径：%s 位于 %s(%.1f%%) 与 %s(%.1f%%) 之间，线性插值",
+				here, p.pointLabel(a), a.TargetRate*100, p.pointLabel(b), b.TargetRate*100)
+		}
+	}
+	return last.TargetRate, ""
+}
+
+// 按 365.25 天一年计算的年龄
+func ageAt(birth, date time.Time) float64 {
+	return date.Sub(birth).Hours() / 24 / 365.25
+}
+
+// 解析投资者出生日期，未配置时返回零值
+func glideBirthDate(birthDate string) (time.Time, error) {
+	if birthDate == "" {
+		return time.Time{}, nil
+	}
+	birth, err := time.Parse(dateLayout, birthDate)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("无效的出生日期: %s（格式: YYYY-MM-DD）", birthDate)
+	}
+	return birth, nil
+}
+
+// 解析 "时间点=目标占比,..." 形式的下滑路径，时间点按日期时为 YYYY-MM-DD，按年龄时为岁数
+func parseGlidePoints(basis, value string) ([]GlidePoint, error) {
+	var points []GlidePoint
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		at, rateStr, ok := strings.Cut(part, "=")
+		rate, err := strconv.ParseFloat(strings.TrimSpace(rateStr), 64)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("无效的下滑路径点: %s（格式: 时间点=目标占比,...）", part)
+		}
+		pt := GlidePoint{TargetRate: rate}
+		if basis == GlideBasisAge {
+			if pt.Age, err = strconv.ParseFloat(strings.TrimSpace(at), 64); err != nil {
+				return nil, fmt.Errorf("无效的年龄: %s", at)
+			}
+		} else {
+			pt.Date = strings.TrimSpace(at)
+		}
+		points = append(points, pt)
+	}
+	return points, nil
+}
+
+// 校验并整理桶的下滑路径(按时间排序、日期格式统一)；paths 为已有的下滑路径，
+// 用新路径替换该桶原有的路径后，各时间点同一父桶下的目标占比须能补足到100%
+func validateGlidePath(path *GlidePath, dbBuckets []DBBucket, paths []GlidePath, birthDate string) error {
+	if _, found := findDBBucketByID(dbBuckets, path.BucketID); !found {
+		return fmt.Errorf("桶不存在: %d", path.BucketID)
+	}
+	switch path.Basis {
+	case GlideBasisDate:
+	case GlideBasisAge:
+		if birthDate == "" {
+			return fmt.Errorf("按年龄的下滑路径需要先配置投资者出生日期(birth_date 或 FUND_BIRTH_DATE)")
+		}
+	default:
+		return fmt.Errorf("无效的下滑路径基准: %s（可选 %s、%s）", path.Basis, GlideBasisDate, GlideBasisAge)
+	}
+	if len(path.Points) < 2 {
+		return fmt.Errorf("下滑路径至少需要两个点")
+	}
+
+	for i := range path.Points {
+		pt := &path.Points[i]
+		if path.Basis == GlideBasisDate {
+			t, err := time.Parse(dateLayout, strings.TrimSpace(pt.Date))
+			if err != nil {
+				return fmt.Errorf("无效的日期: %s（格式: YYYY-MM-DD）", pt.Date)
+			}
+			pt.Date, pt.Age = t.Format(dateLayout), 0
+		} else {
+			if pt.Age <= 0 || pt.Age >= 150 {
+				return fmt.Errorf("无效的年龄: %v", pt.Age)
+			}
+			pt.Date = ""
+		}
+		if pt.TargetRate < 0 || pt.TargetRate > 1 {
+			return fmt.Errorf("目标占比必须在0-1之间: %v", pt.TargetRate)
+		}
+	}
+	sort.SliceStable(path.Points, func(i, j int) bool {
+		return path.position(path.Points[i]) < path.position(path.Points[j])
+	})
+	for i := 1; i < len(path.Points); i++ {
+		if path.position(path.Points[i]) == path.position(path.Points[i-1]) {
+			return fmt.Errorf("下滑路径的时间点重复: %s", path.pointLabel(path.Points[i]))
+		}
+	}
+
+	// 各路径都在端点之间线性变化，检查所有端点即可覆盖整条路径
+	merged := append(withoutGlidePath(paths, path.BucketID), *path)
+	birth, err := glideBirthDate(birthDate)
+	if err != nil {
+		return err
+	}
+	buckets := convertDBBucketsToAPIBuckets(dbBuckets)
+	for _, p := range merged {
+		for _, pt := range p.Points {
+			date := p.pointDate(pt, birth).Format(dateLayout)
+			if _, err := adjustTargetsByGlidePath(append([]Bucket(nil), buckets...), merged, date, birthDate); err != nil {
+				return fmt.Errorf("%s 时%v", date, err)
+			}
+		}
+	}
+	return nil
+}
+
+// 去掉某个桶的下滑路径
+func withoutGlidePath(paths []GlidePath, bucketID int) []GlidePath {
+	result := make([]GlidePath, 0, len(paths))
+	for _, p := range paths {
+		if p.BucketID != bucketID {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// 按下滑路径计算 date 的目标占比并写入 buckets：有下滑路径的桶使用插值得到的占比，
+// 同一父桶下其他桶按原占比的比例补足，使合计仍为100%
+func adjustTargetsByGlidePath(buckets []Bucket, paths []GlidePath, date, birthDate string) ([]TargetAdjustment, error) {
+	byBucket := make(map[int]GlidePath)
+	for _, p := range paths {
+		byBucket[p.BucketID] = p
+	}
+	applicable := false
+	for _, b := range buckets {
+		if _, ok := byBucket[b.ID]; ok {
+			applicable = true
+		}
+	}
+	if !applicable {
+		return nil, nil
+	}
+	if err := validateBucketTargets(buckets); err != nil {
+		return nil, err
+	}
+	day, err := time.Parse(dateLayout, date)
+	if err != nil {
+		return nil, fmt.Errorf("无效的日期: %s（格式: YYYY-MM-DD）", date)
+	}
+	birth, err := glideBirthDate(birthDate)
+	if err != nil {
+		return nil, err
+	}
+
+	t := newBucketTree(buckets)
+	rates := make([]float64, len(buckets))
+	fixed := make([]bool, len(buckets))
+	var adjustments []TargetAdjustment
+	for i, b := range buckets {
+		rates[i] = b.TargetRate
+		path, ok := byBucket[b.ID]
+		if !ok {
+			continue
+		}
+		if path.Basis == GlideBasisAge && birthDate == "" {
+			return nil, fmt.Errorf("%s 的下滑路径按年龄设置，需要先配置投资者出生日期", t.path(i))
+		}
+		rate, note := path.rateAt(day, birth)
+		rates[i], fixed[i] = rate, true
+		adjustments = append(adjustments, TargetAdjustment{
+			BucketID: b.ID, BucketName: t.path(i), BaseRate: b.TargetRate, TargetRate: rate, Note: note,
+		})
+	}
+
+	filled, err := fillSiblingTargets(buckets, rates, fixed, "按下滑路径调整")
+	if err != nil {
+		return nil, err
+	}
+	return append(adjustments, filled...), nil
+}
+
+// 按数据库中的下滑路径计算 date 的目标占比，没有下滑路径时不做调整
+func applyGlidePathTargets(buckets []Bucket, date string) ([]TargetAdjustment, error) {
+	paths, err := store.GetGlidePaths()
+	if err != nil {
+		return nil, fmt.Errorf("获取下滑路径失败: %v", err)
+	}
+	return adjustTargetsByGlidePath(buckets, paths, date, appConfig.BirthDate)
+}
+
+// 计算本次再平衡使用的目标占比：先按下滑路径得到当日的基准占比，再按估值规则调整
+func applyDynamicTargets(buckets []Bucket, date string) ([]TargetAdjustment, error) {
+	adjustments, err := applyGlidePathTargets(buckets, date)
+	if err != nil {
+		return nil, fmt.Errorf("按下滑路径计算目标占比失败: %v", err)
+	}
+	valuationAdjustments, err := applyValuationTargets(buckets, date)
+	if err != nil {
+		return nil, fmt.Errorf("按估值调整目标占比失败: %v", err)
+	}
+	return append(adjustments, valuationAdjustments...), nil
+}
+
+// 下滑路径的投影：各日期按下滑路径计算的各桶目标占比，用于绘制下滑路径图
+type GlideProjection struct {
+	BirthDate string                 `json:"birth_date,omitempty"`
+	Paths     []GlidePath            `json:"paths"`
+	Points    []GlideProjectionPoint `json:"points"`
+}
+
+// 投影中某一日各桶的目标占比
+type GlideProjectionPoint struct {
+	Date    string              `json:"date"`
+	Age     float64             `json:"age,omitempty"` // 配置了出生日期时为投资者年龄
+	Targets []GlideBucketTarget `json:"targets"`
+}
+
+type GlideBucketTarget struct {
+	BucketID      int     `json:"bucket_id"`
+	Name          string  `json:"name"`           // 从顶层到该桶的名称
+	TargetRate    float64 `json:"target_rate"`    // 子桶为在父桶中的占比
+	EffectiveRate float64 `json:"effective_rate"` // 占总市值的目标占比
+}
+
+// 投影步长对应的月数
+func glideStepMonths(step string) (int, error) {
+	switch step {
+	case "", "year":
+		return 12, nil
+	case "quarter":
+		return 3, nil
+	case "month":
+		return 1, nil
+	}
+	return 0, fmt.Errorf("无效的步长: %s（可选 month、quarter、year）", step)
+}
+
+// 按下滑路径计算 from 到 to 之间每隔 months 个月以及每个路径端点的目标占比；
+// to 为空时到最后一个路径端点为止。估值规则依赖未来的估值，不计入投影
+func projectGlidePaths(buckets []Bucket, paths []GlidePath, birthDate, from, to string, months int) (*GlideProjection, error) {
+	start, err := time.Parse(dateLayout, from)
+	if err != nil {
+		return nil, fmt.Errorf("无效的日期: %s（格式: YYYY-MM-DD）", from)
+	}
+	birth, err := glideBirthDate(birthDate)
+	if err != nil {
+		return nil, err
+	}
+	var breakpoints []time.Time
+	for _, p := range paths {
+		if p.Basis == GlideBasisAge && birthDate == "" {
+			return nil, fmt.Errorf("桶 %d 的下滑路径按年龄设置，需要先配置投资者出生日期", p.BucketID)
+		}
+		for _, pt := range p.Points {
+			breakpoints = append(breakpoints, p.pointDate(pt, birth))
+		}
+	}
+
+	end := start
+	if to != "" {
+		if end, err = time.Parse(dateLayout, to); err != nil {
+			return nil, fmt.Errorf("无效的日期: %s（格式: YYYY-MM-DD）", to)
+		}
+		if end.Before(start) {
+			return nil, fmt.Errorf("结束日期不能早于开始日期")
+		}
+	} else {
+		for _, d := range breakpoints {
+			if d.After(end) {
+				end = d
+			}
+		}
+	}
+
+	seen := make(map[string]bool)
+	var dates []string
+	add := func(d time.Time) {
+		if key := d.Format(dateLayout); !seen[key] {
+			seen[key] = true
+			dates = append(dates, key)
+		}
+	}
+	for i := 0; ; i++ {
+		d := start.AddDate(0, i*months, 0)
+		if d.After(end) {
+			break
+		}
+		if len(dates) >= maxGlideProjectionPoints {
+			return nil, fmt.Errorf("投影的点数超过%d个，请缩短区间或加大步长", maxGlideProjectionPoints)
+		}
+		add(d)
+	}
+	for _, d := range append(breakpoints, end) {
+		if !d.Before(start) && !d.After(end) {
+			add(d)
+		}
+	}
+	sort.Strings(dates)
+
+	projection := &GlideProjection{BirthDate: birthDate, Paths: paths, Points: make([]GlideProjectionPoint, 0, len(dates))}
+	for _, date := range dates {
+		day := append([]Bucket(nil), buckets...)
+		if _, err := adjustTargetsByGlidePath(day, paths, date, birthDate); err != nil {
+			return nil, fmt.Errorf("%s 时%v", date, err)
+		}
+		annotateBucketTree(day)
+
+		point := GlideProjectionPoint{Date: date, Targets: make([]GlideBucketTarget, 0, len(day))}
+		if birthDate != "" {
+			d, _ := time.Parse(dateLayout, date)
+			point.Age = ageAt(birth, d)
+		}
+		t := newBucketTree(day)
+		for i, b := range day {
+			point.Targets = append(point.Targets, GlideBucketTarget{BucketID: b.ID, Name: t.path(i), TargetRate: b.TargetRate, EffectiveRate: b.EffectiveRate})
+		}
+		projection.Points = append(projection.Points, point)
+	}
+	return projection, nil
+}
+
+// 按数据库中的配置和下滑路径计算投影，from 为空时从今天开始
+func getGlideProjection(from, to string, months int) (*GlideProjection, error) {
+	dbBuckets, err := getAllBucketsFromDB()
+	if err != nil {
+		return nil, fmt.Errorf("获取基金配置失败: %v", err)
+	}
+	paths, err := store.GetGlidePaths()
+	if err != nil {
+		return nil, fmt.Errorf("获取下滑路径失败: %v", err)
+	}
+	if from == "" {
+		from = time.Now().Format(dateLayout)
+	}
+	return projectGlidePaths(convertDBBucketsToAPIBuckets(dbBuckets), paths, appConfig.BirthDate, from, to, months)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGlidePathRateAt(t *testing.T) {
+	datePath := GlidePath{Basis: GlideBasisDate, Points: []GlidePoint{
+		{Date: "2025-01-01", TargetRate: 0.7}, {Date: "2035-01-01", TargetRate: 0.4}, {Date: "2045-01-01", TargetRate: 0.3},
+	}}
+	agePath := GlidePath{Basis: GlideBasisAge, Points: []GlidePoint{{Age: 40, TargetRate: 0.1}, {Age: 60, TargetRate: 0.3}}}
+	birth := time.Date(1985, 6, 15, 0, 0, 0, 0, time.UTC)
+	date := func(value string) time.Time {
+		d, _ := time.Parse(dateLayout, value)
+		return d
+	}
+	atAge := func(age float64) time.Time {
+		return birth.Add(time.Duration(age * 365.25 * 24 * float64(time.Hour)))
+	}
+
+	tests := []struct {
+		name     string
+		path     GlidePath
+		date     time.Time
+		want     float64
+		wantNote string
+	}{
+		{"未到起点时取起点的占比", datePath, date("2024-06-01"), 0.7, "未到起点"},
+		{"位于起点", datePath, date("2025-01-01"), 0.7, "线性插值"},
+		{"两点之间线性插值", datePath, date("2030-01-01"), 0.55, "2025-01-01(70.0%) 与 2035-01-01(40.0%) 之间"},
+		{"位于中间的点", datePath, date("2035-01-01"), 0.4, "线性插值"},
+		{"在第二段内插值", datePath, date("2040-01-01"), 0.4 - 0.1*1826/3653, "2035-01-01(40.0%) 与 2045-01-01(30.0%) 之间"},
+		{"位于终点", datePath, date("2045-01-01"), 0.3, "线性插值"},
+		{"已过终点时取终点的占比", datePath, date("2050-01-01"), 0.3, "已过终点"},
+		{"按年龄未到起点", agePath, atAge(30), 0.1, "未到起点 40岁"},
+		{"按年龄线性插值", agePath, atAge(50), 0.2, "40岁(10.0%) 与 60岁(30.0%) 之间"},
+		{"按年龄已过终点", agePath, atAge(70), 0.3, "已过终点 60岁"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, note := tt.path.rateAt(tt.date, birth)
+			if !approxEqual(got, tt.want) {
+				t.Errorf("目标占比%.6f，应为%.6f", got, tt.want)
+			}
+			if !strings.Contains(note, tt.wantNote) {
+				t.Errorf("说明 %q 应包含 %q", note, tt.wantNote)
+			}
+		})
+	}
+}
+
+func TestParseGlidePoints(t *testing.T) {
+	tests := []struct {
+		basis   string
+		value   string
+		want    []GlidePoint
+		wantErr bool
+	}{
+		{basis: GlideBasisDate, value: "2025-01-01=0.8, 2035-01-01=0.5", want: []GlidePoint{{Date: "2025-01-01", TargetRate: 0.8}, {Date: "2035-01-01", TargetRate: 0.5}}},
+		{basis: GlideBasisAge, value: "40=0.1,60=0.3,", want: []GlidePoint{{Age: 40, TargetRate: 0.1}, {Age: 60, TargetRate: 0.3}}},
+		{basis: GlideBasisDate, value: "2025-01-01:0.8", wantErr: true},
+		{basis: GlideBasisAge, value: "四十=0.1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseGlidePoints(tt.basis, tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%q 应报错，实际解析为 %v", tt.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q 解析失败: %v", tt.value, err)
+			continue
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%q 解析为 %v，应为 %v", tt.value, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%q 解析为 %v，应为 %v", tt.value, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+// 有下滑路径的桶使用插值得到的占比，同一父桶下其他桶按原占比的比例补足
+func TestAdjustTargetsByGlidePath(t *testing.T) {
+	newBuckets := func() []Bucket {
+		return []Bucket{
+			{ID: 1, Name: "短期", TargetRate: 0.1},
+			{ID: 2, Name: "中期", TargetRate: 0.3},
+			{ID: 3, Name: "长期", TargetRate: 0.6},
+		}
+	}
+	paths := []GlidePath{{BucketID: 3, Basis: GlideBasisDate, Points: []GlidePoint{
+		{Date: "2025-01-01", TargetRate: 0.7}, {Date: "2035-01-01", TargetRate: 0.5},
+	}}}
+
+	tests := []struct {
+		name  string
+		paths []GlidePath
+		date  string
+		want  []float64
+	}{
+		{"没有下滑路径时目标不变", nil, "2030-01-01", []float64{0.1, 0.3, 0.6}},
+		{"未到起点", paths, "2024-01-01", []float64{0.075, 0.225, 0.7}},
+		{"两点之间", paths, "2030-01-01", []float64{0.1, 0.3, 0.6}},
+		{"已过终点", paths, "2040-01-01", []float64{0.125, 0.375, 0.5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buckets := newBuckets()
+			if _, err := adjustTargetsByGlidePath(buckets, tt.paths, tt.date, ""); err != nil {
+				t.Fatal(err)
+			}
+			for i, b := range buckets {
+				if !approxEqual(b.TargetRate, tt.want[i]) {
+					t.Errorf("%s 目标占比%.4f，应为%.4f", b.Name, b.TargetRate, tt.want[i])
+				}
+			}
+		})
+	}
+
+	agePath := []GlidePath{{BucketID: 1, Basis: GlideBasisAge, Points: []GlidePoint{{Age: 40, TargetRate: 0.1}, {Age: 60, TargetRate: 0.3}}}}
+	if _, err := adjustTargetsByGlidePath(newBuckets(), agePath, "2030-01-01", ""); err == nil {
+		t.Error("未配置出生日期时按年龄的下滑路径应报错")
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// 交易类型
+const (
+	TransactionBuy        = "buy"        // 买入(申购)
+	TransactionSell       = "sell"       // 卖出(赎回)
+	TransactionDividend   = "dividend"   // 分红，shares 为红利再投资的份额，现金分红只记 amount
+	TransactionConversion = "conversion" // 基金转换，shares 为正表示转入，为负表示转出
+	TransactionFee        = "fee"        // 费用，shares 为扣除的份额，现金支付的费用只记 amount
+)
+
+// 交易流水
+type Transaction struct {
+	ID        int       `json:"id"`
+	FundID    int       `json:"fund_id"`
+	Type      string    `json:"type"`
+	TradeDate string    `json:"trade_date"` // 交易日期 YYYY-MM-DD
+	Shares    float64   `json:"shares"`     // 份额
+	NAV       float64   `json:"nav"`        // 成交净值(元/份)
+	Amount    float64   `json:"amount"`     // 金额(万元)
+	Note      string    `json:"note"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// 由交易流水得到的持仓
+type Holding struct {
+	Shares    float64 `json:"shares"`     // 持有份额
+	NAV       float64 `json:"nav"`        // 最新净值(元/份)
+	NAVDate   string  `json:"nav_date"`   // 最新净值日期
+	NAVSource string  `json:"nav_source"` // 最新净值来源：transaction 表示取自交易流水，其余同净值库的 source
+}
+
+// 持仓市值(万元)
+func (h Holding) Value() float64 {
+	return h.Shares * h.NAV / 10000
+}
+
+// 净值库中有更新的净值时按最新净值计算持仓
+func (h *Holding) useLatestNAV(nav FundNAV) {
+	if nav.UnitNAV > 0 && nav.Date > h.NAVDate {
+		h.NAV = nav.UnitNAV
+		h.NAVDate = nav.Date
+		h.NAVSource = nav.Source
+	}
+}
+
+// 交易对持有份额的影响
+func (t Transaction) shareDelta() float64 {
+	switch t.Type {
+	case TransactionBuy, TransactionDividend, TransactionConversion:
+		return t.Shares
+	case TransactionSell, TransactionFee:
+		return -t.Shares
+	default:
+		return 0
+	}
+}
+
+// 份额允许的误差，避免浮点误差导致全部卖出后持仓为负
+const shareTolerance = 1e-6
+
+// 校验单条交易流水并补全金额
+func checkTransaction(t *Transaction) error {
+	if _, err := parseDate(t.TradeDate); err != nil {
+		return err
+	}
+	if t.Amount < 0 {
+		return fmt.Errorf("金额不能为负数")
+	}
+
+	switch t.Type {
+	case TransactionBuy, TransactionSell:
+		if t.Shares <= 0 || t.NAV <= 0 {
+			return fmt.Errorf("买入和卖出需要填写大于0的份额和净值")
+		}
+		if t.Amount == 0 {
+			t.Amount = t.Shares * t.NAV / 10000
+		}
+	case TransactionDividend:
+		if t.Shares < 0 {
+			return fmt.Errorf("红利再投资的份额不能为负数")
+		}
+		if t.Shares == 0 && t.Amount == 0 {
+			return fmt.Errorf("分红需要填写再投资份额或现金分红金额")
+		}
+		if t.Shares > 0 && t.NAV <= 0 {
+			return fmt.Errorf("红利再投资需要填写净值")
+		}
+		if t.Amount == 0 {
+			t.Amount = t.Shares * t.NAV / 10000
+		}
+	case TransactionConversion:
+		if t.Shares == 0 || t.NAV <= 0 {
+			return fmt.Errorf("基金转换需要填写份额(转入为正，转出为负)和净值")
+		}
+		if t.Amount == 0 {
+			t.Amount = math.Abs(t.Shares) * t.NAV / 10000
+		}
+	case TransactionFee:
+		if t.Shares < 0 {
+			return fmt.Errorf("扣除的份额不能为负数")
+		}
+		if t.Shares == 0 && t.Amount == 0 {
+			return fmt.Errorf("费用需要填写扣除份额或金额")
+		}
+	default:
+		return fmt.Errorf("无效的交易类型: %s（可选 buy、sell、dividend、conversion、fee）", t.Type)
+	}
+	return nil
+}
+
+// 校验同一基金的交易流水按日期累计后持有份额始终不为负
+func checkLedger(txs []Transaction) error {
+	sorted := make([]Transaction, len(txs))
+	copy(sorted, txs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].TradeDate < sorted[j].TradeDate
+	})
+
+	var shares float64
+	for _, t := range sorted {
+		shares += t.shareDelta()
+		if shares < -shareTolerance {
+			return fmt.Errorf("%s 持有份额不足：该日%s后持有份额为%.2f份", t.TradeDate, transactionTypeLabel(t.Type), shares)
+		}
+	}
+	return nil
+}
+
+// 交易类型的显示名称
+func transactionTypeLabel(txType string) string {
+	switch txType {
+	case TransactionBuy:
+		return "买入"
+	case TransactionSell:
+		return "卖出"
+	case TransactionDividend:
+		return "分红"
+	case TransactionConversion:
+		return "转换"
+	case TransactionFee:
+		return "费用"
+	default:
+		return txType
+	}
+}
+
+// 基金的交易流水及持仓
+type FundLedger struct {
+	FundID       int           `json:"fund_id"`
+	Holding      Holding       `json:"holding"`
+	Transactions []Transaction `json:"transactions"`
+}
+
+func newFundLedger(fundID int, txs []Transaction) FundLedger {
+	if txs == nil {
+		txs = []Transaction{}
+	}
+	return FundLedger{FundID: fundID, Holding: computeHoldings(txs)[fundID], Transactions: txs}
+}
+
+// 汇总交易流水得到各基金的持仓，txs 需按交易日期排序
+func computeHoldings(txs []Transaction) map[int]Holding {
+	holdings := make(map[int]Holding)
+	for _, t := range txs {
+		h := holdings[t.FundID]
+		h.Shares += t.shareDelta()
+		if t.NAV > 0 && t.TradeDate >= h.NAVDate {
+			h.NAV = t.NAV
+			h.NAVDate = t.TradeDate
+			h.NAVSource = "transaction"
+		}
+		if math.Abs(h.Shares) < shareTolerance {
+			h.Shares = 0
+		}
+		holdings[t.FundID] = h
+	}
+	return holdings
+}
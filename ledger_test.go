@@ -0,0 +1,117 @@
+package main
+
+import "testing"
+
+func TestComputeHoldings(t *testing.T) {
+	txs := []Transaction{
+		{FundID: 1, Type: TransactionBuy, TradeDate: "2024-01-02", Shares: 10000, NAV: 1.2},
+		{FundID: 2, Type: TransactionBuy, TradeDate: "2024-01-02", Shares: 5000, NAV: 2},
+		{FundID: 1, Type: TransactionDividend, TradeDate: "2024-03-01", Shares: 200, NAV: 1.25},
+		{FundID: 1, Type: TransactionDividend, TradeDate: "2024-03-15", Amount: 0.01}, // 现金分红不影响份额和净值
+		{FundID: 1, Type: TransactionFee, TradeDate: "2024-04-01", Shares: 10},
+		{FundID: 1, Type: TransactionSell, TradeDate: "2024-05-06", Shares: 4190, NAV: 1.3},
+		{FundID: 2, Type: TransactionConversion, TradeDate: "2024-05-06", Shares: -5000, NAV: 2.1},
+		{FundID: 3, Type: TransactionConversion, TradeDate: "2024-05-06", Shares: 3000, NAV: 3.5},
+	}
+
+	tests := []struct {
+		fundID     int
+		wantShares float64
+		wantNAV    float64
+		wantDate   string
+		wantValue  float64 // 万元
+	}{
+		{1, 6000, 1.3, "2024-05-06", 0.78},
+		{2, 0, 2.1, "2024-05-06", 0},
+		{3, 3000, 3.5, "2024-05-06", 1.05},
+	}
+
+	holdings := computeHoldings(txs)
+	for _, tt := range tests {
+		h := holdings[tt.fundID]
+		if !approxEqual(h.Shares, tt.wantShares) || h.NAV != tt.wantNAV || h.NAVDate != tt.wantDate || !approxEqual(h.Value(), tt.wantValue) {
+			t.Errorf("基金%d 持仓 %+v、市值%.4f万，应为%.2f份、净值%.4f(%s)、市值%.4f万",
+				tt.fundID, h, h.Value(), tt.wantShares, tt.wantNAV, tt.wantDate, tt.wantValue)
+		}
+		if h.NAVSource != "transaction" {
+			t.Errorf("基金%d 净值来源为 %q，应为 transaction", tt.fundID, h.NAVSource)
+		}
+	}
+}
+
+func TestHoldingUseLatestNAV(t *testing.T) {
+	tests := []struct {
+		name     string
+		nav      FundNAV
+		wantNAV  float64
+		wantDate string
+	}{
+		{"净值库中有更新的净值", FundNAV{Date: "2024-05-10", UnitNAV: 1.35, Source: NAVSourceImport}, 1.35, "2024-05-10"},
+		{"净值库中的净值早于交易流水", FundNAV{Date: "2024-05-01", UnitNAV: 1.35, Source: NAVSourceImport}, 1.3, "2024-05-06"},
+		{"净值无效", FundNAV{Date: "2024-05-10", UnitNAV: 0}, 1.3, "2024-05-06"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Holding{Shares: 6000, NAV: 1.3, NAVDate: "2024-05-06", NAVSource: "transaction"}
+			h.useLatestNAV(tt.nav)
+			if h.NAV != tt.wantNAV || h.NAVDate != tt.wantDate {
+				t.Errorf("净值%.4f(%s)，应为%.4f(%s)", h.NAV, h.NAVDate, tt.wantNAV, tt.wantDate)
+			}
+		})
+	}
+}
+
+func TestCheckLedger(t *testing.T) {
+	buy := Transaction{Type: TransactionBuy, TradeDate: "2024-01-02", Shares: 1000, NAV: 1}
+	tests := []struct {
+		name    string
+		txs     []Transaction
+		wantErr bool
+	}{
+		{"全部卖出", []Transaction{buy, {Type: TransactionSell, TradeDate: "2024-02-01", Shares: 1000, NAV: 1.1}}, false},
+		{"按日期累计，录入顺序不影响", []Transaction{{Type: TransactionSell, TradeDate: "2024-02-01", Shares: 500, NAV: 1.1}, buy}, false},
+		{"卖出超过持有份额", []Transaction{buy, {Type: TransactionSell, TradeDate: "2024-02-01", Shares: 1000.5, NAV: 1.1}}, true},
+		{"买入之前卖出", []Transaction{buy, {Type: TransactionSell, TradeDate: "2024-01-01", Shares: 10, NAV: 1}}, true},
+		{"转出超过持有份额", []Transaction{buy, {Type: TransactionConversion, TradeDate: "2024-02-01", Shares: -2000, NAV: 1}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkLedger(tt.txs); (err != nil) != tt.wantErr {
+				t.Errorf("校验结果 %v，应报错: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckTransaction(t *testing.T) {
+	tests := []struct {
+		name       string
+		tx         Transaction
+		wantAmount float64
+		wantErr    bool
+	}{
+		{"买入按份额和净值补全金额", Transaction{Type: TransactionBuy, TradeDate: "2024-01-02", Shares: 10000, NAV: 1.2}, 1.2, false},
+		{"转出按份额绝对值补全金额", Transaction{Type: TransactionConversion, TradeDate: "2024-01-02", Shares: -10000, NAV: 1.5}, 1.5, false},
+		{"现金分红只记金额", Transaction{Type: TransactionDividend, TradeDate: "2024-01-02", Amount: 0.02}, 0.02, false},
+		{"买入缺少净值", Transaction{Type: TransactionBuy, TradeDate: "2024-01-02", Shares: 100}, 0, true},
+		{"日期格式无效", Transaction{Type: TransactionBuy, TradeDate: "2024/01/02", Shares: 100, NAV: 1}, 0, true},
+		{"红利再投资缺少净值", Transaction{Type: TransactionDividend, TradeDate: "2024-01-02", Shares: 100}, 0, true},
+		{"费用未填写份额和金额", Transaction{Type: TransactionFee, TradeDate: "2024-01-02"}, 0, true},
+		{"交易类型无效", Transaction{Type: "transfer", TradeDate: "2024-01-02", Shares: 100, NAV: 1}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := tt.tx
+			err := checkTransaction(&tx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("校验结果 %v，应报错: %v", err, tt.wantErr)
+			}
+			if err == nil && !approxEqual(tx.Amount, tt.wantAmount) {
+				t.Errorf("金额%.4f万，应为%.4f万", tx.Amount, tt.wantAmount)
+			}
+		})
+	}
+}
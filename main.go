@@ -1,30 +1,385 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"log"
 	"math"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Fund struct {
+	ID      int     `json:"id"` // 对应 funds 表主键，未入库时为0
 	Name    string  `json:"name"`
 	Code    string  `json:"code"`
 	Current float64 `json:"current"`
 	Weight  float64 `json:"weight"` // 在桶内的权重
-	Target  float64 `json:"target"`
-	Diff    float64 `json:"diff"`
-	Advice  string  `json:"advice"`
-	Reason  string  `json:"reason"` // 操作原因
+	// 目标市值：按占总市值的目标占比和桶内权重计算的配置目标，与再平衡模式无关；
+	// cash_flow 模式下按投入/取出后的总市值计算
+	Target    float64     `json:"target"`
+	Diff      float64     `json:"diff"`
+	PostTrade float64     `json:"post_trade"` // 调整后的预计市值，即当前市值加调整金额
+	Advice    string      `json:"advice"`
+	Reason    string      `json:"reason"`    // 操作原因
+	Fees      FeeSchedule `json:"fees"`      // 费率
+	Fee       float64     `json:"fee"`       // 预计交易费用(万元)
+	MinTrade  float64     `json:"min_trade"` // 最低交易金额(万元)，如最低申购额，0表示不限
+	Dropped   float64     `json:"dropped"`   // 因低于最低交易额被取消的调整金额(万元)
+	// 市值来源：manual 手动录入，ledger 由交易流水计算，override 有流水但手动覆盖
+	CurrentSource string `json:"current_source"`
 }
 
 type Bucket struct {
+	ID         int     `json:"id"` // 对应 buckets 表主键，未入库时为0
 	Name       string  `json:"name"`
-	TargetRate float64 `json:"target_rate"`
+	ParentID   int     `json:"parent_id"`   // 父桶ID，0为顶层桶
+	TargetRate float64 `json:"target_rate"` // 子桶为在父桶中的占比
 	Funds      []Fund  `json:"funds"`
+	// 以下由层级计算得出：层级深度(顶层为0)和占总市值的目标占比
+	Level         int     `json:"level"`
+	EffectiveRate float64 `json:"effective_rate"`
 }
 
-func rebalance(buckets []Bucket, threshold float64) []Bucket {
+// 基金层面的偏离带，按基金在桶内的占比与其权重比较，0 表示不启用
+type DriftBand struct {
+	Absolute float64 `json:"absolute"` // 绝对偏离带，0.05 表示桶内占比偏离权重 ±5 个百分点
+	Relative float64 `json:"relative"` // 相对偏离带，0.25 表示桶内占比偏离权重的 ±25%
+}
+
+// 判断基金是否超出偏离带，返回触发的规则说明
+// 两种偏离带同时启用时任一触发即可，即经典的 5/25 规则
+func (b DriftBand) breach(currentShare, targetShare float64) (string, bool) {
+	deviation := math.Abs(currentShare - targetShare)
+	if b.Absolute > 0 && deviation > b.Absolute {
+		return fmt.Sprintf("桶内占比%.1f%%偏离权重%.1f%%达%.1f个百分点，超出绝对偏离带±%.1f个百分点",
+			currentShare*100, targetShare*100, deviation*100, b.Absolute*100), true
+	}
+	if b.Relative > 0 && deviation > 0 {
+		if targetShare <= 0 {
+			return fmt.Sprintf("权重为0但桶内占比%.1f%%，超出相对偏离带±%.0f%%", currentShare*100, b.Relative*100), true
+		}
+		if deviation/targetShare > b.Relative {
+			return fmt.Sprintf("桶内占比%.1f%%相对权重%.1f%%偏离%.1f%%，超出相对偏离带±%.0f%%",
+				currentShare*100, targetShare*100, deviation/targetShare*100, b.Relative*100), true
+		}
+	}
+	return "", false
+}
+
+// 偏离带半宽，即基金桶内占比允许偏离权重的最大值；两种偏离带同时启用时取较窄者
+func (b DriftBand) width(targetShare float64) float64 {
+	width := math.Inf(1)
+	if b.Absolute > 0 {
+		width = b.Absolute
+	}
+	if b.Relative > 0 {
+		width = math.Min(width, b.Relative*targetShare)
+	}
+	return width
+}
+
+// 小于显示精度(0.01万)的调整金额视为无需调整
+const diffEpsilon = 0.005
+
+// 再平衡模式
+const (
+	RebalanceModeTarget   = "target"    // 触发后完全调回目标配置
+	RebalanceModeBandEdge = "band_edge" // 触发后只调回偏离带边缘(或内层带)，交易量更小
+	RebalanceModeCashFlow = "cash_flow" // 只用新投入或取出的资金调整配置，投入只买、取出只卖
+)
+
+// 再平衡参数
+type RebalanceOptions struct {
+	Strategy  string    `json:"strategy"`  // 再平衡策略名称，空为默认策略
+	Threshold float64   `json:"threshold"` // 桶层面触发阈值
+	FundBand  DriftBand `json:"fund_band"` // 基金层面偏离带，桶未触发时用于桶内调整
+	Mode      string    `json:"mode"`      // 再平衡模式，空为 target
+	// 偏离带边缘模式下调回到偏离带的比例位置：1或0为边缘，0.5为回到一半偏离带处
+	InnerBand float64 `json:"inner_band"`
+	CashFlow  float64 `json:"cash_flow"` // 现金流模式下的金额(万元)，正数为投入，负数为取出
+	// 费用占交易金额的比例超过该值时跳过该笔交易，0为不跳过
+	MaxFeeRate float64 `json:"max_fee_rate"`
+	MinTrade   float64 `json:"min_trade"`  // 全局最低交易金额(万元)，与基金自身的最低交易金额取较大者
+	RoundStep  float64 `json:"round_step"` // 交易金额取整的步长(万元)，如0.01表示取整到百元，0为不取整
+}
+
+// 校验再平衡参数并补全默认值
+func normalizeRebalanceOptions(opts *RebalanceOptions) error {
+	strategy, err := findStrategy(opts.Strategy)
+	if err != nil {
+		return err
+	}
+	opts.Strategy = strategy.Name()
+	if opts.FundBand.Absolute < 0 || opts.FundBand.Relative < 0 {
+		return fmt.Errorf("基金偏离带不能为负数")
+	}
+	switch opts.Mode {
+	case "":
+		// 只给出金额时默认按现金流模式处理
+		opts.Mode = RebalanceModeTarget
+		if opts.CashFlow != 0 {
+			opts.Mode = RebalanceModeCashFlow
+		}
+	case RebalanceModeTarget, RebalanceModeBandEdge, RebalanceModeCashFlow:
+	default:
+		return fmt.Errorf("无效的再平衡模式: %s（可选 %s、%s、%s）", opts.Mode,
+			RebalanceModeTarget, RebalanceModeBandEdge, RebalanceModeCashFlow)
+	}
+	if opts.Mode == RebalanceModeCashFlow && opts.CashFlow == 0 {
+		return fmt.Errorf("现金流模式需要指定投入(正数)或取出(负数)金额")
+	}
+	if opts.Mode != RebalanceModeCashFlow && opts.CashFlow != 0 {
+		return fmt.Errorf("投入/取出金额只能用于 %s 模式", RebalanceModeCashFlow)
+	}
+	if opts.MinTrade < 0 || opts.RoundStep < 0 {
+		return fmt.Errorf("最低交易金额和取整步长不能为负数")
+	}
+	if opts.MaxFeeRate < 0 || opts.MaxFeeRate > 1 {
+		return fmt.Errorf("费用上限比例必须在0-1之间")
+	}
+	if opts.InnerBand < 0 || opts.InnerBand > 1 {
+		return fmt.Errorf("内层带比例必须在0-1之间")
+	}
+	if opts.Mode != RebalanceModeBandEdge {
+		opts.InnerBand = 0
+	} else if opts.InnerBand == 0 {
+		opts.InnerBand = 1
+	}
+	return nil
+}
+
+// 再平衡模式的显示名称
+func rebalanceModeLabel(record RebalanceRecord) string {
+	switch record.Mode {
+	case RebalanceModeBandEdge:
+		if record.InnerBand > 0 && record.InnerBand < 1 {
+			return fmt.Sprintf("调回内层带(%.0f%%)", record.InnerBand*100)
+		}
+		return "调回偏离带边缘"
+	case RebalanceModeCashFlow:
+		if record.CashFlow < 0 {
+			return fmt.Sprintf("现金流(取出%.2f万)", -record.CashFlow)
+		}
+		return fmt.Sprintf("现金流(投入%.2f万)", record.CashFlow)
+	default:
+		return "完全调回目标"
+	}
+}
+
+// 偏差方向：超配为1，低配为-1
+func deviationSign(deviation float64) float64 {
+	if deviation > 0 {
+		return 1
+	}
+	return -1
+}
+
+// 按所选策略生成调仓建议，并估算每笔交易的费用
+func rebalance(buckets []Bucket, opts RebalanceOptions) (StrategyResult, error) {
+	return rebalanceAt(buckets, opts, time.Now())
+}
+
+// 按指定时间计算调仓建议，时间用于确定持有天数和赎回费档位，重现历史记录时使用记录的时间。
+// 策略给出调整金额后，统一应用费用上限、最低交易金额和取整规则，再按最终金额估算费用
+func rebalanceAt(buckets []Bucket, opts RebalanceOptions, now time.Time) (StrategyResult, error) {
+	strategy, err := findStrategy(opts.Strategy)
+	if err != nil {
+		return StrategyResult{}, err
+	}
+	result := strategy.Rebalance(buckets, opts, now)
+	skipped := skipCostlyTrades(result.Buckets, opts.MaxFeeRate, now)
+	result.Unallocated = applyTradeRules(result.Buckets, skipped, opts, now)
+	if note := unallocatedNote(result.Unallocated); note != "" {
+		result.Diagnostics = append(result.Diagnostics, note)
+	}
+	applyTradeFees(result.Buckets, now)
+	for bi := range result.Buckets {
+		for fi := range result.Buckets[bi].Funds {
+			fund := &result.Buckets[bi].Funds[fi]
+			fund.PostTrade = fund.Current + fund.Diff
+		}
+	}
+	return result, nil
+}
+
+// 对交易金额取整，并取消低于最低交易金额的交易；skipped 为各桶因费用超出上限已跳过的调整金额。
+// 跳过、取消和取整产生的差额只在本桶内从金额最大的同方向(买入或卖出)交易开始并入，使桶内买卖与调整前一致，
+// 卖出不超过持有市值，并入后费用占比超过上限的交易不再并入，放不下的部分并入下一笔；
+// 卖出差额放不下时买入资金不足，从金额最大的买入中扣减。
+// 返回未能分配的金额(万元)：正数为以现金留存，负数为未能卖出。只调整金额，不改变基金的目标市值
+func applyTradeRules(buckets []Bucket, skipped []float64, opts RebalanceOptions, now time.Time) float64 {
+	roundStep := opts.RoundStep
+	roundTrade := func(amount float64) float64 {
+		if roundStep > 0 {
+			return math.Round(amount/roundStep) * roundStep
+		}
+		return amount
+	}
+	fundMinTrade := func(fund *Fund) float64 {
+		return math.Max(opts.MinTrade, fund.MinTrade)
+	}
+
+	var unallocated float64
+	var funds []*Fund
+	for bi := range buckets {
+		before, after := 0.0, 0.0
+		if bi < len(skipped) {
+			before = skipped[bi]
+		}
+		var bucketFunds []*Fund
+		for fi := range buckets[bi].Funds {
+			fund := &buckets[bi].Funds[fi]
+			fund.Dropped = 0
+			if fund.Diff == 0 {
+				continue
+			}
+			before += fund.Diff
+
+			diff := roundTrade(fund.Diff)
+			if min := fundMinTrade(fund); diff == 0 || math.Abs(diff) < min {
+				if diff == 0 {
+					fund.Reason = fmt.Sprintf("%s；调整金额%.4f万按%.4f万取整后为0，取消该笔交易",
+						fund.Reason, math.Abs(fund.Diff), roundStep)
+				} else {
+					fund.Reason = fmt.Sprintf("%s；调整金额%.4f万低于最低交易金额%.4f万，取消该笔交易",
+						fund.Reason, math.Abs(fund.Diff), min)
+				}
+				fund.Dropped = fund.Diff
+				fund.Advice = "保持不动"
+				diff = 0
+			}
+
+			fund.Diff = diff
+			after += diff
+		}
+		for fi := range buckets[bi].Funds {
+			bucketFunds = append(bucketFunds, &buckets[bi].Funds[fi])
+		}
+		funds = append(funds, bucketFunds...)
+
+		if residual := roundTrade(before - after); math.Abs(residual) >= 1e-9 {
+			unallocated += residual - placeResidual(bucketFunds, residual, opts.MaxFeeRate, now)
+		}
+	}
+
+	if unallocated < -1e-9 {
+		unallocated += reduceBuys(funds, -unallocated, fundMinTrade)
+	}
+	return unallocated
+}
+
+// 把差额从金额最大的同方向交易开始并入，卖出不超过持有市值，maxFeeRate 大于0时并入后的费用占比不能超过该值，
+// 返回已并入的金额
+func placeResidual(funds []*Fund, residual, maxFeeRate float64, now time.Time) float64 {
+	var candidates []*Fund
+	for _, fund := range funds {
+		if fund.Diff*residual > 0 {
+			candidates = append(candidates, fund)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return math.Abs(candidates[i].Diff) > math.Abs(candidates[j].Diff)
+	})
+
+	var placed float64
+	for _, fund := range candidates {
+		add := residual - placed
+		if math.Abs(add) < 1e-9 {
+			break
+		}
+		if add < 0 {
+			add = math.Max(add, -(fund.Current + fund.Diff))
+		}
+		if math.Abs(add) < 1e-9 {
+			continue
+		}
+		if maxFeeRate > 0 {
+			if fee, _ := fund.Fees.tradeFee(fund.Diff+add, now); fee/math.Abs(fund.Diff+add) > maxFeeRate {
+				continue
+			}
+		}
+		fund.Diff += add
+		fund.Reason = fmt.Sprintf("%s；为保持资金平衡，调整金额变动%+.4f万", fund.Reason, add)
+		placed += add
+	}
+	return placed
+}
+
+// 卖出差额放不下时，从金额最大的买入开始扣减 need，扣减后低于最低交易金额的买入一并取消，返回扣减的金额
+func reduceBuys(funds []*Fund, need float64, minTrade func(*Fund) float64) float64 {
+	var buys []*Fund
+	for _, fund := range funds {
+		if fund.Diff > 0 {
+			buys = append(buys, fund)
+		}
+	}
+	sort.SliceStable(buys, func(i, j int) bool {
+		return buys[i].Diff > buys[j].Diff
+	})
+
+	var reduced float64
+	for _, fund := range buys {
+		if need-reduced < 1e-9 {
+			break
+		}
+		cut := math.Min(need-reduced, fund.Diff)
+		if rest := fund.Diff - cut; rest > 1e-9 && rest < minTrade(fund) {
+			cut = fund.Diff
+		}
+		fund.Diff -= cut
+		if fund.Diff < 1e-9 {
+			fund.Diff = 0
+			fund.Advice = "保持不动"
+		}
+		fund.Reason = fmt.Sprintf("%s；卖出不足以支付买入，调整金额减少%.4f万", fund.Reason, cut)
+		reduced += cut
+	}
+	return reduced
+}
+
+// 未能分配的金额的说明，金额可忽略时为空
+func unallocatedNote(unallocated float64) string {
+	switch {
+	case unallocated > diffEpsilon:
+		return fmt.Sprintf("取消、跳过或取整后有%.4f万未能分配到其他交易，以现金留存", unallocated)
+	case unallocated < -diffEpsilon:
+		return fmt.Sprintf("持有市值不足，有%.4f万卖出未能执行", -unallocated)
+	}
+	return ""
+}
+
+// 再平衡结果摘要：预计交易费用及被取消的小额交易
+func rebalanceSummary(results []Bucket) string {
+	summary := fmt.Sprintf("预计交易费用%.2f元", totalTradeFee(results)*10000)
+	if buy, sell := totalDroppedTrades(results); buy > 0 || sell > 0 {
+		summary += fmt.Sprintf("，取消小额买入%.4f万、卖出%.4f万", buy, sell)
+	}
+	return summary
+}
+
+// 因低于最低交易金额被取消的调整金额合计(万元)，买入和卖出分别统计
+func totalDroppedTrades(buckets []Bucket) (buy, sell float64) {
+	for _, b := range buckets {
+		for _, f := range b.Funds {
+			if f.Dropped > 0 {
+				buy += f.Dropped
+			} else {
+				sell -= f.Dropped
+			}
+		}
+	}
+	return buy, sell
+}
+
+// 阈值触发的再平衡：桶超出阈值时调回目标(或偏离带边缘)，否则检查基金偏离带
+func rebalanceByThreshold(buckets []Bucket, opts RebalanceOptions) []Bucket {
+	threshold := opts.Threshold
+
 	// 计算总市值
 	var total float64
 	for _, b := range buckets {
@@ -45,6 +400,29 @@ func rebalance(buckets []Bucket, threshold float64) []Bucket {
 		// 计算桶的偏差
 		bucketDeviation := (bucketCurrent / total) - bucket.TargetRate
 		bucketDeviationPercent := bucketDeviation * 100
+		bucketTriggered := math.Abs(bucketDeviation) > threshold
+		bandEdge := opts.Mode == RebalanceModeBandEdge
+
+		// 偏离带边缘模式：桶只调回到目标占比±(阈值×内层带比例)处，调整金额按权重分摊到桶内基金
+		var bucketEdgeDiff, bucketEdgePercent, bucketWeight float64
+		if bucketTriggered && bandEdge {
+			edge := threshold * opts.InnerBand
+			bucketEdgePercent = edge * 100
+			bucketEdgeDiff = total*(bucket.TargetRate+deviationSign(bucketDeviation)*edge) - bucketCurrent
+			for _, f := range bucket.Funds {
+				bucketWeight += f.Weight
+			}
+		}
+
+		// 桶整体在阈值内时，检查桶内基金是否超出基金层面的偏离带
+		fundRules := make(map[int]string)
+		if !bucketTriggered && bucketCurrent > 0 {
+			for fi, f := range bucket.Funds {
+				if rule, ok := opts.FundBand.breach(f.Current/bucketCurrent, f.Weight); ok {
+					fundRules[fi] = rule
+				}
+			}
+		}
 
 		for fi := range bucket.Funds {
 			fund := &bucket.Funds[fi]
@@ -56,7 +434,36 @@ func rebalance(buckets []Bucket, threshold float64) []Bucket {
 			fundTargetPercent := (fund.Target / total) * 100
 			fundDeviationPercent := fundCurrentPercent - fundTargetPercent
 
-			if math.Abs(bucketDeviation) > threshold {
+			if bucketTriggered && bandEdge {
+				share := 1 / float64(len(bucket.Funds))
+				if bucketWeight > 0 {
+					share = fund.Weight / bucketWeight
+				}
+				fund.Diff = bucketEdgeDiff * share
+				postTrade := fund.Current + fund.Diff
+
+				direction := "偏低"
+				if bucketDeviation > 0 {
+					direction = "偏高"
+				}
+				switch {
+				case fund.Diff > diffEpsilon:
+					fund.Advice = "买入"
+					fund.Reason = fmt.Sprintf("%s整体%s%.1f%%，超出阈值±%.1f%%，按偏离带边缘模式调回至%s%.1f%%处：当前市值%.2f万(占比%.1f%%)，需要买入%.2f万至%.2f万",
+						bucket.Name, direction, math.Abs(bucketDeviationPercent), threshold*100, direction, bucketEdgePercent,
+						fund.Current, fundCurrentPercent, fund.Diff, postTrade)
+				case fund.Diff < -diffEpsilon:
+					fund.Advice = "卖出"
+					fund.Reason = fmt.Sprintf("%s整体%s%.1f%%，超出阈值±%.1f%%，按偏离带边缘模式调回至%s%.1f%%处：当前市值%.2f万(占比%.1f%%)，需要卖出%.2f万至%.2f万",
+						bucket.Name, direction, math.Abs(bucketDeviationPercent), threshold*100, direction, bucketEdgePercent,
+						fund.Current, fundCurrentPercent, -fund.Diff, postTrade)
+				default:
+					fund.Advice = "保持不动"
+					fund.Diff = 0
+					fund.Reason = fmt.Sprintf("%s整体%s%.1f%%，按偏离带边缘模式调整，本基金权重为0，无需调整",
+						bucket.Name, direction, math.Abs(bucketDeviationPercent))
+				}
+			} else if bucketTriggered {
 				if fund.Diff > 0 {
 					fund.Advice = "买入"
 					fund.Reason = fmt.Sprintf("当前市值%.2f万(占比%.1f%%)低于目标%.2f万(占比%.1f%%)，%s整体偏低%.1f%%，需要买入%.2f万",
@@ -72,6 +479,54 @@ func rebalance(buckets []Bucket, threshold float64) []Bucket {
 					fund.Reason = fmt.Sprintf("当前市值%.2f万符合目标配置，但%s整体偏低%.1f%%，需要适量买入",
 						fund.Current, bucket.Name, math.Abs(bucketDeviationPercent))
 				}
+			} else if len(fundRules) > 0 && bandEdge {
+				// 偏离带边缘模式：只有超出偏离带的基金调回到偏离带边缘(或内层带)，其他基金不动
+				rule, ok := fundRules[fi]
+				if !ok {
+					fund.Advice = "保持不动"
+					fund.Diff = 0
+					fund.Reason = fmt.Sprintf("同桶内其他基金超出偏离带，偏离带边缘模式下本基金当前市值%.2f万(桶内占比%.1f%%)在偏离带内，暂不调整",
+						fund.Current, fund.Current/bucketCurrent*100)
+					continue
+				}
+
+				currentShare := fund.Current / bucketCurrent
+				edgeShare := fund.Weight + deviationSign(currentShare-fund.Weight)*opts.FundBand.width(fund.Weight)*opts.InnerBand
+				postTrade := bucketCurrent * math.Max(edgeShare, 0)
+				fund.Diff = postTrade - fund.Current
+				if fund.Diff > 0 {
+					fund.Advice = "买入"
+					fund.Reason = fmt.Sprintf("%s整体偏差%.1f%%在阈值范围内，但基金层面%s，按偏离带边缘模式调回至桶内占比%.1f%%：需要买入%.2f万至%.2f万",
+						bucket.Name, math.Abs(bucketDeviationPercent), rule, postTrade/bucketCurrent*100, fund.Diff, postTrade)
+				} else {
+					fund.Advice = "卖出"
+					fund.Reason = fmt.Sprintf("%s整体偏差%.1f%%在阈值范围内，但基金层面%s，按偏离带边缘模式调回至桶内占比%.1f%%：需要卖出%.2f万至%.2f万",
+						bucket.Name, math.Abs(bucketDeviationPercent), rule, postTrade/bucketCurrent*100, -fund.Diff, postTrade)
+				}
+			} else if len(fundRules) > 0 {
+				// 桶内调整：按权重重新分配桶的当前市值，桶整体市值不变
+				postTrade := bucketCurrent * fund.Weight
+				fund.Diff = postTrade - fund.Current
+
+				trigger := "同桶内其他基金超出偏离带"
+				if rule, ok := fundRules[fi]; ok {
+					trigger = "基金层面" + rule
+				}
+
+				switch {
+				case fund.Diff > diffEpsilon:
+					fund.Advice = "买入"
+					fund.Reason = fmt.Sprintf("%s整体偏差%.1f%%在阈值范围内，但%s，桶内调整：当前市值%.2f万(占比%.1f%%)，需要买入%.2f万至%.2f万",
+						bucket.Name, math.Abs(bucketDeviationPercent), trigger, fund.Current, fundCurrentPercent, fund.Diff, postTrade)
+				case fund.Diff < -diffEpsilon:
+					fund.Advice = "卖出"
+					fund.Reason = fmt.Sprintf("%s整体偏差%.1f%%在阈值范围内，但%s，桶内调整：当前市值%.2f万(占比%.1f%%)，需要卖出%.2f万至%.2f万",
+						bucket.Name, math.Abs(bucketDeviationPercent), trigger, fund.Current, fundCurrentPercent, -fund.Diff, postTrade)
+				default:
+					fund.Advice = "保持不动"
+					fund.Diff = 0
+					fund.Reason = fmt.Sprintf("%s，桶内调整后当前市值%.2f万已符合权重，无需调整", trigger, fund.Current)
+				}
 			} else {
 				fund.Advice = "保持不动"
 				fund.Diff = 0
@@ -88,6 +543,540 @@ func rebalance(buckets []Bucket, threshold float64) []Bucket {
 	return buckets
 }
 
+// 现金流再平衡：只用投入(或取出)的资金调整配置，投入时只买不卖，取出时只卖不买，
+// 在此约束下使调整后各基金市值尽量接近新总市值下的目标市值。
+// 基金的 target 为新总市值下的目标市值，reason 中给出调整后的占比
+func rebalanceCashFlow(buckets []Bucket, amount float64) []Bucket {
+	var total float64
+	var currents, targets []float64
+	for _, b := range buckets {
+		for _, f := range b.Funds {
+			total += f.Current
+			currents = append(currents, f.Current)
+		}
+	}
+	newTotal := total + amount
+	for _, b := range buckets {
+		for _, f := range b.Funds {
+			targets = append(targets, newTotal*b.TargetRate*f.Weight)
+		}
+	}
+
+	trades := allocateCashFlow(currents, targets, amount)
+
+	i := 0
+	for bi := range buckets {
+		bucket := &buckets[bi]
+		for fi := range bucket.Funds {
+			fund := &bucket.Funds[fi]
+			fund.Diff = trades[i]
+			fund.Target = targets[i]
+			i++
+
+			if math.Abs(fund.Diff) <= diffEpsilon {
+				fund.Diff = 0
+			}
+			postTrade := fund.Current + fund.Diff
+
+			var projectedPercent, targetPercent float64
+			if newTotal > 0 {
+				projectedPercent = postTrade / newTotal * 100
+				targetPercent = fund.Target / newTotal * 100
+			}
+
+			switch {
+			case fund.Diff > 0:
+				fund.Advice = "买入"
+				fund.Reason = fmt.Sprintf("投入资金%.2f万，本基金买入%.2f万：调整后市值%.2f万(占比%.1f%%)，目标占比%.1f%%",
+					amount, fund.Diff, postTrade, projectedPercent, targetPercent)
+			case fund.Diff < 0:
+				fund.Advice = "卖出"
+				fund.Reason = fmt.Sprintf("取出资金%.2f万，本基金卖出%.2f万：调整后市值%.2f万(占比%.1f%%)，目标占比%.1f%%",
+					-amount, -fund.Diff, postTrade, projectedPercent, targetPercent)
+			case amount > 0:
+				fund.Advice = "保持不动"
+				fund.Reason = fmt.Sprintf("投入资金时只买入低配基金，本基金调整后占比%.1f%%，目标占比%.1f%%，不买入",
+					projectedPercent, targetPercent)
+			default:
+				fund.Advice = "保持不动"
+				fund.Reason = fmt.Sprintf("取出资金时只卖出超配基金，本基金调整后占比%.1f%%，目标占比%.1f%%，不卖出",
+					projectedPercent, targetPercent)
+			}
+		}
+	}
+	return buckets
+}
+
+// 按现金流分配各基金的交易金额，正数为买入，负数为卖出。
+// 以目标市值加权的平方偏差最小为目标，投入时按"注水"方式优先补足低配最多的基金，
+// 取出时优先卖出目标为0的基金，再从超配最多的基金开始卖出，且不超过持有市值
+func allocateCashFlow(currents, targets []float64, amount float64) []float64 {
+	trades := make([]float64, len(currents))
+	if amount == 0 || len(currents) == 0 {
+		return trades
+	}
+
+	if amount > 0 {
+		var totalTarget float64
+		for _, t := range targets {
+			totalTarget += t
+		}
+		if totalTarget <= 0 {
+			// 没有有效的目标配置，平均分配
+			for i := range trades {
+				trades[i] = amount / float64(len(trades))
+			}
+			return trades
+		}
+
+		// 买入金额 x_i = max(目标_i - 当前_i - λ·目标_i, 0)，λ 使买入合计等于投入金额
+		lo, hi := math.Inf(1), math.Inf(-1)
+		for i, t := range targets {
+			if t > 0 {
+				ratio := (t - currents[i]) / t
+				lo = math.Min(lo, ratio)
+				hi = math.Max(hi, ratio)
+			}
+		}
+		lo -= amount / totalTarget
+		fill := func(lambda float64) float64 {
+			var sum float64
+			for i, t := range targets {
+				trades[i] = 0
+				if t > 0 {
+					trades[i] = math.Max(t-currents[i]-lambda*t, 0)
+				}
+				sum += trades[i]
+			}
+			return sum
+		}
+		solveCashFlow(fill, lo, hi, amount, false)
+		return normalizeTrades(trades, amount)
+	}
+
+	withdrawal := -amount
+	// 目标为0的基金全部属于超配，优先卖出
+	var zeroTargetTotal float64
+	for i, t := range targets {
+		if t <= 0 {
+			zeroTargetTotal += currents[i]
+		}
+	}
+	if zeroTargetTotal > 0 {
+		ratio := math.Min(withdrawal/zeroTargetTotal, 1)
+		for i, t := range targets {
+			if t <= 0 {
+				trades[i] = -currents[i] * ratio
+			}
+		}
+		withdrawal -= zeroTargetTotal * ratio
+		if withdrawal <= 0 {
+			return trades
+		}
+	}
+
+	// 卖出金额 y_i = clamp(当前_i - 目标_i + μ·目标_i, 0, 当前_i)，μ 使卖出合计等于剩余取出金额
+	sells := make([]float64, len(currents))
+	lo := math.Inf(1)
+	for i, t := range targets {
+		if t > 0 {
+			lo = math.Min(lo, (t-currents[i])/t)
+		}
+	}
+	if math.IsInf(lo, 1) {
+		return trades
+	}
+	fill := func(mu float64) float64 {
+		var sum float64
+		for i, t := range targets {
+			sells[i] = 0
+			if t > 0 {
+				sells[i] = math.Min(math.Max(currents[i]-t+mu*t, 0), currents[i])
+			}
+			sum += sells[i]
+		}
+		return sum
+	}
+	solveCashFlow(fill, lo, 1, withdrawal, true)
+	sells = normalizeTrades(sells, withdrawal)
+	for i, t := range targets {
+		if t > 0 {
+			trades[i] = -sells[i]
+		}
+	}
+	return trades
+}
+
+// 二分求解使 fill(x) 等于 amount 的 x，increasing 表示 fill 随 x 递增
+func solveCashFlow(fill func(float64) float64, lo, hi, amount float64, increasing bool) {
+	for iter := 0; iter < 100; iter++ {
+		mid := (lo + hi) / 2
+		if (fill(mid) < amount) == increasing {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	if increasing {
+		fill(hi)
+	} else {
+		fill(lo)
+	}
+}
+
+// 消除二分求解的误差，使交易金额合计恰好等于 amount
+func normalizeTrades(trades []float64, amount float64) []float64 {
+	var sum float64
+	for _, t := range trades {
+		sum += t
+	}
+	if sum > 0 {
+		for i := range trades {
+			trades[i] *= amount / sum
+		}
+	}
+	return trades
+}
+
+// 校验再平衡的输入：桶的层级有效，各层目标占比合计为100%，取出金额不超过总市值
+func checkRebalanceInput(buckets []Bucket, opts RebalanceOptions) error {
+	if err := validateBucketTree(buckets); err != nil {
+		return err
+	}
+	if err := validateBucketTargets(buckets); err != nil {
+		return err
+	}
+	if opts.CashFlow < 0 {
+		total := totalCurrentValue(buckets)
+		if -opts.CashFlow > total {
+			return fmt.Errorf("取出金额%.2f万超过当前总市值%.2f万", -opts.CashFlow, total)
+		}
+	}
+	return nil
+}
+
+// 桶目标占比合计允许的误差
+const targetRateTolerance = 1e-6
+
+// 校验所有桶的目标占比合计为100%
+func validateTargetRates(rates []float64) error {
+	var total float64
+	for _, rate := range rates {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("目标占比必须在0-1之间")
+		}
+		total += rate
+	}
+
+	if math.Abs(total-1.0) > targetRateTolerance {
+		return fmt.Errorf("所有桶目标占比合计为%.2f%%，必须等于100%%", total*100)
+	}
+	return nil
+}
+
+// 校验整体调整后的目标占比，rates 为桶ID -> 新的目标占比
+func validateNewTargetRates(dbBuckets []DBBucket, rates map[int]float64) error {
+	buckets := convertDBBucketsToAPIBuckets(dbBuckets)
+	for i := range buckets {
+		buckets[i].TargetRate = rates[buckets[i].ID]
+	}
+	return validateBucketTargets(buckets)
+}
+
+// 再平衡前校验桶目标占比：顶层桶合计为100%，每个父桶的子桶合计也为100%
+func validateBucketTargets(buckets []Bucket) error {
+	var topRates []float64
+	childRates := make(map[int][]float64)
+	for _, b := range buckets {
+		if b.ParentID == 0 {
+			topRates = append(topRates, b.TargetRate)
+		} else {
+			childRates[b.ParentID] = append(childRates[b.ParentID], b.TargetRate)
+		}
+	}
+	if err := validateTargetRates(topRates); err != nil {
+		return err
+	}
+
+	for _, b := range buckets {
+		rates, ok := childRates[b.ID]
+		if !ok {
+			continue
+		}
+		if err := validateTargetRates(rates); err != nil {
+			return fmt.Errorf("%s 的子桶: %v", b.Name, err)
+		}
+	}
+	return nil
+}
+
+// 再平衡预览时的假设性调整，均按ID指定
+type RebalanceOverrides struct {
+	TargetRates map[int]float64 `json:"target_rates"` // 桶ID -> 目标占比
+	Currents    map[int]float64 `json:"currents"`     // 基金ID -> 当前市值(万元)
+	Weights     map[int]float64 `json:"weights"`      // 基金ID -> 桶内权重
+}
+
+// 将假设性调整应用到组合上（只修改传入的副本，不涉及数据库）
+func applyRebalanceOverrides(buckets []Bucket, overrides *RebalanceOverrides) error {
+	if overrides == nil {
+		return nil
+	}
+
+	bucketSeen := make(map[int]bool)
+	fundSeen := make(map[int]bool)
+	for bi := range buckets {
+		bucket := &buckets[bi]
+		if rate, ok := overrides.TargetRates[bucket.ID]; ok {
+			if rate < 0 || rate > 1 {
+				return fmt.Errorf("%s 的目标占比必须在0-1之间", bucket.Name)
+			}
+			bucket.TargetRate = rate
+			bucketSeen[bucket.ID] = true
+		}
+
+		var totalWeight float64
+		for fi := range bucket.Funds {
+			fund := &bucket.Funds[fi]
+			if current, ok := overrides.Currents[fund.ID]; ok {
+				if current < 0 {
+					return fmt.Errorf("%s 的当前市值不能为负数", fund.Name)
+				}
+				fund.Current = current
+				fundSeen[fund.ID] = true
+			}
+			if weight, ok := overrides.Weights[fund.ID]; ok {
+				if weight < 0 || weight > 1 {
+					return fmt.Errorf("%s 的权重必须在0-1之间", fund.Name)
+				}
+				fund.Weight = weight
+				fundSeen[fund.ID] = true
+			}
+			totalWeight += fund.Weight
+		}
+
+		if totalWeight > 1.0+targetRateTolerance {
+			return fmt.Errorf("%s 内基金权重合计为%.2f，超过1", bucket.Name, totalWeight)
+		}
+	}
+
+	for id := range overrides.TargetRates {
+		if !bucketSeen[id] {
+			return fmt.Errorf("桶不存在: %d", id)
+		}
+	}
+	for _, m := range []map[int]float64{overrides.Currents, overrides.Weights} {
+		for id := range m {
+			if !fundSeen[id] {
+				return fmt.Errorf("基金不存在: %d", id)
+			}
+		}
+	}
+	return nil
+}
+
+// 合并两个桶时两边基金权重的缩放系数
+// 合并后桶的目标占比为两者之和，按原占比缩放权重可保持每只基金的目标市值不变；
+// 源桶没有基金时，并入的占比直接按原权重分摊给目标桶的基金
+func mergedWeightScales(fromRate, toRate float64, fromFunds int) (fromScale, toScale float64) {
+	if fromFunds == 0 {
+		return 0, 1
+	}
+	merged := fromRate + toRate
+	if merged <= 0 {
+		return 0.5, 0.5
+	}
+	return fromRate / merged, toRate / merged
+}
+
+// 校验桶内基金权重合计不超过1，excludeFundID 为正在修改的基金（新增时为0）
+func checkFundWeight(bucket DBBucket, excludeFundID int, weight float64) error {
+	var totalWeight float64
+	for _, f := range bucket.Funds {
+		if f.ID != excludeFundID {
+			totalWeight += f.Weight
+		}
+	}
+
+	if totalWeight+weight > 1.0 {
+		label := "当前桶内总权重"
+		if excludeFundID != 0 {
+			label = "其他基金总权重"
+		}
+		return fmt.Errorf("权重超出限制！%s: %.2f，剩余可分配: %.2f", label, totalWeight, 1.0-totalWeight)
+	}
+	return nil
+}
+
+// 校验恢复归档基金，bucketID 为0时恢复到原所在桶；恢复后桶内权重合计不能超过100%
+func checkFundRestore(dbBuckets []DBBucket, fund DBFund, bucketID int) (*DBBucket, error) {
+	if fund.ArchivedAt == "" {
+		return nil, fmt.Errorf("基金 %s 未归档，无需恢复", fund.Name)
+	}
+	if bucketID == 0 {
+		bucketID = fund.BucketID
+	}
+
+	bi, found := findDBBucketByID(dbBuckets, bucketID)
+	if !found {
+		if bucketID == fund.BucketID {
+			return nil, fmt.Errorf("基金原所在的桶已删除，请指定恢复到的桶")
+		}
+		return nil, fmt.Errorf("桶不存在: %d", bucketID)
+	}
+	if err := checkLeafBucket(dbBuckets, dbBuckets[bi]); err != nil {
+		return nil, err
+	}
+	if err := checkFundWeight(dbBuckets[bi], 0, fund.Weight); err != nil {
+		return nil, err
+	}
+	return &dbBuckets[bi], nil
+}
+
+// 校验基金字段修改
+func checkFundField(bucket DBBucket, fund DBFund, field, value string) error {
+	switch field {
+	case "name", "code":
+		// 字符串字段直接更新
+		return nil
+	case "current":
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("无效的数值")
+		}
+		return nil
+	case "weight":
+		val, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("无效的数值")
+		}
+		return checkFundWeight(bucket, fund.ID, val)
+	case "current_override":
+		// 只允许关闭手动覆盖，恢复由交易流水计算市值；开启覆盖通过直接修改市值完成
+		if value != "0" {
+			return fmt.Errorf("current_override 只能设为0，手动覆盖请直接修改 current")
+		}
+		return nil
+	case "min_trade":
+		val, err := strconv.ParseFloat(value, 64)
+		if err != nil || val < 0 {
+			return fmt.Errorf("最低交易金额不能为负数")
+		}
+		return nil
+	case "purchase_rate", "purchase_discount":
+		val, err := strconv.ParseFloat(value, 64)
+		if err != nil || val < 0 || val > 1 {
+			return fmt.Errorf("费率和折扣必须在0-1之间")
+		}
+		return nil
+	case "redemption_tiers":
+		_, err := parseRedemptionTiers(value)
+		return err
+	case "held_since":
+		if value == "" {
+			return nil
+		}
+		_, err := parseDate(value)
+		return err
+	default:
+		return fmt.Errorf("无效的字段")
+	}
+}
+
+// 校验桶名称非空且不重复，excludeBucketID 为正在修改的桶（新增时为0）
+func checkBucketName(dbBuckets []DBBucket, excludeBucketID int, name string) error {
+	if name == "" {
+		return fmt.Errorf("桶名称不能为空")
+	}
+	for _, b := range dbBuckets {
+		if b.ID != excludeBucketID && b.Name == name {
+			return fmt.Errorf("桶名称已存在: %s", name)
+		}
+	}
+	return nil
+}
+
+// 校验单个桶的目标占比，同一父桶下(包括顶层)各桶合计不能超过1
+func checkBucketRate(dbBuckets []DBBucket, excludeBucketID, parentID int, rate float64) error {
+	if rate < 0 || rate > 1 {
+		return fmt.Errorf("目标占比必须在0-1之间")
+	}
+
+	totalRate := siblingRateSum(dbBuckets, parentID, excludeBucketID)
+	if totalRate+rate > 1.0+targetRateTolerance {
+		label := "当前所有桶总占比"
+		if excludeBucketID != 0 {
+			label = "其他桶总占比"
+		}
+		if parentID != 0 {
+			label = "同一父桶下" + label
+		}
+		return fmt.Errorf("目标占比超出限制！%s: %.2f，剩余可分配: %.2f。可先以0占比创建，再统一调整所有桶的目标占比",
+			label, totalRate, 1.0-totalRate)
+	}
+	return nil
+}
+
+// 校验桶字段修改，返回规范化后的值
+func checkBucketField(dbBuckets []DBBucket, bucket DBBucket, field, value string) (string, error) {
+	switch field {
+	case "name":
+		value = strings.TrimSpace(value)
+		return value, checkBucketName(dbBuckets, bucket.ID, value)
+	case "target_rate":
+		val, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return value, fmt.Errorf("目标占比必须在0-1之间")
+		}
+		return value, checkBucketRate(dbBuckets, bucket.ID, bucket.ParentID, val)
+	case "parent_id":
+		// 改变父桶后目标占比按新的同级桶校验，必要时先把目标占比调为0
+		parentID, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return value, fmt.Errorf("无效的父桶ID")
+		}
+		if err := checkBucketParent(dbBuckets, bucket.ID, parentID); err != nil {
+			return value, err
+		}
+		return strconv.Itoa(parentID), checkBucketRate(dbBuckets, bucket.ID, parentID, bucket.TargetRate)
+	default:
+		return value, fmt.Errorf("无效的字段")
+	}
+}
+
+// 校验删除桶的请求，moveToIndex 为-1表示未指定合并目标桶
+func checkBucketDelete(dbBuckets []DBBucket, bucketIndex, moveToIndex int) (*DBBucket, error) {
+	bucket := dbBuckets[bucketIndex]
+	if hasChildBuckets(dbBuckets, bucket.ID) {
+		return nil, fmt.Errorf("%s 下还有子桶，请先删除子桶或把子桶移到其他父桶", bucket.Name)
+	}
+
+	if moveToIndex >= 0 {
+		if moveToIndex >= len(dbBuckets) {
+			return nil, fmt.Errorf("无效的迁移目标桶")
+		}
+		if moveToIndex == bucketIndex {
+			return nil, fmt.Errorf("迁移目标桶不能是被删除的桶本身")
+		}
+		// 目标占比只能在同一父桶下的桶之间合并
+		moveTo := &dbBuckets[moveToIndex]
+		if moveTo.ParentID != bucket.ParentID {
+			return nil, fmt.Errorf("迁移目标桶必须与 %s 属于同一父桶", bucket.Name)
+		}
+		if err := checkLeafBucket(dbBuckets, *moveTo); err != nil {
+			return nil, err
+		}
+		return moveTo, nil
+	}
+
+	if len(bucket.Funds) > 0 {
+		return nil, fmt.Errorf("桶内还有%d只基金，请指定迁移目标桶或先删除基金", len(bucket.Funds))
+	}
+	if bucket.TargetRate > targetRateTolerance {
+		return nil, fmt.Errorf("该桶目标占比为%.1f%%，请先将其调整为0或指定合并目标桶", bucket.TargetRate*100)
+	}
+	return nil, nil
+}
+
 // 显示菜单
 func showMenu() {
 	fmt.Println("\n🏦 动态基金再平衡系统")
@@ -97,8 +1086,11 @@ func showMenu() {
 	fmt.Println("3. 添加基金")
 	fmt.Println("4. 删除基金")
 	fmt.Println("5. 修改基金信息")
-	fmt.Println("6. 退出")
-	fmt.Print("请选择操作 (1-6): ")
+	fmt.Println("6. 添加桶")
+	fmt.Println("7. 修改桶信息")
+	fmt.Println("8. 删除桶")
+	fmt.Println("9. 退出")
+	fmt.Print("请选择操作 (1-9): ")
 }
 
 // 查看当前基金配置
@@ -106,7 +1098,7 @@ func listFunds(buckets []Bucket) {
 	fmt.Println("\n📊 当前基金配置")
 	fmt.Println("=======================================================")
 	for _, bucket := range buckets {
-		fmt.Printf("\n🗂️  %s (目标占比: %.1f%%)\n", bucket.Name, bucket.TargetRate*100)
+		fmt.Printf("\n🗂️  %s (%s)\n", indentedBucketName(bucket), bucketRateLabel(bucket))
 		fmt.Println("-------------------------------------------------------")
 		for i, fund := range bucket.Funds {
 			fmt.Printf("%d. %s (%s) | 当前: %.2f万 | 权重: %.1f%%\n",
@@ -119,7 +1111,7 @@ func listFunds(buckets []Bucket) {
 func findBucketIndex(buckets []Bucket) int {
 	fmt.Println("\n选择桶:")
 	for i, bucket := range buckets {
-		fmt.Printf("%d. %s\n", i+1, bucket.Name)
+		fmt.Printf("%d. %s\n", i+1, indentedBucketName(bucket))
 	}
 
 	var choice int
@@ -133,14 +1125,33 @@ func findBucketIndex(buckets []Bucket) int {
 	return choice - 1
 }
 
+// 从数据库读取当前基金配置
+func loadBucketsCLI() ([]DBBucket, []Bucket, bool) {
+	dbBuckets, err := getAllBucketsFromDB()
+	if err != nil {
+		fmt.Printf("❌ 读取基金配置失败: %v\n", err)
+		return nil, nil, false
+	}
+	return dbBuckets, convertDBBucketsToAPIBuckets(dbBuckets), true
+}
+
 // CLI版本的添加基金
-func addFundCLI(buckets []Bucket) []Bucket {
+func addFundCLI() {
+	dbBuckets, buckets, ok := loadBucketsCLI()
+	if !ok {
+		return
+	}
+
 	bucketIndex := findBucketIndex(buckets)
 	if bucketIndex == -1 {
-		return buckets
+		return
+	}
+	if err := checkLeafBucket(dbBuckets, dbBuckets[bucketIndex]); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return
 	}
 
-	bucket := &buckets[bucketIndex]
+	bucket := buckets[bucketIndex]
 
 	var name, code string
 	var current, weight float64
@@ -167,37 +1178,33 @@ func addFundCLI(buckets []Bucket) []Bucket {
 	if totalWeight+weight > 1.0 {
 		fmt.Printf("❌ 权重超出限制！当前桶内总权重: %.2f，剩余可分配: %.2f\n",
 			totalWeight, 1.0-totalWeight)
-		return buckets
+		return
 	}
 
-	newFund := Fund{
-		Name:    name,
-		Code:    code,
-		Current: current,
-		Weight:  weight,
-		Target:  0,
-		Diff:    0,
-		Advice:  "",
+	if err := store.AddFund(bucket.ID, name, code, current, weight); err != nil {
+		fmt.Printf("❌ 添加基金失败: %v\n", err)
+		return
 	}
-
-	bucket.Funds = append(bucket.Funds, newFund)
 	fmt.Printf("✅ 已添加基金: %s\n", name)
-
-	return buckets
 }
 
 // CLI版本的删除基金
-func deleteFundCLI(buckets []Bucket) []Bucket {
+func deleteFundCLI() {
+	_, buckets, ok := loadBucketsCLI()
+	if !ok {
+		return
+	}
+
 	bucketIndex := findBucketIndex(buckets)
 	if bucketIndex == -1 {
-		return buckets
+		return
 	}
 
-	bucket := &buckets[bucketIndex]
+	bucket := buckets[bucketIndex]
 
 	if len(bucket.Funds) == 0 {
 		fmt.Println("❌ 该桶内没有基金")
-		return buckets
+		return
 	}
 
 	fmt.Printf("\n%s 内的基金:\n", bucket.Name)
@@ -211,31 +1218,36 @@ func deleteFundCLI(buckets []Bucket) []Bucket {
 
 	if choice < 1 || choice > len(bucket.Funds) {
 		fmt.Println("❌ 无效的基金编号")
-		return buckets
+		return
 	}
 
-	fundIndex := choice - 1
-	fundName := bucket.Funds[fundIndex].Name
-
-	// 删除基金
-	bucket.Funds = append(bucket.Funds[:fundIndex], bucket.Funds[fundIndex+1:]...)
-	fmt.Printf("✅ 已删除基金: %s\n", fundName)
+	fund := bucket.Funds[choice-1]
 
-	return buckets
+	// 归档基金，历史记录保留
+	if err := store.ArchiveFund(fund.ID); err != nil {
+		fmt.Printf("❌ 删除基金失败: %v\n", err)
+		return
+	}
+	fmt.Printf("✅ 已归档基金: %s（历史记录保留，可通过 restore-fund 恢复）\n", fund.Name)
 }
 
 // CLI版本的修改基金信息
-func updateFundCLI(buckets []Bucket) []Bucket {
+func updateFundCLI() {
+	_, buckets, ok := loadBucketsCLI()
+	if !ok {
+		return
+	}
+
 	bucketIndex := findBucketIndex(buckets)
 	if bucketIndex == -1 {
-		return buckets
+		return
 	}
 
-	bucket := &buckets[bucketIndex]
+	bucket := buckets[bucketIndex]
 
 	if len(bucket.Funds) == 0 {
 		fmt.Println("❌ 该桶内没有基金")
-		return buckets
+		return
 	}
 
 	fmt.Printf("\n%s 内的基金:\n", bucket.Name)
@@ -250,10 +1262,10 @@ func updateFundCLI(buckets []Bucket) []Bucket {
 
 	if choice < 1 || choice > len(bucket.Funds) {
 		fmt.Println("❌ 无效的基金编号")
-		return buckets
+		return
 	}
 
-	fund := &bucket.Funds[choice-1]
+	fund := bucket.Funds[choice-1]
 
 	fmt.Println("\n选择要修改的属性:")
 	fmt.Println("1. 基金名称")
@@ -265,25 +1277,21 @@ func updateFundCLI(buckets []Bucket) []Bucket {
 	fmt.Print("请选择 (1-4): ")
 	fmt.Scan(&attr)
 
+	var field, value, label string
 	switch attr {
 	case 1:
-		var newName string
 		fmt.Print("新的基金名称: ")
-		fmt.Scan(&newName)
-		fund.Name = strings.ReplaceAll(newName, "_", " ")
-		fmt.Println("✅ 基金名称已更新")
+		fmt.Scan(&value)
+		field, value, label = "name", strings.ReplaceAll(value, "_", " "), "基金名称"
 	case 2:
-		var newCode string
 		fmt.Print("新的基金代码: ")
-		fmt.Scan(&newCode)
-		fund.Code = newCode
-		fmt.Println("✅ 基金代码已更新")
+		fmt.Scan(&value)
+		field, label = "code", "基金代码"
 	case 3:
 		var newCurrent float64
 		fmt.Print("新的当前市值(万元): ")
 		fmt.Scan(&newCurrent)
-		fund.Current = newCurrent
-		fmt.Println("✅ 当前市值已更新")
+		field, value, label = "current", strconv.FormatFloat(newCurrent, 'f', -1, 64), "当前市值"
 	case 4:
 		var newWeight float64
 		fmt.Print("新的权重(0-1): ")
@@ -291,8 +1299,8 @@ func updateFundCLI(buckets []Bucket) []Bucket {
 
 		// 验证权重
 		var totalWeight float64
-		for i, f := range bucket.Funds {
-			if i != choice-1 { // 排除当前基金
+		for _, f := range bucket.Funds {
+			if f.ID != fund.ID { // 排除当前基金
 				totalWeight += f.Weight
 			}
 		}
@@ -300,58 +1308,217 @@ func updateFundCLI(buckets []Bucket) []Bucket {
 		if totalWeight+newWeight > 1.0 {
 			fmt.Printf("❌ 权重超出限制！其他基金总权重: %.2f，剩余可分配: %.2f\n",
 				totalWeight, 1.0-totalWeight)
-			return buckets
+			return
+		}
+		field, value, label = "weight", strconv.FormatFloat(newWeight, 'f', -1, 64), "权重"
+	default:
+		fmt.Println("❌ 无效选择")
+		return
+	}
+
+	if err := store.UpdateFund(fund.ID, field, value); err != nil {
+		fmt.Printf("❌ 更新基金失败: %v\n", err)
+		return
+	}
+	fmt.Printf("✅ %s已更新\n", label)
+}
+
+// CLI版本的添加桶
+func addBucketCLI() {
+	dbBuckets, buckets, ok := loadBucketsCLI()
+	if !ok {
+		return
+	}
+
+	var name string
+	var targetRate float64
+
+	fmt.Print("桶名称: ")
+	fmt.Scan(&name)
+	name = strings.ReplaceAll(name, "_", " ") // 处理空格
+
+	for _, b := range buckets {
+		if b.Name == name {
+			fmt.Printf("❌ 桶名称已存在: %s\n", name)
+			return
+		}
+	}
+
+	fmt.Print("目标占比(0-1，可先填0再统一调整): ")
+	fmt.Scan(&targetRate)
+
+	// 验证目标占比，交互菜单只添加顶层桶，子桶用 add-bucket --parent-id 添加
+	totalRate := siblingRateSum(dbBuckets, 0, 0)
+	if targetRate < 0 || totalRate+targetRate > 1.0+targetRateTolerance {
+		fmt.Printf("❌ 目标占比超出限制！当前顶层桶总占比: %.2f，剩余可分配: %.2f\n",
+			totalRate, 1.0-totalRate)
+		return
+	}
+
+	if _, err := store.AddBucket(name, targetRate, 0); err != nil {
+		fmt.Printf("❌ 添加桶失败: %v\n", err)
+		return
+	}
+	fmt.Printf("✅ 已添加桶: %s\n", name)
+}
+
+// CLI版本的修改桶信息
+func updateBucketCLI() {
+	dbBuckets, buckets, ok := loadBucketsCLI()
+	if !ok {
+		return
+	}
+
+	fmt.Println("\n选择要修改的属性:")
+	fmt.Println("1. 桶名称")
+	fmt.Println("2. 所有桶的目标占比")
+
+	var attr int
+	fmt.Print("请选择 (1-2): ")
+	fmt.Scan(&attr)
+
+	switch attr {
+	case 1:
+		bucketIndex := findBucketIndex(buckets)
+		if bucketIndex == -1 {
+			return
 		}
 
-		fund.Weight = newWeight
-		fmt.Println("✅ 权重已更新")
+		var newName string
+		fmt.Print("新的桶名称: ")
+		fmt.Scan(&newName)
+		newName = strings.ReplaceAll(newName, "_", " ")
+
+		for i, b := range buckets {
+			if i != bucketIndex && b.Name == newName {
+				fmt.Printf("❌ 桶名称已存在: %s\n", newName)
+				return
+			}
+		}
+
+		if err := store.UpdateBucket(buckets[bucketIndex].ID, "name", newName); err != nil {
+			fmt.Printf("❌ 更新桶失败: %v\n", err)
+			return
+		}
+		fmt.Println("✅ 桶名称已更新")
+	case 2:
+		// 目标占比需要整体调整，才能保证同一父桶下合计为100%
+		rates := make(map[int]float64, len(buckets))
+		for _, b := range buckets {
+			var rate float64
+			fmt.Printf("%s%s 的新目标占比(当前 %.2f): ", strings.Repeat("  ", b.Level), b.Name, b.TargetRate)
+			fmt.Scan(&rate)
+			rates[b.ID] = rate
+		}
+
+		if err := validateNewTargetRates(dbBuckets, rates); err != nil {
+			fmt.Printf("❌ %v\n", err)
+			return
+		}
+
+		if err := store.UpdateBucketTargetRates(rates); err != nil {
+			fmt.Printf("❌ 更新目标占比失败: %v\n", err)
+			return
+		}
+		fmt.Println("✅ 目标占比已更新")
 	default:
 		fmt.Println("❌ 无效选择")
 	}
+}
 
-	return buckets
+// CLI版本的删除桶
+func deleteBucketCLI() {
+	dbBuckets, buckets, ok := loadBucketsCLI()
+	if !ok {
+		return
+	}
+
+	bucketIndex := findBucketIndex(buckets)
+	if bucketIndex == -1 {
+		return
+	}
+
+	bucket := dbBuckets[bucketIndex]
+
+	// 桶内有基金或目标占比不为0时，必须并入其他桶；有子桶时由 checkBucketDelete 直接拒绝
+	moveToIndex := -1
+	if !hasChildBuckets(dbBuckets, bucket.ID) && (len(bucket.Funds) > 0 || bucket.TargetRate > targetRateTolerance) {
+		fmt.Printf("%s 内有 %d 只基金，目标占比 %.1f%%，需要并入其他桶\n",
+			bucket.Name, len(bucket.Funds), bucket.TargetRate*100)
+		if moveToIndex = findBucketIndex(buckets); moveToIndex == -1 {
+			return
+		}
+	}
+	moveTo, err := checkBucketDelete(dbBuckets, bucketIndex, moveToIndex)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return
+	}
+
+	if err := store.DeleteBucket(bucket, moveTo); err != nil {
+		fmt.Printf("❌ 删除桶失败: %v\n", err)
+		return
+	}
+
+	if moveTo != nil {
+		fmt.Printf("✅ 已删除桶: %s，基金及目标占比已并入: %s\n", bucket.Name, moveTo.Name)
+	} else {
+		fmt.Printf("✅ 已删除桶: %s\n", bucket.Name)
+	}
 }
 
 // CLI版本的函数
-func performRebalanceCLI(buckets []Bucket) {
+func performRebalanceCLI() {
 	var threshold float64
-	fmt.Print("请输入再平衡触发阈值 (例如 0.05 表示 ±5%)，按回车默认 0.05：")
+	fmt.Printf("请输入再平衡触发阈值 (例如 0.05 表示 ±5%%)，输入0使用默认值 %g：", appConfig.DefaultThreshold)
 	_, err := fmt.Scan(&threshold)
 	if err != nil || threshold <= 0 {
-		threshold = 0.05
+		threshold = appConfig.DefaultThreshold
 	}
 
-	// 执行再平衡
-	results := rebalance(buckets, threshold)
+	_, buckets, ok := loadBucketsCLI()
+	if !ok {
+		return
+	}
+
+	// 与Web端一致，先按下滑路径和估值规则调整目标占比
+	adjustments, err := applyDynamicTargets(buckets, time.Now().Format(dateLayout))
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return
+	}
+	opts := RebalanceOptions{Strategy: defaultStrategyName, Threshold: threshold, Mode: RebalanceModeTarget}
+	if err := checkRebalanceInput(buckets, opts); err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return
+	}
+
+	// 执行再平衡，与Web端一致回写结果并保存历史记录
+	result, recordID, err := rebalanceAndRecord(buckets, opts, adjustments, RebalanceTriggerManual)
+	if err != nil {
+		fmt.Printf("⚠️  保存再平衡记录失败: %v\n", err)
+	}
+	results := result.Buckets
+	for _, d := range targetAdjustmentDiagnostics(adjustments) {
+		fmt.Printf("📐 %s\n", d)
+	}
 
 	// 输出调仓清单
 	fmt.Println("\n📋 调仓清单（单位：万元）")
 	fmt.Println("-------------------------------------------------------------")
 	for _, b := range results {
 		for _, f := range b.Funds {
-			fmt.Printf("%s (%s) | 当前市值: %.2f | 目标: %.2f | 建议: %s | 调整金额: %.2f\n",
-				f.Name, f.Code, f.Current, f.Target, f.Advice, f.Diff)
+			fmt.Printf("%s (%s) | 当前市值: %.2f | 目标: %.2f | 建议: %s | 调整金额: %.2f | 调整后: %.2f\n",
+				f.Name, f.Code, f.Current, f.Target, f.Advice, f.Diff, f.PostTrade)
 		}
 	}
+	fmt.Printf("\n💰 %s\n", rebalanceSummary(results))
+	if err == nil {
+		fmt.Printf("✅ 再平衡记录已保存，ID: %d\n", recordID)
+	}
 }
 
 func runCLI() {
-	// 初始化默认组合
-	clieBuckets := []Bucket{
-		{"短期桶（货币基金）", 0.10, []Fund{
-			{"易方达货币A", "000009", 20, 1.0, 0, 0, "", ""},
-		}},
-		{"中期桶（债券基金）", 0.30, []Fund{
-			{"广发国开债7-10A", "003375", 50, 0.5, 0, 0, "", ""},
-			{"博时信用债纯债A", "050026", 40, 0.5, 0, 0, "", ""},
-		}},
-		{"长期桶（股票基金）", 0.60, []Fund{
-			{"易方达沪深300ETF联接A", "110020", 100, 0.4, 0, 0, "", ""},
-			{"南方中证500ETF联接A", "160119", 80, 0.3, 0, 0, "", ""},
-			{"汇添富海外互联网50ETF", "006327", 60, 0.3, 0, 0, "", ""},
-		}},
-	}
-
 	for {
 		showMenu()
 
@@ -364,20 +1531,28 @@ func runCLI() {
 
 		switch choice {
 		case 1:
-			listFunds(clieBuckets)
+			if _, buckets, ok := loadBucketsCLI(); ok {
+				listFunds(buckets)
+			}
 		case 2:
-			performRebalanceCLI(clieBuckets)
+			performRebalanceCLI()
 		case 3:
-			clieBuckets = addFundCLI(clieBuckets)
+			addFundCLI()
 		case 4:
-			clieBuckets = deleteFundCLI(clieBuckets)
+			deleteFundCLI()
 		case 5:
-			clieBuckets = updateFundCLI(clieBuckets)
+			updateFundCLI()
 		case 6:
+			addBucketCLI()
+		case 7:
+			updateBucketCLI()
+		case 8:
+			deleteBucketCLI()
+		case 9:
 			fmt.Println("👋 感谢使用，再见！")
 			return
 		default:
-			fmt.Println("❌ 无效选择，请输入 1-6")
+			fmt.Println("❌ 无效选择，请输入 1-9")
 		}
 
 		// 暂停一下，让用户看到结果
@@ -387,7 +1562,35 @@ func runCLI() {
 }
 
 func main() {
-	if len(os.Args) > 1 && os.Args[1] == "cli" {
+	// 全局参数(--config、--db、--listen、--seed)须写在命令之前
+	cfg, args, err := loadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		printUsage(os.Stdout)
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 配置错误: %v\n\n", err)
+		printUsage(os.Stderr)
+		os.Exit(exitUsage)
+	}
+	appConfig = cfg
+
+	if len(args) > 0 && args[0] != "cli" {
+		// 非交互式子命令
+		if args[0] == "help" {
+			printUsage(os.Stdout)
+			return
+		}
+		cmd, ok := findCommand(args[0])
+		if !ok {
+			fmt.Fprintf(os.Stderr, "❌ 未知命令: %s\n\n", args[0])
+			printUsage(os.Stderr)
+			os.Exit(exitUsage)
+		}
+		os.Exit(runCommand(cmd, args[1:]))
+	}
+
+	if len(args) > 0 && args[0] == "cli" {
 		// 命令行模式
 		// 初始化数据库（CLI也需要数据库支持）
 		initData()
@@ -396,14 +1599,18 @@ func main() {
 	} else {
 		// Web服务器模式
 		fmt.Println("🚀 启动Web服务器模式...")
-		fmt.Println("📱 访问 http://localhost:8080 打开Web界面")
-		fmt.Println("💻 或使用 'go run . cli' 启动命令行模式")
-		fmt.Println("📊 数据将持久化存储到 SQLite 数据库")
+		fmt.Printf("📱 访问 %s 打开Web界面\n", appConfig.webURL())
+		fmt.Println("💻 或使用 'go run . cli' 启动命令行模式，'go run . help' 查看子命令")
+		fmt.Printf("📊 数据将持久化存储到 %s\n", appConfig.databaseLabel())
 
 		initData()
 		defer closeDatabase()
+		initNAVProvider()
+		initScheduler()
 
 		r := setupRoutes()
-		r.Run(":8080")
+		if err := r.Run(appConfig.Listen); err != nil {
+			log.Fatalf("Web服务器启动失败: %v", err)
+		}
 	}
 }
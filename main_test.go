@@ -0,0 +1,402 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAllocateCashFlow(t *testing.T) {
+	tests := []struct {
+		name     string
+		currents []float64
+		targets  []float64
+		amount   float64
+		want     []float64
+	}{
+		{"金额为0时不交易", []float64{10, 20}, []float64{15, 15}, 0, []float64{0, 0}},
+		{"投入资金只补足低配基金", []float64{10, 30}, []float64{30, 30}, 20, []float64{20, 0}},
+		{"投入资金超过低配缺口时各基金都买入", []float64{10, 30}, []float64{40, 40}, 40, []float64{30, 10}},
+		{"投入资金按目标市值比例注水", []float64{0, 0}, []float64{30, 10}, 20, []float64{15, 5}},
+		{"没有目标配置时平均分配", []float64{5, 5}, []float64{0, 0}, 6, []float64{3, 3}},
+		{"取出资金优先卖出目标为0的基金", []float64{5, 20, 10}, []float64{0, 15, 10}, -5, []float64{-5, 0, 0}},
+		{"目标为0的基金卖完后卖出超配基金", []float64{5, 20, 10}, []float64{0, 15, 10}, -10, []float64{-5, -5, 0}},
+		{"取出资金超过超配部分时按目标市值比例卖出", []float64{10, 30}, []float64{10, 10}, -35, []float64{-7.5, -27.5}},
+		{"取出全部市值", []float64{10, 30}, []float64{0, 0}, -40, []float64{-10, -30}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := allocateCashFlow(tt.currents, tt.targets, tt.amount)
+			if len(got) != len(tt.want) {
+				t.Fatalf("交易笔数 %d，应为 %d", len(got), len(tt.want))
+			}
+			var sum float64
+			for i := range got {
+				if !approxEqual(got[i], tt.want[i]) {
+					t.Errorf("交易金额 %v，应为 %v", got, tt.want)
+					break
+				}
+				if got[i] < 0 && -got[i] > tt.currents[i]+1e-9 {
+					t.Errorf("第%d只基金卖出%.4f万，超过持有市值%.4f万", i+1, -got[i], tt.currents[i])
+				}
+				sum += got[i]
+			}
+			if !approxEqual(sum, tt.amount) {
+				t.Errorf("交易金额合计 %.6f，应等于现金流 %.6f", sum, tt.amount)
+			}
+		})
+	}
+}
+
+// 现金流模式：目标市值按投入后的总市值计算，调整后市值单独给出，投入的资金全部分配
+func TestRebalanceCashFlowTargets(t *testing.T) {
+	buckets := []Bucket{
+		{ID: 1, Name: "债券", TargetRate: 0.4, Funds: []Fund{{ID: 1, Name: "债券基金", Current: 30, Weight: 1}}},
+		{ID: 2, Name: "股票", TargetRate: 0.6, Funds: []Fund{
+			{ID: 2, Name: "股票基金甲", Current: 36, Weight: 0.5},
+			{ID: 3, Name: "股票基金乙", Current: 30, Weight: 0.5},
+		}},
+	}
+	opts := RebalanceOptions{Threshold: 0.05, Mode: RebalanceModeCashFlow, CashFlow: 24}
+	if err := normalizeRebalanceOptions(&opts); err != nil {
+		t.Fatal(err)
+	}
+	result, err := rebalanceAt(buckets, opts, time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 投入后总市值120：债券48，股票甲、乙各36，投入的24万恰好补足债券和股票乙的缺口
+	want := map[int]struct{ target, diff float64 }{1: {48, 18}, 2: {36, 0}, 3: {36, 6}}
+	var invested float64
+	for _, b := range result.Buckets {
+		for _, f := range b.Funds {
+			w := want[f.ID]
+			if !approxEqual(f.Target, w.target) || !approxEqual(f.Diff, w.diff) {
+				t.Errorf("%s 目标%.4f、调整%.4f，应为目标%.4f、调整%.4f", f.Name, f.Target, f.Diff, w.target, w.diff)
+			}
+			if !approxEqual(f.PostTrade, f.Current+f.Diff) {
+				t.Errorf("%s 调整后市值%.4f，应为%.4f", f.Name, f.PostTrade, f.Current+f.Diff)
+			}
+			invested += f.Diff
+		}
+	}
+	if !approxEqual(invested, 24) {
+		t.Errorf("买入合计%.4f万，应等于投入的24万", invested)
+	}
+}
+
+func TestApplyTradeRules(t *testing.T) {
+	tests := []struct {
+		name        string
+		diffs       []float64
+		currents    []float64 // 持有市值，为空时均为50
+		fundMins    []float64 // 基金自身的最低交易金额
+		skipped     float64
+		minTrade    float64
+		roundStep   float64
+		wantDiffs   []float64
+		wantDropped []float64
+		wantCash    float64 // 未能分配的金额
+	}{
+		{
+			name:  "不设规则时不调整",
+			diffs: []float64{3, 14, -17}, wantDiffs: []float64{3, 14, -17}, wantDropped: []float64{0, 0, 0},
+		},
+		{
+			name:  "取消的小额买入并入金额最大的买入，不改变卖出",
+			diffs: []float64{3, 14, -17}, minTrade: 5,
+			wantDiffs: []float64{0, 17, -17}, wantDropped: []float64{3, 0, 0},
+		},
+		{
+			name:  "取消的小额卖出并入金额最大的卖出",
+			diffs: []float64{20, -2, -18}, minTrade: 5,
+			wantDiffs: []float64{20, 0, -20}, wantDropped: []float64{0, -2, 0},
+		},
+		{
+			name:  "没有同方向的交易时差额以现金留存",
+			diffs: []float64{1, -10}, minTrade: 2,
+			wantDiffs: []float64{0, -10}, wantDropped: []float64{1, 0}, wantCash: 1,
+		},
+		{
+			name:  "卖出不超过持有市值，放不下的部分并入下一笔卖出",
+			diffs: []float64{20, -2, -12, -6}, currents: []float64{50, 50, 13, 40}, minTrade: 5,
+			wantDiffs: []float64{20, 0, -13, -7}, wantDropped: []float64{0, -2, 0, 0},
+		},
+		{
+			name:  "卖出差额放不下时从买入中扣减",
+			diffs: []float64{10, -3, -1, -6}, currents: []float64{50, 23, 21, 6}, minTrade: 3.5,
+			wantDiffs: []float64{6, 0, 0, -6}, wantDropped: []float64{0, -3, -1, 0},
+		},
+		{
+			name:  "扣减后低于最低交易金额的买入一并取消，卖出所得以现金留存",
+			diffs: []float64{5, -3, -2}, currents: []float64{50, 3, 40}, fundMins: []float64{4, 0, 0}, minTrade: 2.5,
+			wantDiffs: []float64{0, -3, 0}, wantDropped: []float64{0, 0, -2}, wantCash: 3,
+		},
+		{
+			name:  "基金的最低交易金额高于全局时按基金的计算",
+			diffs: []float64{8, 12, -20}, fundMins: []float64{10, 0, 0}, minTrade: 1,
+			wantDiffs: []float64{0, 20, -20}, wantDropped: []float64{8, 0, 0},
+		},
+		{
+			name:  "取整到百元，取整后为0的交易取消",
+			diffs: []float64{0.004, 5.006, -5.01}, roundStep: 0.01,
+			wantDiffs: []float64{0, 5.01, -5.01}, wantDropped: []float64{0.004, 0, 0},
+		},
+		{
+			name:  "取整产生的差额并入同方向金额最大的交易",
+			diffs: []float64{1.236, 2.236, -3.472}, roundStep: 0.01,
+			wantDiffs: []float64{1.24, 2.24, -3.48}, wantDropped: []float64{0, 0, 0},
+		},
+		{
+			name:  "因费用跳过的买入金额并入其他买入",
+			diffs: []float64{5, -7}, skipped: 2,
+			wantDiffs: []float64{7, -7}, wantDropped: []float64{0, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			funds := make([]Fund, len(tt.diffs))
+			for i, diff := range tt.diffs {
+				funds[i] = Fund{ID: i + 1, Current: 50, Target: 50 + diff, Diff: diff}
+				if tt.currents != nil {
+					funds[i].Current = tt.currents[i]
+				}
+				if tt.fundMins != nil {
+					funds[i].MinTrade = tt.fundMins[i]
+				}
+			}
+			buckets := []Bucket{{Funds: funds}}
+			opts := RebalanceOptions{MinTrade: tt.minTrade, RoundStep: tt.roundStep}
+			cash := applyTradeRules(buckets, []float64{tt.skipped}, opts, time.Time{})
+			if !approxEqual(cash, tt.wantCash) {
+				t.Errorf("未能分配%.4f万，应为%.4f万", cash, tt.wantCash)
+			}
+
+			for i, f := range buckets[0].Funds {
+				if !approxEqual(f.Diff, tt.wantDiffs[i]) || !approxEqual(f.Dropped, tt.wantDropped[i]) {
+					t.Errorf("第%d只基金调整%.4f、取消%.4f，应为调整%.4f、取消%.4f", i+1, f.Diff, f.Dropped, tt.wantDiffs[i], tt.wantDropped[i])
+				}
+				if f.Current+f.Diff < -1e-9 {
+					t.Errorf("第%d只基金卖出%.4f万，超过持有市值%.4f万", i+1, -f.Diff, f.Current)
+				}
+				if f.Target != 50+tt.diffs[i] {
+					t.Errorf("第%d只基金的目标市值被改为%.4f", i+1, f.Target)
+				}
+			}
+		})
+	}
+}
+
+// 设置最低交易金额只改变交易金额，目标市值与不设置时一致
+func TestRebalanceMinTradeKeepsTargets(t *testing.T) {
+	newBuckets := func() []Bucket {
+		return []Bucket{
+			{ID: 1, Name: "债券", TargetRate: 0.5, Funds: []Fund{
+				{ID: 1, Name: "债券基金甲", Current: 22, Weight: 0.5},
+				{ID: 2, Name: "债券基金乙", Current: 18, Weight: 0.5},
+			}},
+			{ID: 2, Name: "股票", TargetRate: 0.5, Funds: []Fund{{ID: 3, Name: "股票基金", Current: 60, Weight: 1}}},
+		}
+	}
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local)
+	run := func(minTrade float64) []Bucket {
+		opts := RebalanceOptions{Threshold: 0.05, MinTrade: minTrade}
+		if err := normalizeRebalanceOptions(&opts); err != nil {
+			t.Fatal(err)
+		}
+		result, err := rebalanceAt(newBuckets(), opts, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.Buckets
+	}
+
+	plain, limited := run(0), run(5)
+	wantDiffs := map[int]float64{1: 0, 2: 10, 3: -10}
+	for bi := range limited {
+		for fi, f := range limited[bi].Funds {
+			if want := plain[bi].Funds[fi].Target; !approxEqual(f.Target, want) {
+				t.Errorf("%s 目标市值%.4f，应与不设最低交易金额时的%.4f一致", f.Name, f.Target, want)
+			}
+			if !approxEqual(f.Diff, wantDiffs[f.ID]) {
+				t.Errorf("%s 调整%.4f，应为%.4f", f.Name, f.Diff, wantDiffs[f.ID])
+			}
+			if !approxEqual(f.PostTrade, f.Current+f.Diff) {
+				t.Errorf("%s 调整后市值%.4f，应为%.4f", f.Name, f.PostTrade, f.Current+f.Diff)
+			}
+		}
+	}
+}
+
+// 取消的小额卖出并入其他卖出时不能超过持有市值：股票丙只持有6万，目标为0，应卖出6万而不是10万
+func TestRebalanceMinTradeNeverOversells(t *testing.T) {
+	buckets := []Bucket{
+		{ID: 1, Name: "债券", TargetRate: 0.6, Funds: []Fund{{ID: 1, Name: "债券基金", Current: 50, Weight: 1}}},
+		{ID: 2, Name: "股票", TargetRate: 0.4, Funds: []Fund{
+			{ID: 2, Name: "股票基金甲", Current: 23, Weight: 0.5},
+			{ID: 3, Name: "股票基金乙", Current: 21, Weight: 0.5},
+			{ID: 4, Name: "股票基金丙", Current: 6, Weight: 0},
+		}},
+	}
+	opts := RebalanceOptions{Threshold: 0.05, MinTrade: 3.5}
+	if err := normalizeRebalanceOptions(&opts); err != nil {
+		t.Fatal(err)
+	}
+	result, err := rebalanceAt(buckets, opts, time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 甲卖出3万、乙卖出1万低于最低交易金额被取消，丙已全部卖出，少卖的4万从债券基金的买入中扣减
+	want := map[int]struct{ target, diff float64 }{1: {60, 6}, 2: {20, 0}, 3: {20, 0}, 4: {0, -6}}
+	var net float64
+	for _, b := range result.Buckets {
+		for _, f := range b.Funds {
+			w := want[f.ID]
+			if !approxEqual(f.Target, w.target) || !approxEqual(f.Diff, w.diff) {
+				t.Errorf("%s 目标%.4f、调整%.4f，应为目标%.4f、调整%.4f", f.Name, f.Target, f.Diff, w.target, w.diff)
+			}
+			if f.PostTrade < 0 {
+				t.Errorf("%s 调整后市值为%.4f", f.Name, f.PostTrade)
+			}
+			net += f.Diff
+		}
+	}
+	if !approxEqual(net, 0) || !approxEqual(result.Unallocated, 0) {
+		t.Errorf("买卖相抵后为%.4f万、未能分配%.4f万，应均为0", net, result.Unallocated)
+	}
+}
+
+// 两种偏离带同时启用时任一超出即触发(5/25 规则)：权重大的基金由绝对偏离带约束，权重小的由相对偏离带约束
+func TestDriftBandBreach(t *testing.T) {
+	absolute := DriftBand{Absolute: 0.05}
+	relative := DriftBand{Relative: 0.25}
+	both := DriftBand{Absolute: 0.05, Relative: 0.25}
+
+	tests := []struct {
+		name     string
+		band     DriftBand
+		current  float64
+		target   float64
+		wantRule string // 为空表示不应触发
+	}{
+		{"未启用偏离带", DriftBand{}, 0.9, 0.5, ""},
+		{"绝对偏离带内", absolute, 0.54, 0.5, ""},
+		{"超出绝对偏离带", absolute, 0.56, 0.5, "超出绝对偏离带±5.0个百分点"},
+		{"低于权重同样触发", absolute, 0.44, 0.5, "超出绝对偏离带"},
+		{"相对偏离带内", relative, 0.12, 0.1, ""},
+		{"超出相对偏离带", relative, 0.13, 0.1, "相对权重10.0%偏离30.0%，超出相对偏离带±25%"},
+		{"权重为0但持有", relative, 0.02, 0, "权重为0但桶内占比2.0%"},
+		{"5/25：大权重在两种偏离带内", both, 0.54, 0.5, ""},
+		{"5/25：大权重由绝对偏离带触发", both, 0.56, 0.5, "超出绝对偏离带"},
+		{"5/25：小权重由较窄的相对偏离带触发", both, 0.13, 0.1, "超出相对偏离带"},
+		{"5/25：小权重在相对偏离带内", both, 0.12, 0.1, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, ok := tt.band.breach(tt.current, tt.target)
+			if tt.wantRule == "" {
+				if ok {
+					t.Errorf("不应触发，实际: %s", rule)
+				}
+				return
+			}
+			if !ok || !strings.Contains(rule, tt.wantRule) {
+				t.Errorf("触发%v、说明 %q，应触发且包含 %q", ok, rule, tt.wantRule)
+			}
+		})
+	}
+}
+
+// 偏离带半宽取两种偏离带中较窄者
+func TestDriftBandWidth(t *testing.T) {
+	tests := []struct {
+		band   DriftBand
+		target float64
+		want   float64
+	}{
+		{DriftBand{Absolute: 0.05, Relative: 0.25}, 0.5, 0.05},
+		{DriftBand{Absolute: 0.05, Relative: 0.25}, 0.1, 0.025},
+		{DriftBand{Absolute: 0.05}, 0.1, 0.05},
+		{DriftBand{Relative: 0.25}, 0.4, 0.1},
+		{DriftBand{}, 0.4, math.Inf(1)},
+	}
+
+	for _, tt := range tests {
+		if got := tt.band.width(tt.target); !(approxEqual(got, tt.want) || math.IsInf(got, 1) && math.IsInf(tt.want, 1)) {
+			t.Errorf("偏离带 %+v 在权重%.2f时半宽%.4f，应为%.4f", tt.band, tt.target, got, tt.want)
+		}
+	}
+}
+
+// 偏离带边缘模式只交易到偏离带边缘(或内层带)；Target 始终是按目标占比的配置目标，交易后的市值为 PostTrade
+func TestRebalanceBandEdge(t *testing.T) {
+	// 债券30、股票70：股票偏高10%，超出阈值±5%
+	bucketLevel := func() []Bucket {
+		return []Bucket{
+			{ID: 1, Name: "债券", TargetRate: 0.4, Funds: []Fund{{ID: 1, Name: "债券基金", Current: 30, Weight: 1}}},
+			{ID: 2, Name: "股票", TargetRate: 0.6, Funds: []Fund{
+				{ID: 2, Name: "股票基金甲", Current: 35, Weight: 0.5},
+				{ID: 3, Name: "股票基金乙", Current: 35, Weight: 0.5},
+			}},
+		}
+	}
+	// 桶在目标上；乙桶内占比35%、丙15%，分别偏离权重25%达10个百分点，超出5/25偏离带的半宽5个百分点
+	fundLevel := func() []Bucket {
+		return []Bucket{
+			{ID: 1, Name: "股票", TargetRate: 1, Funds: []Fund{
+				{ID: 1, Name: "股票基金甲", Current: 50, Weight: 0.5},
+				{ID: 2, Name: "股票基金乙", Current: 35, Weight: 0.25},
+				{ID: 3, Name: "股票基金丙", Current: 15, Weight: 0.25},
+			}},
+		}
+	}
+	band := DriftBand{Absolute: 0.05, Relative: 0.25}
+
+	type fundWant struct{ target, diff, postTrade float64 }
+	tests := []struct {
+		name    string
+		buckets []Bucket
+		opts    RebalanceOptions
+		want    map[int]fundWant
+	}{
+		{"完全调回目标", bucketLevel(), RebalanceOptions{Mode: RebalanceModeTarget},
+			map[int]fundWant{1: {40, 10, 40}, 2: {30, -5, 30}, 3: {30, -5, 30}}},
+		{"桶调回到阈值边缘", bucketLevel(), RebalanceOptions{Mode: RebalanceModeBandEdge},
+			map[int]fundWant{1: {40, 5, 35}, 2: {30, -2.5, 32.5}, 3: {30, -2.5, 32.5}}},
+		{"桶调回到内层带", bucketLevel(), RebalanceOptions{Mode: RebalanceModeBandEdge, InnerBand: 0.5},
+			map[int]fundWant{1: {40, 7.5, 37.5}, 2: {30, -3.75, 31.25}, 3: {30, -3.75, 31.25}}},
+		{"基金完全调回权重", fundLevel(), RebalanceOptions{Mode: RebalanceModeTarget, FundBand: band},
+			map[int]fundWant{1: {50, 0, 50}, 2: {25, -10, 25}, 3: {25, 10, 25}}},
+		{"基金调回到偏离带边缘", fundLevel(), RebalanceOptions{Mode: RebalanceModeBandEdge, FundBand: band},
+			map[int]fundWant{1: {50, 0, 50}, 2: {25, -5, 30}, 3: {25, 5, 20}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := tt.opts
+			opts.Threshold = 0.05
+			if err := normalizeRebalanceOptions(&opts); err != nil {
+				t.Fatal(err)
+			}
+			result, err := rebalanceAt(tt.buckets, opts, time.Date(2024, 6, 1, 0, 0, 0, 0, time.Local))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, b := range result.Buckets {
+				for _, f := range b.Funds {
+					w := tt.want[f.ID]
+					if !approxEqual(f.Target, w.target) || !approxEqual(f.Diff, w.diff) || !approxEqual(f.PostTrade, w.postTrade) {
+						t.Errorf("%s 目标%.4f、调整%.4f、调整后%.4f，应为目标%.4f、调整%.4f、调整后%.4f",
+							f.Name, f.Target, f.Diff, f.PostTrade, w.target, w.diff, w.postTrade)
+					}
+				}
+			}
+		})
+	}
+}
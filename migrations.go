@@ -0,0 +1,206 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 升级迁移，每种存储后端一个目录，文件名格式为 0001_名称.sql，按版本号顺序执行，已发布的迁移不能修改；
+// 同一版本号在各后端表示同一次结构变化
+//
+//go:embed migrations/sqlite/*.sql migrations/postgres/*.sql
+var migrationFiles embed.FS
+
+type migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// 一个迁移的执行情况
+type MigrationStatus struct {
+	Version   int    `json:"version"`
+	Name      string `json:"name"`
+	Applied   bool   `json:"applied"`
+	AppliedAt string `json:"applied_at,omitempty"`
+}
+
+// 数据库结构版本
+type SchemaStatus struct {
+	CurrentVersion int               `json:"current_version"`
+	LatestVersion  int               `json:"latest_version"`
+	Migrations     []MigrationStatus `json:"migrations"`
+}
+
+// 执行迁移的结果
+type MigrationResult struct {
+	FromVersion int               `json:"from_version"`
+	ToVersion   int               `json:"to_version"`
+	Applied     []MigrationStatus `json:"applied"`
+	Backup      string            `json:"backup,omitempty"` // 迁移前的备份文件，没有执行迁移或新建数据库时为空
+}
+
+// 读取目录中内嵌的迁移文件，按版本号排序，版本号须从1开始连续
+func loadMigrations(dir string) ([]migration, error) {
+	entries, err := migrationFiles.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		prefix, label, ok := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("迁移文件名无效: %s（格式: 0001_名称.sql）", entry.Name())
+		}
+		data, err := migrationFiles.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{Version: version, Name: label, SQL: string(data)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("迁移版本号不连续: 缺少版本 %d", i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// 已执行的迁移及其执行时间
+func (s *sqlRepository) getAppliedMigrations() (map[int]string, error) {
+	rows, err := s.query("SELECT version, applied_at FROM schema_version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]string)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt.Format("2006-01-02 15:04:05")
+	}
+	return applied, rows.Err()
+}
+
+func (s *sqlRepository) SchemaStatus() (*SchemaStatus, error) {
+	migrations, err := loadMigrations(s.dialect.migrationDir)
+	if err != nil {
+		return nil, err
+	}
+	// 创建记录已执行迁移的表
+	if _, err := s.exec(s.dialect.schemaVersionDDL); err != nil {
+		return nil, err
+	}
+	applied, err := s.getAppliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	status := &SchemaStatus{LatestVersion: len(migrations)}
+	for _, m := range migrations {
+		appliedAt, ok := applied[m.Version]
+		status.Migrations = append(status.Migrations, MigrationStatus{Version: m.Version, Name: m.Name, Applied: ok, AppliedAt: appliedAt})
+		if ok {
+			status.CurrentVersion = m.Version
+		}
+	}
+	for version := range applied {
+		if version > status.LatestVersion {
+			return nil, fmt.Errorf("数据库结构版本 %d 高于程序支持的版本 %d，请升级程序", version, status.LatestVersion)
+		}
+	}
+	return status, nil
+}
+
+// 依次执行尚未执行的迁移；backup 为 true、后端支持备份且数据库中已有数据时，先备份数据库
+func (s *sqlRepository) Migrate(backup bool) (*MigrationResult, error) {
+	status, err := s.SchemaStatus()
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := loadMigrations(s.dialect.migrationDir)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &MigrationResult{FromVersion: status.CurrentVersion, ToVersion: status.CurrentVersion, Applied: []MigrationStatus{}}
+	var pending []migration
+	for i, s := range status.Migrations {
+		if !s.Applied {
+			pending = append(pending, migrations[i])
+		}
+	}
+	if len(pending) == 0 {
+		return result, nil
+	}
+
+	if backup && s.dialect.backup != nil {
+		hasData, err := s.databaseHasTables()
+		if err != nil {
+			return nil, err
+		}
+		if hasData {
+			if result.Backup, err = s.dialect.backup(s.db, s.dsn, status.CurrentVersion); err != nil {
+				return nil, fmt.Errorf("迁移前备份数据库失败: %v", err)
+			}
+		}
+	}
+
+	for _, m := range pending {
+		if err := s.applyMigration(m); err != nil {
+			return result, fmt.Errorf("执行迁移 %04d_%s 失败: %v", m.Version, m.Name, err)
+		}
+		log.Printf("✅ 已执行数据库迁移 %04d_%s", m.Version, m.Name)
+		result.ToVersion = m.Version
+		result.Applied = append(result.Applied, MigrationStatus{
+			Version: m.Version, Name: m.Name, Applied: true, AppliedAt: time.Now().Format("2006-01-02 15:04:05"),
+		})
+	}
+	return result, nil
+}
+
+// 在一个事务中执行迁移并记录版本
+func (s *sqlRepository) applyMigration(m migration) error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// 迁移文件可能包含多条语句，且不带参数，不需要改写占位符
+	if _, err := tx.Tx.Exec(m.SQL); err != nil {
+		return err
+	}
+	// 引入版本化迁移之前的数据库，表已存在但可能缺少后来新增的列
+	if m.Version == 1 && s.dialect.upgradeLegacy != nil {
+		if err := s.dialect.upgradeLegacy(tx.Tx); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec("INSERT INTO schema_version (version, name) VALUES (?, ?)", m.Version, m.Name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// 数据库中是否已有业务表(不含迁移版本表)，新建的数据库无需备份
+func (s *sqlRepository) databaseHasTables() (bool, error) {
+	var count int
+	err := s.queryRow(s.dialect.tablesQuery).Scan(&count)
+	return count > 0, err
+}
@@ -0,0 +1,98 @@
+-- 初始表结构，与 SQLite 的 0001_initial_schema.sql 对应
+-- 金额、净值和比例用 DOUBLE PRECISION(REAL 在 PostgreSQL 中是单精度)
+-- funds.bucket_id 不设外键：与 SQLite(未开启外键约束)一致，桶删除后已归档的基金保留原桶ID，恢复时另选桶
+
+CREATE TABLE IF NOT EXISTS buckets (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    target_rate DOUBLE PRECISION NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS funds (
+    id SERIAL PRIMARY KEY,
+    bucket_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    code TEXT NOT NULL,
+    current DOUBLE PRECISION NOT NULL DEFAULT 0,
+    weight DOUBLE PRECISION NOT NULL DEFAULT 0,
+    target DOUBLE PRECISION NOT NULL DEFAULT 0,
+    diff DOUBLE PRECISION NOT NULL DEFAULT 0,
+    advice TEXT DEFAULT '',
+    purchase_rate DOUBLE PRECISION NOT NULL DEFAULT 0,
+    purchase_discount DOUBLE PRECISION NOT NULL DEFAULT 0,
+    redemption_tiers TEXT NOT NULL DEFAULT '',
+    held_since TEXT NOT NULL DEFAULT '',
+    min_trade DOUBLE PRECISION NOT NULL DEFAULT 0,
+    current_override BOOLEAN NOT NULL DEFAULT FALSE,
+    archived_at TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS rebalance_records (
+    id SERIAL PRIMARY KEY,
+    threshold DOUBLE PRECISION NOT NULL,
+    total_value DOUBLE PRECISION NOT NULL,
+    mode TEXT NOT NULL DEFAULT 'target',
+    inner_band DOUBLE PRECISION NOT NULL DEFAULT 0,
+    cash_flow DOUBLE PRECISION NOT NULL DEFAULT 0,
+    total_fee DOUBLE PRECISION NOT NULL DEFAULT 0,
+    snapshot TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS rebalance_suggestions (
+    id SERIAL PRIMARY KEY,
+    record_id INTEGER NOT NULL REFERENCES rebalance_records(id) ON DELETE CASCADE,
+    fund_id INTEGER NOT NULL REFERENCES funds(id) ON DELETE CASCADE,
+    fund_name TEXT NOT NULL,
+    fund_code TEXT NOT NULL,
+    current_value DOUBLE PRECISION NOT NULL,
+    target_value DOUBLE PRECISION NOT NULL,
+    diff_value DOUBLE PRECISION NOT NULL,
+    advice TEXT NOT NULL,
+    reason TEXT DEFAULT '',
+    fee DOUBLE PRECISION NOT NULL DEFAULT 0,
+    status TEXT NOT NULL DEFAULT 'pending',
+    executed_amount DOUBLE PRECISION NOT NULL DEFAULT 0,
+    executed_nav DOUBLE PRECISION NOT NULL DEFAULT 0,
+    executed_shares DOUBLE PRECISION NOT NULL DEFAULT 0,
+    executed_at TEXT NOT NULL DEFAULT '',
+    execution_note TEXT NOT NULL DEFAULT '',
+    transaction_id INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS transactions (
+    id SERIAL PRIMARY KEY,
+    fund_id INTEGER NOT NULL REFERENCES funds(id) ON DELETE CASCADE,
+    type TEXT NOT NULL,
+    trade_date TEXT NOT NULL,
+    shares DOUBLE PRECISION NOT NULL DEFAULT 0,
+    nav DOUBLE PRECISION NOT NULL DEFAULT 0,
+    amount DOUBLE PRECISION NOT NULL DEFAULT 0,
+    note TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS fund_navs (
+    code TEXT NOT NULL,
+    date TEXT NOT NULL,
+    unit_nav DOUBLE PRECISION NOT NULL,
+    acc_nav DOUBLE PRECISION NOT NULL DEFAULT 0,
+    source TEXT NOT NULL DEFAULT 'import',
+    fetched_at TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (code, date)
+);
+
+CREATE INDEX IF NOT EXISTS idx_funds_bucket_id ON funds(bucket_id);
+
+CREATE INDEX IF NOT EXISTS idx_transactions_fund_id ON transactions(fund_id);
+
+CREATE INDEX IF NOT EXISTS idx_suggestions_record_id ON rebalance_suggestions(record_id);
+
+CREATE INDEX IF NOT EXISTS idx_suggestions_fund_id ON rebalance_suggestions(fund_id);
@@ -0,0 +1,3 @@
+-- 再平衡记录增加所用策略，与 SQLite 的 0002_rebalance_strategy.sql 对应
+
+ALTER TABLE rebalance_records ADD COLUMN strategy TEXT NOT NULL DEFAULT 'bucket_threshold';
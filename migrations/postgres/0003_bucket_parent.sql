@@ -0,0 +1,4 @@
+-- 桶层级，与 SQLite 的 0003_bucket_parent.sql 对应
+-- 与 funds.bucket_id 一样不设外键，0 表示顶层桶
+
+ALTER TABLE buckets ADD COLUMN parent_id INTEGER NOT NULL DEFAULT 0;
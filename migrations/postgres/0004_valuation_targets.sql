@@ -0,0 +1,25 @@
+-- 指数估值数据及按估值分位调整桶目标占比的规则
+
+CREATE TABLE IF NOT EXISTS index_valuations (
+    index_code TEXT NOT NULL,
+    metric TEXT NOT NULL,
+    date TEXT NOT NULL,
+    value DOUBLE PRECISION NOT NULL DEFAULT 0,
+    percentile DOUBLE PRECISION NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (index_code, metric, date)
+);
+
+CREATE TABLE IF NOT EXISTS valuation_rules (
+    id SERIAL PRIMARY KEY,
+    bucket_id INTEGER NOT NULL,
+    index_code TEXT NOT NULL,
+    metric TEXT NOT NULL,
+    min_percentile DOUBLE PRECISION NOT NULL,
+    max_percentile DOUBLE PRECISION NOT NULL,
+    target_rate DOUBLE PRECISION NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_valuation_rules_bucket_id ON valuation_rules(bucket_id);
@@ -0,0 +1,3 @@
+-- 再平衡记录增加触发方式：manual 手动、scheduled 按日历定时、drift 偏离超出阈值，已有记录均为手动执行
+
+ALTER TABLE rebalance_records ADD COLUMN trigger TEXT NOT NULL DEFAULT 'manual';
@@ -0,0 +1,13 @@
+-- 时间列改为带时区的 TIMESTAMPTZ。不带时区的 TIMESTAMP 按会话时区写入当地时间，读出时却被当作UTC，
+-- 会话时区不是UTC时，与程序中的当前时间比较(如定时再平衡的冷却期)会相差一个时区偏移。
+-- 已有数据按执行迁移时的会话时区解读，与写入时使用的时区一致
+
+ALTER TABLE buckets ALTER COLUMN created_at TYPE TIMESTAMPTZ, ALTER COLUMN updated_at TYPE TIMESTAMPTZ;
+ALTER TABLE funds ALTER COLUMN created_at TYPE TIMESTAMPTZ, ALTER COLUMN updated_at TYPE TIMESTAMPTZ;
+ALTER TABLE rebalance_records ALTER COLUMN created_at TYPE TIMESTAMPTZ;
+ALTER TABLE rebalance_suggestions ALTER COLUMN created_at TYPE TIMESTAMPTZ;
+ALTER TABLE transactions ALTER COLUMN created_at TYPE TIMESTAMPTZ;
+ALTER TABLE fund_navs ALTER COLUMN created_at TYPE TIMESTAMPTZ, ALTER COLUMN updated_at TYPE TIMESTAMPTZ;
+ALTER TABLE index_valuations ALTER COLUMN created_at TYPE TIMESTAMPTZ, ALTER COLUMN updated_at TYPE TIMESTAMPTZ;
+ALTER TABLE valuation_rules ALTER COLUMN created_at TYPE TIMESTAMPTZ;
+ALTER TABLE glide_path_points ALTER COLUMN created_at TYPE TIMESTAMPTZ;
@@ -0,0 +1,101 @@
+-- 初始表结构：程序引入版本化迁移时的完整结构
+-- 旧版本数据库中已存在的表不受影响，缺少的列由程序在应用本迁移时补齐
+
+CREATE TABLE IF NOT EXISTS buckets (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE,
+    target_rate REAL NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS funds (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    bucket_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    code TEXT NOT NULL,
+    current REAL NOT NULL DEFAULT 0,
+    weight REAL NOT NULL DEFAULT 0,
+    target REAL NOT NULL DEFAULT 0,
+    diff REAL NOT NULL DEFAULT 0,
+    advice TEXT DEFAULT '',
+    purchase_rate REAL NOT NULL DEFAULT 0,
+    purchase_discount REAL NOT NULL DEFAULT 0,
+    redemption_tiers TEXT NOT NULL DEFAULT '',
+    held_since TEXT NOT NULL DEFAULT '',
+    min_trade REAL NOT NULL DEFAULT 0,
+    current_override INTEGER NOT NULL DEFAULT 0,
+    archived_at TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (bucket_id) REFERENCES buckets(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS rebalance_records (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    threshold REAL NOT NULL,
+    total_value REAL NOT NULL,
+    mode TEXT NOT NULL DEFAULT 'target',
+    inner_band REAL NOT NULL DEFAULT 0,
+    cash_flow REAL NOT NULL DEFAULT 0,
+    total_fee REAL NOT NULL DEFAULT 0,
+    snapshot TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS rebalance_suggestions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    record_id INTEGER NOT NULL,
+    fund_id INTEGER NOT NULL,
+    fund_name TEXT NOT NULL,
+    fund_code TEXT NOT NULL,
+    current_value REAL NOT NULL,
+    target_value REAL NOT NULL,
+    diff_value REAL NOT NULL,
+    advice TEXT NOT NULL,
+    reason TEXT DEFAULT '',
+    fee REAL NOT NULL DEFAULT 0,
+    status TEXT NOT NULL DEFAULT 'pending',
+    executed_amount REAL NOT NULL DEFAULT 0,
+    executed_nav REAL NOT NULL DEFAULT 0,
+    executed_shares REAL NOT NULL DEFAULT 0,
+    executed_at TEXT NOT NULL DEFAULT '',
+    execution_note TEXT NOT NULL DEFAULT '',
+    transaction_id INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (record_id) REFERENCES rebalance_records(id) ON DELETE CASCADE,
+    FOREIGN KEY (fund_id) REFERENCES funds(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS transactions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    fund_id INTEGER NOT NULL,
+    type TEXT NOT NULL,
+    trade_date TEXT NOT NULL,
+    shares REAL NOT NULL DEFAULT 0,
+    nav REAL NOT NULL DEFAULT 0,
+    amount REAL NOT NULL DEFAULT 0,
+    note TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (fund_id) REFERENCES funds(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS fund_navs (
+    code TEXT NOT NULL,
+    date TEXT NOT NULL,
+    unit_nav REAL NOT NULL,
+    acc_nav REAL NOT NULL DEFAULT 0,
+    source TEXT NOT NULL DEFAULT 'import',
+    fetched_at TEXT NOT NULL DEFAULT '',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (code, date)
+);
+
+CREATE INDEX IF NOT EXISTS idx_funds_bucket_id ON funds(bucket_id);
+
+CREATE INDEX IF NOT EXISTS idx_transactions_fund_id ON transactions(fund_id);
+
+CREATE INDEX IF NOT EXISTS idx_suggestions_record_id ON rebalance_suggestions(record_id);
+
+CREATE INDEX IF NOT EXISTS idx_suggestions_fund_id ON rebalance_suggestions(fund_id);
@@ -0,0 +1,3 @@
+-- 再平衡记录增加所用策略，已有记录均由桶阈值策略生成
+
+ALTER TABLE rebalance_records ADD COLUMN strategy TEXT NOT NULL DEFAULT 'bucket_threshold';
@@ -0,0 +1,3 @@
+-- 桶层级：parent_id 为父桶ID，0 表示顶层桶；子桶的 target_rate 为其在父桶中的占比
+
+ALTER TABLE buckets ADD COLUMN parent_id INTEGER NOT NULL DEFAULT 0;
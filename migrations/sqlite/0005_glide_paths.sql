@@ -0,0 +1,13 @@
+-- 桶目标占比的下滑路径：按日期或投资者年龄设置的时间点，时间点之间线性插值
+
+CREATE TABLE IF NOT EXISTS glide_path_points (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    bucket_id INTEGER NOT NULL,
+    basis TEXT NOT NULL,
+    date TEXT NOT NULL DEFAULT '',
+    age REAL NOT NULL DEFAULT 0,
+    target_rate REAL NOT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_glide_path_points_bucket_id ON glide_path_points(bucket_id);
@@ -0,0 +1,3 @@
+-- 与 PostgreSQL 的同一版本对应：SQLite 的 CURRENT_TIMESTAMP 始终为UTC，读出时也按UTC解读，结构无需改动
+
+SELECT 1;
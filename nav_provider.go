@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// 净值数据源，按基金代码获取最新净值
+type NAVProvider interface {
+	// 数据源名称，记录在净值的 source 字段
+	Name() string
+	// 获取基金的最新净值
+	LatestNAV(ctx context.Context, code string) (FundNAV, error)
+}
+
+// 净值数据源配置，来自配置文件的 nav_provider 部分，可由 NAV_PROVIDER 等环境变量覆盖
+type NAVProviderConfig struct {
+	Type     string        `yaml:"type"`     // http 或 stub，为空表示未配置
+	URL      string        `yaml:"url"`      // http 数据源地址，{code} 替换为基金代码，没有占位符时追加 ?code=
+	Mapping  string        `yaml:"mapping"`  // http 响应字段映射，如 list=Data.LSJZList,date=FSRQ,unit_nav=DWJZ,acc_nav=LJJZ
+	File     string        `yaml:"file"`     // stub 数据源读取的净值文件
+	Interval time.Duration `yaml:"interval"` // Web服务器定时抓取的间隔，0 表示不定时抓取
+	Timeout  time.Duration `yaml:"timeout"`  // 单次请求超时
+}
+
+// 按配置创建净值数据源，未配置时返回 nil
+func newNAVProvider(cfg NAVProviderConfig) (NAVProvider, error) {
+	switch cfg.Type {
+	case "":
+		return nil, nil
+	case "http":
+		return newHTTPNAVProvider(cfg.URL, cfg.Mapping, cfg.Timeout)
+	case "stub":
+		return newStubNAVProvider(cfg.File)
+	default:
+		return nil, fmt.Errorf("无效的净值数据源类型: %s（可选 http、stub）", cfg.Type)
+	}
+}
+
+// 净值响应字段映射，字段值为以 . 分隔的JSON路径
+type NAVResponseMapping struct {
+	List    string // 净值列表的路径，为空表示响应本身就是一条净值(或净值数组)
+	Code    string
+	Date    string
+	UnitNAV string
+	AccNAV  string
+}
+
+// 默认映射，与 nav-stub-server 的响应一致
+var defaultNAVResponseMapping = NAVResponseMapping{
+	Code:    "code",
+	Date:    "date",
+	UnitNAV: "unit_nav",
+	AccNAV:  "acc_nav",
+}
+
+// 解析字段映射，如 "list=Data.LSJZList,date=FSRQ,unit_nav=DWJZ,acc_nav=LJJZ"，未指定的字段使用默认值
+func parseNAVResponseMapping(value string) (NAVResponseMapping, error) {
+	mapping := defaultNAVResponseMapping
+	if strings.TrimSpace(value) == "" {
+		return mapping, nil
+	}
+
+	for _, part := range strings.Split(value, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[1]) == "" {
+			return mapping, fmt.Errorf("无效的字段映射: %s（格式: 字段=JSON路径,...）", part)
+		}
+		path := strings.TrimSpace(kv[1])
+		switch strings.TrimSpace(kv[0]) {
+		case "list":
+			mapping.List = path
+		case "code":
+			mapping.Code = path
+		case "date":
+			mapping.Date = path
+		case "unit_nav":
+			mapping.UnitNAV = path
+		case "acc_nav":
+			mapping.AccNAV = path
+		default:
+			return mapping, fmt.Errorf("无效的映射字段: %s（可选 list、code、date、unit_nav、acc_nav）", kv[0])
+		}
+	}
+	return mapping, nil
+}
+
+// 通过HTTP接口获取净值
+type HTTPNAVProvider struct {
+	URL     string
+	Mapping NAVResponseMapping
+	Client  *http.Client
+}
+
+func newHTTPNAVProvider(rawURL, mapping string, timeout time.Duration) (*HTTPNAVProvider, error) {
+	if rawURL == "" {
+		return nil, fmt.Errorf("http 净值数据源需要配置地址")
+	}
+	if _, err := url.Parse(strings.ReplaceAll(rawURL, "{code}", "000000")); err != nil {
+		return nil, fmt.Errorf("无效的净值数据源地址: %v", err)
+	}
+	m, err := parseNAVResponseMapping(mapping)
+	if err != nil {
+		return nil, err
+	}
+	return &HTTPNAVProvider{URL: rawURL, Mapping: m, Client: &http.Client{Timeout: timeout}}, nil
+}
+
+func (p *HTTPNAVProvider) Name() string {
+	u, err := url.Parse(strings.ReplaceAll(p.URL, "{code}", ""))
+	if err != nil || u.Host == "" {
+		return "http"
+	}
+	return "http:" + u.Host
+}
+
+// 基金代码对应的请求地址
+func (p *HTTPNAVProvider) requestURL(code string) string {
+	if strings.Contains(p.URL, "{code}") {
+		return strings.ReplaceAll(p.URL, "{code}", url.PathEscape(code))
+	}
+	u, _ := url.Parse(p.URL)
+	query := u.Query()
+	query.Set("code", code)
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func (p *HTTPNAVProvider) LatestNAV(ctx context.Context, code string) (FundNAV, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.requestURL(code), nil)
+	if err != nil {
+		return FundNAV{}, err
+	}
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return FundNAV{}, fmt.Errorf("请求失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return FundNAV{}, fmt.Errorf("读取响应失败: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return FundNAV{}, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+
+	nav, err := p.parseResponse(body, code)
+	if err != nil {
+		return FundNAV{}, err
+	}
+	if nav.Code != code {
+		return FundNAV{}, fmt.Errorf("响应中的基金代码 %s 与请求的 %s 不一致", nav.Code, code)
+	}
+	return nav, nil
+}
+
+// 按字段映射解析响应，响应中有多条净值时取日期最新的一条
+func (p *HTTPNAVProvider) parseResponse(body []byte, code string) (FundNAV, error) {
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return FundNAV{}, fmt.Errorf("解析响应失败: %v", err)
+	}
+
+	if p.Mapping.List != "" {
+		value, ok := lookupJSONPath(doc, p.Mapping.List)
+		if !ok {
+			return FundNAV{}, fmt.Errorf("响应中没有 %s", p.Mapping.List)
+		}
+		doc = value
+	}
+	items, ok := doc.([]interface{})
+	if !ok {
+		items = []interface{}{doc}
+	}
+
+	var latest FundNAV
+	for _, item := range items {
+		row := make(map[string]string)
+		for field, path := range map[string]string{
+			"code":     p.Mapping.Code,
+			"date":     p.Mapping.Date,
+			"unit_nav": p.Mapping.UnitNAV,
+			"acc_nav":  p.Mapping.AccNAV,
+		} {
+			if value, ok := lookupJSONPath(item, path); ok && value != nil {
+				row[field] = fmt.Sprint(value)
+			}
+		}
+		nav, err := parseNAVRow(row, code)
+		if err != nil {
+			return FundNAV{}, err
+		}
+		if nav.Date > latest.Date {
+			latest = nav
+		}
+	}
+	if latest.Date == "" {
+		return FundNAV{}, fmt.Errorf("响应中没有净值")
+	}
+	return latest, nil
+}
+
+// 按 . 分隔的路径查找JSON中的值
+func lookupJSONPath(doc interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return nil, false
+	}
+	current := doc
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = obj[key]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// 本地净值数据源，从净值文件读取每只基金的最新净值，用于测试和离线使用
+type StubNAVProvider struct {
+	navs map[string]FundNAV
+}
+
+func newStubNAVProvider(file string) (*StubNAVProvider, error) {
+	if file == "" {
+		return nil, fmt.Errorf("stub 净值数据源需要配置净值文件")
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("读取净值文件失败: %v", err)
+	}
+	navs, err := parseNAVFile(data, "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	p := &StubNAVProvider{navs: make(map[string]FundNAV)}
+	for _, nav := range navs {
+		if nav.Date >= p.navs[nav.Code].Date {
+			p.navs[nav.Code] = nav
+		}
+	}
+	return p, nil
+}
+
+func (p *StubNAVProvider) Name() string {
+	return "stub"
+}
+
+func (p *StubNAVProvider) LatestNAV(ctx context.Context, code string) (FundNAV, error) {
+	nav, ok := p.navs[code]
+	if !ok {
+		return FundNAV{}, fmt.Errorf("没有该基金的净值")
+	}
+	return nav, nil
+}
+
+// 单只基金的抓取失败
+type NAVFetchError struct {
+	Code  string `json:"code"`
+	Error string `json:"error"`
+}
+
+// 净值抓取结果
+type NAVFetchResult struct {
+	Provider  string          `json:"provider"`
+	FetchedAt string          `json:"fetched_at"`
+	NAVs      []FundNAV       `json:"navs"`
+	Failed    []NAVFetchError `json:"failed"`
+	Inserted  int             `json:"inserted"`
+	Updated   int             `json:"updated"`
+	Unchanged int             `json:"unchanged"`
+}
+
+// 抓取结果摘要
+func navFetchSummary(result *NAVFetchResult) string {
+	summary := fmt.Sprintf("从 %s 获取%d只基金的净值，新增%d条，覆盖%d条，未变化%d条",
+		result.Provider, len(result.NAVs), result.Inserted, result.Updated, result.Unchanged)
+	if len(result.Failed) > 0 {
+		summary += fmt.Sprintf("，%d只失败", len(result.Failed))
+	}
+	return summary
+}
+
+// 为 funds 表中的所有基金抓取最新净值并写入净值库，单只基金失败不影响其他基金
+func fetchLatestNAVs(ctx context.Context, provider NAVProvider) (*NAVFetchResult, error) {
+	codes, err := store.GetFundCodes()
+	if err != nil {
+		return nil, fmt.Errorf("获取基金代码失败: %v", err)
+	}
+
+	fetchedAt := time.Now().Format("2006-01-02 15:04:05")
+	result := &NAVFetchResult{Provider: provider.Name(), FetchedAt: fetchedAt, NAVs: []FundNAV{}, Failed: []NAVFetchError{}}
+	for _, code := range codes {
+		nav, err := provider.LatestNAV(ctx, code)
+		if err != nil {
+			result.Failed = append(result.Failed, NAVFetchError{Code: code, Error: err.Error()})
+			continue
+		}
+		nav.Code = code
+		nav.Source = provider.Name()
+		nav.FetchedAt = fetchedAt
+		result.NAVs = append(result.NAVs, nav)
+	}
+	if len(result.NAVs) == 0 {
+		return result, nil
+	}
+
+	imported, err := importFundNAVs(result.NAVs, 1)
+	if err != nil {
+		return nil, fmt.Errorf("保存净值失败: %v", err)
+	}
+	result.Inserted, result.Updated, result.Unchanged = imported.Inserted, imported.Updated, imported.Unchanged
+	return result, nil
+}
+
+// 按间隔定时抓取净值，启动时先抓取一次
+func startNAVFetchScheduler(provider NAVProvider, interval time.Duration) {
+	go func() {
+		for {
+			result, err := fetchLatestNAVs(context.Background(), provider)
+			if err != nil {
+				log.Printf("定时获取净值失败: %v", err)
+			} else {
+				log.Printf("定时获取净值: %s", navFetchSummary(result))
+				for _, failed := range result.Failed {
+					log.Printf("  %s: %s", failed.Code, failed.Error)
+				}
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// 本地替身净值服务：GET /nav/{code} 返回 stub 数据源中该基金的最新净值，响应格式与默认字段映射一致
+func newNAVStubHandler(provider NAVProvider) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/nav/", func(w http.ResponseWriter, r *http.Request) {
+		code := strings.TrimPrefix(r.URL.Path, "/nav/")
+		nav, err := provider.LatestNAV(r.Context(), code)
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(nav)
+	})
+	return mux
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseNAVResponseMapping(t *testing.T) {
+	tests := []struct {
+		value     string
+		want      NAVResponseMapping
+		wantError string // 为空表示不应报错
+	}{
+		{value: "", want: defaultNAVResponseMapping},
+		{value: "list=Data.LSJZList, date=FSRQ ,unit_nav=DWJZ,acc_nav=LJJZ",
+			want: NAVResponseMapping{List: "Data.LSJZList", Code: "code", Date: "FSRQ", UnitNAV: "DWJZ", AccNAV: "LJJZ"}},
+		{value: "code=data.fundcode", want: NAVResponseMapping{Code: "data.fundcode", Date: "date", UnitNAV: "unit_nav", AccNAV: "acc_nav"}},
+		{value: "date", wantError: "无效的字段映射"},
+		{value: "date=", wantError: "无效的字段映射"},
+		{value: "date=FSRQ,,unit_nav=DWJZ", wantError: "无效的字段映射"},
+		{value: "price=DWJZ", wantError: "无效的映射字段: price"},
+	}
+
+	for _, tt := range tests {
+		got, err := parseNAVResponseMapping(tt.value)
+		if tt.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+				t.Errorf("%q 的错误 %v 应包含 %q", tt.value, err, tt.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q 解析失败: %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q 解析为 %+v，应为 %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+// 地址中有 {code} 时替换为基金代码，没有时追加 ?code=，已有的查询参数保留
+func TestHTTPNAVProviderRequestURL(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.RequestURI())
+		code := r.URL.Query().Get("code")
+		if code == "" {
+			code = strings.TrimPrefix(r.URL.Path, "/nav/")
+		}
+		fmt.Fprintf(w, `{"code":%q,"date":"2024-01-02","unit_nav":1.2345,"acc_nav":2.5}`, code)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		url      string
+		wantPath string
+	}{
+		{"替换占位符", server.URL + "/nav/{code}", "/nav/000001"},
+		{"追加查询参数", server.URL + "/nav", "/nav?code=000001"},
+		{"保留已有的查询参数", server.URL + "/nav?token=abc", "/nav?code=000001&token=abc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths = nil
+			provider, err := newHTTPNAVProvider(tt.url, "", time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			nav, err := provider.LatestNAV(context.Background(), "000001")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(paths) != 1 || paths[0] != tt.wantPath {
+				t.Errorf("请求地址 %v，应为 %s", paths, tt.wantPath)
+			}
+			want := FundNAV{Code: "000001", Date: "2024-01-02", UnitNAV: 1.2345, AccNAV: 2.5}
+			if nav != want {
+				t.Errorf("净值 %+v，应为 %+v", nav, want)
+			}
+		})
+	}
+}
+
+func TestHTTPNAVProviderResponse(t *testing.T) {
+	responses := map[string]struct {
+		status int
+		body   string
+	}{
+		"000001": {http.StatusOK, `{"Data":{"LSJZList":[{"FSRQ":"2024-01-02","DWJZ":"1.1"},{"FSRQ":"2024-01-04","DWJZ":"1.3","LJJZ":"2.3"},{"FSRQ":"2024-01-03","DWJZ":"1.2"}]}}`},
+		"000002": {http.StatusNotFound, `not found`},
+		"000003": {http.StatusOK, `{"Data":{"LSJZList":[]}}`},
+		"000004": {http.StatusOK, `{"Data":null}`},
+		"000005": {http.StatusOK, `<html>`},
+		"000006": {http.StatusOK, `{"Data":{"LSJZList":[{"FSRQ":"2024-01-02","DWJZ":"-"}]}}`},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := responses[strings.TrimPrefix(r.URL.Path, "/f10/")]
+		w.WriteHeader(resp.status)
+		fmt.Fprint(w, resp.body)
+	}))
+	defer server.Close()
+
+	provider, err := newHTTPNAVProvider(server.URL+"/f10/{code}", "list=Data.LSJZList,date=FSRQ,unit_nav=DWJZ,acc_nav=LJJZ", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		code      string
+		want      FundNAV
+		wantError string // 为空表示不应报错
+	}{
+		{code: "000001", want: FundNAV{Code: "000001", Date: "2024-01-04", UnitNAV: 1.3, AccNAV: 2.3}},
+		{code: "000002", wantError: "HTTP 404"},
+		{code: "000003", wantError: "响应中没有净值"},
+		{code: "000004", wantError: "响应中没有 Data.LSJZList"},
+		{code: "000005", wantError: "解析响应失败"},
+		{code: "000006", wantError: "无效的单位净值"},
+	}
+
+	for _, tt := range tests {
+		nav, err := provider.LatestNAV(context.Background(), tt.code)
+		if tt.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+				t.Errorf("%s 的错误 %v 应包含 %q", tt.code, err, tt.wantError)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s 获取失败: %v", tt.code, err)
+			continue
+		}
+		if nav != tt.want {
+			t.Errorf("%s 的净值 %+v，应为列表中日期最新的 %+v", tt.code, nav, tt.want)
+		}
+	}
+
+	// 响应中的基金代码与请求的不一致
+	mismatch := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"code":"999999","date":"2024-01-02","unit_nav":1}`)
+	}))
+	defer mismatch.Close()
+	other, err := newHTTPNAVProvider(mismatch.URL, "", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.LatestNAV(context.Background(), "000001"); err == nil || !strings.Contains(err.Error(), "不一致") {
+		t.Errorf("基金代码不一致时应报错，实际: %v", err)
+	}
+}
+
+func TestNewNAVProvider(t *testing.T) {
+	tests := []struct {
+		cfg       NAVProviderConfig
+		wantNil   bool
+		wantError string
+	}{
+		{cfg: NAVProviderConfig{}, wantNil: true},
+		{cfg: NAVProviderConfig{Type: "ftp"}, wantError: "无效的净值数据源类型"},
+		{cfg: NAVProviderConfig{Type: "http"}, wantError: "需要配置地址"},
+		{cfg: NAVProviderConfig{Type: "http", URL: "http://127.0.0.1/nav", Mapping: "price=DWJZ"}, wantError: "无效的映射字段"},
+		{cfg: NAVProviderConfig{Type: "stub"}, wantError: "需要配置净值文件"},
+	}
+
+	for _, tt := range tests {
+		provider, err := newNAVProvider(tt.cfg)
+		if tt.wantError != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+				t.Errorf("%+v 的错误 %v 应包含 %q", tt.cfg, err, tt.wantError)
+			}
+			continue
+		}
+		if err != nil || (provider == nil) != tt.wantNil {
+			t.Errorf("%+v 创建的数据源为 %v，错误 %v", tt.cfg, provider, err)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 基金净值
+type FundNAV struct {
+	Code      string  `json:"code"`
+	Date      string  `json:"date"`                 // 净值日期 YYYY-MM-DD
+	UnitNAV   float64 `json:"unit_nav"`             // 单位净值(元/份)
+	AccNAV    float64 `json:"acc_nav"`              // 累计净值(元/份)，未知时为0
+	Source    string  `json:"source"`               // 来源：import 表示文件导入，其余为净值数据源名称
+	FetchedAt string  `json:"fetched_at,omitempty"` // 从数据源抓取的时间
+}
+
+// 文件导入的净值来源
+const NAVSourceImport = "import"
+
+// 净值缺口：两个相邻净值日期之间缺少工作日的净值
+type NAVGap struct {
+	Code        string `json:"code"`
+	From        string `json:"from"`         // 缺口前最后一个有净值的日期
+	To          string `json:"to"`           // 缺口后第一个有净值的日期
+	MissingDays int    `json:"missing_days"` // 缺少的工作日数(含节假日)
+}
+
+// 净值导入结果
+type NAVImportResult struct {
+	Total      int      `json:"total"`      // 文件中的记录数
+	Inserted   int      `json:"inserted"`   // 新增
+	Updated    int      `json:"updated"`    // 已存在但净值不同，已覆盖
+	Unchanged  int      `json:"unchanged"`  // 已存在且相同
+	Duplicates int      `json:"duplicates"` // 文件内重复的日期，以最后一条为准
+	Codes      []string `json:"codes"`
+	Gaps       []NAVGap `json:"gaps"`
+}
+
+// 导入结果摘要
+func navImportSummary(result *NAVImportResult) string {
+	summary := fmt.Sprintf("净值导入完成：共%d条，新增%d条，覆盖%d条，未变化%d条", result.Total, result.Inserted, result.Updated, result.Unchanged)
+	if result.Duplicates > 0 {
+		summary += fmt.Sprintf("，文件内重复%d条", result.Duplicates)
+	}
+	if len(result.Gaps) > 0 {
+		summary += fmt.Sprintf("，发现%d处缺口", len(result.Gaps))
+	}
+	return summary
+}
+
+// 净值文件中各字段可能的列名，兼容天天基金等网站导出的格式
+var navFieldAliases = map[string][]string{
+	"code":     {"code", "fund_code", "fundcode", "基金代码"},
+	"date":     {"date", "nav_date", "fsrq", "净值日期", "日期"},
+	"unit_nav": {"unit_nav", "nav", "dwjz", "单位净值"},
+	"acc_nav":  {"acc_nav", "ljjz", "累计净值"},
+}
+
+// 按别名确定字段名，不认识的列返回空
+func navFieldName(column string) string {
+	column = strings.ToLower(strings.TrimSpace(column))
+	for field, aliases := range navFieldAliases {
+		for _, alias := range aliases {
+			if column == alias {
+				return field
+			}
+		}
+	}
+	return ""
+}
+
+// 解析净值文件；format 为空时根据内容判断是CSV还是JSON，code 用于文件中没有基金代码列的情况
+func parseNAVFile(data []byte, format, code string) ([]FundNAV, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if format == "" {
+		trimmed := bytes.TrimSpace(data)
+		if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+			format = "json"
+		} else {
+			format = "csv"
+		}
+	}
+
+	var rows []map[string]string
+	var err error
+	switch format {
+	case "csv":
+		rows, err = parseFieldCSV(data, navFieldName)
+	case "json":
+		rows, err = parseFieldJSON(data, navFieldName)
+	default:
+		return nil, fmt.Errorf("无效的净值文件格式: %s（可选 csv、json）", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("净值文件中没有数据")
+	}
+
+	navs := make([]FundNAV, 0, len(rows))
+	for i, row := range rows {
+		nav, err := parseNAVRow(row, code)
+		if err != nil {
+			return nil, fmt.Errorf("第%d条记录: %v", i+1, err)
+		}
+		navs = append(navs, nav)
+	}
+	return navs, nil
+}
+
+// 解析带表头的CSV，fieldName 把列名转换为字段名，不认识的列忽略
+func parseFieldCSV(data []byte, fieldName func(string) string) ([]map[string]string, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("解析CSV失败: %v", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	fields := make([]string, len(records[0]))
+	for i, column := range records[0] {
+		fields[i] = fieldName(column)
+	}
+
+	var rows []map[string]string
+	for _, record := range records[1:] {
+		row := make(map[string]string)
+		for i, value := range record {
+			if i < len(fields) && fields[i] != "" {
+				row[fields[i]] = value
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// 解析JSON：支持对象数组，以及天天基金接口返回的 {"Data":{"LSJZList":[...]}} 格式
+func parseFieldJSON(data []byte, fieldName func(string) string) ([]map[string]string, error) {
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("解析JSON失败: %v", err)
+	}
+
+	items, ok := findNAVList(doc)
+	if !ok {
+		return nil, fmt.Errorf("JSON中没有找到数据列表（支持数组或 Data.LSJZList）")
+	}
+
+	var rows []map[string]string
+	for _, item := range items {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("列表的元素必须是对象")
+		}
+		row := make(map[string]string)
+		for key, value := range obj {
+			field := fieldName(key)
+			if field == "" || value == nil {
+				continue
+			}
+			row[field] = fmt.Sprint(value)
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// 在JSON中查找净值列表：数组本身，或对象中依次查找 Data、LSJZList 字段
+func findNAVList(doc interface{}) ([]interface{}, bool) {
+	switch v := doc.(type) {
+	case []interface{}:
+		return v, true
+	case map[string]interface{}:
+		for _, name := range []string{"Data", "LSJZList"} {
+			value, ok := jsonField(v, name)
+			if !ok {
+				continue
+			}
+			if list, ok := findNAVList(value); ok {
+				return list, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// 取对象的字段，字段名不区分大小写；大小写不同的同名字段优先取完全一致的，其次按字段名排序取第一个
+func jsonField(obj map[string]interface{}, name string) (interface{}, bool) {
+	if value, ok := obj[name]; ok {
+		return value, true
+	}
+	var keys []string
+	for key := range obj {
+		if strings.EqualFold(key, name) {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, false
+	}
+	sort.Strings(keys)
+	return obj[keys[0]], true
+}
+
+// 校验并转换一条净值记录
+func parseNAVRow(row map[string]string, code string) (FundNAV, error) {
+	nav := FundNAV{Code: strings.TrimSpace(row["code"])}
+	if nav.Code == "" {
+		nav.Code = code
+	}
+	if nav.Code == "" {
+		return nav, fmt.Errorf("缺少基金代码，请在文件中提供基金代码列或指定基金代码")
+	}
+
+	date, err := parseNAVDate(row["date"])
+	if err != nil {
+		return nav, err
+	}
+	nav.Date = date
+
+	nav.UnitNAV, err = strconv.ParseFloat(strings.TrimSpace(row["unit_nav"]), 64)
+	if err != nil || nav.UnitNAV <= 0 {
+		return nav, fmt.Errorf("无效的单位净值: %q", row["unit_nav"])
+	}
+	if acc := strings.TrimSpace(row["acc_nav"]); acc != "" {
+		nav.AccNAV, err = strconv.ParseFloat(acc, 64)
+		if err != nil || nav.AccNAV < 0 {
+			return nav, fmt.Errorf("无效的累计净值: %q", row["acc_nav"])
+		}
+	}
+	return nav, nil
+}
+
+// 解析净值日期，兼容 2024-01-02、2024/01/02 和 20240102，统一为 YYYY-MM-DD
+func parseNAVDate(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range []string{dateLayout, "2006/01/02", "2006/1/2", "20060102"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.Format(dateLayout), nil
+		}
+	}
+	return "", fmt.Errorf("无效的净值日期: %q", value)
+}
+
+// 文件内去重，同一基金同一日期以最后一条为准；返回结果按基金代码和日期排序
+func dedupNAVs(navs []FundNAV) ([]FundNAV, int) {
+	index := make(map[string]int)
+	var unique []FundNAV
+	duplicates := 0
+	for _, nav := range navs {
+		key := nav.Code + "|" + nav.Date
+		if i, ok := index[key]; ok {
+			unique[i] = nav
+			duplicates++
+			continue
+		}
+		index[key] = len(unique)
+		unique = append(unique, nav)
+	}
+
+	sort.Slice(unique, func(i, j int) bool {
+		if unique[i].Code != unique[j].Code {
+			return unique[i].Code < unique[j].Code
+		}
+		return unique[i].Date < unique[j].Date
+	})
+	return unique, duplicates
+}
+
+// 查找按日期排序的净值之间的缺口，只报告缺少的工作日数不少于 minGap 的缺口
+func findNAVGaps(code string, dates []string, minGap int) []NAVGap {
+	if minGap < 1 {
+		minGap = 1
+	}
+
+	var gaps []NAVGap
+	for i := 1; i < len(dates); i++ {
+		from, err1 := time.Parse(dateLayout, dates[i-1])
+		to, err2 := time.Parse(dateLayout, dates[i])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		if missing := weekdaysBetween(from, to); missing >= minGap {
+			gaps = append(gaps, NAVGap{Code: code, From: dates[i-1], To: dates[i], MissingDays: missing})
+		}
+	}
+	return gaps
+}
+
+// 两个日期之间(不含两端)的工作日数
+func weekdaysBetween(from, to time.Time) int {
+	count := 0
+	for d := from.AddDate(0, 0, 1); d.Before(to); d = d.AddDate(0, 0, 1) {
+		if d.Weekday() != time.Saturday && d.Weekday() != time.Sunday {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseNAVFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      string
+		format    string
+		code      string
+		want      []FundNAV
+		wantError string // 为空表示不应报错
+	}{
+		{
+			name: "带BOM的CSV，中文表头",
+			data: "\xef\xbb\xbf基金代码,净值日期,单位净值,累计净值\n000001,2024-01-02,1.2345,2.5\n",
+			want: []FundNAV{{Code: "000001", Date: "2024-01-02", UnitNAV: 1.2345, AccNAV: 2.5}},
+		},
+		{
+			name: "兼容的日期格式",
+			data: "date,nav\n2024-01-02,1.1\n2024/01/03,1.2\n2024/1/4,1.3\n20240105,1.4\n",
+			code: "000001",
+			want: []FundNAV{
+				{Code: "000001", Date: "2024-01-02", UnitNAV: 1.1},
+				{Code: "000001", Date: "2024-01-03", UnitNAV: 1.2},
+				{Code: "000001", Date: "2024-01-04", UnitNAV: 1.3},
+				{Code: "000001", Date: "2024-01-05", UnitNAV: 1.4},
+			},
+		},
+		{
+			name: "JSON对象数组",
+			data: `[{"code":"000001","date":"2024-01-02","unit_nav":1.1,"acc_nav":null}]`,
+			want: []FundNAV{{Code: "000001", Date: "2024-01-02", UnitNAV: 1.1}},
+		},
+		{
+			name: "天天基金的 Data.LSJZList 格式",
+			data: `{"Data":{"LSJZList":[{"FSRQ":"2024-01-03","DWJZ":"1.2","LJJZ":"2.2"},{"FSRQ":"2024-01-02","DWJZ":"1.1","LJJZ":"2.1"}]},"ErrCode":0}`,
+			code: "000001",
+			want: []FundNAV{
+				{Code: "000001", Date: "2024-01-03", UnitNAV: 1.2, AccNAV: 2.2},
+				{Code: "000001", Date: "2024-01-02", UnitNAV: 1.1, AccNAV: 2.1},
+			},
+		},
+		{
+			name: "Data 优先于同一层的 LSJZList",
+			data: `{"LSJZList":[{"FSRQ":"2023-12-29","DWJZ":"1.0"}],"data":{"lsjzlist":[{"FSRQ":"2024-01-02","DWJZ":"1.1"}]}}`,
+			code: "000001",
+			want: []FundNAV{{Code: "000001", Date: "2024-01-02", UnitNAV: 1.1}},
+		},
+		{
+			name: "指定JSON格式",
+			data: `{"LSJZList":[{"FSRQ":"2024-01-02","DWJZ":"1.1"}]}`, format: "json",
+			code: "000001",
+			want: []FundNAV{{Code: "000001", Date: "2024-01-02", UnitNAV: 1.1}},
+		},
+		{name: "格式无效", data: "date,nav\n", format: "xml", wantError: "无效的净值文件格式"},
+		{name: "没有数据", data: "date,nav\n", code: "000001", wantError: "没有数据"},
+		{name: "缺少基金代码", data: "date,nav\n2024-01-02,1.1\n", wantError: "第1条记录: 缺少基金代码"},
+		{name: "日期无效", data: "date,nav\n2024-01-02,1.1\n02/01/2024,1.2\n", code: "000001", wantError: "第2条记录: 无效的净值日期"},
+		{name: "单位净值无效", data: "date,nav\n2024-01-02,0\n", code: "000001", wantError: "无效的单位净值"},
+		{name: "JSON中没有列表", data: `{"Data":{"Total":0}}`, code: "000001", wantError: "没有找到数据列表"},
+		{name: "列表元素不是对象", data: `{"Data":[1,2]}`, code: "000001", wantError: "列表的元素必须是对象"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseNAVFile([]byte(tt.data), tt.format, tt.code)
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Errorf("错误 %v 应包含 %q", err, tt.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("解析失败: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("解析为 %+v，应为 %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("第%d条解析为 %+v，应为 %+v", i+1, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// 同一基金同一日期以最后一条为准，结果按基金代码和日期排序
+func TestDedupNAVs(t *testing.T) {
+	navs := []FundNAV{
+		{Code: "000002", Date: "2024-01-03", UnitNAV: 2.3},
+		{Code: "000001", Date: "2024-01-03", UnitNAV: 1.3},
+		{Code: "000001", Date: "2024-01-02", UnitNAV: 1.2},
+		{Code: "000001", Date: "2024-01-03", UnitNAV: 1.31},
+		{Code: "000002", Date: "2024-01-03", UnitNAV: 2.31},
+		{Code: "000001", Date: "2024-01-03", UnitNAV: 1.32},
+	}
+	want := []FundNAV{
+		{Code: "000001", Date: "2024-01-02", UnitNAV: 1.2},
+		{Code: "000001", Date: "2024-01-03", UnitNAV: 1.32},
+		{Code: "000002", Date: "2024-01-03", UnitNAV: 2.31},
+	}
+
+	unique, duplicates := dedupNAVs(navs)
+	if duplicates != 3 {
+		t.Errorf("重复%d条，应为3条", duplicates)
+	}
+	if len(unique) != len(want) {
+		t.Fatalf("去重后为 %+v，应为 %+v", unique, want)
+	}
+	for i := range unique {
+		if unique[i] != want[i] {
+			t.Errorf("第%d条为 %+v，应为 %+v", i+1, unique[i], want[i])
+		}
+	}
+}
+
+// 缺口按两个净值日期之间的工作日数计算，周末不算缺口
+func TestFindNAVGaps(t *testing.T) {
+	// 2024-01-05 为周五
+	dates := []string{"2024-01-05", "2024-01-08", "2024-01-11", "2024-01-16", "2024-01-17", "无效日期", "2024-01-24"}
+	tests := []struct {
+		name   string
+		minGap int
+		want   []NAVGap
+	}{
+		{"报告所有缺口", 1, []NAVGap{
+			{Code: "000001", From: "2024-01-08", To: "2024-01-11", MissingDays: 2},
+			{Code: "000001", From: "2024-01-11", To: "2024-01-16", MissingDays: 2},
+		}},
+		{"最小缺口小于1时按1处理", 0, []NAVGap{
+			{Code: "000001", From: "2024-01-08", To: "2024-01-11", MissingDays: 2},
+			{Code: "000001", From: "2024-01-11", To: "2024-01-16", MissingDays: 2},
+		}},
+		{"只报告较大的缺口", 3, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := findNAVGaps("000001", dates, tt.minGap)
+			if len(got) != len(tt.want) {
+				t.Fatalf("缺口为 %+v，应为 %+v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("第%d个缺口为 %+v，应为 %+v", i+1, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+
+	// 周五到下周一之间只有周末
+	if gaps := findNAVGaps("000001", []string{"2024-01-05", "2024-01-08"}, 1); len(gaps) != 0 {
+		t.Errorf("跨周末不应报告缺口，实际 %+v", gaps)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"math"
+)
+
+// 记录没有配置快照，无法重现
+var errNoSnapshot = errors.New("该记录没有配置快照(为快照功能之前的旧记录)，无法重现")
+
+// 一只基金在一次再平衡中的情况
+type FundOutcome struct {
+	Current float64 `json:"current"` // 再平衡前市值(万元)
+	Target  float64 `json:"target"`  // 目标市值(万元)
+	Diff    float64 `json:"diff"`    // 调整金额(万元)
+	Advice  string  `json:"advice"`
+	Weight  float64 `json:"weight"` // 桶内权重，没有配置快照时为0
+}
+
+// 同一只基金在两次再平衡中的对比，A 或 B 为 null 表示该基金只出现在其中一次
+type FundComparison struct {
+	FundID        int          `json:"fund_id"`
+	FundName      string       `json:"fund_name"`
+	FundCode      string       `json:"fund_code"`
+	A             *FundOutcome `json:"a"`
+	B             *FundOutcome `json:"b"`
+	ValueChange   float64      `json:"value_change"`  // 市值变化 B-A
+	TargetChange  float64      `json:"target_change"` // 目标市值变化 B-A
+	DiffChange    float64      `json:"diff_change"`   // 调整金额变化 B-A
+	AdviceChanged bool         `json:"advice_changed"`
+}
+
+// 桶目标占比的对比，来自两条记录的配置快照
+type BucketComparison struct {
+	BucketID    int      `json:"bucket_id"`
+	Name        string   `json:"name"`
+	TargetRateA *float64 `json:"target_rate_a"` // null 表示该桶不在A中
+	TargetRateB *float64 `json:"target_rate_b"`
+}
+
+// 两条再平衡记录的对比
+type RecordComparison struct {
+	A                RebalanceRecord    `json:"a"`
+	B                RebalanceRecord    `json:"b"`
+	TotalValueChange float64            `json:"total_value_change"`
+	Buckets          []BucketComparison `json:"buckets"` // 两条记录都有配置快照时才比较
+	Funds            []FundComparison   `json:"funds"`
+	AdviceChanges    int                `json:"advice_changes"` // 操作建议变化的基金数
+}
+
+// 用当前算法重现历史记录的结果，A 为原记录的建议，B 为重新计算的结果
+type ReplayResult struct {
+	Record        RebalanceRecord  `json:"record"`
+	Options       RebalanceOptions `json:"options"`
+	Results       []Bucket         `json:"results"`
+	Diagnostics   []string         `json:"diagnostics"` // 策略重新计算时给出的诊断信息
+	Funds         []FundComparison `json:"funds"`
+	AdviceChanges int              `json:"advice_changes"`
+}
+
+// 带基金信息的结果，用于按基金对齐
+type fundOutcomeRow struct {
+	FundID   int
+	FundName string
+	FundCode string
+	Outcome  FundOutcome
+}
+
+// 由历史记录的建议得到每只基金的结果，权重取自配置快照
+func outcomesFromSuggestions(suggestions []RebalanceSuggestion, snapshot *RebalanceSnapshot) []fundOutcomeRow {
+	weights := make(map[int]float64)
+	if snapshot != nil {
+		for _, b := range snapshot.Buckets {
+			for _, f := range b.Funds {
+				weights[f.ID] = f.Weight
+			}
+		}
+	}
+
+	rows := make([]fundOutcomeRow, 0, len(suggestions))
+	for _, s := range suggestions {
+		rows = append(rows, fundOutcomeRow{
+			FundID:   s.FundID,
+			FundName: s.FundName,
+			FundCode: s.FundCode,
+			Outcome:  FundOutcome{Current: s.CurrentValue, Target: s.TargetValue, Diff: s.DiffValue, Advice: s.Advice, Weight: weights[s.FundID]},
+		})
+	}
+	return rows
+}
+
+// 由再平衡结果得到每只基金的结果
+func outcomesFromBuckets(buckets []Bucket) []fundOutcomeRow {
+	var rows []fundOutcomeRow
+	for _, b := range buckets {
+		for _, f := range b.Funds {
+			rows = append(rows, fundOutcomeRow{
+				FundID:   f.ID,
+				FundName: f.Name,
+				FundCode: f.Code,
+				Outcome:  FundOutcome{Current: f.Current, Target: f.Target, Diff: f.Diff, Advice: f.Advice, Weight: f.Weight},
+			})
+		}
+	}
+	return rows
+}
+
+// 按基金ID对齐两次结果并逐只比较，顺序为A中的顺序，其后是只在B中的基金
+func compareOutcomes(a, b []fundOutcomeRow) ([]FundComparison, int) {
+	indexB := make(map[int]int, len(b))
+	for i, row := range b {
+		indexB[row.FundID] = i
+	}
+
+	comparisons := make([]FundComparison, 0, len(a)+len(b))
+	matched := make(map[int]bool)
+	for _, rowA := range a {
+		outcomeA := rowA.Outcome
+		cmp := FundComparison{FundID: rowA.FundID, FundName: rowA.FundName, FundCode: rowA.FundCode, A: &outcomeA}
+		if i, ok := indexB[rowA.FundID]; ok {
+			outcomeB := b[i].Outcome
+			cmp.B = &outcomeB
+			matched[rowA.FundID] = true
+		}
+		comparisons = append(comparisons, cmp)
+	}
+	for _, rowB := range b {
+		if matched[rowB.FundID] {
+			continue
+		}
+		outcomeB := rowB.Outcome
+		comparisons = append(comparisons, FundComparison{FundID: rowB.FundID, FundName: rowB.FundName, FundCode: rowB.FundCode, B: &outcomeB})
+	}
+
+	changes := 0
+	for i := range comparisons {
+		cmp := &comparisons[i]
+		var before, after FundOutcome
+		if cmp.A != nil {
+			before = *cmp.A
+		}
+		if cmp.B != nil {
+			after = *cmp.B
+		}
+		cmp.ValueChange = roundAmount(after.Current - before.Current)
+		cmp.TargetChange = roundAmount(after.Target - before.Target)
+		cmp.DiffChange = roundAmount(after.Diff - before.Diff)
+		cmp.AdviceChanged = cmp.A == nil || cmp.B == nil || before.Advice != after.Advice
+		if cmp.AdviceChanged {
+			changes++
+		}
+	}
+	return comparisons, changes
+}
+
+// 比较两个配置快照中各桶的目标占比
+func compareSnapshotBuckets(a, b *RebalanceSnapshot) []BucketComparison {
+	comparisons := []BucketComparison{}
+	if a == nil || b == nil {
+		return comparisons
+	}
+
+	index := make(map[int]int)
+	for _, bucket := range a.Buckets {
+		rate := bucket.TargetRate
+		index[bucket.ID] = len(comparisons)
+		comparisons = append(comparisons, BucketComparison{BucketID: bucket.ID, Name: bucket.Name, TargetRateA: &rate})
+	}
+	for _, bucket := range b.Buckets {
+		rate := bucket.TargetRate
+		if i, ok := index[bucket.ID]; ok {
+			comparisons[i].TargetRateB = &rate
+			comparisons[i].Name = bucket.Name
+			continue
+		}
+		comparisons = append(comparisons, BucketComparison{BucketID: bucket.ID, Name: bucket.Name, TargetRateB: &rate})
+	}
+	return comparisons
+}
+
+// 去掉浮点误差，金额保留到0.01元
+func roundAmount(value float64) float64 {
+	return math.Round(value*1e6) / 1e6
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+)
+
+// 在临时 SQLite 数据库上运行依赖全局存储的函数，测试结束后恢复
+func useTestStore(t *testing.T) Repository {
+	t.Helper()
+	repo, err := openRepository(DriverSQLite, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Migrate(true); err != nil {
+		repo.Close()
+		t.Fatal(err)
+	}
+	previous := store
+	store = repo
+	t.Cleanup(func() {
+		store = previous
+		repo.Close()
+	})
+	return repo
+}
+
+// 按当前配置再平衡并保存记录
+func recordTestRebalance(t *testing.T) (StrategyResult, int) {
+	t.Helper()
+	dbBuckets, err := getAllBucketsFromDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := RebalanceOptions{Threshold: 0.05, Mode: RebalanceModeTarget}
+	if err := normalizeRebalanceOptions(&opts); err != nil {
+		t.Fatal(err)
+	}
+	result, recordID, err := rebalanceAndRecord(convertDBBucketsToAPIBuckets(dbBuckets), opts, nil, RebalanceTriggerManual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result, recordID
+}
+
+// 重新计算按记录的配置快照进行，记录之后修改配置不影响结果，结果与记录的建议一致
+func TestReplayRebalanceRecord(t *testing.T) {
+	repo := useTestStore(t)
+	bond, err := addConformanceBucket(repo, "债券", 0.4, SeedFund{Name: "债券基金", Code: "000001", Current: 30, Weight: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stock, err := addConformanceBucket(repo, "股票", 0.6,
+		SeedFund{Name: "股票基金甲", Code: "000002", Current: 50, Weight: 0.5},
+		SeedFund{Name: "股票基金乙", Code: "000003", Current: 20, Weight: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, recordID := recordTestRebalance(t)
+
+	// 记录之后修改市值和目标占比
+	if err := repo.UpdateFund(stock.Funds[0].ID, "current", "80"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.UpdateBucket(bond.ID, "target_rate", "0.3"); err != nil {
+		t.Fatal(err)
+	}
+
+	replay, err := replayRebalanceRecord(recordID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replay.AdviceChanges != 0 || len(replay.Funds) != 3 {
+		t.Fatalf("重新计算应与记录一致，实际 %d 只基金、%d 处操作建议变化", len(replay.Funds), replay.AdviceChanges)
+	}
+	want := map[string]struct{ current, target, diff float64 }{
+		"债券基金":  {30, 40, 10},
+		"股票基金甲": {50, 30, -20},
+		"股票基金乙": {20, 30, 10},
+	}
+	for _, f := range replay.Funds {
+		if f.A == nil || f.B == nil {
+			t.Errorf("%s 应同时出现在记录和重新计算的结果中", f.FundName)
+			continue
+		}
+		w := want[f.FundName]
+		if !approxEqual(f.A.Current, w.current) || !approxEqual(f.A.Target, w.target) || !approxEqual(f.A.Diff, w.diff) {
+			t.Errorf("%s 记录的建议 %+v，应为市值%.2f、目标%.2f、调整%.2f", f.FundName, *f.A, w.current, w.target, w.diff)
+		}
+		if f.ValueChange != 0 || f.TargetChange != 0 || f.DiffChange != 0 || f.A.Advice != f.B.Advice {
+			t.Errorf("%s 重新计算 %+v 与记录 %+v 不一致", f.FundName, *f.B, *f.A)
+		}
+	}
+
+	if _, err := replayRebalanceRecord(recordID + 1); err == nil {
+		t.Error("记录不存在时应报错")
+	}
+}
+
+// 对比两条记录：按基金ID对齐，给出市值和建议的变化以及各桶目标占比的变化
+func TestCompareRebalanceRecords(t *testing.T) {
+	repo := useTestStore(t)
+	bond, err := addConformanceBucket(repo, "债券", 0.4, SeedFund{Name: "债券基金", Code: "000001", Current: 30, Weight: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stock, err := addConformanceBucket(repo, "股票", 0.6,
+		SeedFund{Name: "股票基金甲", Code: "000002", Current: 50, Weight: 0.5},
+		SeedFund{Name: "股票基金乙", Code: "000003", Current: 20, Weight: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, recordA := recordTestRebalance(t)
+
+	// 第二次：债券已补足，股票桶未超出阈值不再调整；股票基金乙归档，新增股票基金丙
+	if err := repo.UpdateFund(bond.Funds[0].ID, "current", "40"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.ArchiveFund(stock.Funds[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.AddFund(stock.ID, "股票基金丙", "000004", 10, 0.5); err != nil {
+		t.Fatal(err)
+	}
+	_, recordB := recordTestRebalance(t)
+
+	cmp, err := compareRebalanceRecords(recordA, recordB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !approxEqual(cmp.TotalValueChange, 0) {
+		t.Errorf("总市值变化%.4f万，应为0", cmp.TotalValueChange)
+	}
+
+	want := map[string]struct {
+		inA, inB                bool
+		valueChange, diffChange float64
+	}{
+		"债券基金":  {true, true, 10, -10},
+		"股票基金甲": {true, true, 0, 20},
+		"股票基金乙": {true, false, -20, -10}, // 已归档，只出现在A中
+		"股票基金丙": {false, true, 10, 0},    // 新增，只出现在B中
+	}
+	if len(cmp.Funds) != len(want) || cmp.AdviceChanges != 4 {
+		t.Fatalf("对比结果 %d 只基金、%d 处操作建议变化，应为4只、4处", len(cmp.Funds), cmp.AdviceChanges)
+	}
+	for _, f := range cmp.Funds {
+		w, ok := want[f.FundName]
+		if !ok {
+			t.Errorf("多出基金 %s", f.FundName)
+			continue
+		}
+		if (f.A != nil) != w.inA || (f.B != nil) != w.inB {
+			t.Errorf("%s 出现在A中: %v、B中: %v，应为 %v、%v", f.FundName, f.A != nil, f.B != nil, w.inA, w.inB)
+		}
+		if !approxEqual(f.ValueChange, w.valueChange) || !approxEqual(f.DiffChange, w.diffChange) || !f.AdviceChanged {
+			t.Errorf("%s 市值变化%.4f、调整金额变化%.4f、建议变化%v，应为%.4f、%.4f、true",
+				f.FundName, f.ValueChange, f.DiffChange, f.AdviceChanged, w.valueChange, w.diffChange)
+		}
+	}
+
+	if len(cmp.Buckets) != 2 {
+		t.Fatalf("应比较2个桶的目标占比，实际 %d 个", len(cmp.Buckets))
+	}
+	for _, b := range cmp.Buckets {
+		if b.TargetRateA == nil || b.TargetRateB == nil || *b.TargetRateA != *b.TargetRateB {
+			t.Errorf("桶 %s 的目标占比对比有误: %+v", b.Name, b)
+		}
+	}
+}
+
+func TestCompareOutcomes(t *testing.T) {
+	a := []fundOutcomeRow{
+		{FundID: 1, FundName: "甲", Outcome: FundOutcome{Current: 10, Target: 12, Diff: 2, Advice: "买入"}},
+		{FundID: 2, FundName: "乙", Outcome: FundOutcome{Current: 10, Target: 8, Diff: -2, Advice: "卖出"}},
+	}
+	b := []fundOutcomeRow{
+		{FundID: 3, FundName: "丙", Outcome: FundOutcome{Current: 5, Target: 6, Diff: 1, Advice: "买入"}},
+		{FundID: 1, FundName: "甲", Outcome: FundOutcome{Current: 11, Target: 14, Diff: 3, Advice: "买入"}},
+	}
+
+	tests := []struct {
+		name                                  string
+		inA, inB                              bool
+		valueChange, targetChange, diffChange float64
+		adviceChanged                         bool
+	}{
+		{"甲", true, true, 1, 2, 1, false},
+		{"乙", true, false, -10, -8, 2, true},
+		{"丙", false, true, 5, 6, 1, true},
+	}
+
+	comparisons, changes := compareOutcomes(a, b)
+	if len(comparisons) != len(tests) || changes != 2 {
+		t.Fatalf("对比结果 %d 只基金、%d 处建议变化，应为3只、2处", len(comparisons), changes)
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmp := comparisons[i]
+			if cmp.FundName != tt.name || (cmp.A != nil) != tt.inA || (cmp.B != nil) != tt.inB {
+				t.Fatalf("第%d项为 %s(A: %v、B: %v)，应为 %s(A: %v、B: %v)", i+1, cmp.FundName, cmp.A != nil, cmp.B != nil, tt.name, tt.inA, tt.inB)
+			}
+			if cmp.ValueChange != tt.valueChange || cmp.TargetChange != tt.targetChange || cmp.DiffChange != tt.diffChange || cmp.AdviceChanged != tt.adviceChanged {
+				t.Errorf("变化为市值%v、目标%v、调整%v、建议%v，应为%v、%v、%v、%v", cmp.ValueChange, cmp.TargetChange, cmp.DiffChange, cmp.AdviceChanged,
+					tt.valueChange, tt.targetChange, tt.diffChange, tt.adviceChanged)
+			}
+		})
+	}
+}
+
+func TestCompareSnapshotBuckets(t *testing.T) {
+	a := &RebalanceSnapshot{Buckets: []SnapshotBucket{{ID: 1, Name: "债券", TargetRate: 0.4}, {ID: 2, Name: "股票", TargetRate: 0.6}}}
+	b := &RebalanceSnapshot{Buckets: []SnapshotBucket{{ID: 2, Name: "权益", TargetRate: 0.5}, {ID: 3, Name: "黄金", TargetRate: 0.5}}}
+
+	got := compareSnapshotBuckets(a, b)
+	if len(got) != 3 {
+		t.Fatalf("应比较3个桶，实际 %d 个", len(got))
+	}
+	if got[0].BucketID != 1 || got[0].TargetRateA == nil || *got[0].TargetRateA != 0.4 || got[0].TargetRateB != nil {
+		t.Errorf("只在A中的桶对比有误: %+v", got[0])
+	}
+	if got[1].BucketID != 2 || got[1].Name != "权益" || *got[1].TargetRateA != 0.6 || got[1].TargetRateB == nil || *got[1].TargetRateB != 0.5 {
+		t.Errorf("两次都有的桶应取B中的名称并给出两次的占比: %+v", got[1])
+	}
+	if got[2].BucketID != 3 || got[2].TargetRateA != nil || got[2].TargetRateB == nil || *got[2].TargetRateB != 0.5 {
+		t.Errorf("只在B中的桶对比有误: %+v", got[2])
+	}
+
+	if got := compareSnapshotBuckets(a, nil); len(got) != 0 {
+		t.Errorf("没有配置快照时不比较桶，实际 %d 个", len(got))
+	}
+}
+
+// 快照还原的输入与记录前的配置一致，还原的费率档位不与快照共用
+func TestRebalanceSnapshotRestore(t *testing.T) {
+	buckets := []Bucket{
+		{ID: 1, Name: "权益", TargetRate: 1},
+		{ID: 2, Name: "A股", ParentID: 1, TargetRate: 0.5, Funds: []Fund{{ID: 1, Name: "沪深300", Code: "000001", Current: 10, Weight: 1, MinTrade: 0.1,
+			Fees: FeeSchedule{PurchaseRate: 0.015, RedemptionTiers: []RedemptionTier{{7, 0.015}, {0, 0}}, HeldSince: "2024-01-02"}}}},
+		{ID: 3, Name: "美股", ParentID: 1, TargetRate: 0.5, Funds: []Fund{{ID: 2, Name: "标普500", Code: "000002", Current: 12, Weight: 1}}},
+	}
+	snapshot := newRebalanceSnapshot(buckets, RebalanceOptions{Threshold: 0.05, Mode: RebalanceModeTarget})
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := parseRebalanceSnapshot(string(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := parsed.restoreBuckets()
+	if len(restored) != len(buckets) {
+		t.Fatalf("还原 %d 个桶，应为 %d 个", len(restored), len(buckets))
+	}
+	for i, b := range restored {
+		if b.ID != buckets[i].ID || b.ParentID != buckets[i].ParentID || b.TargetRate != buckets[i].TargetRate || len(b.Funds) != len(buckets[i].Funds) {
+			t.Errorf("还原的桶 %+v 与原配置 %+v 不一致", b, buckets[i])
+			continue
+		}
+		for j, f := range b.Funds {
+			orig := buckets[i].Funds[j]
+			if f.ID != orig.ID || f.Current != orig.Current || f.Weight != orig.Weight || f.MinTrade != orig.MinTrade ||
+				f.Fees.PurchaseRate != orig.Fees.PurchaseRate || f.Fees.HeldSince != orig.Fees.HeldSince || len(f.Fees.RedemptionTiers) != len(orig.Fees.RedemptionTiers) {
+				t.Errorf("还原的基金 %+v 与原配置 %+v 不一致", f, orig)
+			}
+		}
+	}
+	if restored[1].Level != 1 || !approxEqual(restored[1].EffectiveRate, 0.5) {
+		t.Errorf("还原后应重新计算层级和总目标占比，实际层级%d、占比%.4f", restored[1].Level, restored[1].EffectiveRate)
+	}
+
+	restored[1].Funds[0].Fees.RedemptionTiers[0].Rate = 0.5
+	if parsed.Buckets[1].Funds[0].Fees.RedemptionTiers[0].Rate != 0.015 {
+		t.Error("修改还原的费率档位不应影响快照")
+	}
+
+	if s, err := parseRebalanceSnapshot(""); s != nil || err != nil {
+		t.Errorf("没有快照时应返回 nil，实际 %v、%v", s, err)
+	}
+	if _, err := parseRebalanceSnapshot("{"); err == nil {
+		t.Error("快照格式无效时应报错")
+	}
+}
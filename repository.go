@@ -0,0 +1,102 @@
+package main
+
+import "fmt"
+
+// 存储后端
+const (
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+)
+
+// 数据存储：桶、基金、交易流水、净值、再平衡记录和建议的读写都经过这里，
+// 持仓计算、再平衡等业务逻辑不依赖具体的数据库
+type Repository interface {
+	// 桶及其未归档的基金，基金市值为数据库中保存的值(不含交易流水计算)
+	GetBuckets() ([]DBBucket, error)
+	// 新增桶，parentID 为0时为顶层桶，返回桶ID
+	AddBucket(name string, targetRate float64, parentID int) (int, error)
+	UpdateBucket(bucketID int, field, value string) error
+	// 批量更新桶目标占比（bucketID -> target_rate）
+	UpdateBucketTargetRates(rates map[int]float64) error
+	// 删除桶，moveTo 不为空时先把基金和目标占比并入该桶
+	DeleteBucket(bucket DBBucket, moveTo *DBBucket) error
+
+	// 已归档的基金，最近归档的在前
+	GetArchivedFunds() ([]DBFund, error)
+	// 按ID获取基金，包括已归档的基金；不存在时返回 sql.ErrNoRows
+	GetFundByID(fundID int) (*DBFund, error)
+	AddFund(bucketID int, name, code string, current, weight float64) error
+	UpdateFund(fundID int, field, value string) error
+	ArchiveFund(fundID int) error
+	RestoreFund(fundID, bucketID int) error
+	// 未归档基金的代码(去重)
+	GetFundCodes() ([]string, error)
+	// 回写基金的再平衡结果(目标市值、调整金额、建议)
+	UpdateFundRebalanceResults(buckets []Bucket) error
+
+	// 交易流水按交易日期排序
+	GetTransactions() ([]Transaction, error)
+	GetTransactionsByFundID(fundID int) ([]Transaction, error)
+	GetTransactionByID(id int) (*Transaction, error)
+	AddTransaction(t Transaction) (int, error)
+	DeleteTransaction(id int) error
+
+	// 逐条新增或覆盖净值，净值相同的记录只刷新数据源抓取的来源和时间；结果中只填写计数
+	SaveFundNAVs(navs []FundNAV) (*NAVImportResult, error)
+	// 基金的历史净值，from/to 为空表示不限，按日期排序
+	GetFundNAVs(code, from, to string) ([]FundNAV, error)
+	// 每只基金的最新净值，按基金代码索引
+	GetLatestFundNAVs() (map[string]FundNAV, error)
+
+	// 逐条新增或覆盖指数估值；结果中只填写计数
+	SaveIndexValuations(valuations []IndexValuation) (*ValuationImportResult, error)
+	// 指数某个指标的估值，from/to 为空表示不限，按日期排序
+	GetIndexValuations(code, metric, from, to string) ([]IndexValuation, error)
+	// 不晚于 date 的最新估值，没有数据时返回 nil
+	GetLatestIndexValuation(code, metric, date string) (*IndexValuation, error)
+	// 估值规则，按桶ID和分位下限排序
+	GetValuationRules() ([]ValuationRule, error)
+	AddValuationRule(rule ValuationRule) (int, error)
+	DeleteValuationRule(id int) error
+
+	// 各桶的下滑路径，按桶ID排序，点按时间升序
+	GetGlidePaths() ([]GlidePath, error)
+	// 保存桶的下滑路径，替换该桶原有的路径
+	SetGlidePath(path GlidePath) error
+	DeleteGlidePath(bucketID int) error
+
+	// 保存再平衡记录及其建议，返回记录ID
+	SaveRebalanceRecord(snapshot RebalanceSnapshot, trigger string, totalValue, totalFee float64, suggestions []RebalanceSuggestion) (int, error)
+	GetRebalanceHistory(limit int) ([]RebalanceRecord, error)
+	// 记录不存在时返回 sql.ErrNoRows
+	GetRebalanceRecordByID(recordID int) (*RebalanceRecord, error)
+	// 记录保存的配置快照，旧记录没有快照时返回 nil
+	GetRebalanceSnapshot(recordID int) (*RebalanceSnapshot, error)
+	GetSuggestionsByRecordID(recordID int) ([]RebalanceSuggestion, error)
+	// 建议不存在时返回 sql.ErrNoRows
+	GetSuggestionByID(id int) (*RebalanceSuggestion, error)
+	// 在一个事务中登记建议的执行情况、写入成交产生的交易流水，并按交易流水更新基金市值；
+	// 建议已不是未处理状态时返回 errSuggestionNotPending
+	SaveExecution(s RebalanceSuggestion, req ExecutionRequest, planned []Transaction) error
+
+	// 数据库结构版本和每个迁移的执行情况
+	SchemaStatus() (*SchemaStatus, error)
+	// 执行尚未执行的迁移；backup 为 true 且后端支持时先备份已有数据
+	Migrate(backup bool) (*MigrationResult, error)
+	Close() error
+}
+
+// 当前使用的存储，启动时按配置打开
+var store Repository
+
+// 按存储后端打开数据库，dsn 对 SQLite 为数据库文件，对 PostgreSQL 为连接串
+func openRepository(driver, dsn string) (Repository, error) {
+	switch driver {
+	case DriverSQLite:
+		return newSQLRepository(sqliteDialect, dsn)
+	case DriverPostgres:
+		return newSQLRepository(postgresDialect, dsn)
+	default:
+		return nil, fmt.Errorf("不支持的存储后端: %s（可选 %s、%s）", driver, DriverSQLite, DriverPostgres)
+	}
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 定时再平衡的日历
+const (
+	ScheduleMonthly   = "monthly"   // 每月执行
+	ScheduleQuarterly = "quarterly" // 每季度首月(1、4、7、10月)执行
+)
+
+// 定时再平衡配置：按日历(每月、每季度、指定日期)定时执行，和/或定期检查偏离、超出阈值时执行，
+// 只在Web服务器中运行
+type ScheduleConfig struct {
+	Calendar      string        `yaml:"calendar"`       // monthly 或 quarterly，为空表示不按月/季度执行
+	Day           int           `yaml:"day"`            // 按月/季度执行时为每月第几天(1-28)
+	Dates         []string      `yaml:"dates"`          // 额外的执行日期(YYYY-MM-DD)
+	Time          string        `yaml:"time"`           // 日历执行的时刻(HH:MM，本地时间)
+	Drift         bool          `yaml:"drift"`          // 是否定期检查偏离，有桶超出阈值时执行
+	CheckInterval time.Duration `yaml:"check_interval"` // 偏离检查的间隔
+	Cooldown      time.Duration `yaml:"cooldown"`       // 距上次再平衡(任意触发方式)不足该时长时不因偏离执行
+	Threshold     float64       `yaml:"threshold"`      // 再平衡阈值，0 表示使用 default_threshold
+	Strategy      string        `yaml:"strategy"`       // 再平衡策略，为空使用默认策略
+}
+
+func defaultScheduleConfig() ScheduleConfig {
+	return ScheduleConfig{
+		Day:           1,
+		Time:          "10:00",
+		CheckInterval: time.Hour,
+		Cooldown:      24 * time.Hour,
+	}
+}
+
+// 是否配置了任何定时或偏离触发
+func (cfg ScheduleConfig) enabled() bool {
+	return cfg.Calendar != "" || len(cfg.Dates) > 0 || cfg.Drift
+}
+
+func (cfg ScheduleConfig) validate() error {
+	switch cfg.Calendar {
+	case "", ScheduleMonthly, ScheduleQuarterly:
+	default:
+		return fmt.Errorf("无效的定时再平衡日历: %s（可选 %s、%s）", cfg.Calendar, ScheduleMonthly, ScheduleQuarterly)
+	}
+	if cfg.Day < 1 || cfg.Day > 28 {
+		return fmt.Errorf("定时再平衡的日期必须在1-28之间: %d", cfg.Day)
+	}
+	for _, date := range cfg.Dates {
+		if _, err := parseDate(date); err != nil {
+			return fmt.Errorf("无效的定时再平衡日期: %s（格式: YYYY-MM-DD）", date)
+		}
+	}
+	if _, _, err := cfg.clock(); err != nil {
+		return err
+	}
+	if cfg.CheckInterval <= 0 {
+		return fmt.Errorf("偏离检查间隔必须大于0: %s", cfg.CheckInterval)
+	}
+	if cfg.Cooldown < 0 {
+		return fmt.Errorf("偏离触发的冷却时间不能为负数: %s", cfg.Cooldown)
+	}
+	if cfg.Threshold < 0 || cfg.Threshold >= 1 {
+		return fmt.Errorf("定时再平衡阈值必须在0-1之间: %v", cfg.Threshold)
+	}
+	if _, err := findStrategy(cfg.Strategy); err != nil {
+		return err
+	}
+	return nil
+}
+
+// 日历执行的时刻
+func (cfg ScheduleConfig) clock() (int, int, error) {
+	t, err := time.Parse("15:04", cfg.Time)
+	if err != nil {
+		return 0, 0, fmt.Errorf("无效的定时再平衡时刻: %s（格式: HH:MM）", cfg.Time)
+	}
+	return t.Hour(), t.Minute(), nil
+}
+
+// 日历的说明，如 每季度首月1日 10:00
+func (cfg ScheduleConfig) calendarLabel() string {
+	var parts []string
+	switch cfg.Calendar {
+	case ScheduleMonthly:
+		parts = append(parts, fmt.Sprintf("每月%d日", cfg.Day))
+	case ScheduleQuarterly:
+		parts = append(parts, fmt.Sprintf("每季度首月%d日", cfg.Day))
+	}
+	if len(cfg.Dates) > 0 {
+		parts = append(parts, strings.Join(cfg.Dates, "、"))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return strings.Join(parts, "及") + " " + cfg.Time
+}
+
+// after 之后(不含)的下一次日历执行时间，没有时返回零值
+func (cfg ScheduleConfig) nextCalendarRun(after time.Time) time.Time {
+	hour, minute, err := cfg.clock()
+	if err != nil {
+		return time.Time{}
+	}
+	loc := after.Location()
+	var next time.Time
+	earlier := func(t time.Time) {
+		if t.After(after) && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	if cfg.Calendar != "" {
+		// 从当月开始找，最多跨过一个季度
+		for i := 0; i <= 3; i++ {
+			month := time.Date(after.Year(), after.Month()+time.Month(i), 1, 0, 0, 0, 0, loc)
+			if cfg.Calendar == ScheduleQuarterly && (month.Month()-1)%3 != 0 {
+				continue
+			}
+			run := time.Date(month.Year(), month.Month(), cfg.Day, hour, minute, 0, 0, loc)
+			if run.After(after) {
+				earlier(run)
+				break
+			}
+		}
+	}
+	for _, date := range cfg.Dates {
+		d, err := parseDate(date)
+		if err != nil {
+			continue
+		}
+		earlier(time.Date(d.Year(), d.Month(), d.Day(), hour, minute, 0, 0, loc))
+	}
+	return next
+}
+
+// after 之后的最多 n 次日历执行时间
+func (cfg ScheduleConfig) upcomingRuns(after time.Time, n int) []time.Time {
+	var runs []time.Time
+	for len(runs) < n {
+		next := cfg.nextCalendarRun(after)
+		if next.IsZero() {
+			break
+		}
+		runs = append(runs, next)
+		after = next
+	}
+	return runs
+}
+
+// 再平衡选项：目标模式，阈值和策略取自定时配置
+func (cfg ScheduleConfig) rebalanceOptions() (RebalanceOptions, error) {
+	opts := RebalanceOptions{Strategy: cfg.Strategy, Threshold: cfg.Threshold, Mode: RebalanceModeTarget}
+	if opts.Threshold <= 0 {
+		opts.Threshold = appConfig.DefaultThreshold
+	}
+	if err := normalizeRebalanceOptions(&opts); err != nil {
+		return RebalanceOptions{}, err
+	}
+	return opts, nil
+}
+
+// 一次定时或偏离检查的结果
+type ScheduleRun struct {
+	Trigger  string    `json:"trigger"`             // scheduled 或 drift
+	Time     time.Time `json:"time"`                // 执行时间
+	Executed bool      `json:"executed"`            // 是否执行了再平衡，偏离未超出阈值或在冷却时间内时为 false
+	RecordID int       `json:"record_id,omitempty"` // 执行时保存的再平衡记录ID
+	Message  string    `json:"message"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// 定时再平衡的状态
+type ScheduleStatus struct {
+	Enabled        bool             `json:"enabled"`  // 是否配置了定时或偏离触发
+	Running        bool             `json:"running"`  // 调度器是否已在Web服务器中启动
+	Calendar       string           `json:"calendar"` // 日历说明，未配置日历时为空
+	Drift          bool             `json:"drift"`
+	CheckInterval  string           `json:"check_interval,omitempty"`
+	Cooldown       string           `json:"cooldown,omitempty"`
+	Threshold      float64          `json:"threshold"`
+	Strategy       string           `json:"strategy"`
+	NextRun        *time.Time       `json:"next_run,omitempty"`         // 下一次日历执行时间
+	NextDriftCheck *time.Time       `json:"next_drift_check,omitempty"` // 调度器运行时下一次检查偏离的时间
+	Upcoming       []time.Time      `json:"upcoming"`                   // 之后的若干次日历执行时间
+	LastRun        *ScheduleRun     `json:"last_run,omitempty"`         // 调度器本次启动后最近一次定时执行或偏离检查
+	LastRecord     *RebalanceRecord `json:"last_record,omitempty"`      // 最近一条再平衡记录(任意触发方式)
+}
+
+// 定时再平衡调度器，由 initScheduler 在Web服务器中启动
+type rebalanceScheduler struct {
+	cfg            ScheduleConfig
+	mu             sync.Mutex
+	nextRun        time.Time
+	nextDriftCheck time.Time
+	lastRun        *ScheduleRun
+}
+
+// Web服务器中运行的调度器，未配置定时再平衡时为 nil
+var scheduler *rebalanceScheduler
+
+// 按配置启动定时再平衡，未配置时不启动
+func initScheduler() {
+	cfg := appConfig.Schedule
+	if !cfg.enabled() {
+		return
+	}
+	if label := cfg.calendarLabel(); label != "" {
+		fmt.Printf("🗓️  定时再平衡: %s\n", label)
+	}
+	if cfg.Drift {
+		fmt.Printf("🎯 每 %s 检查一次偏离，超出阈值时自动再平衡\n", cfg.CheckInterval)
+	}
+	scheduler = &rebalanceScheduler{cfg: cfg}
+	scheduler.start(time.Now())
+}
+
+// 启动调度循环；错过的日历执行不补做，偏离检查在启动时先执行一次
+func (s *rebalanceScheduler) start(now time.Time) {
+	s.mu.Lock()
+	s.nextRun = s.cfg.nextCalendarRun(now)
+	if s.cfg.Drift {
+		s.nextDriftCheck = now
+	}
+	s.mu.Unlock()
+
+	go func() {
+		for {
+			s.mu.Lock()
+			wake := s.nextRun
+			if !s.nextDriftCheck.IsZero() && (wake.IsZero() || s.nextDriftCheck.Before(wake)) {
+				wake = s.nextDriftCheck
+			}
+			s.mu.Unlock()
+			if wake.IsZero() {
+				// 指定日期都已过去且未开启偏离检查
+				log.Printf("定时再平衡: 没有之后的执行时间，调度器退出")
+				return
+			}
+			time.Sleep(time.Until(wake))
+			s.tick(time.Now())
+		}
+	}()
+}
+
+// 执行到期的日历再平衡和偏离检查
+func (s *rebalanceScheduler) tick(now time.Time) {
+	s.mu.Lock()
+	calendarDue := !s.nextRun.IsZero() && !now.Before(s.nextRun)
+	driftDue := !s.nextDriftCheck.IsZero() && !now.Before(s.nextDriftCheck)
+	s.mu.Unlock()
+
+	if calendarDue {
+		s.record(runScheduledRebalance(s.cfg, RebalanceTriggerScheduled, now))
+	} else if driftDue {
+		// 同一时刻已按日历执行时不再检查偏离
+		s.record(runScheduledRebalance(s.cfg, RebalanceTriggerDrift, now))
+	}
+
+	s.mu.Lock()
+	if calendarDue {
+		s.nextRun = s.cfg.nextCalendarRun(now)
+	}
+	if driftDue {
+		s.nextDriftCheck = now.Add(s.cfg.CheckInterval)
+	}
+	s.mu.Unlock()
+}
+
+func (s *rebalanceScheduler) record(run ScheduleRun) {
+	switch {
+	case run.Error != "":
+		log.Printf("%s再平衡失败: %s", triggerLabel(run.Trigger), run.Error)
+	case run.Executed:
+		log.Printf("✅ %s再平衡已执行，记录ID: %d，%s", triggerLabel(run.Trigger), run.RecordID, run.Message)
+	default:
+		log.Printf("%s检查: %s", triggerLabel(run.Trigger), run.Message)
+	}
+	s.mu.Lock()
+	s.lastRun = &run
+	s.mu.Unlock()
+}
+
+// 按定时配置评估组合并执行再平衡：先按下滑路径和估值规则调整目标占比，
+// 偏离触发时只有桶超出阈值且不在冷却时间内才执行
+func runScheduledRebalance(cfg ScheduleConfig, trigger string, now time.Time) ScheduleRun {
+	run := ScheduleRun{Trigger: trigger, Time: now}
+	fail := func(err error) ScheduleRun {
+		run.Error = err.Error()
+		return run
+	}
+
+	opts, err := cfg.rebalanceOptions()
+	if err != nil {
+		return fail(err)
+	}
+	dbBuckets, err := getAllBucketsFromDB()
+	if err != nil {
+		return fail(fmt.Errorf("获取基金配置失败: %v", err))
+	}
+	buckets := convertDBBucketsToAPIBuckets(dbBuckets)
+	if len(buckets) == 0 {
+		run.Message = "组合为空，未执行"
+		return run
+	}
+	adjustments, err := applyDynamicTargets(buckets, now.Format(dateLayout))
+	if err != nil {
+		return fail(err)
+	}
+	if err := checkRebalanceInput(buckets, opts); err != nil {
+		return fail(err)
+	}
+
+	reason := "按日历 " + cfg.calendarLabel()
+	if trigger == RebalanceTriggerDrift {
+		var exceeded []string
+		for _, d := range bucketDrifts(buckets) {
+			if d.exceeds(opts.Threshold) {
+				exceeded = append(exceeded, fmt.Sprintf("%s 偏差%+.1f%%", d.Path, d.Deviation*100))
+			}
+		}
+		if len(exceeded) == 0 {
+			run.Message = fmt.Sprintf("各桶偏差均未超出阈值±%.1f%%", opts.Threshold*100)
+			return run
+		}
+		reason = fmt.Sprintf("%s，超出阈值±%.1f%%", strings.Join(exceeded, "、"), opts.Threshold*100)
+		if cfg.Cooldown > 0 {
+			records, err := store.GetRebalanceHistory(1)
+			if err != nil {
+				return fail(fmt.Errorf("获取再平衡历史失败: %v", err))
+			}
+			if len(records) > 0 && now.Sub(records[0].CreatedAt) < cfg.Cooldown {
+				run.Message = fmt.Sprintf("%s，但距上次再平衡(%s)不足 %s，暂不执行",
+					reason, records[0].CreatedAt.Format("2006-01-02 15:04:05"), cfg.Cooldown)
+				return run
+			}
+		}
+	}
+
+	result, recordID, err := rebalanceAndRecord(buckets, opts, adjustments, trigger)
+	if err != nil {
+		return fail(fmt.Errorf("保存再平衡记录失败: %v", err))
+	}
+	run.Executed = true
+	run.RecordID = recordID
+	run.Message = fmt.Sprintf("%s；%s", reason, strategyResultSummary(opts.Strategy, result))
+	return run
+}
+
+// 触发方式的说明
+func triggerLabel(trigger string) string {
+	switch trigger {
+	case RebalanceTriggerScheduled:
+		return "定时"
+	case RebalanceTriggerDrift:
+		return "偏离触发"
+	default:
+		return "手动"
+	}
+}
+
+// 查看定时再平衡状态时最多列出的日历执行次数
+const maxScheduleCount = 50
+
+// 定时再平衡的状态：配置、之后 count 次日历执行时间，调度器运行时包括下一次偏离检查和最近一次执行
+func getScheduleStatus(now time.Time, count int) (ScheduleStatus, error) {
+	cfg := appConfig.Schedule
+	opts, err := cfg.rebalanceOptions()
+	if err != nil {
+		return ScheduleStatus{}, err
+	}
+	status := ScheduleStatus{
+		Enabled:   cfg.enabled(),
+		Calendar:  cfg.calendarLabel(),
+		Drift:     cfg.Drift,
+		Threshold: opts.Threshold,
+		Strategy:  opts.Strategy,
+		Upcoming:  cfg.upcomingRuns(now, count),
+	}
+	if cfg.Drift {
+		status.CheckInterval = cfg.CheckInterval.String()
+		status.Cooldown = cfg.Cooldown.String()
+	}
+	if len(status.Upcoming) > 0 {
+		status.NextRun = &status.Upcoming[0]
+	}
+	if status.Upcoming == nil {
+		status.Upcoming = []time.Time{}
+	}
+
+	if scheduler != nil {
+		scheduler.mu.Lock()
+		status.Running = true
+		if !scheduler.nextDriftCheck.IsZero() {
+			next := scheduler.nextDriftCheck
+			status.NextDriftCheck = &next
+		}
+		if scheduler.lastRun != nil {
+			last := *scheduler.lastRun
+			status.LastRun = &last
+		}
+		scheduler.mu.Unlock()
+	}
+
+	records, err := store.GetRebalanceHistory(1)
+	if err != nil {
+		return ScheduleStatus{}, fmt.Errorf("获取再平衡历史失败: %v", err)
+	}
+	if len(records) > 0 {
+		status.LastRecord = &records[0]
+	}
+	return status, nil
+}
+
+// 解析逗号分隔的日期列表并按日期排序
+func parseScheduleDates(value string) []string {
+	var dates []string
+	for _, date := range strings.Split(value, ",") {
+		if date = strings.TrimSpace(date); date != "" {
+			dates = append(dates, date)
+		}
+	}
+	sort.Strings(dates)
+	return dates
+}
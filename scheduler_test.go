@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNextCalendarRun(t *testing.T) {
+	at := func(value string) time.Time {
+		d, _ := time.ParseInLocation("2006-01-02 15:04", value, time.Local)
+		return d
+	}
+	monthly := ScheduleConfig{Calendar: ScheduleMonthly, Day: 15, Time: "10:00"}
+	quarterly := ScheduleConfig{Calendar: ScheduleQuarterly, Day: 15, Time: "10:00"}
+	dates := ScheduleConfig{Day: 1, Dates: []string{"2024-06-30", "2024-03-31"}, Time: "14:30"}
+
+	tests := []struct {
+		name  string
+		cfg   ScheduleConfig
+		after string
+		want  string // 为空表示没有下一次执行
+	}{
+		{"每月：当月执行日之前", monthly, "2024-03-10 09:00", "2024-03-15 10:00"},
+		{"每月：当天执行时刻之前", monthly, "2024-03-15 09:59", "2024-03-15 10:00"},
+		{"每月：恰好在执行时刻时取下个月", monthly, "2024-03-15 10:00", "2024-04-15 10:00"},
+		{"每月：跨年", monthly, "2024-12-20 00:00", "2025-01-15 10:00"},
+		{"每季度：季度首月执行日之前", quarterly, "2024-01-10 00:00", "2024-01-15 10:00"},
+		{"每季度：季度中间的月份取下一季度首月", quarterly, "2024-02-01 00:00", "2024-04-15 10:00"},
+		{"每季度：首月执行之后取下一季度", quarterly, "2024-04-15 10:00", "2024-07-15 10:00"},
+		{"每季度：跨年", quarterly, "2024-11-20 00:00", "2025-01-15 10:00"},
+		{"指定日期：取之后最早的日期", dates, "2024-04-01 00:00", "2024-06-30 14:30"},
+		{"指定日期：日期不必有序", dates, "2024-01-01 00:00", "2024-03-31 14:30"},
+		{"指定日期：全部已过", dates, "2024-07-01 00:00", ""},
+		{"日历与指定日期取较早者", ScheduleConfig{Calendar: ScheduleMonthly, Day: 15, Dates: []string{"2024-03-12"}, Time: "10:00"}, "2024-03-10 00:00", "2024-03-12 10:00"},
+		{"未配置日历和日期", ScheduleConfig{Day: 1, Time: "10:00"}, "2024-03-10 00:00", ""},
+		{"执行时刻无效", ScheduleConfig{Calendar: ScheduleMonthly, Day: 1, Time: "25:00"}, "2024-03-10 00:00", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.cfg.nextCalendarRun(at(tt.after))
+			if tt.want == "" {
+				if !got.IsZero() {
+					t.Errorf("不应有下一次执行，实际为 %s", got)
+				}
+				return
+			}
+			if want := at(tt.want); !got.Equal(want) {
+				t.Errorf("下一次执行 %s，应为 %s", got, want)
+			}
+		})
+	}
+}
+
+func TestUpcomingRuns(t *testing.T) {
+	after := time.Date(2024, 2, 1, 0, 0, 0, 0, time.Local)
+	cfg := ScheduleConfig{Calendar: ScheduleQuarterly, Day: 1, Dates: []string{"2024-05-20"}, Time: "10:00"}
+	runs := cfg.upcomingRuns(after, 4)
+	want := []time.Time{
+		time.Date(2024, 4, 1, 10, 0, 0, 0, time.Local),
+		time.Date(2024, 5, 20, 10, 0, 0, 0, time.Local),
+		time.Date(2024, 7, 1, 10, 0, 0, 0, time.Local),
+		time.Date(2024, 10, 1, 10, 0, 0, 0, time.Local),
+	}
+	if len(runs) != len(want) {
+		t.Fatalf("执行时间 %v，应为 %v", runs, want)
+	}
+	for i := range runs {
+		if !runs[i].Equal(want[i]) {
+			t.Errorf("第%d次执行 %s，应为 %s", i+1, runs[i], want[i])
+		}
+	}
+
+	// 只有指定日期时列完为止
+	if runs := (ScheduleConfig{Day: 1, Dates: []string{"2024-05-20"}, Time: "10:00"}).upcomingRuns(after, maxScheduleCount); len(runs) != 1 {
+		t.Errorf("只有一个指定日期时应列出1次执行，实际 %d 次", len(runs))
+	}
+}
+
+// 查看定时再平衡状态时 count 超出范围返回400
+func TestGetScheduleHandlerCount(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/api/rebalance/schedule", getScheduleHandler)
+
+	for _, count := range []string{"0", "-1", "abc", "51"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/rebalance/schedule?count="+count, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("count=%s 返回 %d，应为400", count, w.Code)
+		}
+	}
+}
+
+// 冷却期按记录时间与当前时刻的间隔判断，与当前时间所在的时区无关
+func TestRunScheduledRebalanceCooldown(t *testing.T) {
+	repo := useTestStore(t)
+	if _, err := addConformanceBucket(repo, "债券", 0.4, SeedFund{Name: "债券基金", Code: "000001", Current: 30, Weight: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := addConformanceBucket(repo, "股票", 0.6, SeedFund{Name: "股票基金", Code: "000002", Current: 70, Weight: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := ScheduleConfig{Drift: true, Cooldown: time.Hour, Threshold: 0.05}
+	now := time.Now().In(time.FixedZone("UTC+8", 8*3600))
+	tests := []struct {
+		name         string
+		at           time.Time
+		wantExecuted bool
+	}{
+		{"没有历史记录时执行", now, true},
+		{"冷却期内不执行", now.Add(30 * time.Minute), false},
+		{"冷却期过后执行", now.Add(2 * time.Hour), true},
+	}
+
+	for _, tt := range tests {
+		run := runScheduledRebalance(cfg, RebalanceTriggerDrift, tt.at)
+		if run.Error != "" {
+			t.Fatalf("%s: %s", tt.name, run.Error)
+		}
+		if run.Executed != tt.wantExecuted {
+			t.Errorf("%s: 执行%v，应为%v（%s）", tt.name, run.Executed, tt.wantExecuted, run.Message)
+		}
+		if !tt.wantExecuted && !strings.Contains(run.Message, "暂不执行") {
+			t.Errorf("%s: 说明 %q 应提示在冷却期内", tt.name, run.Message)
+		}
+	}
+}
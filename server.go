@@ -1,9 +1,15 @@
 package main
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -11,7 +17,8 @@ import (
 
 // API 请求和响应结构体
 type AddFundRequest struct {
-	BucketIndex int     `json:"bucket_index"`
+	BucketID    int     `json:"bucket_id"`    // 优先使用桶ID
+	BucketIndex int     `json:"bucket_index"` // 兼容旧版按索引定位
 	Name        string  `json:"name"`
 	Code        string  `json:"code"`
 	Current     float64 `json:"current"`
@@ -30,8 +37,71 @@ type DeleteFundRequest struct {
 	FundIndex   int `json:"fund_index"`
 }
 
+// 恢复归档基金，BucketID 为0时恢复到原所在桶
+type RestoreFundRequest struct {
+	BucketID int `json:"bucket_id"`
+}
+
+// 按ID修改单个字段，用于 PUT /api/funds/:id 和 PUT /api/buckets/:id
+type UpdateFieldRequest struct {
+	Field string `json:"field"`
+	Value string `json:"value"`
+}
+
+type AddBucketRequest struct {
+	Name       string  `json:"name"`
+	TargetRate float64 `json:"target_rate"` // 子桶为在父桶中的占比
+	ParentID   int     `json:"parent_id"`   // 父桶ID，0为顶层桶
+}
+
+type UpdateBucketRequest struct {
+	BucketIndex int    `json:"bucket_index"`
+	Field       string `json:"field"`
+	Value       string `json:"value"`
+}
+
+type UpdateBucketTargetsRequest struct {
+	TargetRates []float64       `json:"target_rates"` // 按桶顺序排列，合计必须为1
+	Targets     map[int]float64 `json:"targets"`      // 按桶ID指定，需覆盖所有桶，优先于 target_rates
+}
+
+type DeleteBucketRequest struct {
+	BucketIndex int  `json:"bucket_index"`
+	MoveToIndex *int `json:"move_to_index"` // 桶内基金迁移的目标桶，为空时要求该桶无基金且目标占比为0
+	MoveToID    *int `json:"move_to_id"`    // 同上，按桶ID指定，优先于 move_to_index
+}
+
 type RebalanceRequest struct {
-	Threshold float64 `json:"threshold"`
+	Strategy  string    `json:"strategy"` // 再平衡策略，默认 bucket_threshold，可选项见 /api/strategies
+	Threshold float64   `json:"threshold"`
+	FundBand  DriftBand `json:"fund_band"`  // 基金层面偏离带，默认不启用
+	Mode      string    `json:"mode"`       // 再平衡模式：target(默认)、band_edge 或 cash_flow
+	InnerBand float64   `json:"inner_band"` // band_edge 模式下调回到偏离带的比例位置，默认1即边缘
+	Amount    float64   `json:"amount"`     // cash_flow 模式下投入(正数)或取出(负数)的金额(万元)
+	// 费用占交易金额的比例超过该值时跳过该笔交易，默认不跳过
+	MaxFeeRate float64             `json:"max_fee_rate"`
+	MinTrade   float64             `json:"min_trade"`  // 最低交易金额(万元)，低于该金额的交易被取消
+	RoundStep  float64             `json:"round_step"` // 交易金额取整步长(万元)
+	DryRun     bool                `json:"dry_run"`    // 只计算不保存，不回写基金也不生成历史记录
+	Overrides  *RebalanceOverrides `json:"overrides"`  // 假设性调整，仅在 dry_run 时允许
+	// 不按下滑路径和估值规则调整，直接使用配置的目标占比
+	StaticTargets bool `json:"static_targets"`
+}
+
+// 新增估值规则，max_percentile 为空时为1
+type AddValuationRuleRequest struct {
+	BucketID      int      `json:"bucket_id"`
+	IndexCode     string   `json:"index_code"`
+	Metric        string   `json:"metric"` // pe(默认) 或 pb
+	MinPercentile float64  `json:"min_percentile"`
+	MaxPercentile *float64 `json:"max_percentile"`
+	TargetRate    float64  `json:"target_rate"` // 子桶为在父桶中的占比
+}
+
+// 设置桶的下滑路径
+type SetGlidePathRequest struct {
+	Basis  string       `json:"basis"`  // date(默认) 或 age
+	Points []GlidePoint `json:"points"` // 按日期时填写 date，按年龄时填写 age
 }
 
 type Response struct {
@@ -47,6 +117,66 @@ func initData() {
 	}
 }
 
+// Web服务器使用的净值数据源，未配置时为 nil
+var navProvider NAVProvider
+
+// 按配置创建净值数据源，配置了抓取间隔时启动定时抓取
+func initNAVProvider() {
+	cfg := appConfig.NAV
+	var err error
+	navProvider, err = newNAVProvider(cfg)
+	if err != nil {
+		log.Fatalf("净值数据源配置错误: %v", err)
+	}
+	if navProvider == nil {
+		return
+	}
+
+	fmt.Printf("📡 净值数据源: %s\n", navProvider.Name())
+	if cfg.Interval > 0 {
+		fmt.Printf("⏰ 每 %s 自动获取一次最新净值\n", cfg.Interval)
+		startNAVFetchScheduler(navProvider, cfg.Interval)
+	}
+}
+
+// 解析路径中的ID参数，失败时直接返回400
+func parseIDParam(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: "无效的ID",
+		})
+		return 0, false
+	}
+	return id, true
+}
+
+// 读取所有桶，失败时直接返回500
+func loadDBBuckets(c *gin.Context) ([]DBBucket, bool) {
+	dbBuckets, err := getAllBucketsFromDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: "获取桶信息失败: " + err.Error(),
+		})
+		return nil, false
+	}
+	return dbBuckets, true
+}
+
+// 返回更新后的全部桶数据
+func respondWithBuckets(c *gin.Context, message string) {
+	dbBuckets, _ := getAllBucketsFromDB()
+	buckets := convertDBBucketsToAPIBuckets(dbBuckets)
+
+	c.JSON(http.StatusOK, Response{
+		Success: true,
+		Message: message,
+		Data:    buckets,
+	})
+}
+
 // API 处理器
 func getBuckets(c *gin.Context) {
 	dbBuckets, err := getAllBucketsFromDB()
@@ -67,6 +197,58 @@ func getBuckets(c *gin.Context) {
 	})
 }
 
+func getBucketByIDHandler(c *gin.Context) {
+	bucketID, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	dbBuckets, ok := loadDBBuckets(c)
+	if !ok {
+		return
+	}
+
+	bi, found := findDBBucketByID(dbBuckets, bucketID)
+	if !found {
+		c.JSON(http.StatusNotFound, Response{
+			Success: false,
+			Message: "桶不存在",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Success: true,
+		Data:    convertDBBucketsToAPIBuckets(dbBuckets[bi : bi+1])[0],
+	})
+}
+
+func getFundByIDHandler(c *gin.Context) {
+	fundID, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	dbBuckets, ok := loadDBBuckets(c)
+	if !ok {
+		return
+	}
+
+	bi, fi, found := findDBFundByID(dbBuckets, fundID)
+	if !found {
+		c.JSON(http.StatusNotFound, Response{
+			Success: false,
+			Message: "基金不存在",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Success: true,
+		Data:    convertDBBucketsToAPIBuckets(dbBuckets[bi : bi+1])[0].Funds[fi],
+	})
+}
+
 func addFund(c *gin.Context) {
 	var req AddFundRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -76,65 +258,1194 @@ func addFund(c *gin.Context) {
 		})
 		return
 	}
-
-	// 获取所有桶以验证索引
-	dbBuckets, err := getAllBucketsFromDB()
-	if err != nil {
+
+	// 获取所有桶以验证桶ID或索引
+	dbBuckets, ok := loadDBBuckets(c)
+	if !ok {
+		return
+	}
+
+	bucketIndex := req.BucketIndex
+	if req.BucketID > 0 {
+		bi, found := findDBBucketByID(dbBuckets, req.BucketID)
+		if !found {
+			c.JSON(http.StatusBadRequest, Response{
+				Success: false,
+				Message: "桶不存在",
+			})
+			return
+		}
+		bucketIndex = bi
+	}
+
+	if bucketIndex < 0 || bucketIndex >= len(dbBuckets) {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: "无效的桶索引",
+		})
+		return
+	}
+
+	bucket := dbBuckets[bucketIndex]
+
+	// 基金只能放在没有子桶的桶中，并验证权重
+	err := checkLeafBucket(dbBuckets, bucket)
+	if err == nil {
+		err = checkFundWeight(bucket, 0, req.Weight)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// 添加到数据库
+	err = store.AddFund(bucket.ID, req.Name, req.Code, req.Current, req.Weight)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: "添加基金失败: " + err.Error(),
+		})
+		return
+	}
+
+	respondWithBuckets(c, "基金添加成功")
+}
+
+func deleteFund(c *gin.Context) {
+	var req DeleteFundRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: "无效的请求参数",
+		})
+		return
+	}
+
+	// 获取所有桶以验证索引
+	dbBuckets, ok := loadDBBuckets(c)
+	if !ok {
+		return
+	}
+
+	if req.BucketIndex < 0 || req.BucketIndex >= len(dbBuckets) {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: "无效的桶索引",
+		})
+		return
+	}
+
+	bucket := dbBuckets[req.BucketIndex]
+
+	if req.FundIndex < 0 || req.FundIndex >= len(bucket.Funds) {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: "无效的基金索引",
+		})
+		return
+	}
+
+	applyFundDelete(c, bucket.Funds[req.FundIndex])
+}
+
+func deleteFundByID(c *gin.Context) {
+	fundID, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	dbBuckets, ok := loadDBBuckets(c)
+	if !ok {
+		return
+	}
+
+	bi, fi, found := findDBFundByID(dbBuckets, fundID)
+	if !found {
+		c.JSON(http.StatusNotFound, Response{
+			Success: false,
+			Message: "基金不存在",
+		})
+		return
+	}
+
+	applyFundDelete(c, dbBuckets[bi].Funds[fi])
+}
+
+func applyFundDelete(c *gin.Context, fund DBFund) {
+	// 归档而不是物理删除，交易流水和历史建议保留
+	err := store.ArchiveFund(fund.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: "删除基金失败: " + err.Error(),
+		})
+		return
+	}
+
+	respondWithBuckets(c, "已归档基金: "+fund.Name+"，历史记录保留，可随时恢复")
+}
+
+// 获取已归档的基金
+func getArchivedFundsHandler(c *gin.Context) {
+	funds, err := getArchivedFunds()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: "获取归档基金失败: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Success: true,
+		Data:    funds,
+	})
+}
+
+// 恢复归档基金，请求体可选，用于指定恢复到的桶
+func restoreFundHandler(c *gin.Context) {
+	fundID, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req RestoreFundRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, Response{
+				Success: false,
+				Message: "无效的请求参数",
+			})
+			return
+		}
+	}
+
+	fund, err := store.GetFundByID(fundID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, Response{
+			Success: false,
+			Message: "基金不存在",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: "获取基金失败: " + err.Error(),
+		})
+		return
+	}
+
+	dbBuckets, ok := loadDBBuckets(c)
+	if !ok {
+		return
+	}
+
+	bucket, err := checkFundRestore(dbBuckets, *fund, req.BucketID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if err := store.RestoreFund(fund.ID, bucket.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: "恢复基金失败: " + err.Error(),
+		})
+		return
+	}
+
+	respondWithBuckets(c, "已恢复基金: "+fund.Name+" 到 "+bucket.Name)
+}
+
+func updateFund(c *gin.Context) {
+	var req UpdateFundRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: "无效的请求参数",
+		})
+		return
+	}
+
+	// 获取所有桶以验证索引
+	dbBuckets, ok := loadDBBuckets(c)
+	if !ok {
+		return
+	}
+
+	if req.BucketIndex < 0 || req.BucketIndex >= len(dbBuckets) {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: "无效的桶索引",
+		})
+		return
+	}
+
+	bucket := dbBuckets[req.BucketIndex]
+
+	if req.FundIndex < 0 || req.FundIndex >= len(bucket.Funds) {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: "无效的基金索引",
+		})
+		return
+	}
+
+	applyFundUpdate(c, bucket, bucket.Funds[req.FundIndex], req.Field, req.Value)
+}
+
+func updateFundByID(c *gin.Context) {
+	fundID, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateFieldRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: "无效的请求参数",
+		})
+		return
+	}
+
+	dbBuckets, ok := loadDBBuckets(c)
+	if !ok {
+		return
+	}
+
+	bi, fi, found := findDBFundByID(dbBuckets, fundID)
+	if !found {
+		c.JSON(http.StatusNotFound, Response{
+			Success: false,
+			Message: "基金不存在",
+		})
+		return
+	}
+
+	applyFundUpdate(c, dbBuckets[bi], dbBuckets[bi].Funds[fi], req.Field, req.Value)
+}
+
+func applyFundUpdate(c *gin.Context, bucket DBBucket, fund DBFund, field, value string) {
+	// 验证字段
+	if err := checkFundField(bucket, fund, field, value); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	// 更新数据库
+	err := store.UpdateFund(fund.ID, field, value)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: "更新基金失败: " + err.Error(),
+		})
+		return
+	}
+
+	respondWithBuckets(c, "基金信息更新成功")
+}
+
+func addBucket(c *gin.Context) {
+	var req AddBucketRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: "无效的请求参数",
+		})
+		return
+	}
+
+	dbBuckets, ok := loadDBBuckets(c)
+	if !ok {
+		return
+	}
+
+	// 验证名称和目标占比
+	req.Name = strings.TrimSpace(req.Name)
+	err := checkBucketName(dbBuckets, 0, req.Name)
+	if err == nil {
+		err = checkBucketParent(dbBuckets, 0, req.ParentID)
+	}
+	if err == nil {
+		err = checkBucketRate(dbBuckets, 0, req.ParentID, req.TargetRate)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	_, err = store.AddBucket(req.Name, req.TargetRate, req.ParentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: "添加桶失败: " + err.Error(),
+		})
+		return
+	}
+
+	respondWithBuckets(c, "桶添加成功")
+}
+
+func updateBucket(c *gin.Context) {
+	var req UpdateBucketRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: "无效的请求参数",
+		})
+		return
+	}
+
+	dbBuckets, ok := loadDBBuckets(c)
+	if !ok {
+		return
+	}
+
+	if req.BucketIndex < 0 || req.BucketIndex >= len(dbBuckets) {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: "无效的桶索引",
+		})
+		return
+	}
+
+	applyBucketUpdate(c, dbBuckets, dbBuckets[req.BucketIndex], req.Field, req.Value)
+}
+
+func updateBucketByID(c *gin.Context) {
+	bucketID, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateFieldRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: "无效的请求参数",
+		})
+		return
+	}
+
+	dbBuckets, ok := loadDBBuckets(c)
+	if !ok {
+		return
+	}
+
+	bi, found := findDBBucketByID(dbBuckets, bucketID)
+	if !found {
+		c.JSON(http.StatusNotFound, Response{
+			Success: false,
+			Message: "桶不存在",
+		})
+		return
+	}
+
+	applyBucketUpdate(c, dbBuckets, dbBuckets[bi], req.Field, req.Value)
+}
+
+func applyBucketUpdate(c *gin.Context, dbBuckets []DBBucket, bucket DBBucket, field, value string) {
+	// 验证字段
+	value, err := checkBucketField(dbBuckets, bucket, field, value)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	err = store.UpdateBucket(bucket.ID, field, value)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: "更新桶失败: " + err.Error(),
+		})
+		return
+	}
+
+	respondWithBuckets(c, "桶信息更新成功")
+}
+
+// 一次性调整所有桶的目标占比
+func updateBucketTargets(c *gin.Context) {
+	var req UpdateBucketTargetsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: "无效的请求参数",
+		})
+		return
+	}
+
+	dbBuckets, ok := loadDBBuckets(c)
+	if !ok {
+		return
+	}
+
+	rates := make(map[int]float64, len(dbBuckets))
+	if req.Targets != nil {
+		for _, b := range dbBuckets {
+			rate, exists := req.Targets[b.ID]
+			if !exists {
+				c.JSON(http.StatusBadRequest, Response{
+					Success: false,
+					Message: "缺少桶的目标占比: " + b.Name,
+				})
+				return
+			}
+			rates[b.ID] = rate
+		}
+		if len(req.Targets) != len(dbBuckets) {
+			c.JSON(http.StatusBadRequest, Response{
+				Success: false,
+				Message: "包含不存在的桶ID",
+			})
+			return
+		}
+	} else {
+		if len(req.TargetRates) != len(dbBuckets) {
+			c.JSON(http.StatusBadRequest, Response{
+				Success: false,
+				Message: "目标占比数量(" + strconv.Itoa(len(req.TargetRates)) + ")与桶数量(" + strconv.Itoa(len(dbBuckets)) + ")不一致",
+			})
+			return
+		}
+		for i, b := range dbBuckets {
+			rates[b.ID] = req.TargetRates[i]
+		}
+	}
+
+	if err := validateNewTargetRates(dbBuckets, rates); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if err := store.UpdateBucketTargetRates(rates); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: "更新目标占比失败: " + err.Error(),
+		})
+		return
+	}
+
+	respondWithBuckets(c, "目标占比更新成功")
+}
+
+func deleteBucket(c *gin.Context) {
+	var req DeleteBucketRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: "无效的请求参数",
+		})
+		return
+	}
+
+	dbBuckets, ok := loadDBBuckets(c)
+	if !ok {
+		return
+	}
+
+	if req.BucketIndex < 0 || req.BucketIndex >= len(dbBuckets) {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: "无效的桶索引",
+		})
+		return
+	}
+
+	applyBucketDelete(c, dbBuckets, req.BucketIndex, req)
+}
+
+func deleteBucketByID(c *gin.Context) {
+	bucketID, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	// 请求体可选，仅用于指定迁移目标桶
+	var req DeleteBucketRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, Response{
+				Success: false,
+				Message: "无效的请求参数",
+			})
+			return
+		}
+	}
+	if moveTo := c.Query("move_to"); moveTo != "" {
+		moveToID, err := strconv.Atoi(moveTo)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, Response{
+				Success: false,
+				Message: "无效的迁移目标桶ID",
+			})
+			return
+		}
+		req.MoveToID = &moveToID
+	}
+
+	dbBuckets, ok := loadDBBuckets(c)
+	if !ok {
+		return
+	}
+
+	bi, found := findDBBucketByID(dbBuckets, bucketID)
+	if !found {
+		c.JSON(http.StatusNotFound, Response{
+			Success: false,
+			Message: "桶不存在",
+		})
+		return
+	}
+
+	applyBucketDelete(c, dbBuckets, bi, req)
+}
+
+func applyBucketDelete(c *gin.Context, dbBuckets []DBBucket, bucketIndex int, req DeleteBucketRequest) {
+	bucket := dbBuckets[bucketIndex]
+
+	moveToIndex := -1
+	if req.MoveToID != nil {
+		bi, found := findDBBucketByID(dbBuckets, *req.MoveToID)
+		if !found {
+			c.JSON(http.StatusBadRequest, Response{
+				Success: false,
+				Message: "迁移目标桶不存在",
+			})
+			return
+		}
+		moveToIndex = bi
+	} else if req.MoveToIndex != nil {
+		moveToIndex = *req.MoveToIndex
+		if moveToIndex < 0 {
+			c.JSON(http.StatusBadRequest, Response{
+				Success: false,
+				Message: "无效的迁移目标桶索引",
+			})
+			return
+		}
+	}
+
+	moveTo, err := checkBucketDelete(dbBuckets, bucketIndex, moveToIndex)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	err = store.DeleteBucket(bucket, moveTo)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: "删除桶失败: " + err.Error(),
+		})
+		return
+	}
+
+	message := "已删除桶: " + bucket.Name
+	if moveTo != nil {
+		message += "，基金及目标占比已并入: " + moveTo.Name
+	}
+
+	respondWithBuckets(c, message)
+}
+
+// 获取基金的交易流水及持仓
+func getFundTransactionsHandler(c *gin.Context) {
+	fundID, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+	if !checkFundExists(c, fundID) {
+		return
+	}
+
+	respondWithLedger(c, fundID, "")
+}
+
+// 为基金添加交易流水
+func addFundTransactionHandler(c *gin.Context) {
+	fundID, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	var t Transaction
+	if err := c.ShouldBindJSON(&t); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: "无效的请求参数",
+		})
+		return
+	}
+	t.FundID = fundID
+
+	if !checkFundExists(c, fundID) {
+		return
+	}
+
+	if err := checkTransaction(&t); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	txs, err := store.GetTransactionsByFundID(fundID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: "获取交易流水失败: " + err.Error(),
+		})
+		return
+	}
+	if err := checkLedger(append(txs, t)); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	if _, err := store.AddTransaction(t); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: "添加交易流水失败: " + err.Error(),
+		})
+		return
+	}
+
+	respondWithLedger(c, fundID, "交易流水添加成功")
+}
+
+// 删除交易流水
+func deleteTransactionHandler(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	t, err := store.GetTransactionByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, Response{
+			Success: false,
+			Message: "交易流水不存在",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: "获取交易流水失败: " + err.Error(),
+		})
+		return
+	}
+
+	txs, err := store.GetTransactionsByFundID(t.FundID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: "获取交易流水失败: " + err.Error(),
+		})
+		return
+	}
+	remaining := make([]Transaction, 0, len(txs))
+	for _, other := range txs {
+		if other.ID != id {
+			remaining = append(remaining, other)
+		}
+	}
+	if err := checkLedger(remaining); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: "删除后" + err.Error(),
+		})
+		return
+	}
+
+	if err := store.DeleteTransaction(id); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: "删除交易流水失败: " + err.Error(),
+		})
+		return
+	}
+
+	respondWithLedger(c, t.FundID, "交易流水删除成功")
+}
+
+// 检查基金是否存在(包括已归档的基金)，不存在时返回404
+func checkFundExists(c *gin.Context, fundID int) bool {
+	_, err := store.GetFundByID(fundID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, Response{
+			Success: false,
+			Message: "基金不存在",
+		})
+		return false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: "获取基金失败: " + err.Error(),
+		})
+		return false
+	}
+	return true
+}
+
+// 返回基金最新的交易流水及持仓
+func respondWithLedger(c *gin.Context, fundID int, message string) {
+	ledger, err := getFundLedger(fundID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Success: true,
+		Message: message,
+		Data:    ledger,
+	})
+}
+
+// 读取上传的文件：multipart 表单的 file 字段或原始请求体，失败时直接返回400
+func readUploadData(c *gin.Context) ([]byte, bool) {
+	if c.ContentType() != "multipart/form-data" {
+		data, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, Response{
+				Success: false,
+				Message: "读取请求体失败: " + err.Error(),
+			})
+			return nil, false
+		}
+		return data, true
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: "缺少上传文件 file",
+		})
+		return nil, false
+	}
+	f, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: "读取上传文件失败: " + err.Error(),
+		})
+		return nil, false
+	}
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: "读取上传文件失败: " + err.Error(),
+		})
+		return nil, false
+	}
+	return data, true
+}
+
+// 导入净值文件：multipart 表单的 file 字段或原始请求体，?code= 指定文件中缺少的基金代码，
+// ?format=csv|json 指定格式(默认自动识别)，?gap_days= 指定报告缺口的最少缺失工作日数
+func importNAVsHandler(c *gin.Context) {
+	data, ok := readUploadData(c)
+	if !ok {
+		return
+	}
+
+	minGap := 1
+	if value := c.Query("gap_days"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, Response{
+				Success: false,
+				Message: "gap_days 必须是正整数",
+			})
+			return
+		}
+		minGap = n
+	}
+
+	navs, err := parseNAVFile(data, c.Query("format"), strings.TrimSpace(c.Query("code")))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	result, err := importFundNAVs(navs, minGap)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: "导入净值失败: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Success: true,
+		Message: navImportSummary(result),
+		Data:    result,
+	})
+}
+
+// 立即从净值数据源获取所有基金的最新净值
+func fetchNAVsHandler(c *gin.Context) {
+	if navProvider == nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: "未配置净值数据源，请设置 NAV_PROVIDER 环境变量",
+		})
+		return
+	}
+
+	result, err := fetchLatestNAVs(c.Request.Context(), navProvider)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: "获取净值失败: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Success: true,
+		Message: navFetchSummary(result),
+		Data:    result,
+	})
+}
+
+// 获取基金的历史净值，?from=&to= 限定日期范围
+func getFundNAVsHandler(c *gin.Context) {
+	from, to := c.Query("from"), c.Query("to")
+	for _, value := range []string{from, to} {
+		if value == "" {
+			continue
+		}
+		if _, err := parseDate(value); err != nil {
+			c.JSON(http.StatusBadRequest, Response{
+				Success: false,
+				Message: err.Error(),
+			})
+			return
+		}
+	}
+
+	navs, err := store.GetFundNAVs(c.Param("code"), from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: "获取净值失败: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Success: true,
+		Data:    navs,
+	})
+}
+
+// 导入指数估值文件：multipart 表单的 file 字段或原始请求体，?code= 和 ?metric= 指定文件中缺少的
+// 指数代码和估值指标(默认pe)，?format=csv|json 指定格式(默认自动识别)
+func importValuationsHandler(c *gin.Context) {
+	data, ok := readUploadData(c)
+	if !ok {
+		return
+	}
+
+	metric := strings.ToLower(c.DefaultQuery("metric", ValuationMetricPE))
+	valuations, err := parseValuationFile(data, c.Query("format"), strings.TrimSpace(c.Query("code")), metric)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	result, err := importIndexValuations(valuations)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: "导入估值失败: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Success: true,
+		Message: valuationImportSummary(result),
+		Data:    result,
+	})
+}
+
+// 获取指数的历史估值，?metric= 指定估值指标(默认pe)，?from=&to= 限定日期范围
+func getIndexValuationsHandler(c *gin.Context) {
+	metric := strings.ToLower(c.DefaultQuery("metric", ValuationMetricPE))
+	if !validValuationMetric(metric) {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: fmt.Sprintf("无效的估值指标: %s（可选 %s、%s）", metric, ValuationMetricPE, ValuationMetricPB),
+		})
+		return
+	}
+	from, to := c.Query("from"), c.Query("to")
+	for _, value := range []string{from, to} {
+		if value == "" {
+			continue
+		}
+		if _, err := parseDate(value); err != nil {
+			c.JSON(http.StatusBadRequest, Response{
+				Success: false,
+				Message: err.Error(),
+			})
+			return
+		}
+	}
+
+	valuations, err := store.GetIndexValuations(c.Param("code"), metric, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: "获取估值失败: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Success: true,
+		Data:    valuations,
+	})
+}
+
+// 获取估值规则及按 ?date= (默认今天)的估值计算的目标占比
+func getValuationRulesHandler(c *gin.Context) {
+	date := c.Query("date")
+	if date == "" {
+		date = time.Now().Format(dateLayout)
+	} else if _, err := parseDate(date); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	respondWithValuationRules(c, date, "")
+}
+
+// 返回估值规则及其对目标占比的调整
+func respondWithValuationRules(c *gin.Context, date, message string) {
+	view, err := getValuationRulesView(date)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, Response{
+		Success: true,
+		Message: message,
+		Data:    view,
+	})
+}
+
+func addValuationRuleHandler(c *gin.Context) {
+	var req AddValuationRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: "无效的请求参数",
+		})
+		return
+	}
+
+	dbBuckets, ok := loadDBBuckets(c)
+	if !ok {
+		return
+	}
+	if _, found := findDBBucketByID(dbBuckets, req.BucketID); !found {
+		c.JSON(http.StatusNotFound, Response{
+			Success: false,
+			Message: fmt.Sprintf("桶不存在: %d", req.BucketID),
+		})
+		return
+	}
+
+	rule := ValuationRule{
+		BucketID:      req.BucketID,
+		IndexCode:     strings.TrimSpace(req.IndexCode),
+		Metric:        strings.ToLower(req.Metric),
+		MinPercentile: req.MinPercentile,
+		MaxPercentile: 1,
+		TargetRate:    req.TargetRate,
+	}
+	if rule.Metric == "" {
+		rule.Metric = ValuationMetricPE
+	}
+	if req.MaxPercentile != nil {
+		rule.MaxPercentile = *req.MaxPercentile
+	}
+
+	rules, err := store.GetValuationRules()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: "获取估值规则失败: " + err.Error(),
+		})
+		return
+	}
+	if err := validateValuationRule(rule, dbBuckets, rules); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	id, err := store.AddValuationRule(rule)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: "添加估值规则失败: " + err.Error(),
+		})
+		return
+	}
+
+	respondWithValuationRules(c, time.Now().Format(dateLayout), fmt.Sprintf("已添加估值规则 #%d", id))
+}
+
+func deleteValuationRuleHandler(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	rule, err := findValuationRule(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: "获取估值规则失败: " + err.Error(),
+		})
+		return
+	}
+	if rule == nil {
+		c.JSON(http.StatusNotFound, Response{
+			Success: false,
+			Message: "估值规则不存在",
+		})
+		return
+	}
+	if err := store.DeleteValuationRule(id); err != nil {
 		c.JSON(http.StatusInternalServerError, Response{
 			Success: false,
-			Message: "获取桶信息失败: " + err.Error(),
+			Message: "删除估值规则失败: " + err.Error(),
 		})
 		return
 	}
 
-	if req.BucketIndex < 0 || req.BucketIndex >= len(dbBuckets) {
+	respondWithValuationRules(c, time.Now().Format(dateLayout), "估值规则删除成功")
+}
+
+// 获取下滑路径及目标占比投影，?from= 开始日期(默认今天)，?to= 结束日期(默认最后一个路径点)，
+// ?step=year|quarter|month 投影步长
+func getGlidePathsHandler(c *gin.Context) {
+	months, err := glideStepMonths(c.Query("step"))
+	if err != nil {
 		c.JSON(http.StatusBadRequest, Response{
 			Success: false,
-			Message: "无效的桶索引",
+			Message: err.Error(),
 		})
 		return
 	}
-
-	bucket := dbBuckets[req.BucketIndex]
-
-	// 验证权重
-	var totalWeight float64
-	for _, f := range bucket.Funds {
-		totalWeight += f.Weight
+	from, to := c.Query("from"), c.Query("to")
+	for _, value := range []string{from, to} {
+		if value == "" {
+			continue
+		}
+		if _, err := parseDate(value); err != nil {
+			c.JSON(http.StatusBadRequest, Response{
+				Success: false,
+				Message: err.Error(),
+			})
+			return
+		}
 	}
 
-	if totalWeight+req.Weight > 1.0 {
+	projection, err := getGlideProjection(from, to, months)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, Response{
 			Success: false,
-			Message: "权重超出限制！当前桶内总权重: " + strconv.FormatFloat(totalWeight, 'f', 2, 64) +
-				"，剩余可分配: " + strconv.FormatFloat(1.0-totalWeight, 'f', 2, 64),
+			Message: err.Error(),
 		})
 		return
 	}
 
-	// 添加到数据库
-	err = addFundToDB(bucket.ID, req.Name, req.Code, req.Current, req.Weight)
+	c.JSON(http.StatusOK, Response{
+		Success: true,
+		Data:    projection,
+	})
+}
+
+// 返回最新的下滑路径及从今天开始的投影
+func respondWithGlideProjection(c *gin.Context, message string) {
+	projection, err := getGlideProjection("", "", 12)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, Response{
 			Success: false,
-			Message: "添加基金失败: " + err.Error(),
+			Message: err.Error(),
 		})
 		return
 	}
 
-	// 返回更新后的数据
-	dbBuckets, _ = getAllBucketsFromDB()
-	buckets := convertDBBucketsToAPIBuckets(dbBuckets)
-
 	c.JSON(http.StatusOK, Response{
 		Success: true,
-		Message: "基金添加成功",
-		Data:    buckets,
+		Message: message,
+		Data:    projection,
 	})
 }
 
-func deleteFund(c *gin.Context) {
-	var req DeleteFundRequest
+func setGlidePathHandler(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+	var req SetGlidePathRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, Response{
 			Success: false,
@@ -143,59 +1454,90 @@ func deleteFund(c *gin.Context) {
 		return
 	}
 
-	// 获取所有桶以验证索引
-	dbBuckets, err := getAllBucketsFromDB()
+	dbBuckets, ok := loadDBBuckets(c)
+	if !ok {
+		return
+	}
+	if _, found := findDBBucketByID(dbBuckets, id); !found {
+		c.JSON(http.StatusNotFound, Response{
+			Success: false,
+			Message: "桶不存在",
+		})
+		return
+	}
+	paths, err := store.GetGlidePaths()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, Response{
 			Success: false,
-			Message: "获取桶信息失败: " + err.Error(),
+			Message: "获取下滑路径失败: " + err.Error(),
 		})
 		return
 	}
 
-	if req.BucketIndex < 0 || req.BucketIndex >= len(dbBuckets) {
+	path := GlidePath{BucketID: id, Basis: strings.ToLower(req.Basis), Points: req.Points}
+	if path.Basis == "" {
+		path.Basis = GlideBasisDate
+	}
+	if err := validateGlidePath(&path, dbBuckets, paths, appConfig.BirthDate); err != nil {
 		c.JSON(http.StatusBadRequest, Response{
 			Success: false,
-			Message: "无效的桶索引",
+			Message: err.Error(),
 		})
 		return
 	}
-
-	bucket := dbBuckets[req.BucketIndex]
-
-	if req.FundIndex < 0 || req.FundIndex >= len(bucket.Funds) {
-		c.JSON(http.StatusBadRequest, Response{
+	if err := store.SetGlidePath(path); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
 			Success: false,
-			Message: "无效的基金索引",
+			Message: "保存下滑路径失败: " + err.Error(),
 		})
 		return
 	}
 
-	fund := bucket.Funds[req.FundIndex]
+	respondWithGlideProjection(c, "下滑路径设置成功")
+}
+
+func deleteGlidePathHandler(c *gin.Context) {
+	id, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
 
-	// 从数据库删除
-	err = deleteFundFromDB(fund.ID)
+	paths, err := store.GetGlidePaths()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, Response{
 			Success: false,
-			Message: "删除基金失败: " + err.Error(),
+			Message: "获取下滑路径失败: " + err.Error(),
+		})
+		return
+	}
+	if len(withoutGlidePath(paths, id)) == len(paths) {
+		c.JSON(http.StatusNotFound, Response{
+			Success: false,
+			Message: "该桶没有下滑路径",
+		})
+		return
+	}
+	if err := store.DeleteGlidePath(id); err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: "删除下滑路径失败: " + err.Error(),
 		})
 		return
 	}
 
-	// 返回更新后的数据
-	dbBuckets, _ = getAllBucketsFromDB()
-	buckets := convertDBBucketsToAPIBuckets(dbBuckets)
+	respondWithGlideProjection(c, "下滑路径删除成功")
+}
 
+// 获取可选的再平衡策略
+func getStrategiesHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, Response{
 		Success: true,
-		Message: "已删除基金: " + fund.Name,
-		Data:    buckets,
+		Data:    listStrategies(),
 	})
 }
 
-func updateFund(c *gin.Context) {
-	var req UpdateFundRequest
+func performRebalance(c *gin.Context) {
+	var req RebalanceRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, Response{
 			Success: false,
@@ -204,250 +1546,358 @@ func updateFund(c *gin.Context) {
 		return
 	}
 
-	// 获取所有桶以验证索引
-	dbBuckets, err := getAllBucketsFromDB()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, Response{
+	handleRebalance(c, req)
+}
+
+// 再平衡预览：等同于 dry_run 为 true 的再平衡请求
+func previewRebalance(c *gin.Context) {
+	var req RebalanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
 			Success: false,
-			Message: "获取桶信息失败: " + err.Error(),
+			Message: "无效的请求参数",
 		})
 		return
 	}
 
-	if req.BucketIndex < 0 || req.BucketIndex >= len(dbBuckets) {
+	req.DryRun = true
+	handleRebalance(c, req)
+}
+
+func handleRebalance(c *gin.Context, req RebalanceRequest) {
+	if req.Threshold <= 0 {
+		req.Threshold = appConfig.DefaultThreshold
+	}
+
+	opts := RebalanceOptions{
+		Strategy:   req.Strategy,
+		Threshold:  req.Threshold,
+		FundBand:   req.FundBand,
+		Mode:       req.Mode,
+		InnerBand:  req.InnerBand,
+		CashFlow:   req.Amount,
+		MaxFeeRate: req.MaxFeeRate,
+		MinTrade:   req.MinTrade,
+		RoundStep:  req.RoundStep,
+	}
+	if err := normalizeRebalanceOptions(&opts); err != nil {
 		c.JSON(http.StatusBadRequest, Response{
 			Success: false,
-			Message: "无效的桶索引",
+			Message: err.Error(),
 		})
 		return
 	}
 
-	bucket := dbBuckets[req.BucketIndex]
-
-	if req.FundIndex < 0 || req.FundIndex >= len(bucket.Funds) {
+	if req.Overrides != nil && !req.DryRun {
 		c.JSON(http.StatusBadRequest, Response{
 			Success: false,
-			Message: "无效的基金索引",
+			Message: "假设性调整(overrides)只能用于预览，请设置 dry_run 或使用 /api/rebalance/preview",
 		})
 		return
 	}
 
-	fund := bucket.Funds[req.FundIndex]
+	// 从数据库获取当前数据
+	dbBuckets, err := getAllBucketsFromDB()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: "获取基金配置失败: " + err.Error(),
+		})
+		return
+	}
 
-	// 验证字段
-	switch req.Field {
-	case "name", "code":
-		// 字符串字段直接更新
-	case "current":
-		if _, err := strconv.ParseFloat(req.Value, 64); err != nil {
+	// 转换为API格式进行再平衡计算
+	buckets := convertDBBucketsToAPIBuckets(dbBuckets)
+	// 先按下滑路径和估值规则调整目标占比，假设性调整在其后应用
+	var adjustments []TargetAdjustment
+	if !req.StaticTargets {
+		if adjustments, err = applyDynamicTargets(buckets, time.Now().Format(dateLayout)); err != nil {
 			c.JSON(http.StatusBadRequest, Response{
 				Success: false,
-				Message: "无效的数值",
+				Message: err.Error(),
 			})
 			return
 		}
-	case "weight":
-		if val, err := strconv.ParseFloat(req.Value, 64); err == nil {
-			// 验证权重
-			var totalWeight float64
-			for i, f := range bucket.Funds {
-				if i != req.FundIndex {
-					totalWeight += f.Weight
-				}
-			}
+	}
+	if err := applyRebalanceOverrides(buckets, req.Overrides); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
+	if err := checkRebalanceInput(buckets, opts); err != nil {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
 
-			if totalWeight+val > 1.0 {
-				c.JSON(http.StatusBadRequest, Response{
-					Success: false,
-					Message: "权重超出限制！其他基金总权重: " + strconv.FormatFloat(totalWeight, 'f', 2, 64) +
-						"，剩余可分配: " + strconv.FormatFloat(1.0-totalWeight, 'f', 2, 64),
-				})
-				return
-			}
-		} else {
-			c.JSON(http.StatusBadRequest, Response{
+	if req.DryRun {
+		result, err := rebalance(buckets, opts)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, Response{
 				Success: false,
-				Message: "无效的数值",
+				Message: "再平衡计算失败: " + err.Error(),
 			})
 			return
 		}
-	default:
-		c.JSON(http.StatusBadRequest, Response{
-			Success: false,
-			Message: "无效的字段",
+		result.Diagnostics = append(targetAdjustmentDiagnostics(adjustments), result.Diagnostics...)
+		c.JSON(http.StatusOK, Response{
+			Success: true,
+			Message: fmt.Sprintf("再平衡预览完成（未保存），%s", strategyResultSummary(opts.Strategy, result)),
+			Data:    result.Buckets,
 		})
 		return
 	}
 
-	// 更新数据库
-	err = updateFundInDB(fund.ID, req.Field, req.Value)
+	// 执行再平衡，回写结果并保存到历史记录
+	result, recordID, err := rebalanceAndRecord(buckets, opts, adjustments, RebalanceTriggerManual)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, Response{
 			Success: false,
-			Message: "更新基金失败: " + err.Error(),
+			Message: "保存再平衡记录失败: " + err.Error(),
 		})
 		return
 	}
-
-	// 返回更新后的数据
-	dbBuckets, _ = getAllBucketsFromDB()
-	buckets := convertDBBucketsToAPIBuckets(dbBuckets)
+	log.Printf("✅ 再平衡记录已保存，ID: %d", recordID)
 
 	c.JSON(http.StatusOK, Response{
 		Success: true,
-		Message: "基金信息更新成功",
-		Data:    buckets,
+		Message: fmt.Sprintf("再平衡分析完成，%s", strategyResultSummary(opts.Strategy, result)),
+		Data:    result.Buckets,
 	})
 }
 
-func performRebalance(c *gin.Context) {
-	var req RebalanceRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, Response{
+// 获取再平衡历史记录
+func getRebalanceHistoryHandler(c *gin.Context) {
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit <= 0 {
+		limit = appConfig.HistoryLimit
+	}
+
+	records, err := store.GetRebalanceHistory(limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
 			Success: false,
-			Message: "无效的请求参数",
+			Message: "获取历史记录失败: " + err.Error(),
 		})
 		return
 	}
 
-	if req.Threshold <= 0 {
-		req.Threshold = 0.05
+	c.JSON(http.StatusOK, Response{
+		Success: true,
+		Data:    records,
+	})
+}
+
+// 定时再平衡的配置、下一次执行时间和最近一次执行，count 为列出的日历执行次数(默认5，最多50)
+func getScheduleHandler(c *gin.Context) {
+	count := 5
+	if s := c.Query("count"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > maxScheduleCount {
+			c.JSON(http.StatusBadRequest, Response{
+				Success: false,
+				Message: fmt.Sprintf("count 须为1到%d之间的整数", maxScheduleCount),
+			})
+			return
+		}
+		count = n
 	}
 
-	// 从数据库获取当前数据
-	dbBuckets, err := getAllBucketsFromDB()
+	status, err := getScheduleStatus(time.Now(), count)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, Response{
 			Success: false,
-			Message: "获取基金配置失败: " + err.Error(),
+			Message: "获取定时再平衡状态失败: " + err.Error(),
 		})
 		return
 	}
 
-	// 转换为API格式进行再平衡计算
-	buckets := convertDBBucketsToAPIBuckets(dbBuckets)
-	results := rebalance(buckets, req.Threshold)
+	c.JSON(http.StatusOK, Response{
+		Success: true,
+		Data:    status,
+	})
+}
 
-	// 更新数据库中的再平衡结果
-	err = updateFundRebalanceResults(dbBuckets, results)
+// 获取再平衡历史详情
+func getRebalanceDetailHandler(c *gin.Context) {
+	recordIDStr := c.Param("id")
+	recordID, err := strconv.Atoi(recordIDStr)
 	if err != nil {
-		log.Printf("更新再平衡结果失败: %v", err)
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: "无效的记录ID",
+		})
+		return
 	}
 
-	// 保存再平衡记录
-	var totalValue float64
-	for _, bucket := range results {
-		for _, fund := range bucket.Funds {
-			totalValue += fund.Current
-		}
+	// 获取记录基本信息、详细建议及执行汇总
+	detail, err := getRebalanceDetail(recordID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, Response{
+			Success: false,
+			Message: "记录不存在",
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: "获取记录失败: " + err.Error(),
+		})
+		return
 	}
 
-	// 构建建议记录
-	var suggestions []RebalanceSuggestion
-	for _, dbBucket := range dbBuckets {
-		for _, dbFund := range dbBucket.Funds {
-			// 找到对应的再平衡结果
-			for _, bucket := range results {
-				for _, fund := range bucket.Funds {
-					if fund.Name == dbFund.Name && fund.Code == dbFund.Code {
-						suggestions = append(suggestions, RebalanceSuggestion{
-							FundID:       dbFund.ID,
-							FundName:     fund.Name,
-							FundCode:     fund.Code,
-							CurrentValue: fund.Current,
-							TargetValue:  fund.Target,
-							DiffValue:    fund.Diff,
-							Advice:       fund.Advice,
-							Reason:       fund.Reason,
-						})
-						break
-					}
-				}
-			}
-		}
+	c.JSON(http.StatusOK, Response{
+		Success: true,
+		Data:    detail,
+	})
+}
+
+// 用当前算法重新计算历史记录，并与原记录的建议逐只比较
+func replayRebalanceHandler(c *gin.Context) {
+	recordID, ok := parseIDParam(c)
+	if !ok {
+		return
 	}
 
-	// 保存到历史记录
-	recordID, err := saveRebalanceRecord(req.Threshold, totalValue, suggestions)
-	if err != nil {
-		log.Printf("保存再平衡记录失败: %v", err)
-	} else {
-		log.Printf("✅ 再平衡记录已保存，ID: %d", recordID)
+	result, err := replayRebalanceRecord(recordID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		c.JSON(http.StatusNotFound, Response{
+			Success: false,
+			Message: "记录不存在",
+		})
+		return
+	case errors.Is(err, errNoSnapshot):
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: "重现记录失败: " + err.Error(),
+		})
+		return
 	}
 
 	c.JSON(http.StatusOK, Response{
 		Success: true,
-		Message: "再平衡分析完成",
-		Data:    results,
+		Message: fmt.Sprintf("已按当前算法重新计算记录 #%d，%d只基金的操作建议发生变化", recordID, result.AdviceChanges),
+		Data:    result,
 	})
 }
 
-// 获取再平衡历史记录
-func getRebalanceHistoryHandler(c *gin.Context) {
-	limitStr := c.DefaultQuery("limit", "10")
-	limit, err := strconv.Atoi(limitStr)
-	if err != nil || limit <= 0 {
-		limit = 10
+// 比较两条再平衡记录，?a=&b= 为记录ID
+func diffRebalanceHandler(c *gin.Context) {
+	var ids [2]int
+	for i, name := range []string{"a", "b"} {
+		id, err := strconv.Atoi(c.Query(name))
+		if err != nil || id <= 0 {
+			c.JSON(http.StatusBadRequest, Response{
+				Success: false,
+				Message: "需要通过 a、b 参数指定两条记录的ID",
+			})
+			return
+		}
+		ids[i] = id
 	}
 
-	records, err := getRebalanceHistory(limit)
+	comparison, err := compareRebalanceRecords(ids[0], ids[1])
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, Response{
+			Success: false,
+			Message: "记录不存在",
+		})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, Response{
 			Success: false,
-			Message: "获取历史记录失败: " + err.Error(),
+			Message: "比较记录失败: " + err.Error(),
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, Response{
 		Success: true,
-		Data:    records,
+		Data:    comparison,
 	})
 }
 
-// 获取再平衡历史详情
-func getRebalanceDetailHandler(c *gin.Context) {
-	recordIDStr := c.Param("id")
-	recordID, err := strconv.Atoi(recordIDStr)
-	if err != nil {
+// 标记建议为已执行、部分执行或跳过，返回所属记录的详情
+func executeSuggestionHandler(c *gin.Context) {
+	suggestionID, ok := parseIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req ExecutionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, Response{
 			Success: false,
-			Message: "无效的记录ID",
+			Message: "无效的请求参数",
 		})
 		return
 	}
 
-	// 获取记录基本信息
-	record, err := getRebalanceRecordByID(recordID)
+	suggestion, err := store.GetSuggestionByID(suggestionID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, Response{
+			Success: false,
+			Message: "建议不存在",
+		})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, Response{
 			Success: false,
-			Message: "获取记录失败: " + err.Error(),
+			Message: "获取建议失败: " + err.Error(),
 		})
 		return
 	}
 
-	// 获取详细建议
-	suggestions, err := getRebalanceSuggestionsByRecordID(recordID)
+	updated, err := executeSuggestion(*suggestion, req)
+	var execErr executionError
+	if errors.As(err, &execErr) {
+		c.JSON(http.StatusBadRequest, Response{
+			Success: false,
+			Message: execErr.Error(),
+		})
+		return
+	}
+	if errors.Is(err, errSuggestionNotPending) {
+		c.JSON(http.StatusConflict, Response{
+			Success: false,
+			Message: err.Error(),
+		})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, Response{
 			Success: false,
-			Message: "获取建议详情失败: " + err.Error(),
+			Message: "更新执行情况失败: " + err.Error(),
 		})
 		return
 	}
 
-	// 组合返回数据
-	detail := struct {
-		Record      RebalanceRecord       `json:"record"`
-		Suggestions []RebalanceSuggestion `json:"suggestions"`
-	}{
-		Record:      *record,
-		Suggestions: suggestions,
+	detail, err := getRebalanceDetail(updated.RecordID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, Response{
+			Success: false,
+			Message: "获取记录失败: " + err.Error(),
+		})
+		return
 	}
 
 	c.JSON(http.StatusOK, Response{
 		Success: true,
+		Message: fmt.Sprintf("%s已标记为%s", updated.FundName, suggestionStatusLabel(updated.Status)),
 		Data:    detail,
 	})
 }
@@ -468,19 +1918,54 @@ func setupRoutes() *gin.Engine {
 
 	// 主页
 	r.GET("/", func(c *gin.Context) {
-		c.HTML(http.StatusOK, "index.html", nil)
+		c.HTML(http.StatusOK, "index.html", gin.H{
+			"DefaultThreshold": appConfig.DefaultThreshold,
+			"Strategies":       listStrategies(),
+		})
 	})
 
 	// API 路由
 	api := r.Group("/api")
 	{
 		api.GET("/buckets", getBuckets)
+		api.POST("/buckets", addBucket)
+		api.PUT("/buckets", updateBucket)
+		api.PUT("/buckets/targets", updateBucketTargets)
+		api.DELETE("/buckets", deleteBucket)
+		api.GET("/buckets/:id", getBucketByIDHandler)
+		api.PUT("/buckets/:id", updateBucketByID)
+		api.DELETE("/buckets/:id", deleteBucketByID)
+		api.PUT("/buckets/:id/glide-path", setGlidePathHandler)
+		api.DELETE("/buckets/:id/glide-path", deleteGlidePathHandler)
 		api.POST("/funds", addFund)
 		api.DELETE("/funds", deleteFund)
 		api.PUT("/funds", updateFund)
+		api.GET("/funds/archived", getArchivedFundsHandler)
+		api.GET("/funds/:id", getFundByIDHandler)
+		api.PUT("/funds/:id", updateFundByID)
+		api.DELETE("/funds/:id", deleteFundByID)
+		api.POST("/funds/:id/restore", restoreFundHandler)
+		api.GET("/funds/:id/transactions", getFundTransactionsHandler)
+		api.POST("/funds/:id/transactions", addFundTransactionHandler)
+		api.DELETE("/transactions/:id", deleteTransactionHandler)
+		api.POST("/navs/import", importNAVsHandler)
+		api.POST("/navs/fetch", fetchNAVsHandler)
+		api.GET("/navs/:code", getFundNAVsHandler)
+		api.POST("/valuations/import", importValuationsHandler)
+		api.GET("/valuations/:code", getIndexValuationsHandler)
+		api.GET("/valuation-rules", getValuationRulesHandler)
+		api.POST("/valuation-rules", addValuationRuleHandler)
+		api.DELETE("/valuation-rules/:id", deleteValuationRuleHandler)
+		api.GET("/glide-paths", getGlidePathsHandler)
+		api.GET("/strategies", getStrategiesHandler)
 		api.POST("/rebalance", performRebalance)
+		api.POST("/rebalance/preview", previewRebalance)
 		api.GET("/rebalance/history", getRebalanceHistoryHandler)
+		api.GET("/rebalance/schedule", getScheduleHandler)
+		api.GET("/rebalance/history/diff", diffRebalanceHandler)
 		api.GET("/rebalance/history/:id", getRebalanceDetailHandler)
+		api.POST("/rebalance/history/:id/replay", replayRebalanceHandler)
+		api.POST("/rebalance/suggestions/:id/execute", executeSuggestionHandler)
 	}
 
 	return r
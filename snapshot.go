@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// 快照格式版本，结构变化时递增
+const snapshotVersion = 1
+
+// 再平衡时的完整配置快照，保存在再平衡记录中，修改配置后仍能解读和重现旧记录
+type RebalanceSnapshot struct {
+	Version int              `json:"version"`
+	Options RebalanceOptions `json:"options"` // 再平衡参数
+	Buckets []SnapshotBucket `json:"buckets"` // 目标占比为本次使用的目标占比，按下滑路径或估值调整时与配置不同
+	// 按下滑路径和估值规则对目标占比的调整及所用的估值数据，没有下滑路径和规则时为空
+	TargetAdjustments []TargetAdjustment `json:"target_adjustments,omitempty"`
+}
+
+type SnapshotBucket struct {
+	ID         int            `json:"id"`
+	Name       string         `json:"name"`
+	ParentID   int            `json:"parent_id,omitempty"`
+	TargetRate float64        `json:"target_rate"`
+	Funds      []SnapshotFund `json:"funds"`
+}
+
+type SnapshotFund struct {
+	ID            int         `json:"id"`
+	Name          string      `json:"name"`
+	Code          string      `json:"code"`
+	Current       float64     `json:"current"`        // 再平衡前的市值(万元)
+	CurrentSource string      `json:"current_source"` // 市值来源
+	Weight        float64     `json:"weight"`
+	Fees          FeeSchedule `json:"fees"`
+	MinTrade      float64     `json:"min_trade"`
+}
+
+// 记录再平衡前的配置，需在调用 rebalance 之前生成
+func newRebalanceSnapshot(buckets []Bucket, opts RebalanceOptions) RebalanceSnapshot {
+	snapshot := RebalanceSnapshot{Version: snapshotVersion, Options: opts, Buckets: make([]SnapshotBucket, 0, len(buckets))}
+	for _, b := range buckets {
+		sb := SnapshotBucket{ID: b.ID, Name: b.Name, ParentID: b.ParentID, TargetRate: b.TargetRate, Funds: make([]SnapshotFund, 0, len(b.Funds))}
+		for _, f := range b.Funds {
+			fees := f.Fees
+			fees.RedemptionTiers = append([]RedemptionTier(nil), f.Fees.RedemptionTiers...)
+			sb.Funds = append(sb.Funds, SnapshotFund{
+				ID:            f.ID,
+				Name:          f.Name,
+				Code:          f.Code,
+				Current:       f.Current,
+				CurrentSource: f.CurrentSource,
+				Weight:        f.Weight,
+				Fees:          fees,
+				MinTrade:      f.MinTrade,
+			})
+		}
+		snapshot.Buckets = append(snapshot.Buckets, sb)
+	}
+	return snapshot
+}
+
+// 解析保存的快照，旧记录没有快照时返回 nil
+func parseRebalanceSnapshot(data string) (*RebalanceSnapshot, error) {
+	if data == "" {
+		return nil, nil
+	}
+	var snapshot RebalanceSnapshot
+	if err := json.Unmarshal([]byte(data), &snapshot); err != nil {
+		return nil, fmt.Errorf("解析配置快照失败: %v", err)
+	}
+	return &snapshot, nil
+}
+
+// 由快照还原再平衡的输入
+func (s RebalanceSnapshot) restoreBuckets() []Bucket {
+	buckets := make([]Bucket, 0, len(s.Buckets))
+	for _, sb := range s.Buckets {
+		b := Bucket{ID: sb.ID, Name: sb.Name, ParentID: sb.ParentID, TargetRate: sb.TargetRate, Funds: make([]Fund, 0, len(sb.Funds))}
+		for _, sf := range sb.Funds {
+			fees := sf.Fees
+			fees.RedemptionTiers = append([]RedemptionTier(nil), sf.Fees.RedemptionTiers...)
+			b.Funds = append(b.Funds, Fund{
+				ID:            sf.ID,
+				Name:          sf.Name,
+				Code:          sf.Code,
+				Current:       sf.Current,
+				CurrentSource: sf.CurrentSource,
+				Weight:        sf.Weight,
+				Fees:          fees,
+				MinTrade:      sf.MinTrade,
+			})
+		}
+		buckets = append(buckets, b)
+	}
+	annotateBucketTree(buckets)
+	return buckets
+}
@@ -0,0 +1,966 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQL方言：各数据库在占位符、迁移和备份上的差异，查询语句本身两种数据库通用
+type sqlDialect struct {
+	name                 string // 存储后端名称
+	driver               string // database/sql 驱动名
+	migrationDir         string // 内嵌迁移文件所在目录
+	numberedPlaceholders bool   // 占位符为 $1、$2…，否则为 ?
+	schemaVersionDDL     string // 创建迁移版本表
+	tablesQuery          string // 统计业务表数量(不含迁移版本表)
+	// 执行第1个迁移时补齐旧数据库缺少的列，没有旧数据库的后端为 nil
+	upgradeLegacy func(tx *sql.Tx) error
+	// 迁移前备份数据库，返回备份位置；不支持自动备份的后端为 nil
+	backup func(db *sql.DB, dsn string, version int) (string, error)
+	// 打开数据库时的连接串，在 dsn 上附加连接参数；不需要附加参数的后端为 nil
+	connString func(dsn string) string
+}
+
+// 把 ? 占位符改写为当前方言的格式，跳过字符串常量中的问号
+func (d *sqlDialect) rebind(query string) string {
+	if !d.numberedPlaceholders {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	inString := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			inString = !inString
+		case r == '?' && !inString:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// 基于 database/sql 的存储，SQLite 和 PostgreSQL 共用
+type sqlRepository struct {
+	db      *sql.DB
+	dsn     string
+	dialect *sqlDialect
+}
+
+func newSQLRepository(dialect *sqlDialect, dsn string) (*sqlRepository, error) {
+	conn := dsn
+	if dialect.connString != nil {
+		conn = dialect.connString(dsn)
+	}
+	db, err := sql.Open(dialect.driver, conn)
+	if err != nil {
+		return nil, fmt.Errorf("打开数据库失败: %v", err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("连接数据库失败: %v", err)
+	}
+	return &sqlRepository{db: db, dsn: dsn, dialect: dialect}, nil
+}
+
+func (s *sqlRepository) Close() error {
+	return s.db.Close()
+}
+
+func (s *sqlRepository) exec(query string, args ...interface{}) (sql.Result, error) {
+	return s.db.Exec(s.dialect.rebind(query), args...)
+}
+
+func (s *sqlRepository) query(query string, args ...interface{}) (*sql.Rows, error) {
+	return s.db.Query(s.dialect.rebind(query), args...)
+}
+
+func (s *sqlRepository) queryRow(query string, args ...interface{}) *sql.Row {
+	return s.db.QueryRow(s.dialect.rebind(query), args...)
+}
+
+func (s *sqlRepository) begin() (*sqlTx, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &sqlTx{Tx: tx, dialect: s.dialect}, nil
+}
+
+// 自动改写占位符的事务
+type sqlTx struct {
+	*sql.Tx
+	dialect *sqlDialect
+}
+
+func (tx *sqlTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return tx.Tx.Exec(tx.dialect.rebind(query), args...)
+}
+
+func (tx *sqlTx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return tx.Tx.Query(tx.dialect.rebind(query), args...)
+}
+
+func (tx *sqlTx) QueryRow(query string, args ...interface{}) *sql.Row {
+	return tx.Tx.QueryRow(tx.dialect.rebind(query), args...)
+}
+
+// 插入一行并返回自增ID；PostgreSQL 的驱动不支持 LastInsertId，两种数据库都用 RETURNING
+func (tx *sqlTx) insert(query string, args ...interface{}) (int, error) {
+	var id int
+	err := tx.QueryRow(query+" RETURNING id", args...).Scan(&id)
+	return id, err
+}
+
+func (s *sqlRepository) insert(query string, args ...interface{}) (int, error) {
+	var id int
+	err := s.queryRow(query+" RETURNING id", args...).Scan(&id)
+	return id, err
+}
+
+func (s *sqlRepository) GetBuckets() ([]DBBucket, error) {
+	rows, err := s.query(`
+		SELECT id, name, parent_id, target_rate, created_at, updated_at
+		FROM buckets
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var buckets []DBBucket
+	for rows.Next() {
+		var bucket DBBucket
+		err := rows.Scan(&bucket.ID, &bucket.Name, &bucket.ParentID, &bucket.TargetRate,
+			&bucket.CreatedAt, &bucket.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, bucket)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// 获取桶内基金
+	for i := range buckets {
+		if buckets[i].Funds, err = s.getFundsByBucketID(buckets[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return buckets, nil
+}
+
+func (s *sqlRepository) AddBucket(name string, targetRate float64, parentID int) (int, error) {
+	return s.insert("INSERT INTO buckets (name, target_rate, parent_id) VALUES (?, ?, ?)", name, targetRate, parentID)
+}
+
+func (s *sqlRepository) UpdateBucket(bucketID int, field, value string) error {
+	query := fmt.Sprintf("UPDATE buckets SET %s = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", field)
+	_, err := s.exec(query, value, bucketID)
+	return err
+}
+
+func (s *sqlRepository) UpdateBucketTargetRates(rates map[int]float64) error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for bucketID, rate := range rates {
+		_, err := tx.Exec(
+			"UPDATE buckets SET target_rate = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+			rate, bucketID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// 迁移时目标占比并入目标桶，并按原占比缩放两边基金的权重，使每只基金的目标市值保持不变
+func (s *sqlRepository) DeleteBucket(bucket DBBucket, moveTo *DBBucket) error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if moveTo != nil {
+		mergedRate := bucket.TargetRate + moveTo.TargetRate
+		fromScale, toScale := mergedWeightScales(bucket.TargetRate, moveTo.TargetRate, len(bucket.Funds))
+
+		_, err := tx.Exec(
+			"UPDATE funds SET weight = weight * ?, updated_at = CURRENT_TIMESTAMP WHERE bucket_id = ?",
+			toScale, moveTo.ID,
+		)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(
+			"UPDATE funds SET bucket_id = ?, weight = weight * ?, updated_at = CURRENT_TIMESTAMP WHERE bucket_id = ?",
+			moveTo.ID, fromScale, bucket.ID,
+		)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(
+			"UPDATE buckets SET target_rate = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+			mergedRate, moveTo.ID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM valuation_rules WHERE bucket_id = ?", bucket.ID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM glide_path_points WHERE bucket_id = ?", bucket.ID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM buckets WHERE id = ?", bucket.ID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// 基金查询的列，与 queryFunds 中的 Scan 对应
+const fundColumns = `
+	id, bucket_id, name, code, current, weight, target, diff, advice,
+	purchase_rate, purchase_discount, redemption_tiers, held_since, min_trade, current_override,
+	archived_at, created_at, updated_at`
+
+// 获取桶内未归档的基金
+func (s *sqlRepository) getFundsByBucketID(bucketID int) ([]DBFund, error) {
+	return s.queryFunds(`
+		SELECT `+fundColumns+`
+		FROM funds
+		WHERE bucket_id = ? AND archived_at = ''
+		ORDER BY id
+	`, bucketID)
+}
+
+func (s *sqlRepository) GetArchivedFunds() ([]DBFund, error) {
+	return s.queryFunds(`
+		SELECT ` + fundColumns + `
+		FROM funds
+		WHERE archived_at != ''
+		ORDER BY archived_at DESC, id
+	`)
+}
+
+func (s *sqlRepository) GetFundByID(fundID int) (*DBFund, error) {
+	funds, err := s.queryFunds(`SELECT `+fundColumns+` FROM funds WHERE id = ?`, fundID)
+	if err != nil {
+		return nil, err
+	}
+	if len(funds) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &funds[0], nil
+}
+
+func (s *sqlRepository) queryFunds(query string, args ...interface{}) ([]DBFund, error) {
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var funds []DBFund
+	for rows.Next() {
+		var fund DBFund
+		var tiers string
+		err := rows.Scan(&fund.ID, &fund.BucketID, &fund.Name, &fund.Code,
+			&fund.Current, &fund.Weight, &fund.Target, &fund.Diff, &fund.Advice,
+			&fund.Fees.PurchaseRate, &fund.Fees.PurchaseDiscount, &tiers, &fund.Fees.HeldSince, &fund.MinTrade, &fund.CurrentOverride,
+			&fund.ArchivedAt, &fund.CreatedAt, &fund.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if fund.Fees.RedemptionTiers, err = parseRedemptionTiers(tiers); err != nil {
+			return nil, fmt.Errorf("基金 %s 的赎回费档位无效: %v", fund.Name, err)
+		}
+		funds = append(funds, fund)
+	}
+
+	return funds, rows.Err()
+}
+
+func (s *sqlRepository) AddFund(bucketID int, name, code string, current, weight float64) error {
+	query := `
+		INSERT INTO funds (bucket_id, name, code, current, weight)
+		VALUES (?, ?, ?, ?, ?)
+	`
+
+	_, err := s.exec(query, bucketID, name, code, current, weight)
+	return err
+}
+
+func (s *sqlRepository) UpdateFund(fundID int, field, value string) error {
+	if field == "current" {
+		// 有交易流水的基金手动修改市值即视为手动覆盖
+		_, err := s.exec(`
+			UPDATE funds
+			SET current = ?, current_override = EXISTS (SELECT 1 FROM transactions WHERE fund_id = funds.id),
+			    updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?`, value, fundID)
+		return err
+	}
+
+	query := fmt.Sprintf("UPDATE funds SET %s = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", field)
+	_, err := s.exec(query, value, fundID)
+	return err
+}
+
+// 归档基金：不再参与再平衡和列表，交易流水和历史建议保留
+func (s *sqlRepository) ArchiveFund(fundID int) error {
+	_, err := s.exec(
+		"UPDATE funds SET archived_at = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+		time.Now().Format("2006-01-02 15:04:05"), fundID,
+	)
+	return err
+}
+
+// 恢复已归档的基金到指定的桶
+func (s *sqlRepository) RestoreFund(fundID, bucketID int) error {
+	_, err := s.exec(
+		"UPDATE funds SET archived_at = '', bucket_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+		bucketID, fundID,
+	)
+	return err
+}
+
+func (s *sqlRepository) GetFundCodes() ([]string, error) {
+	rows, err := s.query("SELECT DISTINCT code FROM funds WHERE code != '' AND archived_at = '' ORDER BY code")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var codes []string
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, rows.Err()
+}
+
+func (s *sqlRepository) UpdateFundRebalanceResults(rebalancedBuckets []Bucket) error {
+	query := `
+		UPDATE funds
+		SET target = ?, diff = ?, advice = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`
+
+	for _, bucket := range rebalancedBuckets {
+		for _, fund := range bucket.Funds {
+			if fund.ID == 0 {
+				continue
+			}
+			_, err := s.exec(query, fund.Target, fund.Diff, fund.Advice, fund.ID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+const transactionColumns = "id, fund_id, type, trade_date, shares, nav, amount, note, created_at"
+
+func (s *sqlRepository) GetTransactions() ([]Transaction, error) {
+	return s.queryTransactions(`
+		SELECT ` + transactionColumns + `
+		FROM transactions
+		ORDER BY trade_date, id
+	`)
+}
+
+func (s *sqlRepository) GetTransactionsByFundID(fundID int) ([]Transaction, error) {
+	return s.queryTransactions(`
+		SELECT `+transactionColumns+`
+		FROM transactions
+		WHERE fund_id = ?
+		ORDER BY trade_date, id
+	`, fundID)
+}
+
+func (s *sqlRepository) queryTransactions(query string, args ...interface{}) ([]Transaction, error) {
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanTransactions(rows)
+}
+
+func scanTransactions(rows *sql.Rows) ([]Transaction, error) {
+	defer rows.Close()
+
+	var txs []Transaction
+	for rows.Next() {
+		var t Transaction
+		err := rows.Scan(&t.ID, &t.FundID, &t.Type, &t.TradeDate, &t.Shares, &t.NAV, &t.Amount, &t.Note, &t.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		txs = append(txs, t)
+	}
+
+	return txs, rows.Err()
+}
+
+func (s *sqlRepository) AddTransaction(t Transaction) (int, error) {
+	return s.insert(`
+		INSERT INTO transactions (fund_id, type, trade_date, shares, nav, amount, note)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		t.FundID, t.Type, t.TradeDate, t.Shares, t.NAV, t.Amount, t.Note,
+	)
+}
+
+func (s *sqlRepository) GetTransactionByID(id int) (*Transaction, error) {
+	var t Transaction
+	err := s.queryRow(`
+		SELECT `+transactionColumns+`
+		FROM transactions
+		WHERE id = ?`, id).Scan(&t.ID, &t.FundID, &t.Type, &t.TradeDate, &t.Shares, &t.NAV, &t.Amount, &t.Note, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (s *sqlRepository) DeleteTransaction(id int) error {
+	_, err := s.exec("DELETE FROM transactions WHERE id = ?", id)
+	return err
+}
+
+func (s *sqlRepository) SaveFundNAVs(navs []FundNAV) (*NAVImportResult, error) {
+	result := &NAVImportResult{}
+	tx, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for _, nav := range navs {
+		if nav.Source == "" {
+			nav.Source = NAVSourceImport
+		}
+
+		var unitNAV, accNAV float64
+		err := tx.QueryRow("SELECT unit_nav, acc_nav FROM fund_navs WHERE code = ? AND date = ?", nav.Code, nav.Date).Scan(&unitNAV, &accNAV)
+		switch {
+		case err == sql.ErrNoRows:
+			if _, err := tx.Exec("INSERT INTO fund_navs (code, date, unit_nav, acc_nav, source, fetched_at) VALUES (?, ?, ?, ?, ?, ?)",
+				nav.Code, nav.Date, nav.UnitNAV, nav.AccNAV, nav.Source, nav.FetchedAt); err != nil {
+				return nil, err
+			}
+			result.Inserted++
+		case err != nil:
+			return nil, err
+		case unitNAV == nav.UnitNAV && accNAV == nav.AccNAV:
+			if nav.FetchedAt != "" {
+				if _, err := tx.Exec("UPDATE fund_navs SET source = ?, fetched_at = ? WHERE code = ? AND date = ?",
+					nav.Source, nav.FetchedAt, nav.Code, nav.Date); err != nil {
+					return nil, err
+				}
+			}
+			result.Unchanged++
+		default:
+			if _, err := tx.Exec("UPDATE fund_navs SET unit_nav = ?, acc_nav = ?, source = ?, fetched_at = ?, updated_at = CURRENT_TIMESTAMP WHERE code = ? AND date = ?",
+				nav.UnitNAV, nav.AccNAV, nav.Source, nav.FetchedAt, nav.Code, nav.Date); err != nil {
+				return nil, err
+			}
+			result.Updated++
+		}
+	}
+	return result, tx.Commit()
+}
+
+const navColumns = "code, date, unit_nav, acc_nav, source, fetched_at"
+
+func (s *sqlRepository) GetFundNAVs(code, from, to string) ([]FundNAV, error) {
+	query := "SELECT " + navColumns + " FROM fund_navs WHERE code = ?"
+	args := []interface{}{code}
+	if from != "" {
+		query += " AND date >= ?"
+		args = append(args, from)
+	}
+	if to != "" {
+		query += " AND date <= ?"
+		args = append(args, to)
+	}
+	return s.queryFundNAVs(query+" ORDER BY date", args...)
+}
+
+func (s *sqlRepository) GetLatestFundNAVs() (map[string]FundNAV, error) {
+	navs, err := s.queryFundNAVs(`
+		SELECT ` + navColumns + `
+		FROM fund_navs n
+		WHERE date = (SELECT MAX(date) FROM fund_navs WHERE code = n.code)
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	latest := make(map[string]FundNAV, len(navs))
+	for _, nav := range navs {
+		latest[nav.Code] = nav
+	}
+	return latest, nil
+}
+
+func (s *sqlRepository) queryFundNAVs(query string, args ...interface{}) ([]FundNAV, error) {
+	rows, err := s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	navs := []FundNAV{}
+	for rows.Next() {
+		var nav FundNAV
+		if err := rows.Scan(&nav.Code, &nav.Date, &nav.UnitNAV, &nav.AccNAV, &nav.Source, &nav.FetchedAt); err != nil {
+			return nil, err
+		}
+		navs = append(navs, nav)
+	}
+
+	return navs, rows.Err()
+}
+
+func (s *sqlRepository) SaveIndexValuations(valuations []IndexValuation) (*ValuationImportResult, error) {
+	result := &ValuationImportResult{}
+	tx, err := s.begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for _, v := range valuations {
+		var value, percentile float64
+		err := tx.QueryRow("SELECT value, percentile FROM index_valuations WHERE index_code = ? AND metric = ? AND date = ?",
+			v.IndexCode, v.Metric, v.Date).Scan(&value, &percentile)
+		switch {
+		case err == sql.ErrNoRows:
+			if _, err := tx.Exec("INSERT INTO index_valuations (index_code, metric, date, value, percentile) VALUES (?, ?, ?, ?, ?)",
+				v.IndexCode, v.Metric, v.Date, v.Value, v.Percentile); err != nil {
+				return nil, err
+			}
+			result.Inserted++
+		case err != nil:
+			return nil, err
+		case value == v.Value && percentile == v.Percentile:
+			result.Unchanged++
+		default:
+			if _, err := tx.Exec("UPDATE index_valuations SET value = ?, percentile = ?, updated_at = CURRENT_TIMESTAMP WHERE index_code = ? AND metric = ? AND date = ?",
+				v.Value, v.Percentile, v.IndexCode, v.Metric, v.Date); err != nil {
+				return nil, err
+			}
+			result.Updated++
+		}
+	}
+	return result, tx.Commit()
+}
+
+const valuationColumns = "index_code, metric, date, value, percentile"
+
+func (s *sqlRepository) GetIndexValuations(code, metric, from, to string) ([]IndexValuation, error) {
+	query := "SELECT " + valuationColumns + " FROM index_valuations WHERE index_code = ? AND metric = ?"
+	args := []interface{}{code, metric}
+	if from != "" {
+		query += " AND date >= ?"
+		args = append(args, from)
+	}
+	if to != "" {
+		query += " AND date <= ?"
+		args = append(args, to)
+	}
+
+	rows, err := s.query(query+" ORDER BY date", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	valuations := []IndexValuation{}
+	for rows.Next() {
+		var v IndexValuation
+		if err := rows.Scan(&v.IndexCode, &v.Metric, &v.Date, &v.Value, &v.Percentile); err != nil {
+			return nil, err
+		}
+		valuations = append(valuations, v)
+	}
+	return valuations, rows.Err()
+}
+
+func (s *sqlRepository) GetLatestIndexValuation(code, metric, date string) (*IndexValuation, error) {
+	var v IndexValuation
+	err := s.queryRow(`
+		SELECT `+valuationColumns+`
+		FROM index_valuations
+		WHERE index_code = ? AND metric = ? AND date <= ?
+		ORDER BY date DESC
+		LIMIT 1`, code, metric, date).Scan(&v.IndexCode, &v.Metric, &v.Date, &v.Value, &v.Percentile)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func (s *sqlRepository) GetValuationRules() ([]ValuationRule, error) {
+	rows, err := s.query(`
+		SELECT id, bucket_id, index_code, metric, min_percentile, max_percentile, target_rate, created_at
+		FROM valuation_rules
+		ORDER BY bucket_id, min_percentile`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []ValuationRule{}
+	for rows.Next() {
+		var r ValuationRule
+		if err := rows.Scan(&r.ID, &r.BucketID, &r.IndexCode, &r.Metric, &r.MinPercentile, &r.MaxPercentile, &r.TargetRate, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+func (s *sqlRepository) AddValuationRule(rule ValuationRule) (int, error) {
+	return s.insert(`
+		INSERT INTO valuation_rules (bucket_id, index_code, metric, min_percentile, max_percentile, target_rate)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		rule.BucketID, rule.IndexCode, rule.Metric, rule.MinPercentile, rule.MaxPercentile, rule.TargetRate,
+	)
+}
+
+func (s *sqlRepository) DeleteValuationRule(id int) error {
+	_, err := s.exec("DELETE FROM valuation_rules WHERE id = ?", id)
+	return err
+}
+
+func (s *sqlRepository) GetGlidePaths() ([]GlidePath, error) {
+	rows, err := s.query(`
+		SELECT bucket_id, basis, date, age, target_rate
+		FROM glide_path_points
+		ORDER BY bucket_id, date, age`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	paths := []GlidePath{}
+	for rows.Next() {
+		var bucketID int
+		var basis string
+		var pt GlidePoint
+		if err := rows.Scan(&bucketID, &basis, &pt.Date, &pt.Age, &pt.TargetRate); err != nil {
+			return nil, err
+		}
+		if n := len(paths); n == 0 || paths[n-1].BucketID != bucketID {
+			paths = append(paths, GlidePath{BucketID: bucketID, Basis: basis})
+		}
+		paths[len(paths)-1].Points = append(paths[len(paths)-1].Points, pt)
+	}
+	return paths, rows.Err()
+}
+
+func (s *sqlRepository) SetGlidePath(path GlidePath) error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM glide_path_points WHERE bucket_id = ?", path.BucketID); err != nil {
+		return err
+	}
+	for _, pt := range path.Points {
+		if _, err := tx.Exec("INSERT INTO glide_path_points (bucket_id, basis, date, age, target_rate) VALUES (?, ?, ?, ?, ?)",
+			path.BucketID, path.Basis, pt.Date, pt.Age, pt.TargetRate); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqlRepository) DeleteGlidePath(bucketID int) error {
+	_, err := s.exec("DELETE FROM glide_path_points WHERE bucket_id = ?", bucketID)
+	return err
+}
+
+func (s *sqlRepository) SaveRebalanceRecord(snapshot RebalanceSnapshot, trigger string, totalValue, totalFee float64, suggestions []RebalanceSuggestion) (int, error) {
+	opts := snapshot.Options
+	snapshotJSON, err := json.Marshal(snapshot)
+	if err != nil {
+		return 0, fmt.Errorf("序列化配置快照失败: %v", err)
+	}
+
+	// 开始事务
+	tx, err := s.begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// 插入再平衡记录
+	recordID, err := tx.insert(
+		"INSERT INTO rebalance_records (strategy, threshold, total_value, mode, inner_band, cash_flow, total_fee, trigger, snapshot) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		opts.Strategy, opts.Threshold, totalValue, opts.Mode, opts.InnerBand, opts.CashFlow, totalFee, trigger, string(snapshotJSON),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	// 插入建议
+	for _, suggestion := range suggestions {
+		_, err := tx.Exec(`
+			INSERT INTO rebalance_suggestions
+			(record_id, fund_id, fund_name, fund_code, current_value, target_value, diff_value, advice, reason, fee)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			recordID, suggestion.FundID, suggestion.FundName, suggestion.FundCode,
+			suggestion.CurrentValue, suggestion.TargetValue, suggestion.DiffValue, suggestion.Advice, suggestion.Reason,
+			suggestion.Fee,
+		)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return recordID, tx.Commit()
+}
+
+const recordColumns = "id, strategy, threshold, total_value, mode, inner_band, cash_flow, total_fee, trigger, created_at"
+
+func scanRebalanceRecord(scanner interface{ Scan(...interface{}) error }) (RebalanceRecord, error) {
+	var record RebalanceRecord
+	err := scanner.Scan(&record.ID, &record.Strategy, &record.Threshold, &record.TotalValue, &record.Mode, &record.InnerBand, &record.CashFlow, &record.TotalFee, &record.Trigger, &record.CreatedAt)
+	return record, err
+}
+
+func (s *sqlRepository) GetRebalanceHistory(limit int) ([]RebalanceRecord, error) {
+	rows, err := s.query(`
+		SELECT `+recordColumns+`
+		FROM rebalance_records
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []RebalanceRecord
+	for rows.Next() {
+		record, err := scanRebalanceRecord(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+func (s *sqlRepository) GetRebalanceRecordByID(recordID int) (*RebalanceRecord, error) {
+	record, err := scanRebalanceRecord(s.queryRow(`
+		SELECT `+recordColumns+`
+		FROM rebalance_records
+		WHERE id = ?
+	`, recordID))
+	if err != nil {
+		return nil, err
+	}
+
+	return &record, nil
+}
+
+func (s *sqlRepository) GetRebalanceSnapshot(recordID int) (*RebalanceSnapshot, error) {
+	var data string
+	if err := s.queryRow("SELECT snapshot FROM rebalance_records WHERE id = ?", recordID).Scan(&data); err != nil {
+		return nil, err
+	}
+	return parseRebalanceSnapshot(data)
+}
+
+// 建议查询的列，与 scanRebalanceSuggestion 对应
+const suggestionColumns = `
+	id, record_id, fund_id, fund_name, fund_code,
+	current_value, target_value, diff_value, advice,
+	COALESCE(reason, '') as reason, fee, created_at,
+	status, executed_amount, executed_nav, executed_shares, executed_at, execution_note, transaction_id,
+	COALESCE((SELECT CASE WHEN archived_at = '' THEN 'active' ELSE 'archived' END
+	          FROM funds WHERE funds.id = rebalance_suggestions.fund_id), 'deleted') AS fund_status`
+
+func scanRebalanceSuggestion(scanner interface{ Scan(...interface{}) error }) (RebalanceSuggestion, error) {
+	var suggestion RebalanceSuggestion
+	err := scanner.Scan(&suggestion.ID, &suggestion.RecordID, &suggestion.FundID,
+		&suggestion.FundName, &suggestion.FundCode, &suggestion.CurrentValue,
+		&suggestion.TargetValue, &suggestion.DiffValue, &suggestion.Advice,
+		&suggestion.Reason, &suggestion.Fee, &suggestion.CreatedAt,
+		&suggestion.Status, &suggestion.ExecutedAmount, &suggestion.ExecutedNAV, &suggestion.ExecutedShares,
+		&suggestion.ExecutedAt, &suggestion.ExecutionNote, &suggestion.TransactionID, &suggestion.FundStatus)
+	suggestion.Gap = suggestion.executionGap()
+	return suggestion, err
+}
+
+func (s *sqlRepository) GetSuggestionsByRecordID(recordID int) ([]RebalanceSuggestion, error) {
+	query := `
+		SELECT ` + suggestionColumns + `
+		FROM rebalance_suggestions
+		WHERE record_id = ?
+		ORDER BY fund_name, id
+	`
+
+	rows, err := s.query(query, recordID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var suggestions []RebalanceSuggestion
+	for rows.Next() {
+		suggestion, err := scanRebalanceSuggestion(rows)
+		if err != nil {
+			return nil, err
+		}
+		suggestions = append(suggestions, suggestion)
+	}
+
+	return suggestions, rows.Err()
+}
+
+func (s *sqlRepository) GetSuggestionByID(id int) (*RebalanceSuggestion, error) {
+	row := s.queryRow(`SELECT `+suggestionColumns+` FROM rebalance_suggestions WHERE id = ?`, id)
+	suggestion, err := scanRebalanceSuggestion(row)
+	if err != nil {
+		return nil, err
+	}
+	return &suggestion, nil
+}
+
+func (s *sqlRepository) SaveExecution(suggestion RebalanceSuggestion, req ExecutionRequest, planned []Transaction) error {
+	tx, err := s.begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// 先按未处理状态认领建议：同一条建议的并发请求只有一个能更新成功，其余的不再生成交易流水
+	result, err := tx.Exec(`
+		UPDATE rebalance_suggestions
+		SET status = ?, executed_amount = ?, executed_nav = ?, executed_shares = 0, executed_at = ?, execution_note = ?, transaction_id = 0
+		WHERE id = ? AND status = ?`,
+		req.Status, req.Amount, req.NAV, req.Date, req.Note, suggestion.ID, SuggestionPending,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n != 1 {
+		return errSuggestionNotPending
+	}
+	if len(planned) == 0 {
+		return tx.Commit()
+	}
+
+	var transactionID int
+	var shares float64
+	for _, t := range planned {
+		// 最后一笔为本次成交，之前的是期初持仓
+		transactionID, err = tx.insert(`
+			INSERT INTO transactions (fund_id, type, trade_date, shares, nav, amount, note)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			t.FundID, t.Type, t.TradeDate, t.Shares, t.NAV, t.Amount, t.Note,
+		)
+		if err != nil {
+			return err
+		}
+		shares = t.Shares
+	}
+	if _, err := tx.Exec("UPDATE rebalance_suggestions SET executed_shares = ?, transaction_id = ? WHERE id = ?", shares, transactionID, suggestion.ID); err != nil {
+		return err
+	}
+
+	current, err := tx.currentAfterTrade(suggestion.FundID, req.Amount)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE funds SET current = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", current, suggestion.FundID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// 成交后的基金市值：按事务内的交易流水和最新净值计算；手动覆盖市值的基金在覆盖值上加减成交金额
+func (tx *sqlTx) currentAfterTrade(fundID int, amount float64) (float64, error) {
+	var code string
+	var current float64
+	var override bool
+	if err := tx.QueryRow("SELECT code, current, current_override FROM funds WHERE id = ?", fundID).Scan(&code, &current, &override); err != nil {
+		return 0, err
+	}
+	if override {
+		return math.Max(current+amount, 0), nil
+	}
+
+	rows, err := tx.Query(`
+		SELECT `+transactionColumns+`
+		FROM transactions
+		WHERE fund_id = ?
+		ORDER BY trade_date, id
+	`, fundID)
+	if err != nil {
+		return 0, err
+	}
+	txs, err := scanTransactions(rows)
+	if err != nil {
+		return 0, err
+	}
+	holding := computeHoldings(txs)[fundID]
+
+	var nav FundNAV
+	err = tx.QueryRow(`
+		SELECT `+navColumns+`
+		FROM fund_navs
+		WHERE code = ?
+		ORDER BY date DESC
+		LIMIT 1
+	`, code).Scan(&nav.Code, &nav.Date, &nav.UnitNAV, &nav.AccNAV, &nav.Source, &nav.FetchedAt)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	holding.useLatestNAV(nav)
+	return holding.Value(), nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// SQLite：默认的存储后端，数据库为本地文件
+var sqliteDialect = &sqlDialect{
+	name:         DriverSQLite,
+	driver:       "sqlite3",
+	migrationDir: "migrations/sqlite",
+	schemaVersionDDL: `CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`,
+	tablesQuery: `
+		SELECT COUNT(*) FROM sqlite_master
+		WHERE type = 'table' AND name NOT IN ('schema_version', 'sqlite_sequence')`,
+	upgradeLegacy: addLegacyColumns,
+	backup:        backupSQLiteDatabase,
+	connString:    sqliteConnString,
+}
+
+// 调度器和Web请求会同时写库：写锁被占用时最多等待5秒而不是立即返回 database is locked，
+// 事务开始时即取得写锁，避免两个事务都从读升级为写时其中一个直接失败
+func sqliteConnString(file string) string {
+	sep := "?"
+	if strings.Contains(file, "?") {
+		sep = "&"
+	}
+	return file + sep + "_busy_timeout=5000&_txlock=immediate"
+}
+
+// 旧版本数据库可能缺少的列，与 migrations/sqlite/0001_initial_schema.sql 中的定义一致
+var legacyColumns = []struct{ table, column, definition string }{
+	{"rebalance_records", "mode", "TEXT NOT NULL DEFAULT 'target'"},
+	{"rebalance_records", "inner_band", "REAL NOT NULL DEFAULT 0"},
+	{"rebalance_records", "cash_flow", "REAL NOT NULL DEFAULT 0"},
+	{"rebalance_records", "total_fee", "REAL NOT NULL DEFAULT 0"},
+	{"rebalance_records", "snapshot", "TEXT NOT NULL DEFAULT ''"},
+	{"rebalance_suggestions", "reason", "TEXT DEFAULT ''"},
+	{"rebalance_suggestions", "fee", "REAL NOT NULL DEFAULT 0"},
+	{"rebalance_suggestions", "status", "TEXT NOT NULL DEFAULT 'pending'"},
+	{"rebalance_suggestions", "executed_amount", "REAL NOT NULL DEFAULT 0"},
+	{"rebalance_suggestions", "executed_nav", "REAL NOT NULL DEFAULT 0"},
+	{"rebalance_suggestions", "executed_shares", "REAL NOT NULL DEFAULT 0"},
+	{"rebalance_suggestions", "executed_at", "TEXT NOT NULL DEFAULT ''"},
+	{"rebalance_suggestions", "execution_note", "TEXT NOT NULL DEFAULT ''"},
+	{"rebalance_suggestions", "transaction_id", "INTEGER NOT NULL DEFAULT 0"},
+	{"funds", "purchase_rate", "REAL NOT NULL DEFAULT 0"},
+	{"funds", "purchase_discount", "REAL NOT NULL DEFAULT 0"},
+	{"funds", "redemption_tiers", "TEXT NOT NULL DEFAULT ''"},
+	{"funds", "held_since", "TEXT NOT NULL DEFAULT ''"},
+	{"funds", "min_trade", "REAL NOT NULL DEFAULT 0"},
+	{"funds", "current_override", "INTEGER NOT NULL DEFAULT 0"},
+	{"funds", "archived_at", "TEXT NOT NULL DEFAULT ''"},
+	{"fund_navs", "source", "TEXT NOT NULL DEFAULT 'import'"},
+	{"fund_navs", "fetched_at", "TEXT NOT NULL DEFAULT ''"},
+}
+
+func addLegacyColumns(tx *sql.Tx) error {
+	for _, col := range legacyColumns {
+		if err := addColumnIfMissing(tx, col.table, col.column, col.definition); err != nil {
+			return fmt.Errorf("添加列 %s.%s 失败: %v", col.table, col.column, err)
+		}
+	}
+	return nil
+}
+
+// 列不存在时添加列
+func addColumnIfMissing(tx *sql.Tx, table, column, definition string) error {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid        int
+			name, typ  string
+			notNull    int
+			defaultVal sql.NullString
+			pk         int
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &defaultVal, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// 用 VACUUM INTO 在数据库文件旁生成一致副本，文件名包含迁移前的版本号和时间
+func backupSQLiteDatabase(db *sql.DB, file string, version int) (string, error) {
+	backup := fmt.Sprintf("%s.v%d-%s.bak", file, version, time.Now().Format("20060102-150405"))
+	if _, err := db.Exec("VACUUM INTO ?", backup); err != nil {
+		return "", err
+	}
+	return backup, nil
+}
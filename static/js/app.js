@@ -83,27 +83,39 @@ function renderBuckets() {
     const container = document.getElementById('bucketsContainer');
     container.innerHTML = '';
 
-    currentBuckets.forEach((bucket, bucketIndex) => {
+    // 子桶沿用所在顶层桶的颜色
+    let topIndex = -1;
+    currentBuckets.forEach(bucket => {
         const bucketDiv = document.createElement('div');
         bucketDiv.className = 'bucket-container fade-in';
+        if (bucket.level > 0) {
+            bucketDiv.classList.add('sub-bucket');
+            bucketDiv.style.marginLeft = `${bucket.level * 1.5}rem`;
+        } else {
+            topIndex++;
+        }
         
-        const bucketClass = getBucketClass(bucketIndex);
+        const bucketClass = getBucketClass(topIndex);
+        const childCount = currentBuckets.filter(b => b.parent_id === bucket.id).length;
+        const rateText = bucket.parent_id
+            ? `目标占比: ${(bucket.target_rate * 100).toFixed(1)}%（占总市值 ${(bucket.effective_rate * 100).toFixed(1)}%）`
+            : `目标占比: ${(bucket.target_rate * 100).toFixed(1)}%`;
         
         bucketDiv.innerHTML = `
             <div class="bucket-header ${bucketClass}">
                 <div>
                     <h6 class="mb-1">${bucket.name}</h6>
-                    <small>目标占比: ${(bucket.target_rate * 100).toFixed(1)}%</small>
+                    <small>${rateText}</small>
                 </div>
                 <div class="text-end">
                     <div class="badge bg-light text-dark">
-                        ${bucket.funds.length} 个基金
+                        ${childCount > 0 ? `${childCount} 个子桶` : `${bucket.funds.length} 个基金`}
                     </div>
                 </div>
             </div>
-            <div class="bucket-content">
-                ${bucket.funds.map((fund, fundIndex) => renderFund(fund, bucketIndex, fundIndex)).join('')}
-            </div>
+            ${childCount > 0 ? '' : `<div class="bucket-content">
+                ${bucket.funds.map(fund => renderFund(fund)).join('')}
+            </div>`}
         `;
         
         container.appendChild(bucketDiv);
@@ -111,7 +123,7 @@ function renderBuckets() {
 }
 
 // 渲染单个基金
-function renderFund(fund, bucketIndex, fundIndex) {
+function renderFund(fund) {
     return `
         <div class="fund-item">
             <div class="fund-header">
@@ -121,18 +133,18 @@ function renderFund(fund, bucketIndex, fundIndex) {
                 </div>
                 <div class="fund-actions">
                     <button class="btn btn-outline-primary action-btn" 
-                            onclick="editFund(${bucketIndex}, ${fundIndex})">
+                            onclick="editFund(${fund.id})">
                         <i class="fas fa-edit"></i>
                     </button>
                     <button class="btn btn-outline-danger action-btn" 
-                            onclick="deleteFund(${bucketIndex}, ${fundIndex})">
+                            onclick="deleteFund(${fund.id})">
                         <i class="fas fa-trash"></i>
                     </button>
                 </div>
             </div>
             <div class="fund-metrics">
                 <div class="metric">
-                    <div class="metric-label">当前市值</div>
+                    <div class="metric-label">当前市值${formatCurrentSource(fund.current_source)}</div>
                     <div class="metric-value">${fund.current.toFixed(2)}万</div>
                 </div>
                 <div class="metric">
@@ -147,6 +159,18 @@ function renderFund(fund, bucketIndex, fundIndex) {
     `;
 }
 
+// 市值来源标记
+function formatCurrentSource(source) {
+    switch (source) {
+        case 'ledger':
+            return ' <span class="badge bg-info">流水</span>';
+        case 'override':
+            return ' <span class="badge bg-warning text-dark">手动覆盖</span>';
+        default:
+            return '';
+    }
+}
+
 // 获取桶的样式类
 function getBucketClass(index) {
     const classes = ['short-term', 'medium-term', 'long-term'];
@@ -165,15 +189,26 @@ function updateTotalValue() {
     document.getElementById('totalValue').textContent = `总市值: ${total.toFixed(2)}万`;
 }
 
+// 可以直接持有基金的桶，即没有子桶的桶
+function leafBuckets() {
+    return currentBuckets.filter(bucket => !currentBuckets.some(b => b.parent_id === bucket.id));
+}
+
+// 桶的完整名称，子桶带上父桶名称
+function bucketPath(bucket) {
+    const parent = currentBuckets.find(b => b.id === bucket.parent_id);
+    return parent ? `${bucketPath(parent)} / ${bucket.name}` : bucket.name;
+}
+
 // 填充桶选择器
 function populateBucketSelect() {
     const select = document.getElementById('bucketSelect');
     select.innerHTML = '';
     
-    currentBuckets.forEach((bucket, index) => {
+    leafBuckets().forEach(bucket => {
         const option = document.createElement('option');
-        option.value = index;
-        option.textContent = bucket.name;
+        option.value = bucket.id;
+        option.textContent = bucketPath(bucket);
         select.appendChild(option);
     });
 }
@@ -187,7 +222,7 @@ function showAddFundModal() {
 
 // 添加基金
 async function addFund() {
-    const bucketIndex = parseInt(document.getElementById('bucketSelect').value);
+    const bucketId = parseInt(document.getElementById('bucketSelect').value);
     const name = document.getElementById('fundName').value.trim();
     const code = document.getElementById('fundCode').value.trim();
     const current = parseFloat(document.getElementById('fundCurrent').value);
@@ -205,7 +240,7 @@ async function addFund() {
 
     try {
         const result = await apiCall('/api/funds', 'POST', {
-            bucket_index: bucketIndex,
+            bucket_id: bucketId,
             name: name,
             code: code,
             current: current,
@@ -227,16 +262,46 @@ async function addFund() {
     }
 }
 
+// 按ID查找基金
+function findFundById(fundId) {
+    for (const bucket of currentBuckets) {
+        const fund = bucket.funds.find(f => f.id === fundId);
+        if (fund) {
+            return fund;
+        }
+    }
+    return null;
+}
+
 // 编辑基金
-function editFund(bucketIndex, fundIndex) {
-    const fund = currentBuckets[bucketIndex].funds[fundIndex];
+function editFund(fundId) {
+    const fund = findFundById(fundId);
+    if (!fund) {
+        showMessage('基金不存在，请刷新后重试', 'error');
+        return;
+    }
     
-    document.getElementById('editBucketIndex').value = bucketIndex;
-    document.getElementById('editFundIndex').value = fundIndex;
+    document.getElementById('editFundId').value = fundId;
     document.getElementById('editFundName').value = fund.name;
     document.getElementById('editFundCode').value = fund.code;
     document.getElementById('editFundCurrent').value = fund.current;
+    document.getElementById('editFundCurrent').dataset.original = fund.current;
     document.getElementById('editFundWeight').value = fund.weight;
+    document.getElementById('editFundPurchaseRate').value = fund.fees.purchase_rate;
+    document.getElementById('editFundPurchaseDiscount').value = fund.fees.purchase_discount;
+    document.getElementById('editFundRedemptionTiers').value = (fund.fees.redemption_tiers || [])
+        .map(tier => `${tier.max_days}:${tier.rate}`).join(',');
+    document.getElementById('editFundHeldSince').value = fund.fees.held_since;
+    document.getElementById('editFundMinTrade').value = fund.min_trade;
+
+    // 有交易流水的基金：修改市值即为手动覆盖，勾选后恢复按流水计算
+    const hint = {
+        ledger: '当前市值由交易流水按份额×最新净值计算，修改后将改为手动覆盖',
+        override: '当前市值为手动覆盖值'
+    }[fund.current_source] || '';
+    document.getElementById('editFundCurrentHint').textContent = hint;
+    document.getElementById('editFundUseLedgerGroup').style.display = fund.current_source === 'override' ? '' : 'none';
+    document.getElementById('editFundUseLedger').checked = false;
     
     const modal = new bootstrap.Modal(document.getElementById('editFundModal'));
     modal.show();
@@ -244,8 +309,7 @@ function editFund(bucketIndex, fundIndex) {
 
 // 更新基金
 async function updateFund() {
-    const bucketIndex = parseInt(document.getElementById('editBucketIndex').value);
-    const fundIndex = parseInt(document.getElementById('editFundIndex').value);
+    const fundId = parseInt(document.getElementById('editFundId').value);
     const name = document.getElementById('editFundName').value.trim();
     const code = document.getElementById('editFundCode').value.trim();
     const current = parseFloat(document.getElementById('editFundCurrent').value);
@@ -266,14 +330,23 @@ async function updateFund() {
         const updates = [
             { field: 'name', value: name },
             { field: 'code', value: code },
-            { field: 'current', value: current.toString() },
-            { field: 'weight', value: weight.toString() }
+            { field: 'weight', value: weight.toString() },
+            { field: 'purchase_rate', value: document.getElementById('editFundPurchaseRate').value || '0' },
+            { field: 'purchase_discount', value: document.getElementById('editFundPurchaseDiscount').value || '0' },
+            { field: 'redemption_tiers', value: document.getElementById('editFundRedemptionTiers').value.trim() },
+            { field: 'held_since', value: document.getElementById('editFundHeldSince').value },
+            { field: 'min_trade', value: document.getElementById('editFundMinTrade').value || '0' }
         ];
 
+        const currentInput = document.getElementById('editFundCurrent');
+        if (document.getElementById('editFundUseLedger').checked) {
+            updates.push({ field: 'current_override', value: '0' });
+        } else if (current !== parseFloat(currentInput.dataset.original)) {
+            updates.push({ field: 'current', value: current.toString() });
+        }
+
         for (const update of updates) {
-            const result = await apiCall('/api/funds', 'PUT', {
-                bucket_index: bucketIndex,
-                fund_index: fundIndex,
+            const result = await apiCall(`/api/funds/${fundId}`, 'PUT', {
                 field: update.field,
                 value: update.value
             });
@@ -294,41 +367,130 @@ async function updateFund() {
 }
 
 // 删除基金
-async function deleteFund(bucketIndex, fundIndex) {
-    const fund = currentBuckets[bucketIndex].funds[fundIndex];
+async function deleteFund(fundId) {
+    const fund = findFundById(fundId);
+    if (!fund) {
+        showMessage('基金不存在，请刷新后重试', 'error');
+        return;
+    }
     
-    if (!confirm(`确定要删除基金 "${fund.name}" 吗？`)) {
+    if (!confirm(`确定要删除基金 "${fund.name}" 吗？\n基金将被归档，交易流水和历史记录保留，可在"已归档基金"中恢复。`)) {
         return;
     }
 
     try {
-        const result = await apiCall('/api/funds', 'DELETE', {
-            bucket_index: bucketIndex,
-            fund_index: fundIndex
-        });
+        const result = await apiCall(`/api/funds/${fundId}`, 'DELETE');
 
         currentBuckets = result.data;
         renderBuckets();
         updateTotalValue();
         
-        showMessage('基金删除成功', 'success');
+        showMessage(result.message, 'success');
     } catch (error) {
         console.error('删除基金失败:', error);
     }
 }
 
+// 显示已归档基金
+async function showArchivedFundsModal() {
+    try {
+        const result = await apiCall('/api/funds/archived', 'GET');
+        renderArchivedFunds(result.data);
+
+        const modal = bootstrap.Modal.getOrCreateInstance(document.getElementById('archivedFundsModal'));
+        modal.show();
+    } catch (error) {
+        console.error('获取归档基金失败:', error);
+        showMessage('获取归档基金失败: ' + error.message, 'error');
+    }
+}
+
+// 渲染已归档基金，每只基金可选择恢复到原所在桶或其他桶
+function renderArchivedFunds(funds) {
+    const container = document.getElementById('archivedFundsContent');
+    if (!funds || funds.length === 0) {
+        container.innerHTML = '<p class="text-muted text-center py-4">暂无已归档的基金</p>';
+        return;
+    }
+
+    const rows = funds.map(fund => {
+        const options = leafBuckets().map(bucket =>
+            `<option value="${bucket.id}">${bucketPath(bucket)}</option>`
+        ).join('');
+        return `
+            <tr>
+                <td>${fund.name} <code>${fund.code}</code></td>
+                <td>${fund.current.toFixed(2)}万</td>
+                <td>${formatPercent(fund.weight)}</td>
+                <td><small class="text-muted">${fund.archived_at}</small></td>
+                <td>
+                    <div class="input-group input-group-sm">
+                        <select id="restoreBucket${fund.id}" class="form-select">
+                            <option value="0">原所在桶</option>
+                            ${options}
+                        </select>
+                        <button class="btn btn-outline-success" onclick="restoreFund(${fund.id})">
+                            <i class="fas fa-undo me-1"></i>恢复
+                        </button>
+                    </div>
+                </td>
+            </tr>
+        `;
+    }).join('');
+
+    container.innerHTML = `
+        <div class="table-responsive">
+            <table class="table table-hover">
+                <thead class="table-light">
+                    <tr><th>基金</th><th>市值</th><th>权重</th><th>归档时间</th><th>恢复到</th></tr>
+                </thead>
+                <tbody>${rows}</tbody>
+            </table>
+        </div>
+    `;
+}
+
+// 恢复归档基金
+async function restoreFund(fundId) {
+    const bucketId = parseInt(document.getElementById(`restoreBucket${fundId}`).value);
+    try {
+        const result = await apiCall(`/api/funds/${fundId}/restore`, 'POST', { bucket_id: bucketId });
+
+        currentBuckets = result.data;
+        renderBuckets();
+        updateTotalValue();
+        showMessage(result.message, 'success');
+        await showArchivedFundsModal();
+    } catch (error) {
+        console.error('恢复基金失败:', error);
+    }
+}
+
 // 显示再平衡模态框
 function showRebalanceModal() {
-    const threshold = document.getElementById('thresholdInput').value || 0.05;
-    performRebalance(parseFloat(threshold));
+    // 未填写阈值时由服务器使用配置的默认阈值
+    const threshold = parseFloat(document.getElementById('thresholdInput').value) || 0;
+    performRebalance(threshold);
+}
+
+// 构建再平衡请求参数
+function buildRebalanceRequest(threshold) {
+    const request = {
+        strategy: document.getElementById('strategySelect').value,
+        threshold: threshold,
+        mode: document.getElementById('modeSelect').value,
+        static_targets: document.getElementById('staticTargetsCheck').checked
+    };
+    if (request.mode === 'cash_flow') {
+        request.amount = parseFloat(document.getElementById('cashFlowInput').value) || 0;
+    }
+    return request;
 }
 
 // 执行再平衡
 async function performRebalance(threshold = 0.05) {
     try {
-        const result = await apiCall('/api/rebalance', 'POST', {
-            threshold: threshold
-        });
+        const result = await apiCall('/api/rebalance', 'POST', buildRebalanceRequest(threshold));
 
         const rebalanceData = result.data;
         renderRebalanceResults(rebalanceData);
@@ -347,6 +509,24 @@ async function performRebalance(threshold = 0.05) {
     }
 }
 
+// 预览再平衡（不保存结果和历史记录）
+async function previewRebalance() {
+    const threshold = parseFloat(document.getElementById('thresholdInput').value) || 0;
+    try {
+        const result = await apiCall('/api/rebalance/preview', 'POST', buildRebalanceRequest(threshold));
+
+        renderRebalanceResults(result.data);
+        document.getElementById('rebalanceResultSection').style.display = 'block';
+        document.getElementById('rebalanceResultSection').scrollIntoView({ 
+            behavior: 'smooth' 
+        });
+
+        showMessage('再平衡预览完成（未保存）', 'success');
+    } catch (error) {
+        console.error('再平衡预览失败:', error);
+    }
+}
+
 // 渲染再平衡结果
 function renderRebalanceResults(buckets) {
     const tbody = document.getElementById('rebalanceResults');
@@ -376,12 +556,16 @@ function renderRebalanceResults(buckets) {
                     <span class="fw-semibold ${diffClass}">
                         ${fund.diff > 0 ? '+' : ''}${fund.diff.toFixed(2)}万
                     </span>
+                    <div><small class="text-muted">调整后 ${fund.post_trade.toFixed(2)}万</small></div>
                 </td>
                 <td>
                     <span class="fw-semibold ${adviceClass}">
                         ${fund.advice}
                     </span>
                 </td>
+                <td>
+                    <small>${formatFee(fund.fee)}</small>
+                </td>
                 <td>
                     <small class="text-muted">${fund.reason || '暂无说明'}</small>
                 </td>
@@ -392,6 +576,86 @@ function renderRebalanceResults(buckets) {
     });
 }
 
+// 显示导入净值模态框
+function showImportNAVModal() {
+    document.getElementById('importNAVForm').reset();
+    document.getElementById('importNAVResult').innerHTML = '';
+    const modal = new bootstrap.Modal(document.getElementById('importNAVModal'));
+    modal.show();
+}
+
+// 上传净值文件
+async function importNAVs() {
+    const file = document.getElementById('navFile').files[0];
+    if (!file) {
+        showMessage('请选择净值文件', 'error');
+        return;
+    }
+
+    const params = new URLSearchParams();
+    const code = document.getElementById('navCode').value.trim();
+    if (code) {
+        params.set('code', code);
+    }
+    params.set('gap_days', document.getElementById('navGapDays').value || '1');
+
+    const formData = new FormData();
+    formData.append('file', file);
+
+    try {
+        showLoading(true);
+        const response = await fetch(`/api/navs/import?${params}`, { method: 'POST', body: formData });
+        const result = await response.json();
+        if (!result.success) {
+            throw new Error(result.message || '导入失败');
+        }
+
+        renderNAVImportResult(result);
+        showMessage(result.message, 'success');
+        loadBuckets();
+    } catch (error) {
+        console.error('导入净值失败:', error);
+        showMessage('错误: ' + error.message, 'error');
+    } finally {
+        showLoading(false);
+    }
+}
+
+// 从净值数据源获取所有基金的最新净值
+async function fetchNAVs() {
+    try {
+        const result = await apiCall('/api/navs/fetch', 'POST');
+        const failed = result.data.failed;
+        let html = `<div class="alert alert-success mb-2">${result.message}</div>`;
+        if (failed.length > 0) {
+            html += `<ul class="small text-danger">${failed.map(f => `<li>${f.code}: ${f.error}</li>`).join('')}</ul>`;
+        }
+        document.getElementById('importNAVResult').innerHTML = html;
+        showMessage(result.message, 'success');
+        loadBuckets();
+    } catch (error) {
+        console.error('获取净值失败:', error);
+    }
+}
+
+// 渲染净值导入结果及缺口
+function renderNAVImportResult(result) {
+    const gaps = result.data.gaps;
+    let html = `<div class="alert alert-success mb-2">${result.message}</div>`;
+    if (gaps.length > 0) {
+        html += `
+            <table class="table table-sm">
+                <thead><tr><th>基金代码</th><th>缺口起</th><th>缺口止</th><th>缺失工作日</th></tr></thead>
+                <tbody>
+                    ${gaps.map(gap => `<tr><td>${gap.code}</td><td>${gap.from}</td><td>${gap.to}</td><td>${gap.missing_days}</td></tr>`).join('')}
+                </tbody>
+            </table>
+            <div class="form-text">缺失工作日包含节假日，请结合交易日历判断是否真的缺少数据</div>
+        `;
+    }
+    document.getElementById('importNAVResult').innerHTML = html;
+}
+
 // 显示消息
 function showMessage(message, type = 'info') {
     const toast = document.getElementById('messageToast');
@@ -435,6 +699,7 @@ async function showHistoryModal() {
         const result = await apiCall('/api/rebalance/history?limit=20', 'GET');
         console.log('历史记录数据:', result); // 添加调试日志
         renderHistoryRecords(result.data);
+        renderScheduleStatus();
         
         const modal = new bootstrap.Modal(document.getElementById('historyModal'));
         modal.show();
@@ -467,6 +732,7 @@ function renderHistoryRecords(records) {
                     <tr>
                         <th>执行时间</th>
                         <th>阈值</th>
+                        <th>模式</th>
                         <th>总市值(万元)</th>
                         <th>操作</th>
                     </tr>
@@ -474,7 +740,8 @@ function renderHistoryRecords(records) {
                 <tbody>
     `;
 
-    records.forEach(record => {
+    records.forEach((record, index) => {
+        const previous = records[index + 1];
         const date = new Date(record.created_at).toLocaleString('zh-CN', {
             year: 'numeric',
             month: '2-digit',
@@ -493,6 +760,10 @@ function renderHistoryRecords(records) {
                 <td>
                     <span class="badge bg-primary">${(record.threshold * 100).toFixed(1)}%</span>
                 </td>
+                <td>
+                    <span class="badge bg-secondary">${formatRebalanceMode(record)}</span>
+                    <span class="badge ${record.trigger === 'manual' ? 'bg-light text-dark' : 'bg-warning text-dark'}">${formatRebalanceTrigger(record.trigger)}</span>
+                </td>
                 <td>
                     <span class="fw-semibold text-success">${record.total_value.toFixed(2)}</span>万
                 </td>
@@ -500,6 +771,10 @@ function renderHistoryRecords(records) {
                     <button class="btn btn-sm btn-outline-info" onclick="viewHistoryDetail(${record.id})">
                         <i class="fas fa-eye me-1"></i>查看详情
                     </button>
+                    ${previous ? `
+                    <button class="btn btn-sm btn-outline-secondary" onclick="diffHistoryRecords(${previous.id}, ${record.id})" title="与上一条记录比较">
+                        <i class="fas fa-exchange-alt me-1"></i>比较
+                    </button>` : ''}
                 </td>
             </tr>
         `;
@@ -514,6 +789,69 @@ function renderHistoryRecords(records) {
     container.innerHTML = html;
 }
 
+// 在历史记录上方显示定时再平衡的下一次执行时间，未配置时不显示
+async function renderScheduleStatus() {
+    try {
+        const result = await apiCall('/api/rebalance/schedule?count=1', 'GET');
+        const status = result.data;
+        if (!status.enabled) {
+            return;
+        }
+        const parts = [];
+        if (status.next_run) {
+            parts.push(`下次定时再平衡 ${new Date(status.next_run).toLocaleString('zh-CN')}（${status.calendar}）`);
+        }
+        if (status.next_drift_check) {
+            parts.push(`下次偏离检查 ${new Date(status.next_drift_check).toLocaleString('zh-CN')}`);
+        }
+        if (status.last_run) {
+            parts.push(`最近一次${formatRebalanceTrigger(status.last_run.trigger)}：${status.last_run.error || status.last_run.message}`);
+        }
+        if (parts.length === 0) {
+            return;
+        }
+        document.getElementById('historyContent').insertAdjacentHTML('afterbegin', `
+            <div class="alert alert-info py-2 small">
+                <i class="fas fa-clock me-1"></i>${parts.join('<br>')}
+            </div>
+        `);
+    } catch (error) {
+        console.error('获取定时再平衡状态失败:', error);
+    }
+}
+
+// 再平衡触发方式的显示名称
+function formatRebalanceTrigger(trigger) {
+    if (trigger === 'scheduled') {
+        return '定时';
+    }
+    if (trigger === 'drift') {
+        return '偏离触发';
+    }
+    return '手动';
+}
+
+// 交易费用以元显示，接口中的费用单位为万元
+function formatFee(fee) {
+    return `${((fee || 0) * 10000).toFixed(2)}元`;
+}
+
+// 再平衡模式的显示名称
+function formatRebalanceMode(record) {
+    if (record.mode === 'cash_flow') {
+        return record.cash_flow < 0
+            ? `现金流(取出${(-record.cash_flow).toFixed(2)}万)`
+            : `现金流(投入${record.cash_flow.toFixed(2)}万)`;
+    }
+    if (record.mode === 'band_edge') {
+        if (record.inner_band > 0 && record.inner_band < 1) {
+            return `调回内层带(${(record.inner_band * 100).toFixed(0)}%)`;
+        }
+        return '调回偏离带边缘';
+    }
+    return '完全调回目标';
+}
+
 // 查看历史详情
 async function viewHistoryDetail(recordId) {
     try {
@@ -529,6 +867,196 @@ async function viewHistoryDetail(recordId) {
     }
 }
 
+// 渲染再平衡时的配置快照
+function renderSnapshot(snapshot) {
+    if (!snapshot) {
+        return '<p class="text-muted small mb-4">该记录没有配置快照</p>';
+    }
+
+    const opts = snapshot.options;
+    const levelOf = bucket => {
+        const parent = snapshot.buckets.find(b => b.id === bucket.parent_id);
+        return parent ? levelOf(parent) + 1 : 0;
+    };
+    const rows = snapshot.buckets.map(bucket => {
+        const funds = bucket.funds.map(fund => `
+            <tr>
+                <td></td>
+                <td>${fund.name} <code>${fund.code}</code></td>
+                <td>${fund.current.toFixed(2)}万</td>
+                <td>${formatPercent(fund.weight)}</td>
+            </tr>
+        `).join('');
+        return `
+            <tr class="table-light">
+                <td colspan="4" class="fw-semibold" style="padding-left: ${0.5 + levelOf(bucket) * 1.5}rem">${bucket.name}(目标占比 ${formatPercent(bucket.target_rate)})</td>
+            </tr>
+            ${funds}
+        `;
+    }).join('');
+    const adjustments = (snapshot.target_adjustments || []).map(a => `
+        <li>${a.bucket_name}：${formatPercent(a.base_rate)} → ${formatPercent(a.target_rate)}，${a.note}</li>
+    `).join('');
+
+    return `
+        <details class="mb-4">
+            <summary class="mb-2">
+                <i class="fas fa-camera me-2"></i>
+                配置快照：策略 ${opts.strategy || 'bucket_threshold'}，阈值 ${formatPercent(opts.threshold)}，基金偏离带 绝对${formatPercent(opts.fund_band.absolute)} 相对${formatPercent(opts.fund_band.relative)}，
+                费用上限 ${formatPercent(opts.max_fee_rate, 2)}，最低交易 ${opts.min_trade}万，取整步长 ${opts.round_step}万
+            </summary>
+            <table class="table table-sm">
+                <thead><tr><th></th><th>基金</th><th>再平衡前市值</th><th>权重</th></tr></thead>
+                <tbody>${rows}</tbody>
+            </table>
+            ${adjustments ? `<p class="small mb-1">目标占比的动态调整：</p><ul class="small">${adjustments}</ul>` : ''}
+        </details>
+    `;
+}
+
+// 用当前算法重新计算历史记录，与原建议对比
+async function replayHistoryRecord(recordId) {
+    try {
+        const result = await apiCall(`/api/rebalance/history/${recordId}/replay`, 'POST');
+        document.getElementById('replayResult').innerHTML = `
+            <p class="small text-muted mb-2">${result.message}</p>
+            ${renderFundComparisons(result.data.funds, '原建议', '重新计算')}
+        `;
+    } catch (error) {
+        console.error('重新计算失败:', error);
+        showMessage('重新计算失败: ' + error.message, 'error');
+    }
+}
+
+// 比较两条历史记录，结果显示在详情框中
+async function diffHistoryRecords(a, b) {
+    try {
+        const result = await apiCall(`/api/rebalance/history/diff?a=${a}&b=${b}`, 'GET');
+        const diff = result.data;
+        const buckets = diff.buckets
+            .filter(bucket => bucket.target_rate_a !== bucket.target_rate_b)
+            .map(bucket => `<li>${bucket.name} 目标占比：${bucket.target_rate_a === null ? '无' : formatPercent(bucket.target_rate_a)} → ${bucket.target_rate_b === null ? '无' : formatPercent(bucket.target_rate_b)}</li>`)
+            .join('');
+
+        document.getElementById('historyDetailContent').innerHTML = `
+            <div class="alert alert-light border">
+                记录 #${diff.a.id} → #${diff.b.id}：总市值 ${diff.a.total_value.toFixed(2)}万 → ${diff.b.total_value.toFixed(2)}万
+                (${diff.total_value_change >= 0 ? '+' : ''}${diff.total_value_change.toFixed(2)}万)，
+                ${diff.advice_changes}只基金的操作建议发生变化
+                ${buckets ? `<ul class="mb-0 mt-2">${buckets}</ul>` : ''}
+            </div>
+            ${renderFundComparisons(diff.funds, `#${diff.a.id}`, `#${diff.b.id}`)}
+        `;
+
+        const modal = new bootstrap.Modal(document.getElementById('historyDetailModal'));
+        modal.show();
+    } catch (error) {
+        console.error('比较历史记录失败:', error);
+        showMessage('比较历史记录失败: ' + error.message, 'error');
+    }
+}
+
+// 渲染逐只基金的对比表，a、b 为 null 表示该基金只出现在其中一次
+function renderFundComparisons(funds, labelA, labelB) {
+    const signed = num => `${num >= 0 ? '+' : ''}${formatNumber(num)}`;
+    const rows = funds.map(fund => {
+        const adviceA = fund.a ? fund.a.advice : '-';
+        const adviceB = fund.b ? fund.b.advice : '-';
+        return `
+            <tr class="${fund.advice_changed ? 'table-warning' : ''}">
+                <td>${fund.fund_name} <code>${fund.fund_code}</code></td>
+                <td>${signed(fund.value_change)}万</td>
+                <td>${signed(fund.target_change)}万</td>
+                <td>${fund.a ? signed(fund.a.diff) + '万' : '-'}</td>
+                <td>${fund.b ? signed(fund.b.diff) + '万' : '-'}</td>
+                <td>${fund.advice_changed ? `${adviceA} → ${adviceB}` : adviceA}</td>
+            </tr>
+        `;
+    }).join('');
+
+    return `
+        <div class="table-responsive">
+            <table class="table table-sm">
+                <thead class="table-light">
+                    <tr><th>基金</th><th>市值变化</th><th>目标变化</th><th>${labelA}调整</th><th>${labelB}调整</th><th>建议</th></tr>
+                </thead>
+                <tbody>${rows}</tbody>
+            </table>
+        </div>
+    `;
+}
+
+// 渲染建议的执行情况，未处理且需要交易的建议显示操作按钮
+function renderSuggestionExecution(suggestion) {
+    if (suggestion.status === 'pending') {
+        if (suggestion.diff_value === 0) {
+            return '<small class="text-muted">无需交易</small>';
+        }
+        return `
+            <div class="btn-group btn-group-sm">
+                <button class="btn btn-outline-success" onclick="markSuggestion(${suggestion.id}, 'executed', ${suggestion.diff_value})">已执行</button>
+                <button class="btn btn-outline-warning" onclick="markSuggestion(${suggestion.id}, 'partial', ${suggestion.diff_value})">部分</button>
+                <button class="btn btn-outline-secondary" onclick="markSuggestion(${suggestion.id}, 'skipped', ${suggestion.diff_value})">跳过</button>
+            </div>
+        `;
+    }
+
+    const labels = { executed: ['已执行', 'bg-success'], partial: ['部分执行', 'bg-warning text-dark'], skipped: ['已跳过', 'bg-secondary'] };
+    const [label, badgeClass] = labels[suggestion.status] || [suggestion.status, 'bg-light text-dark'];
+    let html = `<span class="badge ${badgeClass}">${label}</span>`;
+    if (suggestion.status !== 'skipped') {
+        html += `<div><small>实际 ${suggestion.executed_amount > 0 ? '+' : ''}${suggestion.executed_amount.toFixed(2)}万 @ ${suggestion.executed_nav.toFixed(4)}</small></div>`;
+    }
+    if (suggestion.gap !== 0) {
+        html += `<div><small class="text-muted">差额 ${suggestion.gap > 0 ? '+' : ''}${suggestion.gap.toFixed(2)}万</small></div>`;
+    }
+    if (suggestion.execution_note) {
+        html += `<div><small class="text-muted">${suggestion.execution_note}</small></div>`;
+    }
+    return html;
+}
+
+// 标记建议的执行情况，执行时生成交易流水并更新基金市值
+async function markSuggestion(suggestionId, status, diffValue) {
+    const data = { status: status };
+
+    if (status === 'partial') {
+        const amount = prompt(`实际成交金额(万元，建议 ${diffValue.toFixed(2)}，卖出为负数)`);
+        if (amount === null) {
+            return;
+        }
+        data.amount = parseFloat(amount);
+        if (isNaN(data.amount)) {
+            showMessage('请输入有效的成交金额', 'error');
+            return;
+        }
+    }
+    if (status !== 'skipped') {
+        const nav = prompt('成交净值(留空则使用净值库中的最新净值)', '');
+        if (nav === null) {
+            return;
+        }
+        if (nav.trim() !== '') {
+            data.nav = parseFloat(nav);
+        }
+    } else {
+        const note = prompt('跳过原因(可选)', '');
+        if (note === null) {
+            return;
+        }
+        data.note = note;
+    }
+
+    try {
+        const result = await apiCall(`/api/rebalance/suggestions/${suggestionId}/execute`, 'POST', data);
+        renderHistoryDetail(result.data);
+        showMessage(result.message, 'success');
+        loadBuckets();
+    } catch (error) {
+        console.error('更新执行情况失败:', error);
+    }
+}
+
 // 渲染历史详情
 function renderHistoryDetail(detail) {
     const container = document.getElementById('historyDetailContent');
@@ -583,11 +1111,21 @@ function renderHistoryDetail(detail) {
                                     <span class="badge bg-primary">${(record.threshold * 100).toFixed(1)}%</span>
                                 </p>
                             </div>
+                            <div class="col-md-2">
+                                <h6 class="card-subtitle mb-1">模式</h6>
+                                <p class="card-text">
+                                    <span class="badge bg-secondary">${formatRebalanceMode(record)}</span>
+                                </p>
+                            </div>
                             <div class="col-md-2">
                                 <h6 class="card-subtitle mb-1">总市值</h6>
                                 <p class="card-text fw-semibold text-success">${record.total_value.toFixed(2)}万</p>
                             </div>
                             <div class="col-md-2">
+                                <h6 class="card-subtitle mb-1">预计费用</h6>
+                                <p class="card-text fw-semibold text-danger">${formatFee(record.total_fee)}</p>
+                            </div>
+                            <div class="col-md-1">
                                 <h6 class="card-subtitle mb-1">记录ID</h6>
                                 <p class="card-text"><code>#${record.id}</code></p>
                             </div>
@@ -647,6 +1185,23 @@ function renderHistoryDetail(detail) {
             </div>
         </div>
 
+        <!-- 执行情况 -->
+        <div class="alert alert-light border mb-4">
+            <i class="fas fa-clipboard-check me-2"></i>
+            执行情况：未处理 ${detail.execution.pending}，已执行 ${detail.execution.executed}，部分执行 ${detail.execution.partial}，已跳过 ${detail.execution.skipped}；
+            建议买入 ${detail.execution.suggested_buy.toFixed(2)}万、卖出 ${detail.execution.suggested_sell.toFixed(2)}万，
+            实际买入 ${detail.execution.executed_buy.toFixed(2)}万、卖出 ${detail.execution.executed_sell.toFixed(2)}万
+        </div>
+
+        ${renderSnapshot(detail.snapshot)}
+        ${detail.snapshot ? `
+        <div class="mb-4">
+            <button class="btn btn-sm btn-outline-primary" onclick="replayHistoryRecord(${record.id})">
+                <i class="fas fa-redo me-1"></i>按当前算法重新计算
+            </button>
+            <div id="replayResult" class="mt-3"></div>
+        </div>` : ''}
+
         <!-- 详细建议表格 -->
         <div class="row">
             <div class="col-12">
@@ -664,6 +1219,8 @@ function renderHistoryDetail(detail) {
                                 <th>目标市值(万)</th>
                                 <th>调整金额(万)</th>
                                 <th>操作建议</th>
+                                <th>预计费用</th>
+                                <th>执行情况</th>
                                 <th>详细原因</th>
                             </tr>
                         </thead>
@@ -680,7 +1237,11 @@ function renderHistoryDetail(detail) {
         html += `
             <tr>
                 <td>
-                    <div class="fw-semibold">${suggestion.fund_name}</div>
+                    <div class="fw-semibold">
+                        ${suggestion.fund_name}
+                        ${suggestion.fund_status === 'archived' ? '<span class="badge bg-secondary ms-1">已归档</span>' : ''}
+                        ${suggestion.fund_status === 'deleted' ? '<span class="badge bg-light text-muted ms-1">已删除</span>' : ''}
+                    </div>
                 </td>
                 <td>
                     <code>${suggestion.fund_code}</code>
@@ -701,6 +1262,12 @@ function renderHistoryDetail(detail) {
                         ${suggestion.advice}
                     </span>
                 </td>
+                <td>
+                    <small>${formatFee(suggestion.fee)}</small>
+                </td>
+                <td>
+                    ${renderSuggestionExecution(suggestion)}
+                </td>
                 <td>
                     <small class="text-muted">${suggestion.reason || '暂无说明'}</small>
                 </td>
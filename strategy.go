@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 默认的再平衡策略
+const defaultStrategyName = "bucket_threshold"
+
+// 再平衡策略：根据组合和再平衡参数计算各基金的目标市值、调整金额和建议。
+// 最低交易金额、金额取整和交易费用由 rebalance 在策略计算之后统一处理，策略不需要关心
+type Strategy interface {
+	Name() string
+	Description() string
+	// buckets 为组合的副本，策略可以直接修改后返回；now 为计算时间，重现历史记录时为记录时间
+	Rebalance(buckets []Bucket, opts RebalanceOptions, now time.Time) StrategyResult
+}
+
+// 策略的计算结果
+type StrategyResult struct {
+	Buckets     []Bucket `json:"buckets"`     // 填写了目标市值、调整金额和建议的组合
+	Diagnostics []string `json:"diagnostics"` // 计算过程的说明，如各桶的偏差及是否触发
+	// 取消、跳过或取整后未能分配的金额(万元)，正数为以现金留存，负数为未能卖出
+	Unallocated float64 `json:"unallocated"`
+}
+
+// 策略信息，用于列出可选的策略
+type StrategyInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Default     bool   `json:"default"`
+}
+
+// 已注册的策略，按名称索引
+var strategies = make(map[string]Strategy)
+
+// 注册策略，新增的策略在 init 中调用，名称不能重复
+func registerStrategy(s Strategy) {
+	if _, ok := strategies[s.Name()]; ok {
+		panic(fmt.Sprintf("再平衡策略重复注册: %s", s.Name()))
+	}
+	strategies[s.Name()] = s
+}
+
+// 按名称查找策略，名称为空时使用默认策略
+func findStrategy(name string) (Strategy, error) {
+	if name == "" {
+		name = defaultStrategyName
+	}
+	s, ok := strategies[name]
+	if !ok {
+		return nil, fmt.Errorf("未知的再平衡策略: %s（可选 %s）", name, strings.Join(strategyNames(), "、"))
+	}
+	return s, nil
+}
+
+// 已注册策略的名称，按名称排序
+func strategyNames() []string {
+	names := make([]string, 0, len(strategies))
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// 列出已注册的策略
+func listStrategies() []StrategyInfo {
+	infos := make([]StrategyInfo, 0, len(strategies))
+	for _, name := range strategyNames() {
+		s := strategies[name]
+		infos = append(infos, StrategyInfo{Name: name, Description: s.Description(), Default: name == defaultStrategyName})
+	}
+	return infos
+}
+
+func init() {
+	registerStrategy(bucketThresholdStrategy{})
+}
+
+// 桶阈值策略：桶偏离目标超出阈值时调回，桶在阈值内时检查基金偏离带；
+// 按再平衡模式完全调回目标、只调回偏离带边缘，或只用投入/取出的资金调整
+type bucketThresholdStrategy struct{}
+
+func (bucketThresholdStrategy) Name() string {
+	return defaultStrategyName
+}
+
+func (bucketThresholdStrategy) Description() string {
+	return "桶阈值：桶偏离目标超出阈值时调回，未超出时检查基金偏离带，支持 target、band_edge、cash_flow 三种模式"
+}
+
+func (bucketThresholdStrategy) Rebalance(buckets []Bucket, opts RebalanceOptions, now time.Time) StrategyResult {
+	// 诊断信息按调整前的市值计算
+	annotateBucketTree(buckets)
+	diagnostics := bucketDriftDiagnostics(buckets, opts)
+	if opts.Mode == RebalanceModeCashFlow {
+		// 子桶的目标占比是在父桶中的占比，现金流按各桶占总市值的目标占比分配；
+		// 副本与原组合共用基金切片，计算结果直接写回原组合
+		flat := make([]Bucket, len(buckets))
+		copy(flat, buckets)
+		for i := range flat {
+			flat[i].TargetRate = flat[i].EffectiveRate
+		}
+		rebalanceCashFlow(flat, opts.CashFlow)
+	} else {
+		buckets = rebalanceTree(buckets, opts)
+	}
+	setAllocationTargets(buckets, totalCurrentValue(buckets)+opts.CashFlow)
+	return StrategyResult{Buckets: buckets, Diagnostics: diagnostics}
+}
+
+// 按占总市值的目标占比和桶内权重填写基金的目标市值。子桶逐层调整时各层按父桶市值计算调整金额，
+// 目标市值统一按总市值计算，使不同模式、不同层级的记录可以相互比较
+func setAllocationTargets(buckets []Bucket, total float64) {
+	for bi := range buckets {
+		for fi := range buckets[bi].Funds {
+			fund := &buckets[bi].Funds[fi]
+			fund.Target = total * buckets[bi].EffectiveRate * fund.Weight
+		}
+	}
+}
+
+// 各桶当前占比相对目标的偏差，以及是否超出阈值；子桶的占比为在父桶中的占比
+func bucketDriftDiagnostics(buckets []Bucket, opts RebalanceOptions) []string {
+	total := totalCurrentValue(buckets)
+	if total <= 0 {
+		return []string{"组合总市值为0，无法计算偏差"}
+	}
+
+	diagnostics := make([]string, 0, len(buckets)+1)
+	if opts.Mode == RebalanceModeCashFlow {
+		diagnostics = append(diagnostics, fmt.Sprintf("现金流模式：总市值%.2f万，调整后%.2f万，各桶偏差仅供参考", total, total+opts.CashFlow))
+	}
+	for _, d := range bucketDrifts(buckets) {
+		if !d.Valid {
+			diagnostics = append(diagnostics, fmt.Sprintf("%s 所在父桶市值为0，无法计算偏差", d.Path))
+			continue
+		}
+		status := "未超出"
+		if d.exceeds(opts.Threshold) {
+			status = "超出"
+		}
+		diagnostics = append(diagnostics, fmt.Sprintf("%s 当前占比%.1f%%，目标%.1f%%，偏差%+.1f%%，%s阈值±%.1f%%",
+			d.Path, d.CurrentRate*100, d.TargetRate*100, d.Deviation*100, status, opts.Threshold*100))
+	}
+	return diagnostics
+}
+
+// 桶的当前占比相对目标的偏差
+type bucketDrift struct {
+	Path        string  // 从顶层到该桶的名称
+	CurrentRate float64 // 在父桶中的当前占比
+	TargetRate  float64 // 在父桶中的目标占比
+	Deviation   float64 // 当前占比 - 目标占比
+	Valid       bool    // 父桶市值为0时无法计算偏差
+}
+
+func (d bucketDrift) exceeds(threshold float64) bool {
+	return d.Valid && math.Abs(d.Deviation) > threshold
+}
+
+// 按桶的层级计算各桶的偏差，顺序与 buckets 一致；子桶的占比为在父桶中的占比
+func bucketDrifts(buckets []Bucket) []bucketDrift {
+	total := totalCurrentValue(buckets)
+	t := newBucketTree(buckets)
+	drifts := make([]bucketDrift, len(buckets))
+	for i, b := range buckets {
+		drifts[i] = bucketDrift{Path: t.path(i), TargetRate: b.TargetRate}
+		parentTotal := total
+		if p := t.parent(i); p >= 0 {
+			parentTotal = t.subtreeCurrent(p)
+		}
+		if parentTotal <= 0 {
+			continue
+		}
+		drifts[i].CurrentRate = t.subtreeCurrent(i) / parentTotal
+		drifts[i].Deviation = drifts[i].CurrentRate - b.TargetRate
+		drifts[i].Valid = true
+	}
+	return drifts
+}
+
+// 再平衡结果的说明：所用策略、预计交易费用及策略给出的诊断信息
+func strategyResultSummary(strategy string, result StrategyResult) string {
+	summary := fmt.Sprintf("策略 %s，%s", strategy, rebalanceSummary(result.Buckets))
+	if len(result.Diagnostics) > 0 {
+		summary += "。诊断：" + strings.Join(result.Diagnostics, "；")
+	}
+	return summary
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 估值指标
+const (
+	ValuationMetricPE = "pe" // 市盈率
+	ValuationMetricPB = "pb" // 市净率
+)
+
+// 估值数据超过该天数未更新时，在调整说明中提示
+const valuationStaleDays = 31
+
+// 指数估值：某日的估值指标及其在历史上的分位
+type IndexValuation struct {
+	IndexCode  string  `json:"index_code"`
+	Metric     string  `json:"metric"`     // pe 或 pb
+	Date       string  `json:"date"`       // 估值日期 YYYY-MM-DD
+	Value      float64 `json:"value"`      // 指标值，如市盈率，未知时为0
+	Percentile float64 `json:"percentile"` // 历史分位，0-1
+}
+
+// 估值导入结果
+type ValuationImportResult struct {
+	Total      int `json:"total"`      // 文件中的记录数
+	Inserted   int `json:"inserted"`   // 新增
+	Updated    int `json:"updated"`    // 已存在但数值不同，已覆盖
+	Unchanged  int `json:"unchanged"`  // 已存在且相同
+	Duplicates int `json:"duplicates"` // 文件内重复的日期，以最后一条为准
+}
+
+// 估值规则：指数估值分位落在 [MinPercentile, MaxPercentile) 内时，桶使用 TargetRate 作为目标占比；
+// MaxPercentile 为1时包含1。同一个桶的规则使用同一个指数和指标，区间不能重叠
+type ValuationRule struct {
+	ID            int       `json:"id"`
+	BucketID      int       `json:"bucket_id"`
+	IndexCode     string    `json:"index_code"`
+	Metric        string    `json:"metric"`
+	MinPercentile float64   `json:"min_percentile"`
+	MaxPercentile float64   `json:"max_percentile"`
+	TargetRate    float64   `json:"target_rate"` // 子桶为在父桶中的占比
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// 本次再平衡中桶目标占比的调整(按下滑路径或估值规则)，记入再平衡记录的配置快照
+type TargetAdjustment struct {
+	BucketID   int             `json:"bucket_id"`
+	BucketName string          `json:"bucket_name"`
+	BaseRate   float64         `json:"base_rate"`         // 调整前的目标占比，估值规则的调整在下滑路径之后
+	TargetRate float64         `json:"target_rate"`       // 本次使用的目标占比
+	RuleID     int             `json:"rule_id,omitempty"` // 命中的规则，0表示没有命中规则
+	Signal     *IndexValuation `json:"signal,omitempty"`  // 所用的估值数据，没有规则或没有数据时为空
+	Note       string          `json:"note"`
+}
+
+func (r ValuationRule) matches(percentile float64) bool {
+	return percentile >= r.MinPercentile && (percentile < r.MaxPercentile || r.MaxPercentile >= 1 && percentile <= 1)
+}
+
+// 规则的说明，如 "000300 PE分位[0.0%, 20.0%) → 70.0%"
+func (r ValuationRule) String() string {
+	closing := ")"
+	if r.MaxPercentile >= 1 {
+		closing = "]"
+	}
+	return fmt.Sprintf("%s %s分位[%.1f%%, %.1f%%%s → %.1f%%", r.IndexCode, metricLabel(r.Metric),
+		r.MinPercentile*100, r.MaxPercentile*100, closing, r.TargetRate*100)
+}
+
+func metricLabel(metric string) string {
+	return strings.ToUpper(metric)
+}
+
+func validValuationMetric(metric string) bool {
+	return metric == ValuationMetricPE || metric == ValuationMetricPB
+}
+
+// 校验新增的估值规则：桶存在，区间和目标占比有效，与同一个桶的已有规则使用同一指标且区间不重叠
+func validateValuationRule(rule ValuationRule, dbBuckets []DBBucket, rules []ValuationRule) error {
+	if _, found := findDBBucketByID(dbBuckets, rule.BucketID); !found {
+		return fmt.Errorf("桶不存在: %d", rule.BucketID)
+	}
+	if rule.IndexCode == "" {
+		return fmt.Errorf("指数代码不能为空")
+	}
+	if !validValuationMetric(rule.Metric) {
+		return fmt.Errorf("无效的估值指标: %s（可选 %s、%s）", rule.Metric, ValuationMetricPE, ValuationMetricPB)
+	}
+	if rule.MinPercentile < 0 || rule.MaxPercentile > 1 || rule.MinPercentile >= rule.MaxPercentile {
+		return fmt.Errorf("分位区间无效，需满足 0 ≤ 下限 < 上限 ≤ 1")
+	}
+	if rule.TargetRate < 0 || rule.TargetRate > 1 {
+		return fmt.Errorf("目标占比必须在0-1之间")
+	}
+
+	for _, r := range rules {
+		if r.BucketID != rule.BucketID {
+			continue
+		}
+		if r.IndexCode != rule.IndexCode || r.Metric != rule.Metric {
+			return fmt.Errorf("同一个桶只能按一个估值指标调整，该桶已有规则使用 %s %s", r.IndexCode, metricLabel(r.Metric))
+		}
+		if rule.MinPercentile < r.MaxPercentile && r.MinPercentile < rule.MaxPercentile {
+			return fmt.Errorf("分位区间与规则#%d(%s)重叠", r.ID, r)
+		}
+	}
+	return nil
+}
+
+// 按数据库中的估值规则和不晚于 date 的最新估值调整各桶的目标占比，没有规则时不做调整
+func applyValuationTargets(buckets []Bucket, date string) ([]TargetAdjustment, error) {
+	rules, err := store.GetValuationRules()
+	if err != nil {
+		return nil, fmt.Errorf("获取估值规则失败: %v", err)
+	}
+	return adjustTargetsByValuation(buckets, rules, date, func(code, metric string) (*IndexValuation, error) {
+		return store.GetLatestIndexValuation(code, metric, date)
+	})
+}
+
+// 按估值规则计算本次使用的目标占比并写入 buckets：命中规则的桶使用规则的目标占比，
+// 同一父桶下没有命中规则的桶按原占比的比例补足，使合计仍为100%。
+// lookup 返回指数不晚于 date 的最新估值，没有数据时返回 nil
+func adjustTargetsByValuation(buckets []Bucket, rules []ValuationRule, date string,
+	lookup func(code, metric string) (*IndexValuation, error)) ([]TargetAdjustment, error) {
+	byBucket := make(map[int][]ValuationRule)
+	for _, r := range rules {
+		byBucket[r.BucketID] = append(byBucket[r.BucketID], r)
+	}
+	applicable := false
+	for _, b := range buckets {
+		if len(byBucket[b.ID]) > 0 {
+			applicable = true
+		}
+	}
+	if !applicable {
+		return nil, nil
+	}
+	// 配置的目标占比须先有效，避免按比例补足时掩盖错误的配置
+	if err := validateBucketTargets(buckets); err != nil {
+		return nil, err
+	}
+
+	t := newBucketTree(buckets)
+	rates := make([]float64, len(buckets))
+	fixed := make([]bool, len(buckets))
+	signals := make(map[string]*IndexValuation)
+	var adjustments []TargetAdjustment
+	for i, b := range buckets {
+		rates[i] = b.TargetRate
+		bucketRules := byBucket[b.ID]
+		if len(bucketRules) == 0 {
+			continue
+		}
+
+		code, metric := bucketRules[0].IndexCode, bucketRules[0].Metric
+		key := code + "|" + metric
+		signal, ok := signals[key]
+		if !ok {
+			var err error
+			if signal, err = lookup(code, metric); err != nil {
+				return nil, fmt.Errorf("获取 %s %s 估值失败: %v", code, metricLabel(metric), err)
+			}
+			signals[key] = signal
+		}
+
+		adj := TargetAdjustment{BucketID: b.ID, BucketName: t.path(i), BaseRate: b.TargetRate, TargetRate: b.TargetRate, Signal: signal}
+		if signal == nil {
+			adj.Note = fmt.Sprintf("没有 %s %s 估值数据，目标占比不变", code, metricLabel(metric))
+			adjustments = append(adjustments, adj)
+			continue
+		}
+
+		adj.Note = fmt.Sprintf("%s %s分位%.1f%%(%s)不在任何规则区间内，目标占比不变", code, metricLabel(metric), signal.Percentile*100, signal.Date)
+		for _, r := range bucketRules {
+			if r.matches(signal.Percentile) {
+				adj.TargetRate, adj.RuleID = r.TargetRate, r.ID
+				adj.Note = fmt.Sprintf("%s %s分位%.1f%%(%s)命中规则#%d %s", code, metricLabel(metric), signal.Percentile*100, signal.Date, r.ID, r)
+				rates[i], fixed[i] = r.TargetRate, true
+				break
+			}
+		}
+		if days := daysBetween(signal.Date, date); days > valuationStaleDays {
+			adj.Note += fmt.Sprintf("；估值数据已有%d天未更新", days)
+		}
+		adjustments = append(adjustments, adj)
+	}
+
+	filled, err := fillSiblingTargets(buckets, rates, fixed, "按估值调整")
+	if err != nil {
+		return nil, err
+	}
+	return append(adjustments, filled...), nil
+}
+
+// 两个 YYYY-MM-DD 日期相隔的天数，日期无效时返回0
+func daysBetween(from, to string) int {
+	f, err1 := time.Parse(dateLayout, from)
+	t, err2 := time.Parse(dateLayout, to)
+	if err1 != nil || err2 != nil {
+		return 0
+	}
+	return int(t.Sub(f).Hours() / 24)
+}
+
+// 目标占比调整的说明，用于再平衡的诊断信息
+func targetAdjustmentDiagnostics(adjustments []TargetAdjustment) []string {
+	diagnostics := make([]string, 0, len(adjustments))
+	for _, adj := range adjustments {
+		if math.Abs(adj.TargetRate-adj.BaseRate) > targetRateTolerance {
+			diagnostics = append(diagnostics, fmt.Sprintf("目标调整：%s %.1f%% → %.1f%%，%s", adj.BucketName, adj.BaseRate*100, adj.TargetRate*100, adj.Note))
+		} else {
+			diagnostics = append(diagnostics, fmt.Sprintf("目标不变：%s %.1f%%，%s", adj.BucketName, adj.BaseRate*100, adj.Note))
+		}
+	}
+	return diagnostics
+}
+
+// 估值文件中各字段可能的列名
+var valuationFieldAliases = map[string][]string{
+	"index_code": {"index_code", "code", "指数代码"},
+	"metric":     {"metric", "指标"},
+	"date":       {"date", "trade_date", "日期"},
+	"value":      {"value", "pe", "pb", "pe_ttm", "市盈率", "市净率"},
+	"percentile": {"percentile", "pct", "分位", "百分位", "分位点"},
+}
+
+func valuationFieldName(column string) string {
+	column = strings.ToLower(strings.TrimSpace(column))
+	for field, aliases := range valuationFieldAliases {
+		for _, alias := range aliases {
+			if column == alias {
+				return field
+			}
+		}
+	}
+	return ""
+}
+
+// 解析估值文件；format 为空时根据内容判断是CSV还是JSON，code 和 metric 用于文件中没有对应列的情况
+func parseValuationFile(data []byte, format, code, metric string) ([]IndexValuation, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if format == "" {
+		trimmed := bytes.TrimSpace(data)
+		if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+			format = "json"
+		} else {
+			format = "csv"
+		}
+	}
+
+	var rows []map[string]string
+	var err error
+	switch format {
+	case "csv":
+		rows, err = parseFieldCSV(data, valuationFieldName)
+	case "json":
+		rows, err = parseFieldJSON(data, valuationFieldName)
+	default:
+		return nil, fmt.Errorf("无效的估值文件格式: %s（可选 csv、json）", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("估值文件中没有数据")
+	}
+
+	valuations := make([]IndexValuation, 0, len(rows))
+	for i, row := range rows {
+		v, err := parseValuationRow(row, code, metric)
+		if err != nil {
+			return nil, fmt.Errorf("第%d条记录: %v", i+1, err)
+		}
+		valuations = append(valuations, v)
+	}
+	return valuations, nil
+}
+
+// 校验并转换一条估值记录；分位可以是0-1的小数，或带百分号的百分数
+func parseValuationRow(row map[string]string, code, metric string) (IndexValuation, error) {
+	v := IndexValuation{IndexCode: strings.TrimSpace(row["index_code"]), Metric: strings.ToLower(strings.TrimSpace(row["metric"]))}
+	if v.IndexCode == "" {
+		v.IndexCode = code
+	}
+	if v.IndexCode == "" {
+		return v, fmt.Errorf("缺少指数代码，请在文件中提供指数代码列或指定指数代码")
+	}
+	if v.Metric == "" {
+		v.Metric = metric
+	}
+	if !validValuationMetric(v.Metric) {
+		return v, fmt.Errorf("无效的估值指标: %q（可选 %s、%s）", v.Metric, ValuationMetricPE, ValuationMetricPB)
+	}
+
+	date, err := parseNAVDate(row["date"])
+	if err != nil {
+		return v, fmt.Errorf("无效的估值日期: %q", row["date"])
+	}
+	v.Date = date
+
+	if value := strings.TrimSpace(row["value"]); value != "" {
+		if v.Value, err = strconv.ParseFloat(value, 64); err != nil {
+			return v, fmt.Errorf("无效的指标值: %q", row["value"])
+		}
+	}
+
+	percentile := strings.TrimSpace(row["percentile"])
+	scale := 1.0
+	if strings.HasSuffix(percentile, "%") {
+		percentile, scale = strings.TrimSpace(strings.TrimSuffix(percentile, "%")), 100
+	}
+	p, err := strconv.ParseFloat(percentile, 64)
+	if err != nil || p/scale < 0 || p/scale > 1 {
+		return v, fmt.Errorf("无效的估值分位: %q（0-1的小数或百分数，如 0.35 或 35%%）", row["percentile"])
+	}
+	v.Percentile = p / scale
+	return v, nil
+}
+
+// 文件内去重，同一指数、指标和日期以最后一条为准；返回结果按指数、指标和日期排序
+func dedupValuations(valuations []IndexValuation) ([]IndexValuation, int) {
+	index := make(map[string]int)
+	var unique []IndexValuation
+	duplicates := 0
+	for _, v := range valuations {
+		key := v.IndexCode + "|" + v.Metric + "|" + v.Date
+		if i, ok := index[key]; ok {
+			unique[i] = v
+			duplicates++
+			continue
+		}
+		index[key] = len(unique)
+		unique = append(unique, v)
+	}
+
+	sort.Slice(unique, func(i, j int) bool {
+		a, b := unique[i], unique[j]
+		if a.IndexCode != b.IndexCode {
+			return a.IndexCode < b.IndexCode
+		}
+		if a.Metric != b.Metric {
+			return a.Metric < b.Metric
+		}
+		return a.Date < b.Date
+	})
+	return unique, duplicates
+}
+
+// 导入估值：文件内去重后逐条新增或覆盖
+func importIndexValuations(valuations []IndexValuation) (*ValuationImportResult, error) {
+	unique, duplicates := dedupValuations(valuations)
+	result, err := store.SaveIndexValuations(unique)
+	if err != nil {
+		return nil, err
+	}
+	result.Total = len(valuations)
+	result.Duplicates = duplicates
+	return result, nil
+}
+
+// 估值导入结果摘要
+func valuationImportSummary(result *ValuationImportResult) string {
+	summary := fmt.Sprintf("估值导入完成：共%d条，新增%d条，覆盖%d条，未变化%d条", result.Total, result.Inserted, result.Updated, result.Unchanged)
+	if result.Duplicates > 0 {
+		summary += fmt.Sprintf("，文件内重复%d条", result.Duplicates)
+	}
+	return summary
+}
+
+// 估值规则及其在某日对目标占比的调整
+type ValuationRulesView struct {
+	Date        string             `json:"date"`
+	Rules       []ValuationRule    `json:"rules"`
+	Adjustments []TargetAdjustment `json:"adjustments"`
+	Error       string             `json:"error,omitempty"` // 规则无法应用时的原因，如调整后合计超过100%
+}
+
+// 列出估值规则，并按 date 的估值计算当前配置下各桶的目标占比
+func getValuationRulesView(date string) (*ValuationRulesView, error) {
+	rules, err := store.GetValuationRules()
+	if err != nil {
+		return nil, fmt.Errorf("获取估值规则失败: %v", err)
+	}
+	dbBuckets, err := getAllBucketsFromDB()
+	if err != nil {
+		return nil, fmt.Errorf("获取基金配置失败: %v", err)
+	}
+
+	view := &ValuationRulesView{Date: date, Rules: rules, Adjustments: []TargetAdjustment{}}
+	// 估值规则在下滑路径得到的当日占比上调整
+	buckets := convertDBBucketsToAPIBuckets(dbBuckets)
+	if _, err := applyGlidePathTargets(buckets, date); err != nil {
+		view.Error = "按下滑路径计算目标占比失败: " + err.Error()
+		return view, nil
+	}
+	adjustments, err := adjustTargetsByValuation(buckets, rules, date, func(code, metric string) (*IndexValuation, error) {
+		return store.GetLatestIndexValuation(code, metric, date)
+	})
+	if err != nil {
+		view.Error = err.Error()
+	} else if adjustments != nil {
+		view.Adjustments = adjustments
+	}
+	return view, nil
+}
+
+// 校验并新增估值规则，返回规则ID
+func addValuationRule(dbBuckets []DBBucket, rule ValuationRule) (int, error) {
+	rules, err := store.GetValuationRules()
+	if err != nil {
+		return 0, fmt.Errorf("获取估值规则失败: %v", err)
+	}
+	if err := validateValuationRule(rule, dbBuckets, rules); err != nil {
+		return 0, err
+	}
+	return store.AddValuationRule(rule)
+}
+
+// 按ID查找估值规则，不存在时返回 nil
+func findValuationRule(id int) (*ValuationRule, error) {
+	rules, err := store.GetValuationRules()
+	if err != nil {
+		return nil, err
+	}
+	for i := range rules {
+		if rules[i].ID == id {
+			return &rules[i], nil
+		}
+	}
+	return nil, nil
+}
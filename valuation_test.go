@@ -0,0 +1,217 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// 分位区间含下限不含上限，上限为1时包含1
+func TestValuationRuleMatches(t *testing.T) {
+	low := ValuationRule{MinPercentile: 0, MaxPercentile: 0.2}
+	high := ValuationRule{MinPercentile: 0.8, MaxPercentile: 1}
+	tests := []struct {
+		rule       ValuationRule
+		percentile float64
+		want       bool
+	}{
+		{low, 0, true},
+		{low, 0.1999, true},
+		{low, 0.2, false},
+		{high, 0.7999, false},
+		{high, 0.8, true},
+		{high, 1, true},
+		{high, 1.0001, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.rule.matches(tt.percentile); got != tt.want {
+			t.Errorf("规则 %s 对分位%.4f 匹配结果为 %v，应为 %v", tt.rule, tt.percentile, got, tt.want)
+		}
+	}
+}
+
+func TestValidateValuationRule(t *testing.T) {
+	dbBuckets := []DBBucket{{ID: 1, Name: "股票"}, {ID: 2, Name: "债券"}}
+	existing := []ValuationRule{{ID: 1, BucketID: 1, IndexCode: "000300", Metric: ValuationMetricPE, MinPercentile: 0, MaxPercentile: 0.2, TargetRate: 0.7}}
+	rule := func(modify func(r *ValuationRule)) ValuationRule {
+		r := ValuationRule{BucketID: 1, IndexCode: "000300", Metric: ValuationMetricPE, MinPercentile: 0.8, MaxPercentile: 1, TargetRate: 0.45}
+		modify(&r)
+		return r
+	}
+
+	tests := []struct {
+		name      string
+		rule      ValuationRule
+		wantError string // 为空表示不应报错
+	}{
+		{"与已有规则相邻的区间", rule(func(r *ValuationRule) { r.MinPercentile, r.MaxPercentile = 0.2, 0.5 }), ""},
+		{"目标占比取上限1", rule(func(r *ValuationRule) { r.TargetRate = 1 }), ""},
+		{"目标占比取下限0", rule(func(r *ValuationRule) { r.TargetRate = 0 }), ""},
+		{"桶不存在", rule(func(r *ValuationRule) { r.BucketID = 9 }), "桶不存在"},
+		{"缺少指数代码", rule(func(r *ValuationRule) { r.IndexCode = "" }), "指数代码不能为空"},
+		{"估值指标无效", rule(func(r *ValuationRule) { r.Metric = "ps" }), "无效的估值指标"},
+		{"分位下限小于0", rule(func(r *ValuationRule) { r.MinPercentile = -0.1 }), "分位区间无效"},
+		{"分位上限大于1", rule(func(r *ValuationRule) { r.MaxPercentile = 1.1 }), "分位区间无效"},
+		{"分位下限不小于上限", rule(func(r *ValuationRule) { r.MinPercentile, r.MaxPercentile = 0.5, 0.5 }), "分位区间无效"},
+		{"目标占比超过1", rule(func(r *ValuationRule) { r.TargetRate = 1.2 }), "目标占比必须在0-1之间"},
+		{"目标占比为负数", rule(func(r *ValuationRule) { r.TargetRate = -0.1 }), "目标占比必须在0-1之间"},
+		{"同一个桶使用不同指标", rule(func(r *ValuationRule) { r.Metric = ValuationMetricPB }), "只能按一个估值指标调整"},
+		{"区间重叠", rule(func(r *ValuationRule) { r.MinPercentile, r.MaxPercentile = 0.1, 0.3 }), "与规则#1"},
+		{"其他桶不受已有规则限制", rule(func(r *ValuationRule) { r.BucketID, r.Metric, r.MinPercentile = 2, ValuationMetricPB, 0 }), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateValuationRule(tt.rule, dbBuckets, existing)
+			if tt.wantError == "" {
+				if err != nil {
+					t.Errorf("不应报错，实际: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+				t.Errorf("错误 %v 应包含 %q", err, tt.wantError)
+			}
+		})
+	}
+}
+
+// 命中规则的桶使用规则的目标占比，同一父桶下的其他桶按原占比的比例补足，合计仍为100%
+func TestAdjustTargetsByValuation(t *testing.T) {
+	newBuckets := func() []Bucket {
+		return []Bucket{
+			{ID: 1, Name: "股票", TargetRate: 0.6},
+			{ID: 2, Name: "A股", ParentID: 1, TargetRate: 0.5},
+			{ID: 3, Name: "美股", ParentID: 1, TargetRate: 0.5},
+			{ID: 4, Name: "债券", TargetRate: 0.3},
+			{ID: 5, Name: "现金", TargetRate: 0.1},
+		}
+	}
+	equityRules := []ValuationRule{
+		{ID: 1, BucketID: 1, IndexCode: "000300", Metric: ValuationMetricPE, MinPercentile: 0, MaxPercentile: 0.2, TargetRate: 0.7},
+		{ID: 2, BucketID: 1, IndexCode: "000300", Metric: ValuationMetricPE, MinPercentile: 0.8, MaxPercentile: 1, TargetRate: 0.45},
+	}
+	childRules := []ValuationRule{
+		{ID: 3, BucketID: 2, IndexCode: "000300", Metric: ValuationMetricPE, MinPercentile: 0, MaxPercentile: 0.5, TargetRate: 0.8},
+	}
+	signal := func(p float64) *IndexValuation {
+		return &IndexValuation{IndexCode: "000300", Metric: ValuationMetricPE, Date: "2024-05-31", Percentile: p}
+	}
+
+	tests := []struct {
+		name     string
+		rules    []ValuationRule
+		signal   *IndexValuation
+		want     []float64 // 各桶调整后的目标占比
+		wantRule int       // 股票或A股命中的规则，0 表示没有命中
+	}{
+		{"低估时提高股票占比", equityRules, signal(0.1), []float64{0.7, 0.5, 0.5, 0.225, 0.075}, 1},
+		{"分位恰好在区间上限时不命中", equityRules, signal(0.2), []float64{0.6, 0.5, 0.5, 0.3, 0.1}, 0},
+		{"高估区间的下限", equityRules, signal(0.8), []float64{0.45, 0.5, 0.5, 0.4125, 0.1375}, 2},
+		{"分位为1时命中上限为1的区间", equityRules, signal(1), []float64{0.45, 0.5, 0.5, 0.4125, 0.1375}, 2},
+		{"不在任何区间内", equityRules, signal(0.5), []float64{0.6, 0.5, 0.5, 0.3, 0.1}, 0},
+		{"没有估值数据", equityRules, nil, []float64{0.6, 0.5, 0.5, 0.3, 0.1}, 0},
+		{"子桶的规则只在同一父桶内补足", childRules, signal(0.3), []float64{0.6, 0.8, 0.2, 0.3, 0.1}, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buckets := newBuckets()
+			adjustments, err := adjustTargetsByValuation(buckets, tt.rules, "2024-06-01", func(code, metric string) (*IndexValuation, error) {
+				return tt.signal, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sums := make(map[int]float64)
+			for i, b := range buckets {
+				if !approxEqual(b.TargetRate, tt.want[i]) {
+					t.Errorf("%s 目标占比%.4f，应为%.4f", b.Name, b.TargetRate, tt.want[i])
+				}
+				sums[b.ParentID] += b.TargetRate
+			}
+			for parentID, sum := range sums {
+				if !approxEqual(sum, 1) {
+					t.Errorf("父桶%d下的目标占比合计%.4f，应为1", parentID, sum)
+				}
+			}
+			if len(adjustments) == 0 || adjustments[0].RuleID != tt.wantRule {
+				t.Errorf("调整说明 %+v，应命中规则%d", adjustments, tt.wantRule)
+			}
+		})
+	}
+
+	// 命中规则的桶合计超过100%时报错
+	rules := append(append([]ValuationRule{}, equityRules...),
+		ValuationRule{ID: 4, BucketID: 4, IndexCode: "000300", Metric: ValuationMetricPE, MinPercentile: 0, MaxPercentile: 0.5, TargetRate: 0.5})
+	_, err := adjustTargetsByValuation(newBuckets(), rules, "2024-06-01", func(code, metric string) (*IndexValuation, error) {
+		return signal(0.1), nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "超过100%") {
+		t.Errorf("命中规则的目标占比合计120%%时应报错，实际: %v", err)
+	}
+
+	// 估值数据过旧时在说明中提示
+	adjustments, err := adjustTargetsByValuation(newBuckets(), equityRules, "2024-09-01", func(code, metric string) (*IndexValuation, error) {
+		return signal(0.1), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(adjustments[0].Note, "估值数据已有93天未更新") {
+		t.Errorf("说明 %q 应提示估值数据过旧", adjustments[0].Note)
+	}
+}
+
+func TestParseValuationRow(t *testing.T) {
+	tests := []struct {
+		name      string
+		row       map[string]string
+		want      IndexValuation
+		wantError string // 为空表示不应报错
+	}{
+		{"小数分位", map[string]string{"index_code": "000300", "metric": "PE", "date": "2024-05-31", "value": "12.5", "percentile": "0.35"},
+			IndexValuation{IndexCode: "000300", Metric: "pe", Date: "2024-05-31", Value: 12.5, Percentile: 0.35}, ""},
+		{"百分数分位", map[string]string{"date": "2024/05/31", "percentile": " 35% "},
+			IndexValuation{IndexCode: "000905", Metric: "pb", Date: "2024-05-31", Percentile: 0.35}, ""},
+		{"分位为边界值", map[string]string{"date": "20240531", "percentile": "100%"},
+			IndexValuation{IndexCode: "000905", Metric: "pb", Date: "2024-05-31", Percentile: 1}, ""},
+		{"估值指标无效", map[string]string{"metric": "ps", "date": "2024-05-31", "percentile": "0.35"}, IndexValuation{}, "无效的估值指标"},
+		{"日期无效", map[string]string{"date": "31/05/2024", "percentile": "0.35"}, IndexValuation{}, "无效的估值日期"},
+		{"指标值无效", map[string]string{"date": "2024-05-31", "value": "n/a", "percentile": "0.35"}, IndexValuation{}, "无效的指标值"},
+		{"缺少分位", map[string]string{"date": "2024-05-31"}, IndexValuation{}, "无效的估值分位"},
+		{"分位超过1", map[string]string{"date": "2024-05-31", "percentile": "35"}, IndexValuation{}, "无效的估值分位"},
+		{"百分数超过100%", map[string]string{"date": "2024-05-31", "percentile": "120%"}, IndexValuation{}, "无效的估值分位"},
+		{"分位为负数", map[string]string{"date": "2024-05-31", "percentile": "-0.1"}, IndexValuation{}, "无效的估值分位"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseValuationRow(tt.row, "000905", ValuationMetricPB)
+			if tt.wantError != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantError) {
+					t.Errorf("错误 %v 应包含 %q", err, tt.wantError)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("解析失败: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("解析为 %+v，应为 %+v", got, tt.want)
+			}
+		})
+	}
+
+	// 文件中没有指数代码列，也没有指定指数代码
+	if _, err := parseValuationRow(map[string]string{"index_code": " ", "date": "2024-05-31", "percentile": "0.35"}, "", ValuationMetricPE); err == nil || !strings.Contains(err.Error(), "缺少指数代码") {
+		t.Errorf("缺少指数代码时应报错，实际: %v", err)
+	}
+
+	// 文件中的错误带上记录序号
+	_, err := parseValuationFile([]byte("date,percentile\n2024-05-30,0.3\n2024-05-31,abc\n"), "", "000300", ValuationMetricPE)
+	if err == nil || !strings.Contains(err.Error(), "第2条记录") {
+		t.Errorf("错误 %v 应指出第2条记录", err)
+	}
+}